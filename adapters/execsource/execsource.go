@@ -0,0 +1,486 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package execsource provides a SimpleJSON source that answers queries
+// by running a command, making it easy to graph the output of existing
+// scripts.
+//
+// The command is run once per target. The details of the query are passed
+// in three ways, so that the script may use whichever is most convenient:
+// the target is appended to the command's arguments, after a "--" so that
+// targets beginning with "-" are not taken as options; the environment holds
+// GSJ_TARGET, GSJ_TYPE ("timeserie" or "table"), GSJ_FROM and GSJ_TO (as
+// RFC 3339 times), GSJ_INTERVAL_MS and GSJ_MAX_DATA_POINTS; and the same
+// details are written to stdin as a JSON Query.
+//
+// For timeserie queries, the command should write either a JSON array of
+// [value, time] pairs, as sent to Grafana, or CSV records of time and
+// value. For table queries, it should write either a JSON object with
+// columns and rows, as sent to Grafana, or CSV with a header record. Times
+// may be RFC 3339 strings, or numbers of seconds or milliseconds since the
+// epoch.
+package execsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+const (
+	// DefaultTimeout is the default limit on the run time of the command.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxOutput is the default limit on the size of the command's
+	// output.
+	DefaultMaxOutput = 16 << 20
+
+	// maxStderr is the amount of the command's stderr included in errors.
+	maxStderr = 4096
+)
+
+// Query holds the details of a query, as written to the command's stdin.
+type Query struct {
+	Target        string    `json:"target"`
+	Type          string    `json:"type"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	IntervalMS    int64     `json:"intervalMs,omitempty"`
+	MaxDataPoints int       `json:"maxDataPoints,omitempty"`
+}
+
+// Source runs a command to answer queries. It implements
+// simplejson.Querier and simplejson.TableQuerier, and may be passed to
+// simplejson.WithSource.
+type Source struct {
+	path      string
+	args      []string
+	env       []string
+	dir       string
+	timeout   time.Duration
+	maxOutput int
+}
+
+// An Opt configures a Source.
+type Opt func(*Source) error
+
+// WithTimeout limits the time the command may run for. The default is
+// DefaultTimeout.
+func WithTimeout(d time.Duration) Opt {
+	return func(s *Source) error {
+		s.timeout = d
+		return nil
+	}
+}
+
+// WithMaxOutput limits the size of the command's output, commands that
+// write more are killed. The default is DefaultMaxOutput.
+func WithMaxOutput(n int) Opt {
+	return func(s *Source) error {
+		s.maxOutput = n
+		return nil
+	}
+}
+
+// WithEnv adds variables, of the form "key=value", to the environment of
+// the command. The command otherwise inherits the environment of the
+// process.
+func WithEnv(env ...string) Opt {
+	return func(s *Source) error {
+		s.env = append(s.env, env...)
+		return nil
+	}
+}
+
+// WithDir sets the working directory of the command.
+func WithDir(dir string) Opt {
+	return func(s *Source) error {
+		s.dir = dir
+		return nil
+	}
+}
+
+// New creates a Source that runs the program at path, with the given
+// arguments followed by the target.
+func New(path string, args []string, opts ...Opt) (*Source, error) {
+	if _, err := exec.LookPath(path); err != nil {
+		return nil, err
+	}
+	s := &Source{
+		path:      path,
+		args:      args,
+		timeout:   DefaultTimeout,
+		maxOutput: DefaultMaxOutput,
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// GrafanaQuery implements simplejson.Querier.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	out, err := s.run(ctx, Query{
+		Target:        target,
+		Type:          "timeserie",
+		From:          args.From,
+		To:            args.To,
+		IntervalMS:    args.Interval.Milliseconds(),
+		MaxDataPoints: args.MaxDPs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var dps []simplejson.DataPoint
+	if isJSON(out) {
+		dps, err = parseJSONPoints(out)
+	} else {
+		dps, err = parseCSVPoints(out)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid output for %q, %w", target, err)
+	}
+	series := simplejson.NewSeries(target)
+	for _, dp := range dps {
+		series.AddPoint(dp)
+	}
+	return series.Points(), nil
+}
+
+// GrafanaQueryTable implements simplejson.TableQuerier.
+func (s *Source) GrafanaQueryTable(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+	out, err := s.run(ctx, Query{
+		Target: target,
+		Type:   "table",
+		From:   args.From,
+		To:     args.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cols []simplejson.TableColumn
+	if isJSON(out) {
+		cols, err = parseJSONTable(out)
+	} else {
+		cols, err = parseCSVTable(out)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid output for %q, %w", target, err)
+	}
+	return cols, nil
+}
+
+var errOutputTooLarge = errors.New("command output too large")
+
+// limitedBuffer buffers up to max bytes, refusing further writes and
+// calling overflow, if set, when the limit is reached. The buffer is not
+// embedded, so that io.Copy cannot bypass the limit with ReadFrom.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	overflow  func()
+	truncated bool
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if lb.buf.Len()+len(p) > lb.max {
+		lb.truncated = true
+		if lb.overflow != nil {
+			lb.overflow()
+		}
+		lb.buf.Write(p[:max(lb.max-lb.buf.Len(), 0)])
+		return 0, errOutputTooLarge
+	}
+	return lb.buf.Write(p)
+}
+
+// run runs the command for q, returning its stdout.
+func (s *Source) run(ctx context.Context, q Query) ([]byte, error) {
+	stdin, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.path, append(append([]string{}, s.args...), "--", q.Target)...)
+	cmd.Dir = s.dir
+	cmd.Env = append(os.Environ(), s.env...)
+	cmd.Env = append(cmd.Env,
+		"GSJ_TARGET="+q.Target,
+		"GSJ_TYPE="+q.Type,
+		"GSJ_FROM="+q.From.Format(time.RFC3339Nano),
+		"GSJ_TO="+q.To.Format(time.RFC3339Nano),
+		"GSJ_INTERVAL_MS="+strconv.FormatInt(q.IntervalMS, 10),
+		"GSJ_MAX_DATA_POINTS="+strconv.Itoa(q.MaxDataPoints),
+	)
+	cmd.Stdin = bytes.NewReader(stdin)
+	// The command is killed if it writes too much, rather than left
+	// blocked writing to its stdout.
+	stdout := &limitedBuffer{max: s.maxOutput, overflow: cancel}
+	stderr := &limitedBuffer{max: maxStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	switch {
+	case stdout.truncated:
+		return nil, fmt.Errorf("%w, limit is %d bytes", errOutputTooLarge, s.maxOutput)
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("command timed out after %v, %w", s.timeout, simplejson.ErrTimeout)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.buf.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.buf.Bytes(), nil
+}
+
+func isJSON(out []byte) bool {
+	out = bytes.TrimSpace(out)
+	return len(out) > 0 && (out[0] == '[' || out[0] == '{')
+}
+
+func parseJSONPoints(out []byte) ([]simplejson.DataPoint, error) {
+	var raw [][2]*json.Number
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	dps := make([]simplejson.DataPoint, 0, len(raw))
+	for _, p := range raw {
+		if p[1] == nil {
+			return nil, errors.New("missing time")
+		}
+		t, err := parseTime(p[1].String())
+		if err != nil {
+			return nil, err
+		}
+		if p[0] == nil {
+			dps = append(dps, simplejson.NullDataPoint(t))
+			continue
+		}
+		v, err := p[0].Float64()
+		if err != nil {
+			return nil, err
+		}
+		dps = append(dps, simplejson.DataPoint{Time: t, Value: v})
+	}
+	return dps, nil
+}
+
+func parseCSVPoints(out []byte) ([]simplejson.DataPoint, error) {
+	recs, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	dps := make([]simplejson.DataPoint, 0, len(recs))
+	for i, rec := range recs {
+		if len(rec) != 2 {
+			return nil, fmt.Errorf("record %d should hold a time and a value", i+1)
+		}
+		t, err := parseTime(rec[0])
+		if err != nil {
+			if i == 0 {
+				// Allow for a header record.
+				continue
+			}
+			return nil, err
+		}
+		if strings.TrimSpace(rec[1]) == "" {
+			dps = append(dps, simplejson.NullDataPoint(t))
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("record %d, %w", i+1, err)
+		}
+		dps = append(dps, simplejson.DataPoint{Time: t, Value: v})
+	}
+	return dps, nil
+}
+
+func parseJSONTable(out []byte) ([]simplejson.TableColumn, error) {
+	var raw struct {
+		Columns []simplejson.TableColumnHeader `json:"columns"`
+		Rows    [][]interface{}                `json:"rows"`
+	}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, err
+	}
+
+	types := make([]string, len(raw.Columns))
+	names := make([]string, len(raw.Columns))
+	for i, c := range raw.Columns {
+		names[i], types[i] = c.Text, c.Type
+	}
+	return buildTable(names, types, len(raw.Rows), func(row, col int) (interface{}, error) {
+		if len(raw.Rows[row]) != len(raw.Columns) {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", row+1, len(raw.Rows[row]), len(raw.Columns))
+		}
+		return raw.Rows[row][col], nil
+	})
+}
+
+func parseCSVTable(out []byte) ([]simplejson.TableColumn, error) {
+	recs, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, errors.New("missing header record")
+	}
+	names, rows := recs[0], recs[1:]
+
+	// Columns are numbers if all their values parse as numbers, and
+	// times if they are named "time".
+	types := make([]string, len(names))
+	for i, name := range names {
+		types[i] = "number"
+		if strings.EqualFold(name, "time") {
+			types[i] = "time"
+			continue
+		}
+		for _, row := range rows {
+			if v := strings.TrimSpace(row[i]); v != "" {
+				if _, err := strconv.ParseFloat(v, 64); err != nil {
+					types[i] = "string"
+					break
+				}
+			}
+		}
+	}
+	return buildTable(names, types, len(rows), func(row, col int) (interface{}, error) {
+		if rows[row][col] == "" {
+			return nil, nil
+		}
+		return rows[row][col], nil
+	})
+}
+
+// buildTable builds typed columns from the values returned by value.
+func buildTable(names, types []string, rows int, value func(row, col int) (interface{}, error)) ([]simplejson.TableColumn, error) {
+	cols := make([]simplejson.TableColumn, len(names))
+	for c, name := range names {
+		var (
+			numbers simplejson.Column[*float64]
+			times   simplejson.Column[*time.Time]
+			bools   simplejson.Column[*bool]
+			strs    simplejson.Column[*string]
+		)
+		for r := 0; r < rows; r++ {
+			v, err := value(r, c)
+			if err != nil {
+				return nil, err
+			}
+			switch types[c] {
+			case "number":
+				var f *float64
+				if v != nil {
+					n, err := toFloat(v)
+					if err != nil {
+						return nil, fmt.Errorf("column %s, %w", name, err)
+					}
+					f = &n
+				}
+				numbers.Append(f)
+			case "time":
+				var t *time.Time
+				if v != nil {
+					tv, err := parseTime(fmt.Sprint(v))
+					if err != nil {
+						return nil, fmt.Errorf("column %s, %w", name, err)
+					}
+					t = &tv
+				}
+				times.Append(t)
+			case "boolean":
+				var b *bool
+				if v != nil {
+					bv, ok := v.(bool)
+					if !ok {
+						return nil, fmt.Errorf("column %s, invalid boolean %v", name, v)
+					}
+					b = &bv
+				}
+				bools.Append(b)
+			default:
+				var s *string
+				if v != nil {
+					sv := fmt.Sprint(v)
+					s = &sv
+				}
+				strs.Append(s)
+			}
+		}
+
+		cols[c] = simplejson.TableColumn{Text: name}
+		switch types[c] {
+		case "number":
+			cols[c].Data = numbers
+		case "time":
+			cols[c].Data = times
+		case "boolean":
+			cols[c].Data = bools
+		default:
+			cols[c].Data = strs
+		}
+	}
+	return cols, nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("invalid number %v", v)
+}
+
+// parseTime parses an RFC 3339 time, or a number of seconds since the
+// epoch. Numbers too large to be seconds are taken as milliseconds.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > 1e11 {
+			return time.UnixMilli(int64(f)), nil
+		}
+		return time.UnixMilli(int64(f * 1000)), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
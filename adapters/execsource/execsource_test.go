@@ -0,0 +1,160 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execsource_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/adapters/execsource"
+)
+
+var testArgs = simplejson.QueryArguments{
+	QueryCommonArguments: simplejson.QueryCommonArguments{
+		From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+	},
+	Interval: time.Minute,
+	MaxDPs:   60,
+}
+
+func shell(t *testing.T, script string, opts ...execsource.Opt) *execsource.Source {
+	t.Helper()
+	src, err := execsource.New("/bin/sh", []string{"-c", script, "script"}, opts...)
+	if err != nil {
+		t.Skipf("no shell available: %v", err)
+	}
+	return src
+}
+
+func TestQueryCSV(t *testing.T) {
+	src := shell(t, `
+echo "time,value"
+echo "$GSJ_FROM,$GSJ_INTERVAL_MS"
+echo "$GSJ_TO,"
+echo "1704067230,$2"
+`)
+	dps, err := src.GrafanaQuery(context.Background(), "42", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 3 {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+	if !dps[0].Time.Equal(testArgs.From) || dps[0].Value != 60000 {
+		t.Fatalf("unexpected first point %+v", dps[0])
+	}
+	if dps[1].Value != 42 || !dps[2].Null || !dps[2].Time.Equal(testArgs.To) {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestQueryJSONStdin(t *testing.T) {
+	// The script echoes the query it was given as the value of a point.
+	src := shell(t, `
+q=$(cat)
+case "$q" in
+*'"target":"cpu","type":"timeserie","from":"2024-01-01T00:00:00Z","to":"2024-01-01T01:00:00Z","intervalMs":60000,"maxDataPoints":60'*) echo '[[1.5, 1704067200000], [null, 1704067260000]]' ;;
+*) echo "unexpected query $q" >&2; exit 1 ;;
+esac
+`)
+	dps, err := src.GrafanaQuery(context.Background(), "cpu", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 2 || dps[0].Value != 1.5 || !dps[0].Time.Equal(testArgs.From) || !dps[1].Null {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	tests := []struct {
+		script, expect string
+	}{
+		{
+			`echo '{"columns":[{"text":"host","type":"string"},{"text":"up","type":"boolean"},{"text":"load","type":"number"}],"rows":[["a",true,1.5],["b",false,null]]}'`,
+			`[{"type":"table","columns":[{"text":"host","type":"string"},{"text":"up","type":"boolean"},{"text":"load","type":"number"}],"rows":[["a",true,1.5],["b",false,null]]}]`,
+		},
+		{
+			`printf 'time,host,load\n2024-01-01T00:00:00Z,a,1\n1704067260,b,\n'`,
+			`[{"type":"table","columns":[{"text":"time","type":"time"},{"text":"host","type":"string"},{"text":"load","type":"number"}],"rows":[["2024-01-01T00:00:00Z","a",1],["2024-01-01T00:01:00Z","b",null]]}]`,
+		},
+	}
+	for _, tt := range tests {
+		gsj := simplejson.New(simplejson.WithSource(shell(t, `[ "$GSJ_TYPE" = table ] || exit 1; `+tt.script)))
+		body := `{"range":{"from":"2024-01-01T00:00:00Z","to":"2024-01-01T01:00:00Z"},"targets":[{"target":"hosts","type":"table"}]}`
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body)))
+
+		if w.Body.String() != tt.expect {
+			t.Errorf("\nexpected: %s\ngot:      %s", tt.expect, w.Body.String())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+
+	src := shell(t, "sleep 5", execsource.WithTimeout(50*time.Millisecond))
+	if _, err := src.GrafanaQuery(ctx, "x", testArgs); !errors.Is(err, simplejson.ErrTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	src = shell(t, `while true; do echo "1704067200,1"; done`, execsource.WithMaxOutput(1024))
+	if _, err := src.GrafanaQuery(ctx, "x", testArgs); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected an output limit error, got %v", err)
+	}
+
+	src = shell(t, `echo "broken" >&2; exit 3`)
+	if _, err := src.GrafanaQuery(ctx, "x", testArgs); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the command's error, got %v", err)
+	}
+
+	src = shell(t, `echo "not,a,point"`)
+	if _, err := src.GrafanaQuery(ctx, "x", testArgs); err == nil {
+		t.Fatalf("expected an error for invalid output")
+	}
+}
+
+func TestOptionLikeTarget(t *testing.T) {
+	src := shell(t, `
+[ "$1" = "--" ] || { echo "unexpected arguments $*" >&2; exit 1; }
+echo "1704067230,$2"
+`)
+	dps, err := src.GrafanaQuery(context.Background(), "-5", testArgs)
+	if err != nil || len(dps) != 1 || dps[0].Value != -5 {
+		t.Fatalf("unexpected points %+v, %v", dps, err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	src := shell(t, `echo "$GSJ_FROM,$EXTRA"`, execsource.WithEnv("EXTRA=7"))
+	dps, err := src.GrafanaQuery(context.Background(), "x", testArgs)
+	if err != nil || len(dps) != 1 || dps[0].Value != 7 {
+		t.Fatalf("unexpected points %+v, %v", dps, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := execsource.New("/no/such/command", nil); err == nil {
+		t.Fatalf("expected an error for a missing command")
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filesource provides a SimpleJSON source that serves the
+// contents of local data files, such as CSV exports, so that ad-hoc data
+// can be graphed without loading it into a database.
+//
+// Each file is named by its base name, without extension. A table query
+// for the name returns the rows of the file within the query range, and a
+// timeserie query for "<name>.<column>" returns the values of a numeric
+// column. Files are re-read when they change.
+//
+// Only CSV files are supported directly. In particular Parquet is not
+// decoded by this package, as that would add a Parquet library to the
+// module's dependencies. Parquet, and other formats, can be served by
+// registering a Decoder for their extension, built on a library of the
+// user's choosing, with WithDecoder.
+package filesource
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// Data is the decoded contents of a file. Values are held as strings,
+// and their types inferred when the file is loaded. Empty values are
+// treated as null.
+type Data struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// A Decoder decodes the contents of a file.
+type Decoder func(r io.Reader) (*Data, error)
+
+// DecodeCSV decodes a CSV file whose first record holds the column
+// names.
+func DecodeCSV(r io.Reader) (*Data, error) {
+	cr := csv.NewReader(r)
+	recs, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("missing header record")
+	}
+	return &Data{Columns: recs[0], Rows: recs[1:]}, nil
+}
+
+// Source serves data from files. It implements simplejson.Querier,
+// simplejson.TableQuerier and simplejson.Searcher, and may be passed to
+// simplejson.WithSource.
+type Source struct {
+	timeColumn string
+	decoders   map[string]Decoder
+
+	mu    sync.Mutex
+	files map[string]*file
+}
+
+// An Opt configures a Source.
+type Opt func(*Source) error
+
+// WithFile serves the file at path. The file must exist, and be of a
+// format with a registered decoder.
+func WithFile(path string) Opt {
+	return func(s *Source) error {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if _, ok := s.files[name]; ok {
+			return fmt.Errorf("duplicate file name %q", name)
+		}
+		f := &file{path: path}
+		s.files[name] = f
+		return nil
+	}
+}
+
+// WithTimeColumn sets the name of the column holding the time of each
+// row. The default is "time". Files without the column are served as
+// tables only, ignoring the range of the query.
+func WithTimeColumn(name string) Opt {
+	return func(s *Source) error {
+		s.timeColumn = name
+		return nil
+	}
+}
+
+// WithDecoder registers the decoder for files with extension ext, e.g.
+// ".parquet".
+func WithDecoder(ext string, dec Decoder) Opt {
+	return func(s *Source) error {
+		s.decoders[strings.ToLower(ext)] = dec
+		return nil
+	}
+}
+
+// New creates a Source. Options that add files must be given after any
+// WithDecoder options they rely on.
+func New(opts ...Opt) (*Source, error) {
+	s := &Source{
+		timeColumn: "time",
+		decoders:   map[string]Decoder{".csv": DecodeCSV},
+		files:      map[string]*file{},
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range s.files {
+		if err := s.refresh(f); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// file is a loaded file, and the state used to detect changes to it.
+type file struct {
+	path    string
+	modTime time.Time
+	size    int64
+
+	columns []*column
+	timeIdx int // -1 if there is no time column
+}
+
+type column struct {
+	name string
+	kind kind
+
+	times   []*time.Time
+	numbers []*float64
+	strings []*string
+}
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindNumber
+	kindTime
+)
+
+// refresh reloads f if it has changed since it was last read. The caller
+// must hold s.mu, or have exclusive access to s.
+func (s *Source) refresh(f *file) error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.columns != nil && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+
+	dec, ok := s.decoders[strings.ToLower(filepath.Ext(f.path))]
+	if !ok {
+		return fmt.Errorf("%s: no decoder for files of this type", f.path)
+	}
+	r, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := dec(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	cols, timeIdx, err := s.load(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.columns, f.timeIdx = cols, timeIdx
+	f.modTime, f.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// load converts data to typed columns, sorting the rows by time if there
+// is a time column.
+func (s *Source) load(data *Data) ([]*column, int, error) {
+	timeIdx := -1
+	for i, name := range data.Columns {
+		if name == s.timeColumn {
+			timeIdx = i
+		}
+	}
+	for n, row := range data.Rows {
+		if len(row) != len(data.Columns) {
+			return nil, 0, fmt.Errorf("row %d has %d values, expected %d", n+1, len(row), len(data.Columns))
+		}
+	}
+
+	rows := data.Rows
+	var times []*time.Time
+	if timeIdx != -1 {
+		times = make([]*time.Time, len(rows))
+		for n, row := range rows {
+			if row[timeIdx] == "" {
+				continue
+			}
+			t, err := parseTime(row[timeIdx])
+			if err != nil {
+				return nil, 0, fmt.Errorf("row %d: %w", n+1, err)
+			}
+			times[n] = &t
+		}
+		order := make([]int, len(rows))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			ti, tj := times[order[i]], times[order[j]]
+			return ti != nil && (tj == nil || ti.Before(*tj))
+		})
+		sortedRows := make([][]string, len(rows))
+		sortedTimes := make([]*time.Time, len(rows))
+		for i, o := range order {
+			sortedRows[i], sortedTimes[i] = rows[o], times[o]
+		}
+		rows, times = sortedRows, sortedTimes
+	}
+
+	cols := make([]*column, len(data.Columns))
+	for i, name := range data.Columns {
+		c := &column{name: name}
+		cols[i] = c
+		if i == timeIdx {
+			c.kind, c.times = kindTime, times
+			continue
+		}
+
+		c.kind = kindNumber
+		c.numbers = make([]*float64, len(rows))
+		for n, row := range rows {
+			if row[i] == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				c.kind, c.numbers = kindString, nil
+				break
+			}
+			c.numbers[n] = &v
+		}
+		if c.kind == kindString {
+			c.strings = make([]*string, len(rows))
+			for n, row := range rows {
+				if row[i] != "" {
+					c.strings[n] = &row[i]
+				}
+			}
+		}
+	}
+	return cols, timeIdx, nil
+}
+
+// timeLayouts are tried in turn when parsing times.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+// parseTime parses a time as one of timeLayouts, or as a number of
+// seconds since the epoch. Numbers too large to be seconds are taken as
+// milliseconds.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > 1e11 {
+			return time.UnixMilli(int64(f)), nil
+		}
+		return time.UnixMilli(int64(f * 1000)), nil
+	}
+	for _, l := range timeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time", s)
+}
+
+// lookup returns the named file, reloading it if it has changed.
+func (s *Source) lookup(name string) (*file, error) {
+	f, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown file %q, %w", name, simplejson.ErrNotFound)
+	}
+	if err := s.refresh(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// between returns the indices of the rows of f from from to to. If f has
+// no time column all rows are returned.
+func (f *file) between(from, to time.Time) (int, int) {
+	n := f.columns[0].len()
+	if f.timeIdx == -1 {
+		return 0, n
+	}
+	times := f.columns[f.timeIdx].times
+	i := sort.Search(n, func(i int) bool { return times[i] == nil || !times[i].Before(from) })
+	j := sort.Search(n, func(i int) bool { return times[i] == nil || times[i].After(to) })
+	return i, j
+}
+
+func (c *column) len() int {
+	switch c.kind {
+	case kindTime:
+		return len(c.times)
+	case kindNumber:
+		return len(c.numbers)
+	}
+	return len(c.strings)
+}
+
+// GrafanaQuery implements simplejson.Querier. The target has the form
+// "<file>.<column>", and the column must be numeric.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	name, colName, ok := strings.Cut(target, ".")
+	if !ok {
+		return nil, fmt.Errorf("target %q should have the form file.column, %w", target, simplejson.ErrBadRequest)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.timeIdx == -1 {
+		return nil, fmt.Errorf("file %q has no %s column, %w", name, s.timeColumn, simplejson.ErrBadRequest)
+	}
+	var col *column
+	for _, c := range f.columns {
+		if c.name == colName && c.kind == kindNumber {
+			col = c
+		}
+	}
+	if col == nil {
+		return nil, fmt.Errorf("file %q has no numeric column %q, %w", name, colName, simplejson.ErrNotFound)
+	}
+
+	times := f.columns[f.timeIdx].times
+	i, j := f.between(args.From, args.To)
+	dps := make([]simplejson.DataPoint, 0, j-i)
+	for n := i; n < j; n++ {
+		if col.numbers[n] == nil {
+			dps = append(dps, simplejson.NullDataPoint(*times[n]))
+			continue
+		}
+		dps = append(dps, simplejson.DataPoint{Time: *times[n], Value: *col.numbers[n]})
+	}
+	return dps, nil
+}
+
+// GrafanaQueryTable implements simplejson.TableQuerier, returning the
+// rows of the file named by target.
+func (s *Source) GrafanaQueryTable(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.lookup(target)
+	if err != nil {
+		return nil, err
+	}
+
+	i, j := f.between(args.From, args.To)
+	res := make([]simplejson.TableColumn, len(f.columns))
+	for n, c := range f.columns {
+		res[n] = simplejson.TableColumn{Text: c.name}
+		switch c.kind {
+		case kindTime:
+			res[n].Data = simplejson.Column[*time.Time](c.times[i:j])
+		case kindNumber:
+			res[n].Data = simplejson.Column[*float64](c.numbers[i:j])
+		default:
+			res[n].Data = simplejson.Column[*string](c.strings[i:j])
+		}
+	}
+	return res, nil
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the file names
+// and timeserie targets containing target.
+func (s *Source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := []string{}
+	for name, f := range s.files {
+		if err := s.refresh(f); err != nil {
+			return nil, err
+		}
+		if strings.Contains(name, target) {
+			res = append(res, name)
+		}
+		if f.timeIdx == -1 {
+			continue
+		}
+		for _, c := range f.columns {
+			if t := name + "." + c.name; c.kind == kindNumber && strings.Contains(t, target) {
+				res = append(res, t)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesource_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/adapters/filesource"
+)
+
+const testCSV = `time,host,load
+2024-01-01T00:02:00Z,a,3
+2024-01-01T00:00:00Z,a,1
+2024-01-01T00:01:00Z,b,
+`
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+var testArgs = simplejson.QueryArguments{
+	QueryCommonArguments: simplejson.QueryCommonArguments{
+		From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC),
+	},
+}
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.csv")
+	writeFile(t, path, testCSV)
+	src, err := filesource.New(filesource.WithFile(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dps, err := src.GrafanaQuery(context.Background(), "hosts.load", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 2 || dps[0].Value != 1 || !dps[1].Null || !dps[1].Time.Equal(testArgs.To) {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+
+	for _, target := range []string{"hosts", "hosts.host", "hosts.missing", "other.load"} {
+		if _, err := src.GrafanaQuery(context.Background(), target, testArgs); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.csv")
+	writeFile(t, path, testCSV)
+	src, _ := filesource.New(filesource.WithFile(path))
+	gsj := simplejson.New(simplejson.WithSource(src))
+
+	body := `{"range":{"from":"2024-01-01T00:00:00Z","to":"2024-01-01T00:01:00Z"},"targets":[{"target":"hosts","type":"table"}]}`
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body)))
+
+	expect := `[{"type":"table","columns":[{"text":"time","type":"time"},{"text":"host","type":"string"},{"text":"load","type":"number"}],"rows":[["2024-01-01T00:00:00Z","a",1],["2024-01-01T00:01:00Z","b",null]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.csv")
+	writeFile(t, path, testCSV)
+	src, _ := filesource.New(filesource.WithFile(path))
+
+	writeFile(t, path, "time,load,mem\n1704067200,5,1\n")
+	dps, err := src.GrafanaQuery(context.Background(), "hosts.load", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 1 || dps[0].Value != 5 {
+		t.Fatalf("expected the file to be reloaded, got %+v", dps)
+	}
+
+	res, _ := src.GrafanaSearch(context.Background(), "")
+	if strings.Join(res, ",") != "hosts,hosts.load,hosts.mem" {
+		t.Fatalf("unexpected search results %v", res)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.tsv")
+	writeFile(t, path, "ts\tcount\n2024-01-01 00:00:00\t7\n")
+
+	tsv := func(r io.Reader) (*filesource.Data, error) {
+		var d filesource.Data
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			fields := strings.Split(sc.Text(), "\t")
+			if d.Columns == nil {
+				d.Columns = fields
+				continue
+			}
+			d.Rows = append(d.Rows, fields)
+		}
+		return &d, sc.Err()
+	}
+	src, err := filesource.New(
+		filesource.WithDecoder(".tsv", tsv),
+		filesource.WithTimeColumn("ts"),
+		filesource.WithFile(path),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dps, err := src.GrafanaQuery(context.Background(), "events.count", testArgs)
+	if err != nil || len(dps) != 1 || dps[0].Value != 7 {
+		t.Fatalf("unexpected points %+v, %v", dps, err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), "{}")
+	writeFile(t, filepath.Join(dir, "bad.csv"), "time,v\nyesterday,1\n")
+
+	for _, path := range []string{"a.json", "missing.csv", "bad.csv"} {
+		if _, err := filesource.New(filesource.WithFile(filepath.Join(dir, path))); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+
+	src, _ := filesource.New()
+	if _, err := src.GrafanaQueryTable(context.Background(), "nope", simplejson.TableQueryArguments{}); !errors.Is(err, simplejson.ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package graphite provides a SimpleJSON source that forwards queries to
+// the render API of a Graphite server, allowing a datasource built with
+// simplejson to sit in front of Graphite, e.g. to enrich or combine its
+// data.
+//
+//	src, err := graphite.New("http://graphite:8080")
+//	...
+//	http.ListenAndServe(":8080", simplejson.New(simplejson.WithSource(src)))
+package graphite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// Source queries a Graphite server. It implements simplejson.Querier and
+// simplejson.Searcher, and may be passed to simplejson.WithSource.
+type Source struct {
+	baseURL *url.URL
+	hc      *http.Client
+	header  http.Header
+}
+
+// An Opt configures a Source.
+type Opt func(*Source) error
+
+// WithHTTPClient sets the http.Client used to make requests, the default
+// is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Opt {
+	return func(s *Source) error {
+		s.hc = hc
+		return nil
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authentication.
+func WithHeader(key, value string) Opt {
+	return func(s *Source) error {
+		s.header.Add(key, value)
+		return nil
+	}
+}
+
+// New creates a Source for the Graphite server at baseURL.
+func New(baseURL string, opts ...Opt) (*Source, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Graphite URL %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	s := &Source{baseURL: u, hc: http.DefaultClient, header: http.Header{}}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// GrafanaQuery implements simplejson.Querier, rendering the Graphite
+// target over the range of args. The target must render at most one
+// series, targets matching several should be combined, e.g. with
+// sumSeries(). The maximum number of datapoints is passed to Graphite,
+// which consolidates the series to fit.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	params := url.Values{
+		"target": {target},
+		"from":   {strconv.FormatInt(args.From.Unix(), 10)},
+		"until":  {strconv.FormatInt(args.To.Unix(), 10)},
+		"format": {"json"},
+	}
+	if args.MaxDPs > 0 {
+		params.Set("maxDataPoints", strconv.Itoa(args.MaxDPs))
+	}
+
+	var res []struct {
+		Target     string        `json:"target"`
+		DataPoints [][2]*float64 `json:"datapoints"`
+	}
+	if err := s.do(ctx, "/render", params, &res); err != nil {
+		return nil, err
+	}
+
+	switch len(res) {
+	case 0:
+		return []simplejson.DataPoint{}, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("target rendered %d series, it should be combined to render one, %w", len(res), simplejson.ErrBadRequest)
+	}
+
+	dps := make([]simplejson.DataPoint, 0, len(res[0].DataPoints))
+	for _, p := range res[0].DataPoints {
+		if p[1] == nil {
+			continue
+		}
+		t := time.Unix(int64(*p[1]), 0)
+		if p[0] == nil {
+			dps = append(dps, simplejson.NullDataPoint(t))
+			continue
+		}
+		dps = append(dps, simplejson.DataPoint{Time: t, Value: *p[0]})
+	}
+	return dps, nil
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the paths of
+// the metrics and branches matching the Graphite pattern target, e.g.
+// "servers.*.cpu". An empty target lists the top level of the tree.
+func (s *Source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	if target == "" {
+		target = "*"
+	}
+
+	var res []struct {
+		ID   string `json:"id"`
+		Text string `json:"text"`
+	}
+	if err := s.do(ctx, "/metrics/find", url.Values{"query": {target}}, &res); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(res))
+	for i, r := range res {
+		paths[i] = r.ID
+	}
+	return paths, nil
+}
+
+// do makes a GET request to path, decoding the JSON response into res.
+func (s *Source) do(ctx context.Context, path string, params url.Values, res interface{}) error {
+	u := *s.baseURL
+	u.Path += path
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range s.header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("graphite: %v, %w", err, simplejson.ErrUnavailable)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Graphite reports errors, such as invalid targets, as plain
+		// text.
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		kind := simplejson.ErrUnavailable
+		if resp.StatusCode == http.StatusBadRequest {
+			kind = simplejson.ErrBadRequest
+		}
+		return fmt.Errorf("graphite: status %d, %s, %w", resp.StatusCode, strings.TrimSpace(string(msg)), kind)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("invalid response from Graphite, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/adapters/graphite"
+)
+
+func fakeGraphite(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /render", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("from") != "1000" || q.Get("until") != "1120" || q.Get("format") != "json" || q.Get("maxDataPoints") != "100" {
+			t.Errorf("unexpected query %v", q)
+		}
+		switch q.Get("target") {
+		case "servers.a.cpu":
+			fmt.Fprint(w, `[{"target":"servers.a.cpu","datapoints":[[1.5,1000],[null,1060],[2,1120]]}]`)
+		case "servers.*.cpu":
+			fmt.Fprint(w, `[{"target":"a","datapoints":[]},{"target":"b","datapoints":[]}]`)
+		case "nothing":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.Error(w, "invalid target", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("GET /metrics/find", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("query") {
+		case "*":
+			fmt.Fprint(w, `[{"id":"servers","text":"servers","leaf":0},{"id":"carbon","text":"carbon","leaf":0}]`)
+		case "servers.*":
+			fmt.Fprint(w, `[{"id":"servers.a","text":"a","leaf":0}]`)
+		default:
+			fmt.Fprint(w, `[]`)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var testArgs = simplejson.QueryArguments{
+	QueryCommonArguments: simplejson.QueryCommonArguments{From: time.Unix(1000, 0), To: time.Unix(1120, 0)},
+	MaxDPs:               100,
+}
+
+func TestQuery(t *testing.T) {
+	src, err := graphite.New(fakeGraphite(t).URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	dps, err := src.GrafanaQuery(ctx, "servers.a.cpu", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 3 || dps[0].Value != 1.5 || !dps[1].Null || !dps[2].Time.Equal(time.Unix(1120, 0)) {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+
+	if dps, err := src.GrafanaQuery(ctx, "nothing", testArgs); err != nil || len(dps) != 0 {
+		t.Fatalf("expected no points, got %v, %v", dps, err)
+	}
+	if _, err := src.GrafanaQuery(ctx, "servers.*.cpu", testArgs); !errors.Is(err, simplejson.ErrBadRequest) {
+		t.Fatalf("expected a bad request for multiple series, got %v", err)
+	}
+	if _, err := src.GrafanaQuery(ctx, "sumSeries(", testArgs); !errors.Is(err, simplejson.ErrBadRequest) {
+		t.Fatalf("expected a bad request, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	src, _ := graphite.New(fakeGraphite(t).URL)
+	ctx := context.Background()
+
+	res, err := src.GrafanaSearch(ctx, "")
+	if err != nil || len(res) != 2 || res[0] != "servers" {
+		t.Fatalf("unexpected results %v, %v", res, err)
+	}
+	if res, _ = src.GrafanaSearch(ctx, "servers.*"); len(res) != 1 || res[0] != "servers.a" {
+		t.Fatalf("unexpected results %v", res)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	src, _ := graphite.New(srv.URL)
+	if _, err := src.GrafanaSearch(context.Background(), ""); !errors.Is(err, simplejson.ErrUnavailable) {
+		t.Fatalf("expected an unavailable error, got %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheus provides a SimpleJSON source that answers queries
+// using the HTTP API of a Prometheus server. Targets are PromQL
+// expressions, evaluated over the range of the query.
+//
+//	src, err := prometheus.New("http://prometheus:9090")
+//	...
+//	http.ListenAndServe(":8080", simplejson.New(simplejson.WithSource(src)))
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+const (
+	// maxResolution is the largest number of points per series that
+	// Prometheus will return for a range query.
+	maxResolution = 11000
+
+	// defaultPoints is the number of points requested when a query gives
+	// neither an interval nor a maximum number of datapoints.
+	defaultPoints = 1000
+)
+
+// Source queries a Prometheus server. It implements simplejson.Querier,
+// simplejson.Searcher and simplejson.TagSearcher, and may be passed to
+// simplejson.WithSource.
+type Source struct {
+	baseURL *url.URL
+	hc      *http.Client
+	header  http.Header
+}
+
+// An Opt configures a Source.
+type Opt func(*Source) error
+
+// WithHTTPClient sets the http.Client used to make requests, the default
+// is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Opt {
+	return func(s *Source) error {
+		s.hc = hc
+		return nil
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authentication.
+func WithHeader(key, value string) Opt {
+	return func(s *Source) error {
+		s.header.Add(key, value)
+		return nil
+	}
+}
+
+// New creates a Source for the Prometheus server at baseURL.
+func New(baseURL string, opts ...Opt) (*Source, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Prometheus URL %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	s := &Source{baseURL: u, hc: http.DefaultClient, header: http.Header{}}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Step returns the resolution used to query the range of args. It is the
+// interval of the query, widened if needed so that no more than
+// args.MaxDPs points are returned.
+func Step(args simplejson.QueryArguments) time.Duration {
+	rng := args.To.Sub(args.From)
+	maxPoints := maxResolution
+	if args.MaxDPs > 0 {
+		maxPoints = min(args.MaxDPs, maxResolution)
+	}
+
+	step := args.Interval
+	if step <= 0 {
+		step = rng / time.Duration(min(maxPoints, defaultPoints))
+	}
+	if minStep := (rng + time.Duration(maxPoints) - 1) / time.Duration(maxPoints); step < minStep {
+		step = minStep
+	}
+	// Prometheus only accepts steps of whole milliseconds, rounding up
+	// ensures the limit on the number of points is not exceeded.
+	return max((step + time.Millisecond - 1).Truncate(time.Millisecond), time.Millisecond)
+}
+
+// GrafanaQuery implements simplejson.Querier, evaluating the PromQL
+// expression target over the range of args. The expression must return
+// at most one series, queries returning more should be aggregated, e.g.
+// with sum(). The labels of the series are attached with
+// simplejson.SetLabels.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	params := url.Values{
+		"query": {target},
+		"start": {formatTime(args.From)},
+		"end":   {formatTime(args.To)},
+		"step":  {strconv.FormatFloat(Step(args).Seconds(), 'f', -1, 64)},
+	}
+
+	var res struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	}
+	if err := s.do(ctx, "/api/v1/query_range", params, &res); err != nil {
+		return nil, err
+	}
+	if res.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected result type %q", res.ResultType)
+	}
+
+	switch len(res.Result) {
+	case 0:
+		return []simplejson.DataPoint{}, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("query returned %d series, it should be aggregated to return one, %w", len(res.Result), simplejson.ErrBadRequest)
+	}
+
+	series := res.Result[0]
+	simplejson.SetLabels(ctx, series.Metric)
+
+	dps := make([]simplejson.DataPoint, len(series.Values))
+	for i, v := range series.Values {
+		dp, err := parseSample(v)
+		if err != nil {
+			return nil, err
+		}
+		dps[i] = dp
+	}
+	return dps, nil
+}
+
+// parseSample parses a [<unix seconds>, "<value>"] pair.
+func parseSample(v [2]interface{}) (simplejson.DataPoint, error) {
+	ts, ok := v[0].(float64)
+	if !ok {
+		return simplejson.DataPoint{}, fmt.Errorf("invalid sample time %v", v[0])
+	}
+	str, ok := v[1].(string)
+	if !ok {
+		return simplejson.DataPoint{}, fmt.Errorf("invalid sample value %v", v[1])
+	}
+	val, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return simplejson.DataPoint{}, fmt.Errorf("invalid sample value %q", str)
+	}
+	sec, frac := math.Modf(ts)
+	return simplejson.DataPoint{
+		Time:  time.Unix(int64(sec), int64(math.Round(frac*1000))*int64(time.Millisecond)),
+		Value: val,
+	}, nil
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the metric
+// names that contain target. A target of the form "label:<name>" returns
+// the values of the label instead.
+func (s *Source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	label, filter := "__name__", target
+	if l, ok := strings.CutPrefix(target, "label:"); ok {
+		label, filter = l, ""
+	}
+
+	vals, err := s.labelValues(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, v := range vals {
+		if strings.Contains(v, filter) {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+// GrafanaAdhocFilterTags implements simplejson.TagSearcher, returning
+// the label names known to Prometheus.
+func (s *Source) GrafanaAdhocFilterTags(ctx context.Context) ([]simplejson.TagInfoer, error) {
+	var names []string
+	if err := s.do(ctx, "/api/v1/labels", nil, &names); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	res := make([]simplejson.TagInfoer, len(names))
+	for i, n := range names {
+		res[i] = simplejson.TagStringKey(n)
+	}
+	return res, nil
+}
+
+// GrafanaAdhocFilterTagValues implements simplejson.TagSearcher,
+// returning the values of the label key.
+func (s *Source) GrafanaAdhocFilterTagValues(ctx context.Context, key string) ([]simplejson.TagValuer, error) {
+	vals, err := s.labelValues(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]simplejson.TagValuer, len(vals))
+	for i, v := range vals {
+		res[i] = simplejson.TagStringValue(v)
+	}
+	return res, nil
+}
+
+func (s *Source) labelValues(ctx context.Context, label string) ([]string, error) {
+	var vals []string
+	if err := s.do(ctx, "/api/v1/label/"+url.PathEscape(label)+"/values", nil, &vals); err != nil {
+		return nil, err
+	}
+	sort.Strings(vals)
+	return vals, nil
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+// apiResponse is the envelope of all Prometheus API responses.
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// do makes a request to the API endpoint at path, decoding the data of
+// the response into res. Requests with params are POSTed as a form.
+func (s *Source) do(ctx context.Context, path string, params url.Values, res interface{}) error {
+	u := *s.baseURL
+	u.Path += path
+
+	method, body := http.MethodGet, io.Reader(nil)
+	if params != nil {
+		method, body = http.MethodPost, strings.NewReader(params.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	for k, vs := range s.header {
+		req.Header[k] = vs
+	}
+	if params != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("prometheus: %v, %w", err, simplejson.ErrUnavailable)
+	}
+	defer resp.Body.Close()
+
+	var ar apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return fmt.Errorf("invalid response from Prometheus, status %d, %w", resp.StatusCode, err)
+	}
+	if ar.Status != "success" {
+		return apiError(ar)
+	}
+	return json.Unmarshal(ar.Data, res)
+}
+
+// apiError maps a Prometheus error to one reported to Grafana with a
+// suitable status.
+func apiError(ar apiResponse) error {
+	var kind error
+	switch ar.ErrorType {
+	case "bad_data":
+		kind = simplejson.ErrBadRequest
+	case "timeout", "canceled":
+		kind = simplejson.ErrTimeout
+	case "unavailable":
+		kind = simplejson.ErrUnavailable
+	default:
+		return errors.New("prometheus: " + ar.Error)
+	}
+	return fmt.Errorf("prometheus: %s, %w", ar.Error, kind)
+}
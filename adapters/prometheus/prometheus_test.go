@@ -0,0 +1,162 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/adapters/prometheus"
+)
+
+func fakePrometheus(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/query_range", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("query") {
+		case "up":
+			if r.Form.Get("start") != "1000" || r.Form.Get("end") != "1060" || r.Form.Get("step") != "30" {
+				t.Errorf("unexpected range %v", r.Form)
+			}
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"__name__":"up","job":"a"},"values":[[1000,"1"],[1030.5,"NaN"],[1060,"0"]]}]}}`)
+		case "none":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+		case "many":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[]},{"metric":{},"values":[]}]}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+		}
+	})
+	mux.HandleFunc("GET /api/v1/labels", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":["job","__name__"]}`)
+	})
+	mux.HandleFunc("GET /api/v1/label/{name}/values", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("name") {
+		case "__name__":
+			fmt.Fprint(w, `{"status":"success","data":["up","node_cpu_seconds_total","node_load1"]}`)
+		case "job":
+			fmt.Fprint(w, `{"status":"success","data":["b","a"]}`)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestQuery(t *testing.T) {
+	src, err := prometheus.New(fakePrometheus(t).URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args := simplejson.QueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: time.Unix(1000, 0), To: time.Unix(1060, 0)},
+		Interval:             30 * time.Second,
+	}
+
+	dps, err := src.GrafanaQuery(context.Background(), "up", args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 3 || dps[0].Value != 1 || !math.IsNaN(dps[1].Value) || dps[2].Value != 0 {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+	if !dps[1].Time.Equal(time.UnixMilli(1030500)) {
+		t.Fatalf("unexpected time %v", dps[1].Time)
+	}
+
+	if dps, err := src.GrafanaQuery(context.Background(), "none", args); err != nil || len(dps) != 0 {
+		t.Fatalf("expected no points, got %v, %v", dps, err)
+	}
+	if _, err := src.GrafanaQuery(context.Background(), "many", args); !errors.Is(err, simplejson.ErrBadRequest) {
+		t.Fatalf("expected a bad request for multiple series, got %v", err)
+	}
+	if _, err := src.GrafanaQuery(context.Background(), "up{", args); !errors.Is(err, simplejson.ErrBadRequest) {
+		t.Fatalf("expected a bad request, got %v", err)
+	}
+}
+
+func TestStep(t *testing.T) {
+	from := time.Unix(0, 0)
+	tests := []struct {
+		rng, interval time.Duration
+		maxDPs        int
+		expect        time.Duration
+	}{
+		{time.Hour, time.Minute, 0, time.Minute},
+		{time.Hour, time.Second, 60, time.Minute},
+		{time.Hour, 0, 120, 30 * time.Second},
+		{time.Hour, 0, 0, 3600 * time.Millisecond},
+		{30 * 24 * time.Hour, time.Second, 0, 235637 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := prometheus.Step(simplejson.QueryArguments{
+			QueryCommonArguments: simplejson.QueryCommonArguments{From: from, To: from.Add(tt.rng)},
+			Interval:             tt.interval,
+			MaxDPs:               tt.maxDPs,
+		})
+		if got != tt.expect {
+			t.Errorf("%v/%v/%d: expected %v, got %v", tt.rng, tt.interval, tt.maxDPs, tt.expect, got)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	src, _ := prometheus.New(fakePrometheus(t).URL)
+	ctx := context.Background()
+
+	res, err := src.GrafanaSearch(ctx, "node")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 2 || res[0] != "node_cpu_seconds_total" || res[1] != "node_load1" {
+		t.Fatalf("unexpected results %v", res)
+	}
+
+	if res, _ = src.GrafanaSearch(ctx, "label:job"); len(res) != 2 || res[0] != "a" {
+		t.Fatalf("unexpected results %v", res)
+	}
+
+	keys, err := src.GrafanaAdhocFilterTags(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("unexpected keys %v, %v", keys, err)
+	}
+	vals, err := src.GrafanaAdhocFilterTagValues(ctx, "job")
+	if err != nil || len(vals) != 2 {
+		t.Fatalf("unexpected values %v, %v", vals, err)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	src, _ := prometheus.New(srv.URL)
+	if _, err := src.GrafanaSearch(context.Background(), ""); !errors.Is(err, simplejson.ErrUnavailable) {
+		t.Fatalf("expected an unavailable error, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := prometheus.New("prometheus:9090"); err == nil {
+		t.Fatalf("expected an error for a URL without a scheme")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed JSONPath expression, supporting only the subset
+// needed to pick values out of typical API responses.
+type jsonPath []pathStep
+
+// A pathStep selects a member by key, an element by index, or, if
+// wildcard is set, all members or elements.
+type pathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(expr string) (jsonPath, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(expr), "$")
+	if !ok {
+		return nil, fmt.Errorf("JSONPath %q must begin with $", expr)
+	}
+
+	var p jsonPath
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			return nil, fmt.Errorf("JSONPath %q: recursive descent is not supported", expr)
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			switch key {
+			case "":
+				return nil, fmt.Errorf("JSONPath %q: empty key", expr)
+			case "*":
+				p = append(p, pathStep{wildcard: true})
+			default:
+				p = append(p, pathStep{key: key})
+			}
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath %q: unterminated [", expr)
+			}
+			sel := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			switch {
+			case sel == "*":
+				p = append(p, pathStep{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				p = append(p, pathStep{key: sel[1 : len(sel)-1]})
+			default:
+				n, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("JSONPath %q: invalid selector [%s]", expr, sel)
+				}
+				p = append(p, pathStep{index: n, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("JSONPath %q: unexpected %q", expr, rest)
+		}
+	}
+	return p, nil
+}
+
+// eval returns the values selected from v. Steps that do not match
+// select nothing.
+func (p jsonPath) eval(v interface{}) []interface{} {
+	cur := []interface{}{v}
+	for _, step := range p {
+		var next []interface{}
+		for _, c := range cur {
+			switch c := c.(type) {
+			case map[string]interface{}:
+				switch {
+				case step.wildcard:
+					for _, v := range c {
+						next = append(next, v)
+					}
+				case !step.isIndex:
+					if v, ok := c[step.key]; ok {
+						next = append(next, v)
+					}
+				}
+			case []interface{}:
+				switch {
+				case step.wildcard:
+					next = append(next, c...)
+				case step.isIndex:
+					i := step.index
+					if i < 0 {
+						i += len(c)
+					}
+					if i >= 0 && i < len(c) {
+						next = append(next, c[i])
+					}
+				}
+			}
+		}
+		cur = next
+	}
+	return cur
+}
@@ -0,0 +1,375 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rest provides a SimpleJSON source that answers queries by
+// calling an upstream REST API, so that arbitrary third-party APIs can be
+// graphed without bespoke code.
+//
+// Each target is configured with an Endpoint, giving the request to make,
+// and how to extract the datapoints from the JSON response, either with
+// JSONPath expressions or a Go template:
+//
+//	src, err := rest.New(rest.WithEndpoint("btc", rest.Endpoint{
+//		URL:   "https://api.example.com/prices?from={{unix .From}}&to={{unix .To}}",
+//		Items: "$.data.prices[*]",
+//		Time:  "$.timestamp",
+//		Value: "$.price",
+//	}))
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// maxResponseBytes is the largest upstream response that will be read.
+const maxResponseBytes = 64 << 20
+
+// An Endpoint describes the upstream request made for a target, and how
+// to extract datapoints from the response.
+//
+// URL and Body are Go templates, executed with a Request. As well as the
+// standard functions, templates may use unix and unixMilli to format a
+// time as seconds or milliseconds since the epoch, rfc3339 to format it
+// as a string, and query to escape a string for use in a URL.
+//
+// Datapoints are extracted with either JSONPath, or a template. With
+// JSONPath, Items selects the elements of the response holding each point,
+// and Time and Value select the time and value from each element. The
+// supported JSONPath syntax is $, .key, ['key'], [n] and [*].
+//
+// Alternatively, Template is executed with the decoded response, and
+// should produce a line for each point, holding the time and value
+// separated by whitespace.
+//
+// Times may be numbers of seconds, or milliseconds, since the epoch, or
+// strings in the format of TimeLayout, which defaults to RFC 3339. Values
+// may be numbers, numeric strings or booleans, and null values give null
+// points.
+type Endpoint struct {
+	Method string // defaults to GET
+	URL    string
+	Header http.Header
+	Body   string
+
+	Items string
+	Time  string
+	Value string
+
+	Template string
+
+	TimeLayout string
+}
+
+// Request is the data available to the templates of an Endpoint.
+type Request struct {
+	Target        string
+	From, To      time.Time
+	Interval      time.Duration
+	MaxDataPoints int
+}
+
+var templateFuncs = template.FuncMap{
+	"unix":      func(t time.Time) int64 { return t.Unix() },
+	"unixMilli": func(t time.Time) int64 { return t.UnixMilli() },
+	"rfc3339":   func(t time.Time) string { return t.Format(time.RFC3339) },
+	"query":     url.QueryEscape,
+}
+
+// endpoint is a compiled Endpoint.
+type endpoint struct {
+	Endpoint
+
+	url, body, extract *template.Template
+	items, time, value jsonPath
+}
+
+// Source calls upstream REST APIs. It implements simplejson.Querier and
+// simplejson.Searcher, and may be passed to simplejson.WithSource.
+type Source struct {
+	hc        *http.Client
+	endpoints map[string]*endpoint
+}
+
+// An Opt configures a Source.
+type Opt func(*Source) error
+
+// WithHTTPClient sets the http.Client used to make requests, the default
+// is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Opt {
+	return func(s *Source) error {
+		s.hc = hc
+		return nil
+	}
+}
+
+// WithEndpoint configures the endpoint called for target.
+func WithEndpoint(target string, ep Endpoint) Opt {
+	return func(s *Source) error {
+		c, err := compile(ep)
+		if err != nil {
+			return fmt.Errorf("endpoint for %q: %w", target, err)
+		}
+		s.endpoints[target] = c
+		return nil
+	}
+}
+
+// New creates a Source.
+func New(opts ...Opt) (*Source, error) {
+	s := &Source{hc: http.DefaultClient, endpoints: map[string]*endpoint{}}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func compile(ep Endpoint) (*endpoint, error) {
+	c := &endpoint{Endpoint: ep}
+	if c.Method == "" {
+		c.Method = http.MethodGet
+	}
+
+	var err error
+	if c.url, err = template.New("url").Funcs(templateFuncs).Parse(ep.URL); err != nil {
+		return nil, err
+	}
+	if c.body, err = template.New("body").Funcs(templateFuncs).Parse(ep.Body); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ep.Template != "" && ep.Items != "":
+		return nil, fmt.Errorf("only one of Template and Items may be given")
+	case ep.Template != "":
+		c.extract, err = template.New("extract").Funcs(templateFuncs).Parse(ep.Template)
+		return c, err
+	case ep.Items == "" || ep.Time == "" || ep.Value == "":
+		return nil, fmt.Errorf("either Template, or Items, Time and Value must be given")
+	}
+
+	if c.items, err = parseJSONPath(ep.Items); err != nil {
+		return nil, err
+	}
+	if c.time, err = parseJSONPath(ep.Time); err != nil {
+		return nil, err
+	}
+	if c.value, err = parseJSONPath(ep.Value); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the configured
+// targets that contain target.
+func (s *Source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	res := []string{}
+	for name := range s.endpoints {
+		if strings.Contains(name, target) {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// GrafanaQuery implements simplejson.Querier.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	ep, ok := s.endpoints[target]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %q, %w", target, simplejson.ErrNotFound)
+	}
+
+	resp, err := s.call(ctx, ep, Request{
+		Target:        target,
+		From:          args.From,
+		To:            args.To,
+		Interval:      args.Interval,
+		MaxDataPoints: args.MaxDPs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	series := simplejson.NewSeries(target)
+	if ep.extract != nil {
+		err = ep.extractTemplate(resp, series)
+	} else {
+		err = ep.extractJSONPath(resp, series)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", target, err)
+	}
+	return series.Points(), nil
+}
+
+// call makes the upstream request, returning the decoded response.
+func (s *Source) call(ctx context.Context, ep *endpoint, r Request) (interface{}, error) {
+	var u, body bytes.Buffer
+	if err := ep.url.Execute(&u, r); err != nil {
+		return nil, err
+	}
+	if err := ep.body.Execute(&body, r); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, ep.Method, u.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range ep.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream request failed, %v, %w", err, simplejson.ErrUnavailable)
+	}
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("upstream returned status %d, %w", resp.StatusCode, simplejson.ErrUnavailable)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return nil, fmt.Errorf("invalid upstream response, %w", err)
+	}
+	return v, nil
+}
+
+func (ep *endpoint) extractJSONPath(resp interface{}, series *simplejson.Series) error {
+	for _, item := range ep.items.eval(resp) {
+		ts := ep.time.eval(item)
+		vs := ep.value.eval(item)
+		if len(ts) != 1 || len(vs) != 1 {
+			return fmt.Errorf("expected a single time and value for each item, got %d and %d", len(ts), len(vs))
+		}
+		if err := ep.addPoint(series, ts[0], vs[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ep *endpoint) extractTemplate(resp interface{}, series *simplejson.Series) error {
+	var out bytes.Buffer
+	if err := ep.extract.Execute(&out, resp); err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+		default:
+			return fmt.Errorf("template output %q should hold a time and a value", sc.Text())
+		}
+		var v interface{} = fields[1]
+		if fields[1] == "null" || fields[1] == "<nil>" {
+			v = nil
+		}
+		if err := ep.addPoint(series, fields[0], v); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func (ep *endpoint) addPoint(series *simplejson.Series, tv, vv interface{}) error {
+	t, err := ep.parseTime(tv)
+	if err != nil {
+		return err
+	}
+	if vv == nil {
+		series.AddNull(t)
+		return nil
+	}
+	v, err := parseValue(vv)
+	if err != nil {
+		return err
+	}
+	series.Add(t, v)
+	return nil
+}
+
+func parseValue(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return parseValue(b)
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", v)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("invalid value %v", v)
+}
+
+func (ep *endpoint) parseTime(v interface{}) (time.Time, error) {
+	if s, ok := v.(string); ok {
+		layout := ep.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+		v = f
+	}
+
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time %v", v)
+	}
+	// Numbers too large to be seconds are taken as milliseconds.
+	if f > 1e11 {
+		return time.UnixMilli(int64(f)), nil
+	}
+	return time.UnixMilli(int64(f * 1000)), nil
+}
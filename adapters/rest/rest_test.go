@@ -0,0 +1,144 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/adapters/rest"
+)
+
+var testArgs = simplejson.QueryArguments{
+	QueryCommonArguments: simplejson.QueryCommonArguments{From: time.Unix(1000, 0), To: time.Unix(2000, 0)},
+	Interval:             time.Minute,
+}
+
+func upstream(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /prices", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("from") != "1000" || r.URL.Query().Get("to") != "2000000" || r.URL.Query().Get("sym") != "a b" {
+			t.Errorf("unexpected query %v", r.URL.Query())
+		}
+		fmt.Fprint(w, `{"data":{"prices":[
+			{"ts":1060,"price":"2"},
+			{"ts":1000,"price":1},
+			{"ts":"1970-01-01T00:20:00Z","price":null}
+		]}}`)
+	})
+	mux.HandleFunc("POST /search", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"interval":"1m0s"}` || r.Header.Get("X-Key") != "k" {
+			t.Errorf("unexpected request %s, %v", body, r.Header)
+		}
+		fmt.Fprint(w, `[[1000, 5], [1060, true]]`)
+	})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJSONPath(t *testing.T) {
+	srv := upstream(t)
+	src, err := rest.New(rest.WithEndpoint("price", rest.Endpoint{
+		URL:   srv.URL + `/prices?from={{unix .From}}&to={{unixMilli .To}}&sym={{query "a b"}}`,
+		Items: "$.data['prices'][*]",
+		Time:  "$.ts",
+		Value: "$.price",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dps, err := src.GrafanaQuery(context.Background(), "price", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 3 || dps[0].Value != 1 || dps[1].Value != 2 || !dps[1].Time.Equal(time.Unix(1060, 0)) || !dps[2].Null {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	srv := upstream(t)
+	src, err := rest.New(rest.WithEndpoint("search", rest.Endpoint{
+		Method:   http.MethodPost,
+		URL:      srv.URL + "/search",
+		Header:   http.Header{"X-Key": {"k"}},
+		Body:     `{"interval":"{{.Interval}}"}`,
+		Template: `{{range .}}{{index . 0}} {{index . 1}}{{"\n"}}{{end}}`,
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dps, err := src.GrafanaQuery(context.Background(), "search", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 2 || dps[0].Value != 5 || dps[1].Value != 1 {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := upstream(t)
+	src, err := rest.New(
+		rest.WithEndpoint("fail", rest.Endpoint{URL: srv.URL + "/fail", Items: "$[*]", Time: "$[0]", Value: "$[1]"}),
+		rest.WithEndpoint("multi", rest.Endpoint{URL: srv.URL + "/prices?from=1000&to=2000000&sym=a+b", Items: "$.data", Time: "$.prices[*].ts", Value: "$.x"}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := src.GrafanaQuery(context.Background(), "fail", testArgs); !errors.Is(err, simplejson.ErrUnavailable) {
+		t.Fatalf("expected an unavailable error, got %v", err)
+	}
+	if _, err := src.GrafanaQuery(context.Background(), "multi", testArgs); err == nil {
+		t.Fatalf("expected an error for paths selecting several values")
+	}
+	if _, err := src.GrafanaQuery(context.Background(), "nope", testArgs); !errors.Is(err, simplejson.ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	res, _ := src.GrafanaSearch(context.Background(), "")
+	if len(res) != 2 || res[0] != "fail" || res[1] != "multi" {
+		t.Fatalf("unexpected search results %v", res)
+	}
+}
+
+func TestInvalidEndpoints(t *testing.T) {
+	for _, ep := range []rest.Endpoint{
+		{URL: "{{.Nope"},
+		{URL: "http://x", Items: "$[*]"},
+		{URL: "http://x", Items: "data", Time: "$.t", Value: "$.v"},
+		{URL: "http://x", Items: "$..data", Time: "$.t", Value: "$.v"},
+		{URL: "http://x", Items: "$[x]", Time: "$.t", Value: "$.v"},
+		{URL: "http://x", Items: "$[*]", Time: "$.t", Value: "$.v", Template: "{{.}}"},
+	} {
+		if _, err := rest.New(rest.WithEndpoint("t", ep)); err == nil {
+			t.Errorf("%+v: expected an error", ep)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqldb provides a SimpleJSON source that answers queries by
+// running SQL against a database/sql database.
+//
+// Queries are registered by name, and selected by the target of a Grafana
+// query. Queries may use the following macros, which are passed to the
+// database as bind parameters:
+//
+//	$__from            the start of the query range, as a time.Time
+//	$__to              the end of the query range, as a time.Time
+//	$__timeFilter(col) replaced with "col BETWEEN $__from AND $__to"
+//	$__interval        the query interval in seconds, as a float64
+//	$__interval_ms     the query interval in milliseconds, as an int64
+//
+// Table queries carry no interval, so $__interval is 0 for them.
+//
+// For timeserie queries, the first time column of the result gives the
+// time of each point, and the first numeric column its value. For table
+// queries all columns are returned, with their Grafana types inferred from
+// the column types reported by the driver.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// Source runs SQL queries against a database. It implements
+// simplejson.Querier, simplejson.TableQuerier and simplejson.Searcher,
+// and may be passed to simplejson.WithSource.
+type Source struct {
+	db          *sql.DB
+	queries     map[string]string
+	raw         bool
+	placeholder func(n int) string
+}
+
+// An Opt configures a Source.
+type Opt func(*Source) error
+
+// WithQuery registers the SQL query to run for targets named name.
+func WithQuery(name, query string) Opt {
+	return func(s *Source) error {
+		s.queries[name] = query
+		return nil
+	}
+}
+
+// WithRawQueries allows targets that do not name a registered query to be
+// run as SQL. This allows any Grafana user able to edit a dashboard to run
+// arbitrary SQL, so the database user should have only the privileges
+// needed for reading.
+func WithRawQueries() Opt {
+	return func(s *Source) error {
+		s.raw = true
+		return nil
+	}
+}
+
+// WithPlaceholder sets the function used to produce the placeholder for
+// the nth bind parameter, counting from 1. The default produces "?", as
+// used by MySQL and SQLite, see also DollarPlaceholder.
+func WithPlaceholder(fn func(n int) string) Opt {
+	return func(s *Source) error {
+		s.placeholder = fn
+		return nil
+	}
+}
+
+// DollarPlaceholder produces placeholders of the form $1, as used by
+// PostgreSQL.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func questionPlaceholder(int) string { return "?" }
+
+// New creates a Source that queries db.
+func New(db *sql.DB, opts ...Opt) (*Source, error) {
+	s := &Source{
+		db:          db,
+		queries:     map[string]string{},
+		placeholder: questionPlaceholder,
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the names of
+// the registered queries that contain target.
+func (s *Source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	res := []string{}
+	for name := range s.queries {
+		if strings.Contains(name, target) {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// GrafanaQuery implements simplejson.Querier.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	cols, err := s.run(ctx, target, args.From, args.To, args.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	ti, vi := -1, -1
+	for i, c := range cols {
+		switch {
+		case ti == -1 && c.kind == kindTime:
+			ti = i
+		case vi == -1 && c.kind == kindNumber:
+			vi = i
+		}
+	}
+	if ti == -1 || vi == -1 {
+		return nil, fmt.Errorf("query for %q must return a time and a numeric column", target)
+	}
+
+	series := simplejson.NewSeries(target)
+	times, vals := cols[ti].times, cols[vi].numbers
+	for i, t := range times {
+		if t == nil {
+			continue
+		}
+		if vals[i] == nil {
+			series.AddNull(*t)
+			continue
+		}
+		series.Add(*t, *vals[i])
+	}
+	return series.Points(), nil
+}
+
+// GrafanaQueryTable implements simplejson.TableQuerier.
+func (s *Source) GrafanaQueryTable(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+	cols, err := s.run(ctx, target, args.From, args.To, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]simplejson.TableColumn, len(cols))
+	for i, c := range cols {
+		res[i] = simplejson.TableColumn{Text: c.name}
+		switch c.kind {
+		case kindNumber:
+			res[i].Data = c.numbers
+		case kindTime:
+			res[i].Data = c.times
+		case kindBool:
+			res[i].Data = c.bools
+		default:
+			res[i].Data = c.strings
+		}
+	}
+	return res, nil
+}
+
+// run expands the macros of the query for target, and runs it.
+func (s *Source) run(ctx context.Context, target string, from, to time.Time, interval time.Duration) ([]*column, error) {
+	query, ok := s.queries[target]
+	if !ok {
+		if !s.raw {
+			return nil, fmt.Errorf("unknown query %q, %w", target, simplejson.ErrNotFound)
+		}
+		query = target
+	}
+
+	query, params := s.expand(query, from, to, interval)
+	rows, err := s.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]*column, len(cts))
+	dest := make([]interface{}, len(cts))
+	for i, ct := range cts {
+		cols[i] = &column{name: ct.Name(), kind: inferKind(ct)}
+		dest[i] = new(interface{})
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, c := range cols {
+			if err := c.append(*dest[i].(*interface{})); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cols, rows.Err()
+}
+
+var macroRE = regexp.MustCompile(`\$__(timeFilter\(([^)]*)\)|from\b|to\b|interval_ms\b|interval\b)`)
+
+// expand replaces the macros in query with placeholders, returning the
+// parameters to bind to them.
+func (s *Source) expand(query string, from, to time.Time, interval time.Duration) (string, []interface{}) {
+	var params []interface{}
+	bind := func(v interface{}) string {
+		params = append(params, v)
+		return s.placeholder(len(params))
+	}
+
+	query = macroRE.ReplaceAllStringFunc(query, func(m string) string {
+		sub := macroRE.FindStringSubmatch(m)
+		switch {
+		case strings.HasPrefix(sub[1], "timeFilter"):
+			col := strings.TrimSpace(sub[2])
+			return col + " BETWEEN " + bind(from) + " AND " + bind(to)
+		case sub[1] == "from":
+			return bind(from)
+		case sub[1] == "to":
+			return bind(to)
+		case sub[1] == "interval_ms":
+			return bind(interval.Milliseconds())
+		default:
+			return bind(interval.Seconds())
+		}
+	})
+	return query, params
+}
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindNumber
+	kindTime
+	kindBool
+)
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// inferKind derives the Grafana type of a column from the scan type
+// reported by the driver, or failing that the database type name.
+func inferKind(ct *sql.ColumnType) kind {
+	if st := ct.ScanType(); st != nil {
+		for st.Kind() == reflect.Pointer {
+			st = st.Elem()
+		}
+		switch st {
+		case timeType, reflect.TypeFor[sql.NullTime]():
+			return kindTime
+		case reflect.TypeFor[sql.NullInt64](), reflect.TypeFor[sql.NullInt32](), reflect.TypeFor[sql.NullInt16](),
+			reflect.TypeFor[sql.NullFloat64](), reflect.TypeFor[sql.NullByte]():
+			return kindNumber
+		case reflect.TypeFor[sql.NullBool]():
+			return kindBool
+		}
+		switch st.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return kindNumber
+		case reflect.Bool:
+			return kindBool
+		}
+	}
+
+	name := strings.ToUpper(ct.DatabaseTypeName())
+	switch {
+	case strings.Contains(name, "BOOL"):
+		return kindBool
+	case strings.Contains(name, "DATE"), strings.Contains(name, "TIME"):
+		return kindTime
+	case strings.Contains(name, "INT"), strings.Contains(name, "REAL"), strings.Contains(name, "FLOAT"),
+		strings.Contains(name, "DOUBLE"), strings.Contains(name, "NUMERIC"), strings.Contains(name, "DECIMAL"):
+		return kindNumber
+	}
+	return kindString
+}
+
+// column accumulates the values of one result column. Only the slice
+// for the column's kind is used. NULL values are stored as nil.
+type column struct {
+	name string
+	kind kind
+
+	numbers simplejson.Column[*float64]
+	times   simplejson.Column[*time.Time]
+	bools   simplejson.Column[*bool]
+	strings simplejson.Column[*string]
+}
+
+func (c *column) append(v interface{}) error {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+
+	switch c.kind {
+	case kindNumber:
+		if v == nil {
+			c.numbers.Append(nil)
+			return nil
+		}
+		f, err := toFloat(v)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.name, err)
+		}
+		c.numbers.Append(&f)
+	case kindTime:
+		if v == nil {
+			c.times.Append(nil)
+			return nil
+		}
+		t, err := toTime(v)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.name, err)
+		}
+		c.times.Append(&t)
+	case kindBool:
+		if v == nil {
+			c.bools.Append(nil)
+			return nil
+		}
+		b, err := toBool(v)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.name, err)
+		}
+		c.bools.Append(&b)
+	default:
+		if v == nil {
+			c.strings.Append(nil)
+			return nil
+		}
+		s := fmt.Sprint(v)
+		c.strings.Append(&s)
+	}
+	return nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int()), nil
+	case rv.CanUint():
+		return float64(rv.Uint()), nil
+	case rv.CanFloat():
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", v)
+}
+
+// timeLayouts are tried in turn when a driver returns times as strings.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case int64:
+		// Integers are taken to be seconds since the epoch.
+		return time.Unix(v, 0), nil
+	case string:
+		for _, l := range timeLayouts {
+			if t, err := time.Parse(l, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("cannot parse %q as a time", v)
+	}
+	return time.Time{}, fmt.Errorf("cannot convert %T to a time", v)
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, fmt.Errorf("cannot convert %T to a boolean", v)
+}
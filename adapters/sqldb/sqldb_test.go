@@ -0,0 +1,219 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqldb_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/adapters/sqldb"
+)
+
+// fakeDriver returns a fixed result for every query, recording the query
+// and its arguments.
+type fakeDriver struct {
+	mu    sync.Mutex
+	query string
+	args  []driver.Value
+
+	columns []fakeColumn
+	rows    [][]driver.Value
+}
+
+type fakeColumn struct {
+	name, dbType string
+	scanType     reflect.Type
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.d, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.query, s.d.args = s.query, args
+	return &fakeRows{d: s.d}, nil
+}
+
+type fakeRows struct {
+	d *fakeDriver
+	i int
+}
+
+func (r *fakeRows) Columns() []string {
+	names := make([]string, len(r.d.columns))
+	for i, c := range r.d.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string { return r.d.columns[i].dbType }
+
+func (r *fakeRows) ColumnTypeScanType(i int) reflect.Type {
+	if st := r.d.columns[i].scanType; st != nil {
+		return st
+	}
+	return reflect.TypeFor[interface{}]()
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i == len(r.d.rows) {
+		return io.EOF
+	}
+	copy(dest, r.d.rows[r.i])
+	r.i++
+	return nil
+}
+
+var driverSeq int
+
+func openFake(t *testing.T, d *fakeDriver) *sql.DB {
+	driverSeq++
+	name := "fake" + string(rune('a'+driverSeq))
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var (
+	testFrom = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testTo   = testFrom.Add(time.Hour)
+)
+
+func TestQuery(t *testing.T) {
+	d := &fakeDriver{
+		columns: []fakeColumn{
+			{name: "time", scanType: reflect.TypeFor[time.Time]()},
+			{name: "host", dbType: "VARCHAR"},
+			{name: "value", dbType: "DOUBLE"},
+		},
+		rows: [][]driver.Value{
+			{testFrom.Add(time.Minute), "a", []byte("2.5")},
+			{testFrom, "a", 1.0},
+			{testFrom.Add(2 * time.Minute), "a", nil},
+		},
+	}
+	src, err := sqldb.New(openFake(t, d),
+		sqldb.WithQuery("load", `SELECT time, host, value FROM load WHERE $__timeFilter(time) AND bucket = $__interval_ms OR $__interval > 0 ORDER BY time`),
+		sqldb.WithPlaceholder(sqldb.DollarPlaceholder),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dps, err := src.GrafanaQuery(context.Background(), "load", simplejson.QueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: testFrom, To: testTo},
+		Interval:             time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectQuery := `SELECT time, host, value FROM load WHERE time BETWEEN $1 AND $2 AND bucket = $3 OR $4 > 0 ORDER BY time`
+	if d.query != expectQuery {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expectQuery, d.query)
+	}
+	expectArgs := []driver.Value{testFrom, testTo, int64(60000), 60.0}
+	if !reflect.DeepEqual(d.args, expectArgs) {
+		t.Fatalf("expected args %v, got %v", expectArgs, d.args)
+	}
+
+	if len(dps) != 3 || dps[0].Value != 1 || dps[1].Value != 2.5 || !dps[2].Null {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	d := &fakeDriver{
+		columns: []fakeColumn{
+			{name: "at", dbType: "TIMESTAMP"},
+			{name: "host", dbType: "TEXT"},
+			{name: "count", scanType: reflect.TypeFor[sql.NullInt64]()},
+			{name: "up", dbType: "BOOLEAN"},
+		},
+		rows: [][]driver.Value{
+			{"2024-01-01 00:00:00", "a", int64(3), true},
+			{nil, nil, nil, int64(0)},
+		},
+	}
+	src, _ := sqldb.New(openFake(t, d), sqldb.WithRawQueries())
+	gsj := simplejson.New(simplejson.WithSource(src))
+
+	body := `{"range":{"from":"2024-01-01T00:00:00Z","to":"2024-01-01T01:00:00Z"},"targets":[{"target":"SELECT * FROM hosts WHERE at < $__to","type":"table"}]}`
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body)))
+
+	expect := `[{"type":"table","columns":[{"text":"at","type":"time"},{"text":"host","type":"string"},{"text":"count","type":"number"},{"text":"up","type":"boolean"}],"rows":[["2024-01-01T00:00:00Z","a",3,true],[null,null,null,false]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	if d.query != "SELECT * FROM hosts WHERE at < ?" {
+		t.Fatalf("unexpected query %s", d.query)
+	}
+}
+
+func TestUnknownQuery(t *testing.T) {
+	src, _ := sqldb.New(openFake(t, &fakeDriver{}), sqldb.WithQuery("a", "SELECT 1"))
+	_, err := src.GrafanaQuery(context.Background(), "DROP TABLE a", simplejson.QueryArguments{})
+	if !errors.Is(err, simplejson.ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	res, _ := src.GrafanaSearch(context.Background(), "")
+	if len(res) != 1 || res[0] != "a" {
+		t.Fatalf("unexpected search results %v", res)
+	}
+}
+
+func TestQueryMissingColumns(t *testing.T) {
+	d := &fakeDriver{columns: []fakeColumn{{name: "host", dbType: "TEXT"}}}
+	src, _ := sqldb.New(openFake(t, d), sqldb.WithRawQueries())
+	if _, err := src.GrafanaQuery(context.Background(), "SELECT host FROM hosts", simplejson.QueryArguments{}); err == nil {
+		t.Fatalf("expected an error for a result without time and value columns")
+	}
+}
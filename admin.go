@@ -0,0 +1,246 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// modulePath is the path of this module, used to report its version.
+const modulePath = "github.com/tcolgate/grafana-simple-json-go"
+
+// WithRecentQueries keeps the last size query targets in memory, for the
+// admin /queries endpoint, see AdminHandler.
+func WithRecentQueries(size int) Opt {
+	return func(sjc *Handler) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid recent query buffer size %d", size)
+		}
+		sjc.recentQueries = newQueryRing(size)
+		return nil
+	}
+}
+
+// RecentQueries returns the query targets held in memory, oldest first.
+// It returns nil unless WithRecentQueries was given.
+func (h *Handler) RecentQueries() []QueryRecord {
+	return h.live().recentQueries.records()
+}
+
+// WithAdminPrefix serves the admin endpoints, see AdminHandler, below
+// prefix, e.g. "/admin", alongside the Grafana endpoints. The admin
+// endpoints are then protected by any authentication given to the
+// handler, but not to tenant resolution, see WithTenantResolver. To
+// serve them on a separate, internal, listener use AdminHandler instead.
+func WithAdminPrefix(prefix string) Opt {
+	return func(sjc *Handler) error {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("invalid admin prefix %q", prefix)
+		}
+		if sjc.adminPrefix != "" {
+			return fmt.Errorf("admin endpoints already served at %q", sjc.adminPrefix)
+		}
+		sjc.adminPrefix = prefix
+		sjc.mux.Handle(prefix+"/", http.StripPrefix(prefix, sjc.AdminHandler()))
+		return nil
+	}
+}
+
+// routeAdmin serves requests below the admin prefix directly from the
+// handler's mux. The admin endpoints describe the handler as a whole, so
+// are not subject to tenant resolution.
+func (h *Handler) routeAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == h.adminPrefix || strings.HasPrefix(r.URL.Path, h.adminPrefix+"/") {
+			h.mux.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminHandler returns a handler for endpoints that describe the
+// handler, to help operate fleets of datasources. All respond to GET
+// requests with JSON.
+//
+//	/              lists the admin endpoints
+//	/handlers      the Grafana endpoints, which are enabled, the
+//	               datasources and tenants configured
+//	/queries       recent query targets, see WithRecentQueries
+//	/slow-queries  recent slow query targets, see WithSlowQueryBuffer
+//	/cache         cache statistics, see WithQueryCache
+//	/version       build and version information
+//
+// Endpoints whose options were not given respond with a 404. The admin
+// endpoints are not served by the handler itself unless WithAdminPrefix
+// is given.
+func (h *Handler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", serveAdminIndex)
+	mux.Handle("GET /handlers", h.liveHandler((*Handler).handleAdminHandlers))
+	mux.Handle("GET /queries", h.liveHandler((*Handler).handleAdminQueries))
+	mux.Handle("GET /slow-queries", h.SlowQueriesHandler())
+	mux.Handle("GET /cache", h.liveHandler((*Handler).handleAdminCache))
+	mux.HandleFunc("GET /version", serveAdminVersion)
+	return mux
+}
+
+var adminEndpoints = []string{"/handlers", "/queries", "/slow-queries", "/cache", "/version"}
+
+func serveAdminIndex(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, map[string][]string{"endpoints": adminEndpoints})
+}
+
+// adminHandlers is the response of the admin /handlers endpoint.
+type adminHandlers struct {
+	Endpoints   []adminEndpoint `json:"endpoints"`
+	Datasources []string        `json:"datasources"`
+	Tenants     []string        `json:"tenants,omitempty"`
+}
+
+type adminEndpoint struct {
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (h *Handler) handleAdminHandlers(w http.ResponseWriter, r *http.Request) {
+	resp := adminHandlers{
+		Endpoints: []adminEndpoint{
+			{"/", true},
+			{"/query", h.hasQuerier()},
+			{"/annotations", h.annotations != nil},
+			{"/annotation", h.annotationWriter != nil},
+			{"/search", h.search != nil || h.resultSearch != nil},
+			{"/tag-keys", h.tags != nil},
+			{"/tag-values", h.tags != nil},
+			{"/variable", h.variables != nil},
+			{"/stream", h.streams != nil},
+			{"/debug/parse", h.parseEndpoint},
+			{"/openapi.json", h.openAPIEndpoint},
+		},
+		Datasources: []string{},
+	}
+
+	for _, ds := range []struct {
+		set  bool
+		name string
+	}{
+		{h.query != nil, "RequestQuerier"},
+		{len(h.namedQueriers) > 0, "NamedQuerier"},
+		{h.iterQuery != nil, "IterQuerier"},
+		{h.seriesQuery != nil, "SeriesQuerier"},
+		{h.tableQuery != nil, "TableRequestQuerier"},
+		{h.tableIter != nil, "TableIterQuerier"},
+		{h.heatmapQuery != nil, "HeatmapQuerier"},
+		{h.logQuery != nil, "LogQuerier"},
+		{h.nodeGraphQuery != nil, "NodeGraphQuerier"},
+		{h.annotations != nil, "AnnotationQuerier"},
+		{h.annotationWriter != nil, "AnnotationWriter"},
+		{h.search != nil, "Searcher"},
+		{h.resultSearch != nil, "ResultSearcher"},
+		{h.tags != nil, "TagSearcher"},
+		{h.variables != nil, "VariableQuerier"},
+		{h.streams != nil, "StreamingQuerier"},
+		{h.health != nil, "HealthChecker"},
+	} {
+		if ds.set {
+			resp.Datasources = append(resp.Datasources, ds.name)
+		}
+	}
+
+	for id := range h.tenants {
+		resp.Tenants = append(resp.Tenants, id)
+	}
+	sort.Strings(resp.Tenants)
+
+	writeAdminJSON(w, resp)
+}
+
+func (h *Handler) handleAdminQueries(w http.ResponseWriter, r *http.Request) {
+	h.recentQueries.writeJSON(w)
+}
+
+func (h *Handler) handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	c := h.queryCache()
+	if c == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, c.currentStats())
+}
+
+// adminVersion is the response of the admin /version endpoint.
+type adminVersion struct {
+	GoVersion      string `json:"goVersion"`
+	Path           string `json:"path,omitempty"`
+	Version        string `json:"version,omitempty"`
+	LibraryVersion string `json:"libraryVersion,omitempty"`
+	Revision       string `json:"revision,omitempty"`
+	RevisionTime   string `json:"revisionTime,omitempty"`
+	Modified       bool   `json:"modified,omitempty"`
+}
+
+func serveAdminVersion(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, buildVersion())
+}
+
+// buildVersion describes the running binary, from the build information
+// embedded by the Go toolchain.
+func buildVersion() adminVersion {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return adminVersion{}
+	}
+
+	v := adminVersion{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Version:   bi.Main.Version,
+	}
+	if bi.Main.Path == modulePath {
+		v.LibraryVersion = bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == modulePath {
+			v.LibraryVersion = dep.Version
+		}
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.RevisionTime = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	return v
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func adminTestQuerier(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}}, nil
+}
+
+func adminGet(t *testing.T, h http.Handler, path string, v interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %s: %v", path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestAdminHandler(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(adminTestQuerier)),
+		simplejson.WithQueryCache(time.Minute, 10),
+		simplejson.WithRecentQueries(10),
+	)
+	admin := gsj.AdminHandler()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"targets":[{"target":"cpu","refId":"A"}]}`))
+		gsj.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var handlers struct {
+		Endpoints []struct {
+			Path    string
+			Enabled bool
+		}
+		Datasources []string
+	}
+	if code := adminGet(t, admin, "/handlers", &handlers); code != http.StatusOK {
+		t.Fatalf("/handlers: expected status 200, got %d", code)
+	}
+	enabled := map[string]bool{}
+	for _, ep := range handlers.Endpoints {
+		enabled[ep.Path] = ep.Enabled
+	}
+	if !enabled["/query"] || enabled["/annotations"] {
+		t.Errorf("unexpected endpoints: %+v", handlers.Endpoints)
+	}
+	if len(handlers.Datasources) != 1 || handlers.Datasources[0] != "RequestQuerier" {
+		t.Errorf("unexpected datasources: %v", handlers.Datasources)
+	}
+
+	// The second query is answered from the cache.
+	var queries []simplejson.QueryRecord
+	if code := adminGet(t, admin, "/queries", &queries); code != http.StatusOK {
+		t.Fatalf("/queries: expected status 200, got %d", code)
+	}
+	if len(queries) != 1 || queries[0].Target != "cpu" || queries[0].RefID != "A" {
+		t.Errorf("unexpected queries: %+v", queries)
+	}
+
+	var stats simplejson.CacheStats
+	if code := adminGet(t, admin, "/cache", &stats); code != http.StatusOK {
+		t.Fatalf("/cache: expected status 200, got %d", code)
+	}
+	if stats.Entries != 1 || stats.MaxEntries != 10 || stats.Hits != 1 || stats.Misses != 1 || stats.Bytes == 0 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+
+	var version struct{ GoVersion string }
+	if code := adminGet(t, admin, "/version", &version); code != http.StatusOK {
+		t.Fatalf("/version: expected status 200, got %d", code)
+	}
+	if !strings.HasPrefix(version.GoVersion, "go") {
+		t.Errorf("unexpected go version %q", version.GoVersion)
+	}
+
+	if code := adminGet(t, admin, "/slow-queries", nil); code != http.StatusNotFound {
+		t.Errorf("/slow-queries: expected status 404, got %d", code)
+	}
+}
+
+func TestWithAdminPrefix(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(adminTestQuerier)),
+		simplejson.WithAdminPrefix("/admin/"),
+	)
+
+	var index struct{ Endpoints []string }
+	if code := adminGet(t, gsj, "/admin/", &index); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(index.Endpoints) == 0 {
+		t.Errorf("expected admin endpoints to be listed")
+	}
+	if code := adminGet(t, gsj, "/admin/cache", nil); code != http.StatusNotFound {
+		t.Errorf("expected status 404 without a cache, got %d", code)
+	}
+	if code := adminGet(t, gsj, "/handlers", nil); code != http.StatusNotFound {
+		t.Errorf("expected admin endpoints only below the prefix, got %d", code)
+	}
+}
+
+func TestWithAdminPrefix_Tenants(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTenantResolver(func(r *http.Request) (string, error) {
+			return r.Header.Get("X-Tenant"), nil
+		}),
+		simplejson.WithTenant("a", simplejson.WithQuerier(simplejson.QuerierFunc(adminTestQuerier))),
+		simplejson.WithAdminPrefix("/admin"),
+	)
+
+	var handlers struct{ Tenants []string }
+	if code := adminGet(t, gsj, "/admin/handlers", &handlers); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(handlers.Tenants) != 1 || handlers.Tenants[0] != "a" {
+		t.Errorf("unexpected tenants %v", handlers.Tenants)
+	}
+	if code := adminGet(t, gsj, "/search", nil); code != http.StatusForbidden {
+		t.Errorf("expected other endpoints to require a tenant, got %d", code)
+	}
+}
+
+func TestWithAdminPrefix_Invalid(t *testing.T) {
+	_, err := simplejson.NewWithError(
+		simplejson.WithQuerier(simplejson.QuerierFunc(adminTestQuerier)),
+		simplejson.WithAdminPrefix("/"),
+	)
+	if err == nil {
+		t.Fatalf("expected an error for an empty prefix")
+	}
+}
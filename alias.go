@@ -0,0 +1,112 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// aliasTargetRE matches a target of the form alias(target, "pattern").
+var aliasTargetRE = regexp.MustCompile(`^\s*alias\((.*),\s*"((?:[^"\\]|\\.)*)"\)\s*$`)
+
+// aliasRefRE matches the references that may be used in an alias
+// pattern: $N, $tag_key, and their braced forms ${N} and ${tag_key}.
+var aliasRefRE = regexp.MustCompile(`\$(?:(\d+)|tag_(\w+)|\{(\d+)\}|\{tag_(\w+)\})`)
+
+// WithAliases allows targets to be wrapped as alias(target, "pattern").
+// The inner target is passed to the querier, and the series returned is
+// named by the pattern, see FormatAlias, rather than by the target. This
+// gives dashboards readable legends without changes to the backend.
+func WithAliases() Opt {
+	return func(sjc *Handler) error {
+		sjc.aliases = true
+		return nil
+	}
+}
+
+// FormatAlias returns the name for a series given by the alias pattern.
+// In the pattern, $N is replaced by the Nth, counting from zero, of the
+// dot separated nodes of the series name, and $tag_key by the value of
+// the label key. References may also be written ${N} and ${tag_key}.
+// If labels has a __name__ label that is used as the series name,
+// otherwise name is. References to missing nodes or labels are replaced
+// with the empty string.
+func FormatAlias(pattern, name string, labels map[string]string) string {
+	if n, ok := labels["__name__"]; ok {
+		name = n
+	}
+	var nodes []string
+	return aliasRefRE.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := aliasRefRE.FindStringSubmatch(ref)
+		if key := m[2] + m[4]; key != "" {
+			return labels[key]
+		}
+		if nodes == nil {
+			nodes = strings.Split(name, ".")
+		}
+		i, err := strconv.Atoi(m[1] + m[3])
+		if err != nil || i >= len(nodes) {
+			return ""
+		}
+		return nodes[i]
+	})
+}
+
+// parseAlias removes any alias from the target of qr, returning the
+// pattern, if aliases are enabled.
+func (h *Handler) parseAlias(qr *QueryRequest) (string, bool) {
+	if !h.aliases {
+		return "", false
+	}
+	m := aliasTargetRE.FindStringSubmatch(qr.Target)
+	if m == nil {
+		return "", false
+	}
+	pattern, err := strconv.Unquote(`"` + m[2] + `"`)
+	if err != nil {
+		pattern = m[2]
+	}
+	qr.Target = strings.TrimSpace(m[1])
+	return pattern, true
+}
+
+// applyAlias renames the series in res using the alias pattern. Series
+// named by the original target, rather than by the querier, are treated
+// as being named by the target with the alias removed.
+func applyAlias(res interface{}, pattern, original, target string) interface{} {
+	name := func(n string) string {
+		if n == original {
+			return target
+		}
+		return n
+	}
+	switch res := res.(type) {
+	case simpleJSONData:
+		res.Target = FormatAlias(pattern, name(res.Target), res.labels)
+		return res
+	case simpleJSONResults:
+		for i := range res {
+			res[i] = applyAlias(res[i], pattern, original, target)
+		}
+		return res
+	case *simpleJSONIterData:
+		// Only labels set before the first point was yielded are
+		// available, since the name is written first.
+		res.Target = FormatAlias(pattern, name(res.Target), res.config.getLabels())
+		return res
+	}
+	return res
+}
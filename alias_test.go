@@ -0,0 +1,111 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestFormatAlias(t *testing.T) {
+	labels := map[string]string{"host": "web1"}
+	tests := []struct {
+		pattern string
+		name    string
+		labels  map[string]string
+		expect  string
+	}{
+		{"$1 CPU", "cpu.core0.user", nil, "core0 CPU"},
+		{"${0}_$2", "cpu.core0.user", nil, "cpu_user"},
+		{"$tag_host: $0", "cpu", labels, "web1: cpu"},
+		{"${tag_host}-$tag_missing-$9", "cpu", labels, "web1--"},
+		{"$1", "ignored", map[string]string{"__name__": "mem.free"}, "free"},
+		{"plain", "cpu", nil, "plain"},
+	}
+	for _, tt := range tests {
+		if got := simplejson.FormatAlias(tt.pattern, tt.name, tt.labels); got != tt.expect {
+			t.Errorf("FormatAlias(%q, %q): expected %q, got %q", tt.pattern, tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestWithAliases(t *testing.T) {
+	var got []string
+	gsj := simplejson.New(
+		simplejson.WithAliases(),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			got = append(got, target)
+			simplejson.SetLabels(ctx, map[string]string{"host": "web1"})
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}}, nil
+		})),
+	)
+
+	body := `{"targets":[{"target":"alias(cpu.core0, \"$tag_host \\\"$1\\\"\")","refId":"A"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(got) != 1 || got[0] != "cpu.core0" {
+		t.Fatalf("unexpected targets %q", got)
+	}
+	expect := `[{"target":"web1 \"core0\"","refId":"A","datapoints":[[1,1000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithAliases_Iter(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithAliases(),
+		simplejson.WithIterQuerier(simplejson.IterQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) iter.Seq2[simplejson.DataPoint, error] {
+			return func(yield func(simplejson.DataPoint, error) bool) {
+				simplejson.SetLabels(ctx, map[string]string{"host": "web2"})
+				yield(simplejson.DataPoint{Time: time.Unix(1, 0), Value: 1}, nil)
+			}
+		})),
+	)
+
+	body := `{"targets":[{"target":"alias(mem, \"$tag_host $0\")"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"web2 mem","datapoints":[[1,1000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithAliases_Disabled(t *testing.T) {
+	var got string
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			got = target
+			return nil, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"alias(cpu, \"x\")"}]}`))
+	gsj.ServeHTTP(httptest.NewRecorder(), req)
+	if got != `alias(cpu, "x")` {
+		t.Fatalf("expected the target to be passed unchanged, got %q", got)
+	}
+}
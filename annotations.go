@@ -0,0 +1,251 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// AnnotationRegionFormat selects how annotations spanning a time range
+// are sent to Grafana.
+type AnnotationRegionFormat int
+
+const (
+	// AnnotationRegionsAuto uses AnnotationRegionsModern for requests from
+	// Grafana 7 or later, as identified by the User-Agent the Grafana
+	// datasource proxy sets, and AnnotationRegionsLegacy otherwise.
+	AnnotationRegionsAuto AnnotationRegionFormat = iota
+	// AnnotationRegionsLegacy sends a region as a pair of annotations, at
+	// its start and end, sharing a regionId. This is the format used by
+	// Grafana 6 and earlier.
+	AnnotationRegionsLegacy
+	// AnnotationRegionsModern sends a region as a single annotation with
+	// timeEnd and isRegion set.
+	AnnotationRegionsModern
+)
+
+// WithAnnotationRegionFormat sets how annotations spanning a time range
+// are sent to Grafana. The default is AnnotationRegionsAuto.
+func WithAnnotationRegionFormat(f AnnotationRegionFormat) Opt {
+	return func(sjc *Handler) error {
+		sjc.annotationRegions = f
+		return nil
+	}
+}
+
+// modernAnnotations reports whether region annotations for r should be
+// sent as single annotations.
+func (f AnnotationRegionFormat) modernAnnotations(r *http.Request) bool {
+	switch f {
+	case AnnotationRegionsLegacy:
+		return false
+	case AnnotationRegionsModern:
+		return true
+	}
+	major, ok := grafanaMajorVersion(r.UserAgent())
+	return ok && major >= 7
+}
+
+// grafanaMajorVersion extracts the major version from a Grafana User-Agent,
+// e.g. "Grafana/10.2.3".
+func grafanaMajorVersion(ua string) (int, bool) {
+	for _, prod := range strings.Fields(ua) {
+		v, ok := strings.CutPrefix(prod, "Grafana/")
+		if !ok {
+			continue
+		}
+		major, _, _ := strings.Cut(v, ".")
+		n, err := strconv.Atoi(major)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// annotationResponses converts anns to the response sent to Grafana.
+func annotationResponses(req simpleJSONAnnotation, anns []Annotation, modern bool) []simpleJSONAnnotationResponse {
+	resp := []simpleJSONAnnotationResponse{}
+	regionID := 1
+	for i := range anns {
+		startAnn := simpleJSONAnnotationResponse{
+			ReqAnnotation: req,
+			ID:            anns[i].ID,
+			Time:          simpleJSONPTime(anns[i].Time),
+			Title:         anns[i].Title,
+			Text:          anns[i].Text,
+			Tags:          anns[i].Tags,
+		}
+		if anns[i].TimeEnd.IsZero() {
+			resp = append(resp, startAnn)
+			continue
+		}
+
+		if modern {
+			end := simpleJSONPTime(anns[i].TimeEnd)
+			startAnn.TimeEnd = &end
+			startAnn.IsRegion = true
+			resp = append(resp, startAnn)
+			continue
+		}
+
+		startAnn.RegionID = regionID
+		endAnn := startAnn
+		endAnn.Time = simpleJSONPTime(anns[i].TimeEnd)
+		resp = append(resp, startAnn, endAnn)
+		regionID++
+	}
+	return resp
+}
+
+// marshalAnnotationResponses returns the JSON encoding of resp. The
+// annotation of the request is the same for every response, so is
+// encoded once and shared.
+func marshalAnnotationResponses(resp []simpleJSONAnnotationResponse, unit time.Duration) ([]byte, error) {
+	if len(resp) == 0 {
+		return []byte("[]"), nil
+	}
+	req, err := json.Marshal(resp[0].ReqAnnotation)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(resp)*(len(req)+128))
+	buf = append(buf, '[')
+	for i, r := range resp {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, `{"annotation":`...)
+		buf = append(buf, req...)
+		if r.ID != "" {
+			buf = append(buf, `,"id":`...)
+			buf = appendJSONString(buf, r.ID)
+		}
+		buf = append(buf, `,"time":`...)
+		buf = strconv.AppendInt(buf, epoch(time.Time(r.Time), unit), 10)
+		if r.TimeEnd != nil {
+			buf = append(buf, `,"timeEnd":`...)
+			buf = strconv.AppendInt(buf, epoch(time.Time(*r.TimeEnd), unit), 10)
+		}
+		if r.IsRegion {
+			buf = append(buf, `,"isRegion":true`...)
+		}
+		if r.RegionID != 0 {
+			buf = append(buf, `,"regionId":`...)
+			buf = strconv.AppendInt(buf, int64(r.RegionID), 10)
+		}
+		buf = append(buf, `,"title":`...)
+		buf = appendJSONString(buf, r.Title)
+		buf = append(buf, `,"text":`...)
+		buf = appendJSONString(buf, r.Text)
+		buf = append(buf, `,"tags":`...)
+		if r.Tags == nil {
+			buf = append(buf, "null"...)
+		} else {
+			buf = append(buf, '[')
+			for j, tag := range r.Tags {
+				if j > 0 {
+					buf = append(buf, ',')
+				}
+				buf = appendJSONString(buf, tag)
+			}
+			buf = append(buf, ']')
+		}
+		buf = append(buf, '}')
+	}
+	return append(buf, ']'), nil
+}
+
+// WithAnnotationFiltering applies the tags and limit of annotation queries
+// to the annotations returned by the annotations handler, for handlers
+// that do not implement them themselves. See AnnotationQuery.Filter.
+func WithAnnotationFiltering() Opt {
+	return func(sjc *Handler) error {
+		sjc.filterAnnotations = true
+		return nil
+	}
+}
+
+// Filter returns the annotations in anns that match the tags of the
+// query, limited to at most q.Limit annotations if it is not zero. anns is
+// not modified.
+func (q AnnotationQuery) Filter(anns []Annotation) []Annotation {
+	var out []Annotation
+	for _, a := range anns {
+		if q.Limit > 0 && len(out) >= q.Limit {
+			break
+		}
+		if q.matchTags(a.Tags) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// matchTags reports whether tags has all the tags of the query, or any of
+// them if MatchAny is set.
+func (q AnnotationQuery) matchTags(tags []string) bool {
+	if len(q.Tags) == 0 {
+		return true
+	}
+	for _, want := range q.Tags {
+		if slices.Contains(tags, want) {
+			if q.MatchAny {
+				return true
+			}
+		} else if !q.MatchAny {
+			return false
+		}
+	}
+	return !q.MatchAny
+}
+
+// annotationTags holds the tags of an annotation query. Grafana may send
+// these as a list, or as a single string of comma or space separated
+// tags.
+type annotationTags []string
+
+func (at *annotationTags) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err == nil {
+		*at = strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		return nil
+	}
+	return json.Unmarshal(bs, (*[]string)(at))
+}
+
+// annotationDatasource is the datasource of an annotation query. Grafana
+// sends this as the datasource name, or, since Grafana 8, as an object
+// referencing the datasource. It is sent back unchanged with each
+// annotation.
+type annotationDatasource json.RawMessage
+
+// MarshalJSON implements JSON marshalling
+func (ad annotationDatasource) MarshalJSON() ([]byte, error) {
+	if len(ad) == 0 {
+		return []byte(`""`), nil
+	}
+	return ad, nil
+}
+
+// UnmarshalJSON implements JSON unmarshalling
+func (ad *annotationDatasource) UnmarshalJSON(injs []byte) error {
+	*ad = append((*ad)[:0], injs...)
+	return nil
+}
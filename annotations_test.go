@@ -0,0 +1,178 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+const annotationsTestQuery = `{"range": { "from": "2016-04-15T13:44:39.070Z", "to": "2016-04-15T14:44:39.070Z" }, "annotation": {"name":"query","query":"some query","enable":true}}`
+
+const (
+	annotationsTestReq    = `"annotation":{"name":"query","datasource":"","query":"some query","enable":true,"iconColor":""}`
+	annotationsTestPoint  = `{` + annotationsTestReq + `,"time":1234000,"title":"First Title","text":"First annotation","tags":null}`
+	annotationsTestModern = `[` + annotationsTestPoint + `,{` + annotationsTestReq + `,"time":1235000,"timeEnd":1237000,"isRegion":true,"title":"Second Title","text":"Second annotation with range","tags":["outage"]}]`
+	annotationsTestLegacy = `[` + annotationsTestPoint +
+		`,{` + annotationsTestReq + `,"time":1235000,"regionId":1,"title":"Second Title","text":"Second annotation with range","tags":["outage"]}` +
+		`,{` + annotationsTestReq + `,"time":1237000,"regionId":1,"title":"Second Title","text":"Second annotation with range","tags":["outage"]}]`
+)
+
+func TestAnnotationRegionFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format simplejson.AnnotationRegionFormat
+		ua     string
+		expect string
+	}{
+		{"auto no agent", simplejson.AnnotationRegionsAuto, "", annotationsTestLegacy},
+		{"auto old grafana", simplejson.AnnotationRegionsAuto, "Grafana/6.7.4", annotationsTestLegacy},
+		{"auto new grafana", simplejson.AnnotationRegionsAuto, "Grafana/10.4.1", annotationsTestModern},
+		{"legacy", simplejson.AnnotationRegionsLegacy, "Grafana/10.4.1", annotationsTestLegacy},
+		{"modern", simplejson.AnnotationRegionsModern, "", annotationsTestModern},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gsj := simplejson.New(
+				simplejson.WithAnnotator(GSJExample{}),
+				simplejson.WithAnnotationRegionFormat(tt.format),
+			)
+
+			req := httptest.NewRequest(http.MethodPost, "/annotations", bytes.NewBufferString(annotationsTestQuery))
+			if tt.ua != "" {
+				req.Header.Set("User-Agent", tt.ua)
+			}
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Body.String() != tt.expect {
+				t.Fatalf("\nexpected: %s\ngot:      %s", tt.expect, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithAnnotationQuerier(t *testing.T) {
+	for name, tags := range map[string]string{
+		"list":   `["deploy", "prod"]`,
+		"string": `"deploy, prod"`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var got simplejson.AnnotationQuery
+			gsj := simplejson.New(
+				simplejson.WithAnnotationQuerier(simplejson.AnnotationQuerierFunc(func(ctx context.Context, q simplejson.AnnotationQuery) ([]simplejson.Annotation, error) {
+					got = q
+					return nil, nil
+				})),
+			)
+
+			q := `{"annotation": {"name": "deploys", "query": "q", "tags": ` + tags + `, "matchAny": true, "limit": 10}}`
+			req := httptest.NewRequest(http.MethodPost, "/annotations", bytes.NewBufferString(q))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+			}
+			if got.Name != "deploys" || got.Query != "q" || !got.MatchAny || got.Limit != 10 || !reflect.DeepEqual(got.Tags, []string{"deploy", "prod"}) {
+				t.Fatalf("unexpected query %+v", got)
+			}
+		})
+	}
+}
+
+func TestAnnotationQueryFilter(t *testing.T) {
+	anns := []simplejson.Annotation{
+		{Title: "a", Tags: []string{"deploy", "prod"}},
+		{Title: "b", Tags: []string{"deploy"}},
+		{Title: "c", Tags: []string{"prod"}},
+		{Title: "d"},
+	}
+	titles := func(anns []simplejson.Annotation) string {
+		var s string
+		for _, a := range anns {
+			s += a.Title
+		}
+		return s
+	}
+
+	tests := []struct {
+		q      simplejson.AnnotationQuery
+		expect string
+	}{
+		{simplejson.AnnotationQuery{}, "abcd"},
+		{simplejson.AnnotationQuery{Tags: []string{"deploy", "prod"}}, "a"},
+		{simplejson.AnnotationQuery{Tags: []string{"deploy", "prod"}, MatchAny: true}, "abc"},
+		{simplejson.AnnotationQuery{Tags: []string{"deploy"}, Limit: 1}, "a"},
+		{simplejson.AnnotationQuery{Limit: 3}, "abc"},
+	}
+	for _, tt := range tests {
+		if got := titles(tt.q.Filter(anns)); got != tt.expect {
+			t.Errorf("%+v: expected %q, got %q", tt.q, tt.expect, got)
+		}
+	}
+}
+
+func TestWithAnnotationFiltering(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithAnnotator(GSJExample{}),
+		simplejson.WithAnnotationFiltering(),
+	)
+
+	q := `{"annotation": {"name": "query", "query": "some query", "enable": true, "tags": ["outage"]}}`
+	req := httptest.NewRequest(http.MethodPost, "/annotations", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	var res []struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body.String(), err)
+	}
+	for _, a := range res {
+		if a.Title != "Second Title" {
+			t.Fatalf("unexpected annotation %q in %s", a.Title, w.Body.String())
+		}
+	}
+	if len(res) == 0 {
+		t.Fatalf("expected the tagged annotation to be returned")
+	}
+}
+
+func TestAnnotationDatasourceReference(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithAnnotator(GSJExample{}))
+
+	query := `{"range": { "from": "2016-04-15T13:44:39.070Z", "to": "2016-04-15T14:44:39.070Z" }, "annotation": {"name":"query","datasource":{"type":"simpod-json-datasource","uid":"abc"},"query":"some query","enable":true}}`
+	req := httptest.NewRequest(http.MethodPost, "/annotations", bytes.NewBufferString(query))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d, %s", w.Code, w.Body.String())
+	}
+	expect := `"datasource":{"type":"simpod-json-datasource","uid":"abc"}`
+	if !strings.Contains(w.Body.String(), expect) {
+		t.Fatalf("expected the datasource to be returned, got %s", w.Body.String())
+	}
+}
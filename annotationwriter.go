@@ -0,0 +1,150 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An AnnotationWriter stores annotations on behalf of Grafana, or other
+// clients, allowing the datasource to be the system of record for events.
+//
+// CreateAnnotation returns the ID of the new annotation, which is returned
+// as the ID of the annotation by subsequent queries. UpdateAnnotation is
+// passed the annotation as sent by the client, and should replace the
+// fields that were set. Returning an Error with a 404 status reports an
+// unknown ID.
+type AnnotationWriter interface {
+	CreateAnnotation(ctx context.Context, a Annotation) (string, error)
+	UpdateAnnotation(ctx context.Context, id string, a Annotation) error
+	DeleteAnnotation(ctx context.Context, id string) error
+}
+
+// WithAnnotationWriter adds a handler for writing annotations. Annotations
+// are created by a POST to /annotation, and updated or deleted with a
+// PATCH or DELETE to /annotation/{id}.
+func WithAnnotationWriter(aw AnnotationWriter) Opt {
+	return func(sjc *Handler) error {
+		sjc.annotationWriter = aw
+		return nil
+	}
+}
+
+// simpleJSONAnnotationWrite is the body of a request to create or update
+// an annotation. Times are in milliseconds since the epoch.
+type simpleJSONAnnotationWrite struct {
+	Time    simpleJSONPTime `json:"time"`
+	TimeEnd simpleJSONPTime `json:"timeEnd"`
+	Title   string          `json:"title"`
+	Text    string          `json:"text"`
+	Tags    []string        `json:"tags"`
+}
+
+func (aw simpleJSONAnnotationWrite) annotation() Annotation {
+	a := Annotation{
+		Time:  time.Time(aw.Time),
+		Title: aw.Title,
+		Text:  aw.Text,
+		Tags:  aw.Tags,
+	}
+	if end := time.Time(aw.TimeEnd); end.UnixNano() != 0 {
+		a.TimeEnd = end
+	}
+	return a
+}
+
+type simpleJSONAnnotationWriteResponse struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// HandleAnnotationWrite responds to requests to create, update or delete
+// annotations.
+func (h *Handler) HandleAnnotationWrite(w http.ResponseWriter, r *http.Request) {
+	if h.annotationWriter == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), h.annotationsTimeout)
+	defer cancel()
+
+	id := r.PathValue("id")
+	switch {
+	case r.Method == http.MethodOptions:
+		if id == "" {
+			w.Write([]byte("Allow: POST,OPTIONS"))
+		} else {
+			w.Write([]byte("Allow: PATCH,DELETE,OPTIONS"))
+		}
+		return
+	case id == "" && r.Method == http.MethodPost:
+	case id != "" && (r.Method == http.MethodPatch || r.Method == http.MethodDelete):
+	default:
+		writeError(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req simpleJSONAnnotationWrite
+	if r.Method != http.MethodDelete {
+		if err := h.decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, err := callWithDeadline(ctx, func(ctx context.Context) (simpleJSONAnnotationWriteResponse, error) {
+		switch r.Method {
+		case http.MethodPost:
+			id, err := h.annotationWriter.CreateAnnotation(ctx, req.annotation())
+			return simpleJSONAnnotationWriteResponse{Message: "annotation added", ID: id}, err
+		case http.MethodPatch:
+			err := h.annotationWriter.UpdateAnnotation(ctx, id, req.annotation())
+			return simpleJSONAnnotationWriteResponse{Message: "annotation updated", ID: id}, err
+		default:
+			err := h.annotationWriter.DeleteAnnotation(ctx, id)
+			return simpleJSONAnnotationWriteResponse{Message: "annotation deleted", ID: id}, err
+		}
+	})
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	bs, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// AnnotationWriteHandler returns the handler for the /annotation
+// endpoints. When registered on another mux, the pattern for updates and
+// deletes must include an {id} wildcard, e.g. "/annotation/{id}".
+func (h *Handler) AnnotationWriteHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleAnnotationWrite)
+}
+
+// isAnnotationWritePath reports whether path is one of the /annotation
+// endpoints.
+func isAnnotationWritePath(path string) bool {
+	return path == "/annotation" || strings.HasPrefix(path, "/annotation/")
+}
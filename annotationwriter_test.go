@@ -0,0 +1,138 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type memoryAnnotations struct {
+	sync.Mutex
+	next int
+	anns map[string]simplejson.Annotation
+}
+
+func (ma *memoryAnnotations) CreateAnnotation(ctx context.Context, a simplejson.Annotation) (string, error) {
+	ma.Lock()
+	defer ma.Unlock()
+	ma.next++
+	id := strconv.Itoa(ma.next)
+	a.ID = id
+	ma.anns[id] = a
+	return id, nil
+}
+
+func (ma *memoryAnnotations) UpdateAnnotation(ctx context.Context, id string, a simplejson.Annotation) error {
+	ma.Lock()
+	defer ma.Unlock()
+	if _, ok := ma.anns[id]; !ok {
+		return simplejson.Error{Status: http.StatusNotFound, Message: "unknown annotation"}
+	}
+	a.ID = id
+	ma.anns[id] = a
+	return nil
+}
+
+func (ma *memoryAnnotations) DeleteAnnotation(ctx context.Context, id string) error {
+	ma.Lock()
+	defer ma.Unlock()
+	if _, ok := ma.anns[id]; !ok {
+		return simplejson.Error{Status: http.StatusNotFound, Message: "unknown annotation"}
+	}
+	delete(ma.anns, id)
+	return nil
+}
+
+func (ma *memoryAnnotations) GrafanaAnnotations(ctx context.Context, query string, args simplejson.AnnotationsArguments) ([]simplejson.Annotation, error) {
+	ma.Lock()
+	defer ma.Unlock()
+	var out []simplejson.Annotation
+	for _, a := range ma.anns {
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func TestWithAnnotationWriter(t *testing.T) {
+	ma := &memoryAnnotations{anns: map[string]simplejson.Annotation{}}
+	gsj := simplejson.New(simplejson.WithSource(ma))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/annotation", `{"time": 1234000, "timeEnd": 1235000, "title": "deploy", "tags": ["prod"]}`)
+	if expect := `{"message":"annotation added","id":"1"}`; w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	a := ma.anns["1"]
+	if !a.Time.Equal(time.Unix(1234, 0)) || !a.TimeEnd.Equal(time.Unix(1235, 0)) || a.Title != "deploy" {
+		t.Fatalf("unexpected annotation %+v", a)
+	}
+
+	w = do(http.MethodPost, "/annotations", `{"annotation": {"name": "q", "query": "q"}}`)
+	expect := `[{"annotation":{"name":"q","datasource":"","query":"q","enable":false,"iconColor":""},"id":"1","time":1234000,"regionId":1,"title":"deploy","text":"","tags":["prod"]},` +
+		`{"annotation":{"name":"q","datasource":"","query":"q","enable":false,"iconColor":""},"id":"1","time":1235000,"regionId":1,"title":"deploy","text":"","tags":["prod"]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+
+	w = do(http.MethodPatch, "/annotation/1", `{"time": 1236000, "title": "rollback"}`)
+	if expect := `{"message":"annotation updated","id":"1"}`; w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	if a := ma.anns["1"]; a.Title != "rollback" || !a.TimeEnd.IsZero() {
+		t.Fatalf("unexpected annotation %+v", a)
+	}
+
+	w = do(http.MethodDelete, "/annotation/1", ``)
+	if expect := `{"message":"annotation deleted","id":"1"}`; w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	if len(ma.anns) != 0 {
+		t.Fatalf("expected annotation to be deleted")
+	}
+
+	if w := do(http.MethodDelete, "/annotation/1", ``); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown annotation, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := do(http.MethodDelete, "/annotation", ``); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestWithAnnotationWriter_NotConfigured(t *testing.T) {
+	gsj := simplejson.New()
+
+	req := httptest.NewRequest(http.MethodPost, "/annotation", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
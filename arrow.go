@@ -0,0 +1,531 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ArrowContentType is the media type of responses encoded as Apache Arrow
+// IPC streams, see WithArrowEncoding.
+const ArrowContentType = "application/vnd.apache.arrow.stream"
+
+// WithArrowEncoding allows clients to request query results encoded as
+// Apache Arrow IPC streams, by sending an Accept header of
+// ArrowContentType. The results are converted to data frames, as with
+// WithDataFrames, and each frame is written as a complete IPC stream, in
+// the same form as Grafana's arrow serialization of data frames. For
+// large tables this is much smaller, and faster to encode, than JSON.
+func WithArrowEncoding() Opt {
+	return func(sjc *Handler) error {
+		sjc.arrowEncoding = true
+		return nil
+	}
+}
+
+// acceptsMediaType reports whether the client has listed mediaType in
+// its Accept header.
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			if mt, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mt == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// responseEncoding returns the media type negotiated for the response
+// to r, or "" for JSON.
+func (h *Handler) responseEncoding(r *http.Request) string {
+	if h.arrowEncoding && acceptsMediaType(r, ArrowContentType) {
+		return ArrowContentType
+	}
+	if _, ct, ok := h.binaryEncoding(r); ok {
+		return ct
+	}
+	return ""
+}
+
+// writeArrowResponse writes each frame to w as an Arrow IPC stream.
+func writeArrowResponse(w io.Writer, frames []interface{}) error {
+	bw := bufio.NewWriterSize(w, streamBufferSize)
+	for _, f := range frames {
+		df, ok := f.(*dataFrame)
+		if !ok {
+			return fmt.Errorf("cannot encode %T as arrow", f)
+		}
+		if err := df.writeArrow(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Values from the Arrow flatbuffer schemas.
+const (
+	arrowMetadataV5 = 4
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeTimestamp     = 10
+
+	arrowPrecisionDouble = 2
+	arrowUnitNanosecond  = 3
+)
+
+// arrowColumn holds the encoded buffers of a single field.
+type arrowColumn struct {
+	nulls    int
+	validity []byte
+	buffers  [][]byte // excluding the validity bitmap
+}
+
+// writeArrow writes the frame to w as an Arrow IPC stream, consisting of
+// a schema message, a single record batch, and an end of stream marker.
+func (df *dataFrame) writeArrow(w io.Writer) error {
+	schema, err := df.arrowSchema()
+	if err != nil {
+		return err
+	}
+	if err := writeArrowMessage(w, schema, nil); err != nil {
+		return err
+	}
+
+	rows := 0
+	if len(df.Data.Values) > 0 {
+		rows = len(df.Data.Values[0])
+	}
+	cols := make([]arrowColumn, len(df.Schema.Fields))
+	for i, f := range df.Schema.Fields {
+		if cols[i], err = arrowEncodeColumn(f, df.Data.Values[i]); err != nil {
+			return err
+		}
+	}
+
+	// Each buffer is padded to 8 bytes within the body.
+	var (
+		body    []byte
+		nodes   [][2]int64
+		buffers [][2]int64
+	)
+	addBuffer := func(bs []byte) {
+		buffers = append(buffers, [2]int64{int64(len(body)), int64(len(bs))})
+		body = append(body, bs...)
+		body = append(body, make([]byte, arrowPadding(len(body)))...)
+	}
+	for _, col := range cols {
+		nodes = append(nodes, [2]int64{int64(rows), int64(col.nulls)})
+		addBuffer(col.validity)
+		for _, bs := range col.buffers {
+			addBuffer(bs)
+		}
+	}
+
+	b := &fbBuilder{}
+	nodesOff := b.structVector(nodes)
+	buffersOff := b.structVector(buffers)
+	b.startTable()
+	b.addInt64(0, int64(rows))
+	b.addOffset(1, nodesOff)
+	b.addOffset(2, buffersOff)
+	batch := b.endTable()
+	if err := writeArrowMessage(w, b.message(arrowHeaderRecordBatch, batch, len(body)), body); err != nil {
+		return err
+	}
+
+	// end of stream
+	_, err = w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	return err
+}
+
+// arrowSchema returns the flatbuffer encoding of a schema message for
+// the frame. As with Grafana, the name, refId and meta of the frame, and
+// the name, labels and config of each field, are stored as metadata.
+func (df *dataFrame) arrowSchema() ([]byte, error) {
+	b := &fbBuilder{}
+
+	fields := make([]int, len(df.Schema.Fields))
+	for i, f := range df.Schema.Fields {
+		md := [][2]string{{"name", f.Name}}
+		if f.Labels != nil {
+			bs, err := json.Marshal(f.Labels)
+			if err != nil {
+				return nil, err
+			}
+			md = append(md, [2]string{"labels", string(bs)})
+		}
+		if f.Config != nil {
+			bs, err := json.Marshal(f.Config)
+			if err != nil {
+				return nil, err
+			}
+			md = append(md, [2]string{"config", string(bs)})
+		}
+		mdOff := b.keyValues(md)
+
+		var (
+			typ    byte
+			typOff int
+		)
+		switch f.TypeInfo.Frame {
+		case "time.Time":
+			tz := b.createString("UTC")
+			b.startTable()
+			b.addInt16(0, arrowUnitNanosecond)
+			b.addOffset(1, tz)
+			typ, typOff = arrowTypeTimestamp, b.endTable()
+		case "float64":
+			b.startTable()
+			b.addInt16(0, arrowPrecisionDouble)
+			typ, typOff = arrowTypeFloatingPoint, b.endTable()
+		case "bool":
+			b.startTable()
+			typ, typOff = arrowTypeBool, b.endTable()
+		default:
+			// strings, and other values encoded as JSON
+			b.startTable()
+			typ, typOff = arrowTypeUtf8, b.endTable()
+		}
+
+		name := b.createString(f.Name)
+		children := b.offsetVector(nil)
+		b.startTable()
+		b.addOffset(0, name)
+		b.addBool(1, f.TypeInfo.Nullable)
+		b.addUint8(2, typ)
+		b.addOffset(3, typOff)
+		b.addOffset(5, children)
+		b.addOffset(6, mdOff)
+		fields[i] = b.endTable()
+	}
+	fieldsOff := b.offsetVector(fields)
+
+	md := [][2]string{{"name", df.Schema.Name}, {"refId", df.Schema.RefID}}
+	if df.Schema.Meta != nil {
+		bs, err := json.Marshal(df.Schema.Meta)
+		if err != nil {
+			return nil, err
+		}
+		md = append(md, [2]string{"meta", string(bs)})
+	}
+	mdOff := b.keyValues(md)
+
+	b.startTable()
+	b.addInt16(0, 0) // little endian
+	b.addOffset(1, fieldsOff)
+	b.addOffset(2, mdOff)
+	schema := b.endTable()
+
+	return b.message(arrowHeaderSchema, schema, 0), nil
+}
+
+// arrowEncodeColumn encodes the values of a field.
+func arrowEncodeColumn(f dataFrameField, vs []interface{}) (arrowColumn, error) {
+	col := arrowColumn{validity: make([]byte, (len(vs)+7)/8)}
+	valid := func(i int, ok bool) {
+		if ok {
+			col.validity[i/8] |= 1 << (i % 8)
+		} else {
+			col.nulls++
+		}
+	}
+
+	switch f.TypeInfo.Frame {
+	case "time.Time", "float64":
+		data := make([]byte, 8*len(vs))
+		for i, v := range vs {
+			var bits uint64
+			if f.TypeInfo.Frame == "time.Time" {
+				ms, ok := v.(int64)
+				valid(i, ok)
+				bits = uint64(ms * int64(time.Millisecond))
+			} else {
+				fv, ok := arrowFloat(v)
+				valid(i, ok)
+				bits = math.Float64bits(fv)
+			}
+			binary.LittleEndian.PutUint64(data[8*i:], bits)
+		}
+		col.buffers = [][]byte{data}
+	case "bool":
+		data := make([]byte, (len(vs)+7)/8)
+		for i, v := range vs {
+			if p, ok := v.(*bool); ok && p != nil {
+				v = *p
+			}
+			bv, ok := v.(bool)
+			valid(i, ok)
+			if bv {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+		col.buffers = [][]byte{data}
+	default:
+		offsets := make([]byte, 4*(len(vs)+1))
+		var data []byte
+		for i, v := range vs {
+			if p, ok := v.(*string); ok && p != nil {
+				v = *p
+			}
+			switch sv := v.(type) {
+			case nil, *string:
+				valid(i, false)
+			case string:
+				valid(i, true)
+				data = append(data, sv...)
+			default:
+				bs, err := json.Marshal(v)
+				if err != nil {
+					return col, err
+				}
+				valid(i, true)
+				data = append(data, bs...)
+			}
+			binary.LittleEndian.PutUint32(offsets[4*(i+1):], uint32(len(data)))
+		}
+		col.buffers = [][]byte{offsets, data}
+	}
+
+	// The validity bitmap may be omitted if there are no nulls.
+	if col.nulls == 0 {
+		col.validity = nil
+	}
+	return col, nil
+}
+
+// arrowFloat returns the value of a numeric column.
+func arrowFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case *float64:
+		if v == nil {
+			return 0, false
+		}
+		return *v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// writeArrowMessage writes an encapsulated IPC message: a continuation
+// marker, the length of the metadata, the metadata padded to 8 bytes, and
+// the body.
+func writeArrowMessage(w io.Writer, meta, body []byte) error {
+	pad := arrowPadding(len(meta))
+	hdr := make([]byte, 8)
+	binary.LittleEndian.PutUint32(hdr, 0xffffffff)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(meta)+pad))
+	for _, bs := range [][]byte{hdr, meta, make([]byte, pad), body} {
+		if _, err := w.Write(bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arrowPadding returns the padding needed to align n to 8 bytes.
+func arrowPadding(n int) int {
+	return (8 - n%8) % 8
+}
+
+// fbBuilder is a minimal FlatBuffers builder, sufficient for the Arrow
+// IPC messages. As with the reference implementation, the buffer is
+// built from back to front, and offsets are measured from the end of the
+// buffer.
+type fbBuilder struct {
+	buf      []byte
+	minAlign int
+
+	tableStart int
+	fields     [][2]int // slot, offset
+}
+
+func (b *fbBuilder) prepend(bs []byte) {
+	b.buf = append(append(make([]byte, 0, len(bs)+len(b.buf)), bs...), b.buf...)
+}
+
+// prep pads the buffer so that, after additional bytes are written, the
+// next value of the given size is aligned.
+func (b *fbBuilder) prep(size, additional int) {
+	if size > b.minAlign {
+		b.minAlign = size
+	}
+	if pad := (size - (len(b.buf)+additional)%size) % size; pad > 0 {
+		b.prepend(make([]byte, pad))
+	}
+}
+
+func (b *fbBuilder) prependUint32(v uint32) {
+	b.prep(4, 0)
+	b.prepend(binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (b *fbBuilder) prependUOffset(off int) {
+	b.prep(4, 0)
+	b.prepend(binary.LittleEndian.AppendUint32(nil, uint32(len(b.buf)+4-off)))
+}
+
+func (b *fbBuilder) createString(s string) int {
+	b.prep(4, len(s)+1)
+	b.prepend(append([]byte(s), 0))
+	b.prependUint32(uint32(len(s)))
+	return len(b.buf)
+}
+
+func (b *fbBuilder) offsetVector(offs []int) int {
+	b.prep(4, 4*len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		b.prependUOffset(offs[i])
+	}
+	b.prependUint32(uint32(len(offs)))
+	return len(b.buf)
+}
+
+// structVector creates a vector of structs of two longs, such as the
+// FieldNode and Buffer structs of a record batch.
+func (b *fbBuilder) structVector(elems [][2]int64) int {
+	b.prep(4, 16*len(elems))
+	b.prep(8, 16*len(elems))
+	for i := len(elems) - 1; i >= 0; i-- {
+		bs := binary.LittleEndian.AppendUint64(nil, uint64(elems[i][0]))
+		b.prepend(binary.LittleEndian.AppendUint64(bs, uint64(elems[i][1])))
+	}
+	b.prependUint32(uint32(len(elems)))
+	return len(b.buf)
+}
+
+// keyValues creates a vector of KeyValue tables.
+func (b *fbBuilder) keyValues(kvs [][2]string) int {
+	offs := make([]int, len(kvs))
+	for i, kv := range kvs {
+		k, v := b.createString(kv[0]), b.createString(kv[1])
+		b.startTable()
+		b.addOffset(0, k)
+		b.addOffset(1, v)
+		offs[i] = b.endTable()
+	}
+	return b.offsetVector(offs)
+}
+
+func (b *fbBuilder) startTable() {
+	b.tableStart = len(b.buf)
+	b.fields = b.fields[:0]
+}
+
+func (b *fbBuilder) addField(slot, size int, bs []byte) {
+	b.prep(size, 0)
+	b.prepend(bs)
+	b.fields = append(b.fields, [2]int{slot, len(b.buf)})
+}
+
+func (b *fbBuilder) addBool(slot int, v bool) {
+	var u uint8
+	if v {
+		u = 1
+	}
+	b.addUint8(slot, u)
+}
+
+func (b *fbBuilder) addUint8(slot int, v uint8) {
+	b.addField(slot, 1, []byte{v})
+}
+
+func (b *fbBuilder) addInt16(slot int, v int16) {
+	b.addField(slot, 2, binary.LittleEndian.AppendUint16(nil, uint16(v)))
+}
+
+func (b *fbBuilder) addInt64(slot int, v int64) {
+	b.addField(slot, 8, binary.LittleEndian.AppendUint64(nil, uint64(v)))
+}
+
+func (b *fbBuilder) addOffset(slot, off int) {
+	b.prependUOffset(off)
+	b.fields = append(b.fields, [2]int{slot, len(b.buf)})
+}
+
+// endTable writes the vtable for the current table, and returns the
+// offset of the table.
+func (b *fbBuilder) endTable() int {
+	b.prependUint32(0) // placeholder for the vtable offset
+	table := len(b.buf)
+
+	slots := 0
+	for _, f := range b.fields {
+		if f[0]+1 > slots {
+			slots = f[0] + 1
+		}
+	}
+	vt := make([]uint16, 2+slots)
+	vt[0] = uint16(2 * len(vt))
+	vt[1] = uint16(table - b.tableStart)
+	for _, f := range b.fields {
+		vt[2+f[0]] = uint16(table - f[1])
+	}
+	var bs []byte
+	for _, v := range vt {
+		bs = binary.LittleEndian.AppendUint16(bs, v)
+	}
+	b.prepend(bs)
+
+	binary.LittleEndian.PutUint32(b.buf[len(b.buf)-table:], uint32(len(b.buf)-table))
+	return table
+}
+
+// finish writes the root offset and returns the completed buffer.
+func (b *fbBuilder) finish(root int) []byte {
+	b.prep(b.minAlign, 4)
+	b.prependUOffset(root)
+	return b.buf
+}
+
+// message creates an Arrow Message table with the given header, and
+// returns the completed buffer.
+func (b *fbBuilder) message(headerType byte, header, bodyLen int) []byte {
+	b.startTable()
+	b.addInt64(3, int64(bodyLen))
+	b.addOffset(2, header)
+	b.addInt16(0, arrowMetadataV5)
+	b.addUint8(1, headerType)
+	return b.finish(b.endTable())
+}
@@ -0,0 +1,279 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// fbTable is a minimal FlatBuffers table reader, used to check the
+// encoded Arrow messages.
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+func fbRoot(buf []byte) fbTable {
+	return fbTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+func (t fbTable) u32(p int) int { return int(binary.LittleEndian.Uint32(t.buf[p:])) }
+
+func (t fbTable) field(slot int) int {
+	vt := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	o := 4 + 2*slot
+	if o >= int(binary.LittleEndian.Uint16(t.buf[vt:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.buf[vt+o:]))
+	if off == 0 {
+		return 0
+	}
+	return t.pos + off
+}
+
+func (t fbTable) int(slot, size int) int64 {
+	p := t.field(slot)
+	if p == 0 {
+		return 0
+	}
+	switch size {
+	case 1:
+		return int64(t.buf[p])
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(t.buf[p:])))
+	default:
+		return int64(binary.LittleEndian.Uint64(t.buf[p:]))
+	}
+}
+
+func (t fbTable) table(slot int) fbTable {
+	p := t.field(slot)
+	return fbTable{t.buf, p + t.u32(p)}
+}
+
+func (t fbTable) str(slot int) string {
+	p := t.field(slot)
+	p += t.u32(p)
+	return string(t.buf[p+4 : p+4+t.u32(p)])
+}
+
+// vector returns the position of the first element, and the length.
+func (t fbTable) vector(slot int) (int, int) {
+	p := t.field(slot)
+	p += t.u32(p)
+	return p + 4, t.u32(p)
+}
+
+func (t fbTable) tables(slot int) []fbTable {
+	start, n := t.vector(slot)
+	ts := make([]fbTable, n)
+	for i := range ts {
+		p := start + 4*i
+		ts[i] = fbTable{t.buf, p + t.u32(p)}
+	}
+	return ts
+}
+
+func (t fbTable) metadata(slot int) map[string]string {
+	md := map[string]string{}
+	for _, kv := range t.tables(slot) {
+		md[kv.str(0)] = kv.str(1)
+	}
+	return md
+}
+
+// readArrowMessage reads an encapsulated message from bs, returning the
+// message and its body.
+func readArrowMessage(t *testing.T, bs *bytes.Reader) (fbTable, []byte) {
+	t.Helper()
+	var hdr [2]uint32
+	if err := binary.Read(bs, binary.LittleEndian, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[0] != 0xffffffff {
+		t.Fatalf("missing continuation marker")
+	}
+	if hdr[1] == 0 {
+		return fbTable{}, nil
+	}
+	if hdr[1]%8 != 0 {
+		t.Fatalf("metadata not padded, length %d", hdr[1])
+	}
+	meta := make([]byte, hdr[1])
+	bs.Read(meta)
+	msg := fbRoot(meta)
+	body := make([]byte, msg.int(3, 8))
+	bs.Read(body)
+	return msg, body
+}
+
+func TestWithArrowEncoding(t *testing.T) {
+	lat := 12.5
+	gsj := simplejson.New(
+		simplejson.WithArrowEncoding(),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{
+				{Text: "Time", Data: simplejson.TableTimeColumn{time.Unix(1, 0), time.Unix(2, 0)}},
+				{Text: "Host", Data: simplejson.TableStringColumn{"web-1", "web-22"}},
+				{Text: "Latency", Unit: "ms", Data: simplejson.Column[*float64]{&lat, nil}},
+				{Text: "OK", Data: simplejson.TableBoolColumn{false, true}},
+			}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "requests", "refId": "B", "type": "table"}]}`))
+	req.Header.Set("Accept", simplejson.ArrowContentType+", application/json;q=0.9")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != simplejson.ArrowContentType {
+		t.Fatalf("unexpected content type %q, %s", ct, w.Body.String())
+	}
+
+	r := bytes.NewReader(w.Body.Bytes())
+
+	msg, _ := readArrowMessage(t, r)
+	if v, typ := msg.int(0, 2), msg.int(1, 1); v != 4 || typ != 1 {
+		t.Fatalf("expected a V5 schema message, got version %d, type %d", v, typ)
+	}
+	schema := msg.table(2)
+	if md := schema.metadata(2); md["refId"] != "B" {
+		t.Errorf("unexpected schema metadata %v", md)
+	}
+	fields := schema.tables(1)
+	expect := []struct {
+		name string
+		typ  int64
+	}{{"Time", 10}, {"Host", 5}, {"Latency", 3}, {"OK", 6}}
+	if len(fields) != len(expect) {
+		t.Fatalf("expected %d fields, got %d", len(expect), len(fields))
+	}
+	for i, f := range fields {
+		if f.str(0) != expect[i].name || f.int(2, 1) != expect[i].typ {
+			t.Errorf("field %d: expected %s of type %d, got %s of type %d", i, expect[i].name, expect[i].typ, f.str(0), f.int(2, 1))
+		}
+	}
+	if md := fields[2].metadata(6); md["config"] != `{"unit":"ms"}` {
+		t.Errorf("unexpected field metadata %v", md)
+	}
+
+	msg, body := readArrowMessage(t, r)
+	if typ := msg.int(1, 1); typ != 3 {
+		t.Fatalf("expected a record batch, got type %d", typ)
+	}
+	batch := msg.table(2)
+	if n := batch.int(0, 8); n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+	start, n := batch.vector(1)
+	nulls := make([]int64, n)
+	for i := range nulls {
+		nulls[i] = int64(binary.LittleEndian.Uint64(msg.buf[start+16*i+8:]))
+	}
+	if nulls[0] != 0 || nulls[2] != 1 {
+		t.Errorf("unexpected null counts %v", nulls)
+	}
+
+	start, n = batch.vector(2)
+	buffer := func(i int) []byte {
+		off := binary.LittleEndian.Uint64(msg.buf[start+16*i:])
+		l := binary.LittleEndian.Uint64(msg.buf[start+16*i+8:])
+		return body[off : off+l]
+	}
+	if n != 9 {
+		t.Fatalf("expected 9 buffers, got %d", n)
+	}
+	if ts := int64(binary.LittleEndian.Uint64(buffer(1)[8:])); ts != time.Unix(2, 0).UnixNano() {
+		t.Errorf("unexpected time %d", ts)
+	}
+	if s := string(buffer(4)); s != "web-1web-22" {
+		t.Errorf("unexpected string data %q", s)
+	}
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(buffer(6))); v != 12.5 {
+		t.Errorf("unexpected value %v", v)
+	}
+	if valid := buffer(5); valid[0] != 1 {
+		t.Errorf("unexpected validity bitmap %08b", valid[0])
+	}
+	if bits := buffer(8); bits[0] != 2 {
+		t.Errorf("unexpected boolean bitmap %08b", bits[0])
+	}
+
+	if msg, _ := readArrowMessage(t, r); msg.buf != nil || r.Len() != 0 {
+		t.Errorf("expected end of stream")
+	}
+}
+
+func TestWithArrowEncoding_NotRequested(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithArrowEncoding(),
+		simplejson.WithQuerier(GSJExample{}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if v := w.Header().Get("Vary"); v != "Accept" {
+		t.Errorf("unexpected Vary header %q", v)
+	}
+}
+
+func TestWithArrowEncoding_Cache(t *testing.T) {
+	cq := &countingQuerier{}
+	gsj := simplejson.New(
+		simplejson.WithArrowEncoding(),
+		simplejson.WithQuerier(cq),
+		simplejson.WithQueryCache(time.Minute, 10),
+	)
+
+	do := func(accept string) string {
+		req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		return w.Header().Get("Content-Type")
+	}
+
+	if ct := do(simplejson.ArrowContentType); ct != simplejson.ArrowContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if ct := do(""); ct != "application/json" {
+		t.Fatalf("json client was served a cached %q response", ct)
+	}
+	if ct := do(simplejson.ArrowContentType); ct != simplejson.ArrowContentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if cq.calls.Load() != 2 {
+		t.Fatalf("expected one query per encoding, got %d calls", cq.calls.Load())
+	}
+}
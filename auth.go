@@ -0,0 +1,138 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authRealm is the realm reported to clients that fail to
+// authenticate.
+const authRealm = "simplejson"
+
+// WithBasicAuth requires that all requests are authenticated using HTTP
+// basic auth with the given user and password.
+func WithBasicAuth(user, pass string) Opt {
+	return WithBasicAuthFunc(func(u, p string) bool {
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+		return userOK && passOK
+	})
+}
+
+// WithBasicAuthFunc requires that all requests are authenticated using
+// HTTP basic auth, the provided function is called to verify the
+// credentials.
+//
+// Authentication options may be combined, a request is accepted if its
+// credentials are accepted by any of them.
+func WithBasicAuthFunc(verify func(user, pass string) bool) Opt {
+	return func(sjc *Handler) error {
+		sjc.authSchemes = append(sjc.authSchemes, authScheme{
+			name:      "Basic",
+			challenge: `Basic realm="` + authRealm + `", charset="UTF-8"`,
+			authenticate: func(r *http.Request, _ string) (context.Context, error) {
+				user, pass, ok := r.BasicAuth()
+				if !ok || !verify(user, pass) {
+					return nil, Error{Status: http.StatusUnauthorized}
+				}
+				return r.Context(), nil
+			},
+		})
+		return nil
+	}
+}
+
+// WithBearerAuth requires that all requests carry an Authorization header
+// with a bearer token, such as an API key or JWT. validate is called with
+// each token, and should return an error if it is not acceptable. The
+// context returned by validate is used for the remainder of the request,
+// allowing the validated identity to be passed on to handlers. If it
+// returns a nil context, the request's own context is kept.
+func WithBearerAuth(validate func(ctx context.Context, token string) (context.Context, error)) Opt {
+	return func(sjc *Handler) error {
+		sjc.authSchemes = append(sjc.authSchemes, authScheme{
+			name:      "Bearer",
+			challenge: `Bearer realm="` + authRealm + `"`,
+			rejected:  `Bearer realm="` + authRealm + `", error="invalid_token"`,
+			authenticate: func(r *http.Request, token string) (context.Context, error) {
+				ctx, err := validate(r.Context(), token)
+				if err == nil && ctx == nil {
+					ctx = r.Context()
+				}
+				return ctx, err
+			},
+		})
+		return nil
+	}
+}
+
+// An authScheme authenticates requests using a single HTTP
+// authentication scheme.
+type authScheme struct {
+	name      string // as given in the Authorization header
+	challenge string // sent in WWW-Authenticate when credentials are missing
+	rejected  string // sent when credentials are rejected, if not challenge
+
+	// authenticate is called with the credentials of requests using the
+	// scheme.
+	authenticate func(r *http.Request, credentials string) (context.Context, error)
+}
+
+// authenticate rejects requests that are not accepted by any of the
+// handler's authentication schemes.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, creds, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		creds = strings.TrimSpace(creds)
+
+		var rejected *authScheme
+		var rejectErr error
+		for i, as := range h.authSchemes {
+			if creds == "" || !strings.EqualFold(scheme, as.name) {
+				continue
+			}
+			ctx, err := as.authenticate(r, creds)
+			if err == nil {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if rejected == nil {
+				rejected, rejectErr = &h.authSchemes[i], err
+			}
+		}
+
+		if rejected != nil {
+			challenge := rejected.rejected
+			if challenge == "" {
+				challenge = rejected.challenge
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			writeError(w, rejectErr, http.StatusUnauthorized)
+			return
+		}
+
+		seen := map[string]bool{}
+		for _, as := range h.authSchemes {
+			if !seen[as.challenge] {
+				seen[as.challenge] = true
+				w.Header().Add("WWW-Authenticate", as.challenge)
+			}
+		}
+		writeError(w, Error{Status: http.StatusUnauthorized}, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,163 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithBasicAuth(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithBasicAuth("grafana", "secret"),
+	)
+
+	tests := []struct {
+		user, pass string
+		setAuth    bool
+		status     int
+	}{
+		{setAuth: false, status: http.StatusUnauthorized},
+		{setAuth: true, user: "grafana", pass: "wrong", status: http.StatusUnauthorized},
+		{setAuth: true, user: "grafana", pass: "secret", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+		if tt.setAuth {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("expected status %d, got %d", tt.status, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("expected WWW-Authenticate header to be set")
+		}
+	}
+}
+
+type userKey struct{}
+
+type userQuerier struct {
+	users chan string
+}
+
+func (uq userQuerier) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	uq.users <- ctx.Value(userKey{}).(string)
+	return nil, nil
+}
+
+func TestWithBearerAuth(t *testing.T) {
+	uq := userQuerier{users: make(chan string, 1)}
+	gsj := simplejson.New(
+		simplejson.WithSearcher(uq),
+		simplejson.WithBearerAuth(func(ctx context.Context, token string) (context.Context, error) {
+			if token != "mytoken" {
+				return nil, errors.New("invalid token")
+			}
+			return context.WithValue(ctx, userKey{}, "alice"), nil
+		}),
+	)
+
+	tests := []struct {
+		auth   string
+		status int
+	}{
+		{auth: "", status: http.StatusUnauthorized},
+		{auth: "Basic Zm9vOmJhcg==", status: http.StatusUnauthorized},
+		{auth: "Bearer wrong", status: http.StatusUnauthorized},
+		{auth: "Bearer mytoken", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("expected status %d, got %d", tt.status, w.Code)
+		}
+	}
+
+	if user := <-uq.users; user != "alice" {
+		t.Fatalf("expected user alice in context, got %q", user)
+	}
+}
+
+func TestWithBearerAuth_NilContext(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(staticSearcher{"a"}),
+		simplejson.WithBearerAuth(func(ctx context.Context, token string) (context.Context, error) {
+			return nil, nil
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": "x"}`))
+	req.Header.Set("Authorization", "Bearer mytoken")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+}
+
+func TestCombinedAuth(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithBasicAuth("grafana", "secret"),
+		simplejson.WithBasicAuth("admin", "hunter2"),
+		simplejson.WithBearerAuth(func(ctx context.Context, token string) (context.Context, error) {
+			if token != "mytoken" {
+				return nil, errors.New("invalid token")
+			}
+			return ctx, nil
+		}),
+	)
+
+	basic := func(user, pass string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, pass)
+		return req.Header.Get("Authorization")
+	}
+
+	tests := []struct {
+		auth       string
+		status     int
+		challenges int
+	}{
+		{auth: "", status: http.StatusUnauthorized, challenges: 2},
+		{auth: basic("grafana", "secret"), status: http.StatusOK},
+		{auth: basic("admin", "hunter2"), status: http.StatusOK},
+		{auth: basic("admin", "secret"), status: http.StatusUnauthorized, challenges: 1},
+		{auth: "Bearer mytoken", status: http.StatusOK},
+		{auth: "Bearer wrong", status: http.StatusUnauthorized, challenges: 1},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.auth, tt.status, w.Code)
+		}
+		if n := len(w.Header().Values("WWW-Authenticate")); n != tt.challenges {
+			t.Errorf("%q: expected %d WWW-Authenticate headers, got %d", tt.auth, tt.challenges, n)
+		}
+	}
+}
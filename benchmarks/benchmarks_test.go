@@ -0,0 +1,199 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package benchmarks_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// discardWriter is a ResponseWriter that counts, and discards, the
+// response, so that benchmarks measure the handler rather than the
+// recording of its output.
+type discardWriter struct {
+	header http.Header
+	n      int64
+	status int
+}
+
+func (w *discardWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *discardWriter) Write(bs []byte) (int, error) {
+	w.n += int64(len(bs))
+	return len(bs), nil
+}
+
+func (w *discardWriter) WriteHeader(status int) { w.status = status }
+
+var (
+	benchStart = time.Unix(1500000000, 0)
+	benchSizes = []int{1e2, 1e3, 1e4, 1e5, 1e6}
+)
+
+// points returns n points at one second intervals.
+func points(n int) []simplejson.DataPoint {
+	dps := make([]simplejson.DataPoint, n)
+	for i := range dps {
+		dps[i] = simplejson.DataPoint{Time: benchStart.Add(time.Duration(i) * time.Second), Value: float64(i) * 1.25}
+	}
+	return dps
+}
+
+// queryBody returns a /query request for n targets.
+func queryBody(n int, typ string) []byte {
+	targets := make([]string, n)
+	for i := range targets {
+		targets[i] = fmt.Sprintf(`{"target":"series.%d","refId":"%c","type":%q}`, i, 'A'+i%26, typ)
+	}
+	return []byte(`{"panelId":1,"range":{"from":"2017-07-14T02:40:00Z","to":"2017-07-15T02:40:00Z","raw":{"from":"now-24h","to":"now"}},` +
+		`"interval":"30s","intervalMs":30000,"maxDataPoints":1000000,` +
+		`"scopedVars":{"host":{"text":"web1","value":"web1"}},` +
+		`"adhocFilters":[{"key":"dc","operator":"=","value":"eu"}],` +
+		`"targets":[` + strings.Join(targets, ",") + `]}`)
+}
+
+// serve runs b.N requests against h, reporting the size of the request
+// and response.
+func serve(b *testing.B, h http.Handler, path string, body []byte) {
+	b.Helper()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var w *discardWriter
+	for i := 0; i < b.N; i++ {
+		w = &discardWriter{}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		h.ServeHTTP(w, req)
+	}
+
+	b.StopTimer()
+	if w.status != 0 && w.status != http.StatusOK {
+		b.Fatalf("unexpected status %d", w.status)
+	}
+	b.SetBytes(w.n)
+	b.ReportMetric(float64(len(body)), "req-bytes")
+}
+
+// BenchmarkDecode measures decoding of /query requests with an
+// increasing number of targets, with a querier that does no work.
+func BenchmarkDecode(b *testing.B) {
+	h := simplejson.New(simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+		return nil, nil
+	})))
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("targets=%d", n), func(b *testing.B) {
+			serve(b, h, "/query", queryBody(n, "timeserie"))
+		})
+	}
+}
+
+// BenchmarkFanOut measures running many targets concurrently, with a
+// querier that takes a fixed time to answer.
+func BenchmarkFanOut(b *testing.B) {
+	dps := points(100)
+	q := simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+		time.Sleep(time.Millisecond)
+		return dps, nil
+	})
+	for _, limit := range []int{0, 8} {
+		h := simplejson.New(simplejson.WithQuerier(q), simplejson.WithMaxConcurrentTargets(limit))
+		for _, n := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("limit=%d/targets=%d", limit, n), func(b *testing.B) {
+				serve(b, h, "/query", queryBody(n, "timeserie"))
+			})
+		}
+	}
+}
+
+// BenchmarkEncodeTimeserie measures encoding a single series of
+// increasing size, in the legacy and data frame formats.
+func BenchmarkEncodeTimeserie(b *testing.B) {
+	for _, frames := range []bool{false, true} {
+		for _, n := range benchSizes {
+			dps := points(n)
+			opts := []simplejson.Opt{simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+				return dps, nil
+			}))}
+			if frames {
+				opts = append(opts, simplejson.WithDataFrames())
+			}
+			h := simplejson.New(opts...)
+			b.Run(fmt.Sprintf("frames=%t/points=%d", frames, n), func(b *testing.B) {
+				serve(b, h, "/query", queryBody(1, "timeserie"))
+			})
+		}
+	}
+}
+
+// BenchmarkEncodeTable measures encoding a table with a time, number and
+// string column, of increasing size.
+func BenchmarkEncodeTable(b *testing.B) {
+	for _, n := range benchSizes {
+		times := make(simplejson.TableTimeColumn, n)
+		values := make(simplejson.TableNumberColumn, n)
+		names := make(simplejson.TableStringColumn, n)
+		for i := 0; i < n; i++ {
+			times[i] = benchStart.Add(time.Duration(i) * time.Second)
+			values[i] = float64(i) * 1.25
+			names[i] = fmt.Sprintf("host-%d", i%100)
+		}
+		cols := []simplejson.TableColumn{
+			{Text: "time", Data: times},
+			{Text: "value", Data: values},
+			{Text: "host", Data: names},
+		}
+		h := simplejson.New(simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return cols, nil
+		})))
+		b.Run(fmt.Sprintf("rows=%d", n), func(b *testing.B) {
+			serve(b, h, "/query", queryBody(1, "table"))
+		})
+	}
+}
+
+// BenchmarkEncodeAnnotations measures encoding an increasing number of
+// annotations.
+func BenchmarkEncodeAnnotations(b *testing.B) {
+	for _, n := range []int{1e2, 1e3, 1e4} {
+		anns := make([]simplejson.Annotation, n)
+		for i := range anns {
+			anns[i] = simplejson.Annotation{
+				Time:  benchStart.Add(time.Duration(i) * time.Minute),
+				Title: fmt.Sprintf("deploy %d", i),
+				Text:  "deployed <b>version</b> to production",
+				Tags:  []string{"deploy", "prod"},
+			}
+		}
+		h := simplejson.New(simplejson.WithAnnotator(simplejson.AnnotatorFunc(func(ctx context.Context, query string, args simplejson.AnnotationsArguments) ([]simplejson.Annotation, error) {
+			return anns, nil
+		})))
+		body := []byte(`{"range":{"from":"2017-07-14T02:40:00Z","to":"2017-07-15T02:40:00Z"},"annotation":{"name":"deploys","query":"deploys","enable":true}}`)
+		b.Run(fmt.Sprintf("annotations=%d", n), func(b *testing.B) {
+			serve(b, h, "/annotations", body)
+		})
+	}
+}
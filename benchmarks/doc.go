@@ -0,0 +1,46 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package benchmarks holds benchmarks of the simplejson handler, run end
+// to end through ServeHTTP, covering request decoding, the fan out of
+// query targets, and the encoding of timeseries, tables and annotations
+// at sizes from 1e2 to 1e6 points.
+//
+// Run them with:
+//
+//	go test -run none -bench . -count 10 ./benchmarks > new.txt
+//
+// and compare against a run from before a change with benchstat.
+//
+// Baseline figures, from a single run on an Intel Xeon, linux/amd64:
+//
+//	Decode/targets=1                          29µs       10KB        67 allocs
+//	Decode/targets=100                       1.0ms      193KB      1571 allocs
+//	Decode/targets=1000                       11ms      1.7MB     15100 allocs
+//	FanOut/limit=0/targets=100               4.2ms      215KB      1972 allocs
+//	FanOut/limit=8/targets=100                18ms      215KB      1972 allocs
+//	EncodeTimeserie/frames=false/points=1e2   60µs       11KB        70 allocs
+//	EncodeTimeserie/frames=false/points=1e4  1.4ms       11KB        70 allocs
+//	EncodeTimeserie/frames=false/points=1e6  173ms       11KB        73 allocs
+//	EncodeTimeserie/frames=true/points=1e2   228µs       30KB       878 allocs
+//	EncodeTimeserie/frames=true/points=1e4    13ms      1.8MB     80086 allocs
+//	EncodeTimeserie/frames=true/points=1e6   1.56s      311MB   8000171 allocs
+//	EncodeTable/rows=1e2                      89µs       23KB       471 allocs
+//	EncodeTable/rows=1e4                     4.8ms      1.2MB     40077 allocs
+//	EncodeTable/rows=1e6                     392ms      120MB   4000102 allocs
+//	EncodeAnnotations/annotations=1e2        109µs      122KB        49 allocs
+//	EncodeAnnotations/annotations=1e4         19ms       17MB        86 allocs
+//
+// The fan out benchmarks use a querier that sleeps for a millisecond, so
+// are dominated by the number of targets that may run concurrently.
+package benchmarks
@@ -0,0 +1,513 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// MessagePackContentType is the media type of responses encoded as
+// MessagePack, see WithMessagePackEncoding.
+const MessagePackContentType = "application/msgpack"
+
+// CBORContentType is the media type of responses encoded as CBOR, see
+// WithCBOREncoding.
+const CBORContentType = "application/cbor"
+
+// WithMessagePackEncoding allows clients to request query results encoded
+// as MessagePack, by sending an Accept header of MessagePackContentType.
+// The results have the same structure as the JSON response, but numbers
+// are sent as binary floats and integers, which is much cheaper to
+// encode and decode for dense series. This is intended for programmatic
+// consumers of the datasource, Grafana itself only understands JSON.
+func WithMessagePackEncoding() Opt {
+	return func(sjc *Handler) error {
+		sjc.msgpackEncoding = true
+		return nil
+	}
+}
+
+// WithCBOREncoding allows clients to request query results encoded as
+// CBOR (RFC 8949), by sending an Accept header of CBORContentType. As
+// with WithMessagePackEncoding, the results have the same structure as
+// the JSON response.
+func WithCBOREncoding() Opt {
+	return func(sjc *Handler) error {
+		sjc.cborEncoding = true
+		return nil
+	}
+}
+
+// binaryEncoding returns the encoder the client has asked for, if any.
+func (h *Handler) binaryEncoding(r *http.Request) (binaryEncoder, string, bool) {
+	switch {
+	case h.msgpackEncoding && acceptsMediaType(r, MessagePackContentType):
+		return msgpackEncoder{}, MessagePackContentType, true
+	case h.cborEncoding && acceptsMediaType(r, CBORContentType):
+		return cborEncoder{}, CBORContentType, true
+	}
+	return nil, "", false
+}
+
+// A binaryEncoder appends the encoding of values in a JSON-like binary
+// format to a buffer. Maps and arrays are written as a header giving the
+// number of entries, followed by the entries.
+type binaryEncoder interface {
+	appendNil(buf []byte) []byte
+	appendBool(buf []byte, b bool) []byte
+	appendInt(buf []byte, i int64) []byte
+	appendFloat(buf []byte, f float64) []byte
+	appendString(buf []byte, s string) []byte
+	appendArray(buf []byte, n int) []byte
+	appendMap(buf []byte, n int) []byte
+}
+
+// msgpackEncoder encodes values as MessagePack.
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) appendNil(buf []byte) []byte { return append(buf, 0xc0) }
+
+func (msgpackEncoder) appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 0xc3)
+	}
+	return append(buf, 0xc2)
+}
+
+func (msgpackEncoder) appendInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= -32 && i <= math.MaxInt8:
+		return append(buf, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+}
+
+func (msgpackEncoder) appendFloat(buf []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+}
+
+func (msgpackEncoder) appendString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func (msgpackEncoder) appendArray(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, 0xdd), uint32(n))
+}
+
+func (msgpackEncoder) appendMap(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, 0xdf), uint32(n))
+}
+
+// cborEncoder encodes values as CBOR.
+type cborEncoder struct{}
+
+// cborHead appends the initial bytes of a data item of the given major
+// type and argument.
+func cborHead(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+}
+
+func (cborEncoder) appendNil(buf []byte) []byte { return append(buf, 0xf6) }
+
+func (cborEncoder) appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 0xf5)
+	}
+	return append(buf, 0xf4)
+}
+
+func (cborEncoder) appendInt(buf []byte, i int64) []byte {
+	if i < 0 {
+		return cborHead(buf, 1, uint64(-1-i))
+	}
+	return cborHead(buf, 0, uint64(i))
+}
+
+func (cborEncoder) appendFloat(buf []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(buf, 0xfb), math.Float64bits(f))
+}
+
+func (cborEncoder) appendString(buf []byte, s string) []byte {
+	return append(cborHead(buf, 3, uint64(len(s))), s...)
+}
+
+func (cborEncoder) appendArray(buf []byte, n int) []byte { return cborHead(buf, 4, uint64(n)) }
+
+func (cborEncoder) appendMap(buf []byte, n int) []byte { return cborHead(buf, 5, uint64(n)) }
+
+// writeBinaryResponse writes the results of a query to w using enc. The
+// results must already have been checked with validateQueryResponse.
+func writeBinaryResponse(w io.Writer, enc binaryEncoder, out []interface{}) error {
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	defer func() {
+		bw.Reset(nil)
+		writerPool.Put(bw)
+	}()
+
+	bp := getBuffer()
+	buf := *bp
+	defer func() { *bp = buf; putBuffer(bp) }()
+
+	buf = enc.appendArray(buf, len(out))
+	for _, res := range out {
+		var err error
+		switch res := res.(type) {
+		case simpleJSONData:
+			buf, err = res.appendBinary(enc, buf)
+		case simpleJSONTableData:
+			buf, err = res.appendBinary(enc, buf)
+		case *dataFrame:
+			buf, err = res.appendBinary(enc, buf)
+		default:
+			// Less common results are converted from their JSON
+			// encoding.
+			var js bytes.Buffer
+			if err = writeResultJSON(&js, res); err == nil {
+				buf, err = appendBinaryJSON(enc, buf, js.Bytes())
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if len(buf) >= streamBufferSize/2 {
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeResultJSON writes the JSON encoding of a single query result to w.
+func writeResultJSON(w io.Writer, res interface{}) error {
+	if jw, ok := res.(interface{ writeJSON(io.Writer) error }); ok {
+		return jw.writeJSON(w)
+	}
+	bs, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
+	return err
+}
+
+// appendBinary appends the encoding of the series, with the same
+// structure as its JSON encoding.
+func (sjd simpleJSONData) appendBinary(enc binaryEncoder, buf []byte) ([]byte, error) {
+	n := 2
+	if sjd.RefID != "" {
+		n++
+	}
+	if sjd.config != nil {
+		n++
+	}
+	if sjd.meta != nil {
+		n++
+	}
+	buf = enc.appendMap(buf, n)
+	buf = enc.appendString(buf, "target")
+	buf = enc.appendString(buf, sjd.Target)
+	if sjd.RefID != "" {
+		buf = enc.appendString(buf, "refId")
+		buf = enc.appendString(buf, sjd.RefID)
+	}
+
+	n = len(sjd.DataPoints)
+	if sjd.nonFinite == NonFiniteDrop {
+		// The length of the array must be known before it is
+		// written, so count the points that will be kept.
+		for _, dp := range sjd.DataPoints {
+			if _, keep, _ := sjd.nonFinite.apply(dp); !keep {
+				n--
+			}
+		}
+	}
+	buf = enc.appendString(buf, "datapoints")
+	buf = enc.appendArray(buf, n)
+	for _, dp := range sjd.DataPoints {
+		dp, keep, err := sjd.nonFinite.apply(dp)
+		if err != nil {
+			return buf, err
+		}
+		if !keep {
+			continue
+		}
+		buf = enc.appendArray(buf, 2)
+		if dp.Null {
+			buf = enc.appendNil(buf)
+		} else {
+			buf = enc.appendFloat(buf, dp.Value)
+		}
+		buf = enc.appendInt(buf, epoch(dp.Time, sjd.timeUnit))
+	}
+
+	var err error
+	if sjd.config != nil {
+		buf = enc.appendString(buf, "config")
+		if buf, err = appendBinaryMarshal(enc, buf, sjd.config); err != nil {
+			return buf, err
+		}
+	}
+	if sjd.meta != nil {
+		buf = enc.appendString(buf, "meta")
+		if buf, err = appendBinaryMarshal(enc, buf, sjd.meta); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// appendBinary appends the encoding of the table, with the same structure
+// as its JSON encoding.
+func (sjd simpleJSONTableData) appendBinary(enc binaryEncoder, buf []byte) ([]byte, error) {
+	n := 3
+	if sjd.RefID != "" {
+		n++
+	}
+	if sjd.Meta != nil {
+		n++
+	}
+	buf = enc.appendMap(buf, n)
+	buf = enc.appendString(buf, "type")
+	buf = enc.appendString(buf, sjd.Type)
+	if sjd.RefID != "" {
+		buf = enc.appendString(buf, "refId")
+		buf = enc.appendString(buf, sjd.RefID)
+	}
+	buf = enc.appendString(buf, "columns")
+	buf, err := appendBinaryMarshal(enc, buf, sjd.Columns)
+	if err != nil {
+		return buf, err
+	}
+	buf = enc.appendString(buf, "rows")
+	if buf, err = appendBinaryRows(enc, buf, sjd.Rows); err != nil {
+		return buf, err
+	}
+	if sjd.Meta != nil {
+		buf = enc.appendString(buf, "meta")
+		if buf, err = appendBinaryMarshal(enc, buf, sjd.Meta); err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+// appendBinary appends the encoding of the frame, with the same structure
+// as its JSON encoding.
+func (df *dataFrame) appendBinary(enc binaryEncoder, buf []byte) ([]byte, error) {
+	buf = enc.appendMap(buf, 2)
+	buf = enc.appendString(buf, "schema")
+	buf, err := appendBinaryMarshal(enc, buf, df.Schema)
+	if err != nil {
+		return buf, err
+	}
+	buf = enc.appendString(buf, "data")
+	buf = enc.appendMap(buf, 1)
+	buf = enc.appendString(buf, "values")
+	return appendBinaryRows(enc, buf, df.Data.Values)
+}
+
+// appendBinaryRows appends an array of arrays of values, such as the
+// rows of a table or the fields of a frame.
+func appendBinaryRows[R ~[]interface{}](enc binaryEncoder, buf []byte, rows []R) ([]byte, error) {
+	if rows == nil {
+		return enc.appendNil(buf), nil
+	}
+	buf = enc.appendArray(buf, len(rows))
+	for _, row := range rows {
+		if row == nil {
+			buf = enc.appendNil(buf)
+			continue
+		}
+		buf = enc.appendArray(buf, len(row))
+		for _, v := range row {
+			var err error
+			if buf, err = appendBinaryValue(enc, buf, v); err != nil {
+				return buf, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+// appendBinaryValue appends the encoding of a single table or frame
+// value. Values are encoded as they would be in JSON, times as RFC3339
+// strings, for example, falling back to the JSON encoding of types that
+// are not handled directly.
+func appendBinaryValue(enc binaryEncoder, buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return enc.appendNil(buf), nil
+	case string:
+		return enc.appendString(buf, v), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return buf, errUnsupportedFloat(v)
+		}
+		return enc.appendFloat(buf, v), nil
+	case int64:
+		return enc.appendInt(buf, v), nil
+	case int:
+		return enc.appendInt(buf, int64(v)), nil
+	case bool:
+		return enc.appendBool(buf, v), nil
+	case *float64:
+		if v == nil {
+			return enc.appendNil(buf), nil
+		}
+		return appendBinaryValue(enc, buf, *v)
+	case *string:
+		if v == nil {
+			return enc.appendNil(buf), nil
+		}
+		return enc.appendString(buf, *v), nil
+	case *bool:
+		if v == nil {
+			return enc.appendNil(buf), nil
+		}
+		return enc.appendBool(buf, *v), nil
+	}
+	return appendBinaryMarshal(enc, buf, v)
+}
+
+// appendBinaryMarshal appends the encoding of v, converted from its JSON
+// encoding.
+func appendBinaryMarshal(enc binaryEncoder, buf []byte, v interface{}) ([]byte, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	return appendBinaryJSON(enc, buf, bs)
+}
+
+// appendBinaryJSON appends the encoding of the JSON value js, keeping the
+// order of the fields of objects. Integers are encoded as integers, and
+// other numbers as floats.
+func appendBinaryJSON(enc binaryEncoder, buf []byte, js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	return appendBinaryTokens(enc, buf, dec)
+}
+
+func appendBinaryTokens(enc binaryEncoder, buf []byte, dec *json.Decoder) ([]byte, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return buf, err
+	}
+	switch tok := tok.(type) {
+	case nil:
+		return enc.appendNil(buf), nil
+	case bool:
+		return enc.appendBool(buf, tok), nil
+	case string:
+		return enc.appendString(buf, tok), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(tok), 10, 64); err == nil {
+			return enc.appendInt(buf, i), nil
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return buf, err
+		}
+		return enc.appendFloat(buf, f), nil
+	case json.Delim:
+		// The number of entries must be known before they are
+		// written, so they are encoded separately first.
+		var body []byte
+		n := 0
+		for dec.More() {
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return buf, err
+				}
+				body = enc.appendString(body, key.(string))
+			}
+			if body, err = appendBinaryTokens(enc, body, dec); err != nil {
+				return buf, err
+			}
+			n++
+		}
+		if _, err := dec.Token(); err != nil {
+			return buf, err
+		}
+		if tok == '{' {
+			buf = enc.appendMap(buf, n)
+		} else {
+			buf = enc.appendArray(buf, n)
+		}
+		return append(buf, body...), nil
+	}
+	return buf, fmt.Errorf("unexpected JSON token %v", tok)
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func binaryTestHandler() *simplejson.Handler {
+	return simplejson.New(
+		simplejson.WithMessagePackEncoding(),
+		simplejson.WithCBOREncoding(),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return []simplejson.DataPoint{
+				{Time: time.Unix(1, 0), Value: 1.5},
+				{Time: time.Unix(2, 0), Null: true},
+			}, nil
+		})),
+	)
+}
+
+// hexString returns the hex encoding of s, so that the expected
+// encodings can include field names legibly.
+func hexString(s string) string { return hex.EncodeToString([]byte(s)) }
+
+func TestBinaryEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		ctype  string
+		expect string
+	}{
+		{
+			name:   "msgpack",
+			accept: simplejson.MessagePackContentType,
+			ctype:  simplejson.MessagePackContentType,
+			expect: "9182" + "a6" + hexString("target") + "a1" + hexString("a") +
+				"aa" + hexString("datapoints") +
+				"92" + "92cb3ff8000000000000cd03e8" + "92c0cd07d0",
+		},
+		{
+			name:   "cbor",
+			accept: "application/json;q=0.5, " + simplejson.CBORContentType,
+			ctype:  simplejson.CBORContentType,
+			expect: "81a2" + "66" + hexString("target") + "61" + hexString("a") +
+				"6a" + hexString("datapoints") +
+				"82" + "82fb3ff80000000000001903e8" + "82f61907d0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"targets":[{"target":"a"}]}`))
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			binaryTestHandler().ServeHTTP(w, req)
+
+			if ct := w.Header().Get("Content-Type"); ct != tt.ctype {
+				t.Fatalf("expected content type %q, got %q", tt.ctype, ct)
+			}
+			if got := hex.EncodeToString(w.Body.Bytes()); got != tt.expect {
+				t.Fatalf("\nexpected: %s\ngot:      %s", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestBinaryEncoding_Table(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithMessagePackEncoding(),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{{Text: "n", Data: simplejson.TableNumberColumn{-1}}}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"targets":[{"target":"t","type":"table"}]}`))
+	req.Header.Set("Accept", simplejson.MessagePackContentType)
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := "9183" + "a4" + hexString("type") + "a5" + hexString("table") +
+		"a7" + hexString("columns") + "9182" + "a4" + hexString("text") + "a1" + hexString("n") + "a4" + hexString("type") + "a6" + hexString("number") +
+		"a4" + hexString("rows") + "9191cbbff0000000000000"
+	if got := hex.EncodeToString(w.Body.Bytes()); got != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, got)
+	}
+}
+
+func TestBinaryEncoding_NotAccepted(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"a"}]}`))
+	w := httptest.NewRecorder()
+	binaryTestHandler().ServeHTTP(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON response, got %q", ct)
+	}
+	expect := `[{"target":"a","datapoints":[[1.5,1000],[null,2000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	if v := w.Header().Get("Vary"); v != "Accept" {
+		t.Fatalf("expected Vary: Accept, got %q", v)
+	}
+}
+
+func TestBinaryEncoding_Cache(t *testing.T) {
+	cq := &countingQuerier{}
+	gsj := simplejson.New(
+		simplejson.WithMessagePackEncoding(),
+		simplejson.WithCBOREncoding(),
+		simplejson.WithQuerier(cq),
+		simplejson.WithQueryCache(time.Minute, 10),
+	)
+
+	for _, ctype := range []string{simplejson.MessagePackContentType, "", simplejson.CBORContentType, simplejson.MessagePackContentType} {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"targets":[{"target":"a"}]}`))
+		if ctype != "" {
+			req.Header.Set("Accept", ctype)
+		}
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		expect := ctype
+		if expect == "" {
+			expect = "application/json"
+		}
+		if ct := w.Header().Get("Content-Type"); ct != expect {
+			t.Fatalf("expected content type %q, got %q", expect, ct)
+		}
+	}
+	if cq.calls.Load() != 3 {
+		t.Fatalf("expected one query per encoding, got %d calls", cq.calls.Load())
+	}
+}
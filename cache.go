@@ -0,0 +1,476 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheEntryBytes is the largest response that will be cached.
+const maxCacheEntryBytes = 4 * 1024 * 1024
+
+// WithQueryCache caches successful responses to /query, /search,
+// /tag-keys and /tag-values requests for ttl, holding at most maxEntries
+// responses. Requests are keyed by their normalized body, along with the
+// Grafana org and tenant making them. Queries over relative time ranges,
+// such as "now-6h" to "now", are keyed by the relative range, so that
+// dashboards refreshing within ttl will share responses.
+//
+// Responses should not be cached if handlers make use of other details of
+// the request, such as the user, or cookies.
+func WithQueryCache(ttl time.Duration, maxEntries int) Opt {
+	return func(sjc *Handler) error {
+		if sjc.cache == nil {
+			sjc.cache = newResponseCache()
+		}
+		sjc.cache.enabled = true
+		sjc.cache.maxEntries = maxEntries
+		for _, ep := range []string{"/query", "/search", "/tag-keys", "/tag-values"} {
+			if _, ok := sjc.cache.ttls[ep]; !ok {
+				sjc.cache.ttls[ep] = ttl
+			}
+		}
+		return nil
+	}
+}
+
+// WithCacheTTL sets the TTL for cached responses from an individual
+// endpoint, e.g. "/search". A ttl of 0 disables caching of that endpoint.
+// WithQueryCache must also be given for responses to be cached.
+func WithCacheTTL(endpoint string, ttl time.Duration) Opt {
+	return func(sjc *Handler) error {
+		if sjc.cache == nil {
+			sjc.cache = newResponseCache()
+		}
+		sjc.cache.ttls[endpoint] = ttl
+		return nil
+	}
+}
+
+// WithStaleWhileRevalidate allows cached responses for targets matching
+// any of the glob patterns (as used by path.Match) to be served for up to
+// stale after they expire. When a stale response is served, the cache is
+// refreshed in the background. If no patterns are given, all targets
+// match. A request with several targets is only served stale if all its
+// targets match, and is given the shortest stale period of its targets.
+// WithQueryCache must also be given for responses to be cached.
+func WithStaleWhileRevalidate(stale time.Duration, patterns ...string) Opt {
+	return func(sjc *Handler) error {
+		if len(patterns) == 0 {
+			patterns = []string{"*"}
+		}
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid stale-while-revalidate pattern %q, %w", p, err)
+			}
+		}
+		if sjc.cache == nil {
+			sjc.cache = newResponseCache()
+		}
+		sjc.cache.staleRules = append(sjc.cache.staleRules, staleRule{patterns: patterns, stale: stale})
+		return nil
+	}
+}
+
+// staleRule sets how long responses for matching targets may be served
+// after expiry.
+type staleRule struct {
+	patterns []string
+	stale    time.Duration
+}
+
+// cachedResponse is a response held in the cache.
+type cachedResponse struct {
+	key         string
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+	staleUntil  time.Time
+}
+
+// responseCache is an LRU cache of responses.
+type responseCache struct {
+	enabled    bool // set by WithQueryCache, the other options only configure it
+	maxEntries int
+	ttls       map[string]time.Duration
+	staleRules []staleRule
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	refreshing map[string]bool
+	stats      CacheStats
+}
+
+// queryCache returns the handler's response cache, or nil if
+// WithQueryCache was not given.
+func (h *Handler) queryCache() *responseCache {
+	if h.cache == nil || !h.cache.enabled {
+		return nil
+	}
+	return h.cache
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		ttls:       map[string]time.Duration{},
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		refreshing: map[string]bool{},
+	}
+}
+
+// get returns the cached response for key, and whether it is still
+// fresh.
+func (c *responseCache) get(key string, now time.Time) (*cachedResponse, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false, false
+	}
+	resp := el.Value.(*cachedResponse)
+	if now.After(resp.expires) && now.After(resp.staleUntil) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		c.stats.Misses++
+		return nil, false, false
+	}
+	c.lru.MoveToFront(el)
+	fresh := !now.After(resp.expires)
+	if fresh {
+		c.stats.Hits++
+	} else {
+		c.stats.StaleHits++
+	}
+	return resp, fresh, true
+}
+
+// CacheStats describes the state of the cache enabled by WithQueryCache.
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"maxEntries"`
+	Bytes      int    `json:"bytes"`
+	Hits       uint64 `json:"hits"`
+	StaleHits  uint64 `json:"staleHits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+// CacheStats returns the current state of the handler's response cache.
+// It returns false if WithQueryCache was not given.
+func (h *Handler) CacheStats() (CacheStats, bool) {
+	c := h.live().queryCache()
+	if c == nil {
+		return CacheStats{}, false
+	}
+	return c.currentStats(), true
+}
+
+func (c *responseCache) currentStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.stats
+	st.Entries, st.MaxEntries = c.lru.Len(), c.maxEntries
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		st.Bytes += len(el.Value.(*cachedResponse).body)
+	}
+	return st
+}
+
+// startRefresh marks key as being refreshed, returning false if a refresh
+// is already under way.
+func (c *responseCache) startRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+func (c *responseCache) endRefresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.refreshing, key)
+}
+
+// staleWindow returns how long a response to a request for endpoint, with
+// the given body, may be served after it has expired.
+func (c *responseCache) staleWindow(endpoint string, body []byte) time.Duration {
+	if len(c.staleRules) == 0 {
+		return 0
+	}
+
+	targets := requestTargets(endpoint, body)
+	if len(targets) == 0 {
+		return 0
+	}
+
+	var window time.Duration
+	for i, t := range targets {
+		stale, ok := c.targetStale(t)
+		if !ok {
+			return 0
+		}
+		if i == 0 || stale < window {
+			window = stale
+		}
+	}
+	return window
+}
+
+// targetStale finds the first rule matching target.
+func (c *responseCache) targetStale(target string) (time.Duration, bool) {
+	for _, r := range c.staleRules {
+		for _, p := range r.patterns {
+			if ok, _ := path.Match(p, target); ok {
+				return r.stale, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// requestTargets extracts the targets from a /query or /search request.
+func requestTargets(endpoint string, body []byte) []string {
+	switch endpoint {
+	case "/query":
+		req := simpleJSONQuery{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil
+		}
+		var ts []string
+		for _, t := range req.Targets {
+			ts = append(ts, t.Target)
+		}
+		return ts
+	case "/search":
+		req := simpleJSONSearchQuery{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil
+		}
+		return []string{req.Target}
+	default:
+		return nil
+	}
+}
+
+func (c *responseCache) add(resp *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[resp.key]; ok {
+		el.Value = resp
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cachedResponse).key)
+		c.stats.Evictions++
+	}
+}
+
+// cacheKey builds a normalized key for a request with the given body.
+// The key includes the negotiated response encoding, so that clients
+// asking for different encodings of the same query are not served each
+// other's responses.
+func (h *Handler) cacheKey(r *http.Request, body []byte) (string, bool) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", false
+	}
+
+	// These vary between otherwise identical requests.
+	delete(req, "requestId")
+	delete(req, "startTime")
+
+	// For relative ranges we key on the raw range, otherwise every
+	// refresh of the dashboard would produce a new key.
+	if raw, ok := req["rangeRaw"].(map[string]interface{}); ok {
+		if to, _ := raw["to"].(string); strings.HasPrefix(to, "now") {
+			delete(req, "range")
+		}
+	}
+
+	// json.Marshal sorts map keys, so this gives a stable encoding.
+	nbody, err := json.Marshal(req)
+	if err != nil {
+		return "", false
+	}
+
+	// The tenant's handler is the one that will encode the response.
+	tenantID, _ := TenantFromContext(r.Context())
+	eh := h
+	if th, ok := h.tenants[tenantID]; ok {
+		eh = th
+	}
+	return strings.Join([]string{
+		r.URL.Path,
+		r.Header.Get(grafanaOrgIDHeader),
+		tenantID,
+		eh.responseEncoding(r),
+		string(nbody),
+	}, "\x00"), true
+}
+
+// serve answers requests from the cache where possible, and caches the
+// successful responses from next.
+func (c *responseCache) serve(h *Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ttl := c.ttls[r.URL.Path]
+		if ttl <= 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(h.requestBody(w, r))
+		if err != nil {
+			writeError(w, decodeError(err), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key, ok := h.cacheKey(r, body)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if resp, fresh, ok := c.get(key, h.now()); ok {
+			h.metrics.observeCache(r.URL.Path, true)
+			if !fresh && c.startRefresh(key) {
+				rr := r.Clone(context.WithoutCancel(r.Context()))
+				go c.refresh(h.recoverPanics(next), rr, body, key, ttl, h.now)
+			}
+			resp.write(w)
+			return
+		}
+		h.metrics.observeCache(r.URL.Path, false)
+
+		cw := &cachingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+
+		if cw.status == http.StatusOK && !cw.overflow {
+			c.store(key, r.URL.Path, body, w.Header().Get("Content-Type"), cw.body.Bytes(), ttl, h.now())
+		}
+	})
+}
+
+// store adds a successful response to the cache.
+func (c *responseCache) store(key, endpoint string, reqBody []byte, contentType string, body []byte, ttl time.Duration, now time.Time) {
+	c.add(&cachedResponse{
+		key:         key,
+		status:      http.StatusOK,
+		contentType: contentType,
+		body:        body,
+		expires:     now.Add(ttl),
+		staleUntil:  now.Add(ttl + c.staleWindow(endpoint, reqBody)),
+	})
+}
+
+// refresh re-runs a request in the background, updating the cache with
+// the response.
+func (c *responseCache) refresh(next http.Handler, r *http.Request, body []byte, key string, ttl time.Duration, now func() time.Time) {
+	defer c.endRefresh(key)
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	cw := &cachingResponseWriter{ResponseWriter: &discardResponseWriter{header: http.Header{}}}
+	next.ServeHTTP(cw, r)
+
+	if cw.status == http.StatusOK && !cw.overflow {
+		c.store(key, r.URL.Path, body, cw.Header().Get("Content-Type"), cw.body.Bytes(), ttl, now())
+	}
+}
+
+func (resp *cachedResponse) write(w http.ResponseWriter) {
+	if resp.contentType != "" {
+		w.Header().Set("Content-Type", resp.contentType)
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// discardResponseWriter is used for background and replayed requests,
+// where the response is only needed for the cache or recording.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (dw *discardResponseWriter) Header() http.Header          { return dw.header }
+func (dw *discardResponseWriter) Write(bs []byte) (int, error) { return len(bs), nil }
+func (dw *discardResponseWriter) WriteHeader(int)              {}
+
+// cachingResponseWriter passes a response through to the client, while
+// keeping a copy for the cache.
+type cachingResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (cw *cachingResponseWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cachingResponseWriter) Write(bs []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.overflow {
+		if cw.body.Len()+len(bs) > maxCacheEntryBytes {
+			cw.overflow = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(bs)
+		}
+	}
+	return cw.ResponseWriter.Write(bs)
+}
+
+// Flush implements http.Flusher.
+func (cw *cachingResponseWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying
+// ResponseWriter.
+func (cw *cachingResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
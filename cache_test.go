@@ -0,0 +1,164 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type countingQuerier struct {
+	calls atomic.Int64
+}
+
+func (cq *countingQuerier) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	cq.calls.Add(1)
+	return []simplejson.DataPoint{{Time: args.To, Value: 1}}, nil
+}
+
+func (cq *countingQuerier) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	cq.calls.Add(1)
+	return []string{target}, nil
+}
+
+func relativeQuery(from, to, target string) string {
+	return `{
+		"range": { "from": "` + from + `", "to": "` + to + `" },
+		"rangeRaw": { "from": "now-6h", "to": "now" },
+		"requestId": "` + from + `",
+		"interval": "30s",
+		"targets": [ { "target": "` + target + `", "refId": "A" } ]
+	}`
+}
+
+func TestWithQueryCache(t *testing.T) {
+	cq := &countingQuerier{}
+	gsj := simplejson.New(
+		simplejson.WithQuerier(cq),
+		simplejson.WithSearcher(cq),
+		simplejson.WithQueryCache(time.Minute, 10),
+	)
+
+	do := func(path, body string) string {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	first := do("/query", relativeQuery("2016-10-31T06:33:44.866Z", "2016-10-31T12:33:44.866Z", "a"))
+	second := do("/query", relativeQuery("2016-10-31T06:33:49.866Z", "2016-10-31T12:33:49.866Z", "a"))
+	if cq.calls.Load() != 1 {
+		t.Fatalf("expected refreshed relative query to be cached, got %d calls", cq.calls.Load())
+	}
+	if first != second {
+		t.Fatalf("cached response differs\nfirst: %s\nsecond: %s", first, second)
+	}
+
+	do("/query", relativeQuery("2016-10-31T06:33:44.866Z", "2016-10-31T12:33:44.866Z", "b"))
+	if cq.calls.Load() != 2 {
+		t.Fatalf("expected different target to miss the cache, got %d calls", cq.calls.Load())
+	}
+
+	do("/search", `{"target": "x"}`)
+	do("/search", `{ "target" : "x" }`)
+	if cq.calls.Load() != 3 {
+		t.Fatalf("expected normalized search to be cached, got %d calls", cq.calls.Load())
+	}
+}
+
+func TestWithCacheTTL(t *testing.T) {
+	cq := &countingQuerier{}
+	gsj := simplejson.New(
+		simplejson.WithSearcher(cq),
+		simplejson.WithQueryCache(time.Minute, 10),
+		simplejson.WithCacheTTL("/search", 0),
+	)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": "x"}`))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+	}
+	if cq.calls.Load() != 2 {
+		t.Fatalf("expected search caching to be disabled, got %d calls", cq.calls.Load())
+	}
+}
+
+func TestWithCacheTTL_NoCache(t *testing.T) {
+	cq := &countingQuerier{}
+	opts := []simplejson.Opt{
+		simplejson.WithSearcher(cq),
+		simplejson.WithCacheTTL("/search", time.Minute),
+	}
+	gsj := simplejson.New(opts...)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": "x"}`))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+	}
+	if cq.calls.Load() != 3 {
+		t.Fatalf("expected no caching without WithQueryCache, got %d calls", cq.calls.Load())
+	}
+	if _, ok := gsj.CacheStats(); ok {
+		t.Errorf("expected no cache statistics without WithQueryCache")
+	}
+
+	if _, err := simplejson.NewWithError(opts...); err == nil {
+		t.Errorf("expected an error for WithCacheTTL without WithQueryCache")
+	}
+}
+
+func TestWithStaleWhileRevalidate(t *testing.T) {
+	cq := &countingQuerier{}
+	gsj := simplejson.New(
+		simplejson.WithSearcher(cq),
+		simplejson.WithQueryCache(20*time.Millisecond, 10),
+		simplejson.WithStaleWhileRevalidate(time.Minute, "slow.*"),
+	)
+
+	do := func(target string) {
+		req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": "`+target+`"}`))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+
+	do("slow.cpu")
+	do("fast.cpu")
+	time.Sleep(30 * time.Millisecond)
+
+	// The fast target has expired and must be fetched again, the slow
+	// target is served stale and refreshed in the background.
+	do("fast.cpu")
+	if n := cq.calls.Load(); n != 3 {
+		t.Fatalf("expected 3 backend calls, got %d", n)
+	}
+	do("slow.cpu")
+
+	deadline := time.Now().Add(time.Second)
+	for cq.calls.Load() != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected background refresh, got %d calls", cq.calls.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The refreshed entry is fresh again.
+	do("slow.cpu")
+	time.Sleep(5 * time.Millisecond)
+	if n := cq.calls.Load(); n != 4 {
+		t.Fatalf("expected refreshed response to be served, got %d calls", n)
+	}
+}
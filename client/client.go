@@ -0,0 +1,419 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client provides a Go client for SimpleJSON datasources, such as
+// those served by the simplejson package, allowing services and tests to
+// query them programmatically. Requests and responses use the same types
+// as the server side, e.g. simplejson.QueryArguments and
+// simplejson.DataPoint.
+//
+//	c, err := client.New("http://localhost:8080")
+//	...
+//	series, err := c.Query(ctx, simplejson.QueryArguments{
+//		QueryCommonArguments: simplejson.QueryCommonArguments{From: from, To: to},
+//		Interval:             time.Minute,
+//	}, client.Target{Target: "cpu"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// Client makes requests to a SimpleJSON datasource.
+type Client struct {
+	baseURL *url.URL
+	hc      *http.Client
+	header  http.Header
+}
+
+// An Opt configures a Client.
+type Opt func(*Client) error
+
+// WithHTTPClient sets the http.Client used to make requests, the default
+// is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Opt {
+	return func(c *Client) error {
+		c.hc = hc
+		return nil
+	}
+}
+
+// WithHeader adds a header to every request, e.g. for authentication.
+func WithHeader(key, value string) Opt {
+	return func(c *Client) error {
+		c.header.Add(key, value)
+		return nil
+	}
+}
+
+// New creates a client for the datasource served at baseURL.
+func New(baseURL string, opts ...Opt) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid datasource URL %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{baseURL: u, hc: http.DefaultClient, header: http.Header{}}
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// A Target is a single target of a query.
+type Target struct {
+	Target    string
+	RefID     string
+	QueryType string
+	Hide      bool
+	Payload   json.RawMessage
+}
+
+// Series is a timeserie returned by a query.
+type Series struct {
+	Target     string
+	RefID      string
+	DataPoints []simplejson.DataPoint
+	Config     *simplejson.FieldConfig
+}
+
+// Table is a table returned by a query. Values in "time" columns are
+// returned as time.Time, other values as decoded by encoding/json.
+type Table struct {
+	RefID   string
+	Columns []simplejson.TableColumnHeader
+	Rows    [][]interface{}
+}
+
+// A TagKey is a key that may be used in adhoc filters.
+type TagKey struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type queryTarget struct {
+	Target    string          `json:"target"`
+	RefID     string          `json:"refId,omitempty"`
+	QueryType string          `json:"queryType,omitempty"`
+	Hide      bool            `json:"hide,omitempty"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+type queryRange struct {
+	From time.Time           `json:"from"`
+	To   time.Time           `json:"to"`
+	Raw  simplejson.RawRange `json:"raw"`
+}
+
+type queryRequest struct {
+	Range         queryRange                      `json:"range"`
+	RangeRaw      simplejson.RawRange             `json:"rangeRaw"`
+	Interval      string                          `json:"interval,omitempty"`
+	IntervalMS    int64                           `json:"intervalMs,omitempty"`
+	MaxDataPoints int                             `json:"maxDataPoints,omitempty"`
+	Targets       []queryTarget                   `json:"targets"`
+	AdhocFilters  []simplejson.QueryAdhocFilter   `json:"adhocFilters,omitempty"`
+	ScopedVars    map[string]simplejson.ScopedVar `json:"scopedVars,omitempty"`
+}
+
+// queryResult holds the fields of either a timeserie or a table in a
+// query response.
+type queryResult struct {
+	Target     string                         `json:"target"`
+	RefID      string                         `json:"refId"`
+	Type       string                         `json:"type"`
+	DataPoints [][2]*float64                  `json:"datapoints"`
+	Config     *simplejson.FieldConfig        `json:"config"`
+	Columns    []simplejson.TableColumnHeader `json:"columns"`
+	Rows       [][]interface{}                `json:"rows"`
+}
+
+// Query requests timeseries for targets.
+func (c *Client) Query(ctx context.Context, args simplejson.QueryArguments, targets ...Target) ([]Series, error) {
+	res, err := c.query(ctx, "timeserie", args, simplejson.RawRange{}, nil, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Series
+	for _, r := range res {
+		if r.Type == "table" {
+			continue
+		}
+		s := Series{Target: r.Target, RefID: r.RefID, Config: r.Config}
+		for _, dp := range r.DataPoints {
+			if dp[1] == nil {
+				return nil, fmt.Errorf("datapoint of %q has no timestamp", r.Target)
+			}
+			t := time.UnixMilli(int64(*dp[1]))
+			if dp[0] == nil {
+				s.DataPoints = append(s.DataPoints, simplejson.NullDataPoint(t))
+				continue
+			}
+			s.DataPoints = append(s.DataPoints, simplejson.DataPoint{Time: t, Value: *dp[0]})
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// QueryTable requests tables for targets. The RefID, Hidden and Payload
+// of args are ignored, they are taken from each target instead.
+func (c *Client) QueryTable(ctx context.Context, args simplejson.TableQueryArguments, targets ...Target) ([]Table, error) {
+	qargs := simplejson.QueryArguments{QueryCommonArguments: args.QueryCommonArguments}
+	res, err := c.query(ctx, "table", qargs, args.RawRange, args.ScopedVars, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Table
+	for _, r := range res {
+		if r.Type != "table" {
+			continue
+		}
+		t := Table{RefID: r.RefID, Columns: r.Columns, Rows: r.Rows}
+		for i, col := range t.Columns {
+			if col.Type != "time" {
+				continue
+			}
+			for _, row := range t.Rows {
+				if i >= len(row) {
+					return nil, fmt.Errorf("table row has %d values, expected %d", len(row), len(t.Columns))
+				}
+				if row[i], err = decodeTime(row[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// decodeTime converts a value from a time column, which may be an
+// RFC3339 string or milliseconds since the epoch.
+func decodeTime(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case float64:
+		return time.UnixMilli(int64(v)), nil
+	default:
+		return v, nil
+	}
+}
+
+func (c *Client) query(ctx context.Context, typ string, args simplejson.QueryArguments, raw simplejson.RawRange, vars map[string]simplejson.ScopedVar, targets []Target) ([]queryResult, error) {
+	req := queryRequest{
+		Range:         queryRange{From: args.From, To: args.To, Raw: raw},
+		RangeRaw:      raw,
+		MaxDataPoints: args.MaxDPs,
+		AdhocFilters:  args.Filters,
+		ScopedVars:    vars,
+	}
+	if args.Interval > 0 {
+		req.Interval = args.Interval.String()
+		req.IntervalMS = args.Interval.Milliseconds()
+	}
+	for _, t := range targets {
+		req.Targets = append(req.Targets, queryTarget{
+			Target:    t.Target,
+			RefID:     t.RefID,
+			QueryType: t.QueryType,
+			Hide:      t.Hide,
+			Type:      typ,
+			Payload:   t.Payload,
+		})
+	}
+
+	var res []queryResult
+	if err := c.do(ctx, "/query", req, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+type annotationRequest struct {
+	Range      queryRange          `json:"range"`
+	RangeRaw   simplejson.RawRange `json:"rangeRaw"`
+	Annotation struct {
+		Name     string   `json:"name"`
+		Query    string   `json:"query"`
+		Enable   bool     `json:"enable"`
+		Tags     []string `json:"tags,omitempty"`
+		MatchAny bool     `json:"matchAny,omitempty"`
+		Limit    int      `json:"limit,omitempty"`
+	} `json:"annotation"`
+}
+
+type annotationResponse struct {
+	ID       string   `json:"id"`
+	Time     int64    `json:"time"`
+	TimeEnd  int64    `json:"timeEnd"`
+	RegionID int      `json:"regionId"`
+	Title    string   `json:"title"`
+	Text     string   `json:"text"`
+	Tags     []string `json:"tags"`
+}
+
+// Annotations requests the annotations matching q. Regions sent in the
+// legacy format, as pairs of annotations, are returned as a single
+// annotation with a TimeEnd.
+func (c *Client) Annotations(ctx context.Context, q simplejson.AnnotationQuery) ([]simplejson.Annotation, error) {
+	req := annotationRequest{
+		Range:    queryRange{From: q.From, To: q.To, Raw: q.RawRange},
+		RangeRaw: q.RawRange,
+	}
+	req.Annotation.Name = q.Name
+	req.Annotation.Query = q.Query
+	req.Annotation.Enable = true
+	req.Annotation.Tags = q.Tags
+	req.Annotation.MatchAny = q.MatchAny
+	req.Annotation.Limit = q.Limit
+
+	var res []annotationResponse
+	if err := c.do(ctx, "/annotations", req, &res); err != nil {
+		return nil, err
+	}
+
+	var out []simplejson.Annotation
+	regions := map[int]int{}
+	for _, r := range res {
+		if r.RegionID != 0 {
+			if i, ok := regions[r.RegionID]; ok {
+				out[i].TimeEnd = time.UnixMilli(r.Time)
+				continue
+			}
+			regions[r.RegionID] = len(out)
+		}
+		a := simplejson.Annotation{
+			ID:    r.ID,
+			Time:  time.UnixMilli(r.Time),
+			Title: r.Title,
+			Text:  r.Text,
+			Tags:  r.Tags,
+		}
+		if r.TimeEnd != 0 {
+			a.TimeEnd = time.UnixMilli(r.TimeEnd)
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// Search requests the metrics matching target. Results sent as plain
+// strings are returned with the string as both the Text and Value.
+func (c *Client) Search(ctx context.Context, target string) ([]simplejson.SearchResult, error) {
+	var res []json.RawMessage
+	if err := c.do(ctx, "/search", map[string]string{"target": target}, &res); err != nil {
+		return nil, err
+	}
+
+	out := make([]simplejson.SearchResult, len(res))
+	for i, r := range res {
+		var s string
+		if err := json.Unmarshal(r, &s); err == nil {
+			out[i] = simplejson.SearchResult{Text: s, Value: s}
+			continue
+		}
+		if err := json.Unmarshal(r, &out[i]); err != nil {
+			return nil, fmt.Errorf("invalid search result, %w", err)
+		}
+	}
+	return out, nil
+}
+
+// TagKeys requests the keys that may be used in adhoc filters.
+func (c *Client) TagKeys(ctx context.Context) ([]TagKey, error) {
+	var res []TagKey
+	if err := c.do(ctx, "/tag-keys", struct{}{}, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TagValues requests the values of the adhoc filter key.
+func (c *Client) TagValues(ctx context.Context, key string) ([]string, error) {
+	var res []struct {
+		Text string `json:"text"`
+	}
+	if err := c.do(ctx, "/tag-values", map[string]string{"key": key}, &res); err != nil {
+		return nil, err
+	}
+
+	out := make([]string, len(res))
+	for i, r := range res {
+		out[i] = r.Text
+	}
+	return out, nil
+}
+
+// do POSTs the JSON encoding of req to path, decoding the response into
+// res. Error responses are returned as a simplejson.Error.
+func (c *Client) do(ctx context.Context, path string, req, res interface{}) error {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	for k, vs := range c.header {
+		hreq.Header[k] = vs
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "application/json")
+
+	resp, err := c.hc.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &e)
+		return simplejson.Error{Status: resp.StatusCode, Message: e.Message}
+	}
+	if err := json.Unmarshal(body, res); err != nil {
+		return fmt.Errorf("invalid response from %s, %w", path, err)
+	}
+	return nil
+}
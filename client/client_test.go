@@ -0,0 +1,197 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/client"
+)
+
+type source struct{}
+
+func (source) GrafanaQueryRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+	if req.Target == "fail" {
+		return nil, simplejson.Error{Status: http.StatusBadGateway, Message: "backend failed"}
+	}
+	return []simplejson.DataPoint{
+		{Time: req.From, Value: float64(req.Interval / time.Second)},
+		simplejson.NullDataPoint(req.To),
+	}, nil
+}
+
+func (source) GrafanaQueryTableRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+	return []simplejson.TableColumn{
+		{Text: "Time", Data: simplejson.TableTimeColumn{req.From}},
+		{Text: "Target", Data: simplejson.TableStringColumn{req.Target}},
+	}, nil
+}
+
+func (source) GrafanaAnnotationQuery(ctx context.Context, q simplejson.AnnotationQuery) ([]simplejson.Annotation, error) {
+	return []simplejson.Annotation{
+		{Time: q.From, Title: q.Query, Tags: q.Tags},
+		{Time: q.From, TimeEnd: q.To, Title: "region"},
+	}, nil
+}
+
+func (source) GrafanaSearchResults(ctx context.Context, target string) ([]simplejson.SearchResult, error) {
+	return []simplejson.SearchResult{{Text: "Host " + target, Value: 1.0}}, nil
+}
+
+func (source) GrafanaAdhocFilterTags(ctx context.Context) ([]simplejson.TagInfoer, error) {
+	return []simplejson.TagInfoer{simplejson.TagStringKey("host")}, nil
+}
+
+func (source) GrafanaAdhocFilterTagValues(ctx context.Context, key string) ([]simplejson.TagValuer, error) {
+	return []simplejson.TagValuer{simplejson.TagStringValue(key + "-1")}, nil
+}
+
+var (
+	from = time.UnixMilli(1000).UTC()
+	to   = time.UnixMilli(2000).UTC()
+)
+
+func newClient(t *testing.T, opts ...simplejson.Opt) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(simplejson.New(append([]simplejson.Opt{simplejson.WithSource(source{})}, opts...)...))
+	t.Cleanup(srv.Close)
+	c, err := client.New(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestQuery(t *testing.T) {
+	c := newClient(t)
+	series, err := c.Query(context.Background(), simplejson.QueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: from, To: to},
+		Interval:             30 * time.Second,
+	}, client.Target{Target: "cpu", RefID: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []client.Series{{
+		Target: "cpu",
+		RefID:  "A",
+		DataPoints: []simplejson.DataPoint{
+			{Time: time.UnixMilli(1000), Value: 30},
+			simplejson.NullDataPoint(time.UnixMilli(2000)),
+		},
+	}}
+	if !reflect.DeepEqual(series, expect) {
+		t.Fatalf("\nexpected: %#v\ngot:      %#v", expect, series)
+	}
+}
+
+func TestQuery_Error(t *testing.T) {
+	c := newClient(t)
+	_, err := c.Query(context.Background(), simplejson.QueryArguments{}, client.Target{Target: "fail"})
+
+	var e simplejson.Error
+	if !errors.As(err, &e) || e.Status != http.StatusBadGateway || e.Message != "backend failed" {
+		t.Fatalf("unexpected error %#v", err)
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	c := newClient(t)
+	tables, err := c.QueryTable(context.Background(), simplejson.TableQueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: from, To: to},
+	}, client.Target{Target: "requests", RefID: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []client.Table{{
+		RefID: "B",
+		Columns: []simplejson.TableColumnHeader{
+			{Text: "Time", Type: "time"},
+			{Text: "Target", Type: "string"},
+		},
+		Rows: [][]interface{}{{from, "requests"}},
+	}}
+	if !reflect.DeepEqual(tables, expect) {
+		t.Fatalf("\nexpected: %#v\ngot:      %#v", expect, tables)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	for _, format := range []simplejson.AnnotationRegionFormat{simplejson.AnnotationRegionsLegacy, simplejson.AnnotationRegionsModern} {
+		c := newClient(t, simplejson.WithAnnotationRegionFormat(format))
+		anns, err := c.Annotations(context.Background(), simplejson.AnnotationQuery{
+			AnnotationsArguments: simplejson.AnnotationsArguments{
+				QueryCommonArguments: simplejson.QueryCommonArguments{From: from, To: to},
+			},
+			Query: "deploys",
+			Tags:  []string{"prod"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := []simplejson.Annotation{
+			{Time: time.UnixMilli(1000), Title: "deploys", Tags: []string{"prod"}},
+			{Time: time.UnixMilli(1000), TimeEnd: time.UnixMilli(2000), Title: "region"},
+		}
+		if !reflect.DeepEqual(anns, expect) {
+			t.Fatalf("format %v\nexpected: %#v\ngot:      %#v", format, expect, anns)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	c := newClient(t)
+	res, err := c.Search(context.Background(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []simplejson.SearchResult{{Text: "Host web", Value: 1.0}}
+	if !reflect.DeepEqual(res, expect) {
+		t.Fatalf("\nexpected: %#v\ngot:      %#v", expect, res)
+	}
+}
+
+func TestTags(t *testing.T) {
+	c := newClient(t)
+	keys, err := c.TagKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []client.TagKey{{Type: "string", Text: "host"}}; !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("\nexpected: %#v\ngot:      %#v", expect, keys)
+	}
+
+	vals, err := c.TagValues(context.Background(), "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"host-1"}; !reflect.DeepEqual(vals, expect) {
+		t.Fatalf("\nexpected: %#v\ngot:      %#v", expect, vals)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := client.New("localhost"); err == nil {
+		t.Errorf("expected an error for a URL without a scheme")
+	}
+}
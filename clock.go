@@ -0,0 +1,106 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Clock tells the handler the current time. It is used to resolve
+// relative time ranges, such as "now-1h", to expire cached responses, and
+// to timestamp recorded requests. Durations, such as those reported in
+// metrics, are always measured with the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc allows a function to be used as a Clock.
+type ClockFunc func() time.Time
+
+// Now implements Clock.
+func (f ClockFunc) Now() time.Time { return f() }
+
+// A SimulatedClock is a Clock whose time only changes when it is set or
+// advanced.
+type SimulatedClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewSimulatedClock returns a clock stopped at t.
+func NewSimulatedClock(t time.Time) *SimulatedClock {
+	return &SimulatedClock{t: t}
+}
+
+// Now implements Clock.
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Set sets the time of the clock.
+func (c *SimulatedClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+// Advance moves the clock forward by d.
+func (c *SimulatedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// WithClock sets the clock used by the handler, the default is the
+// system clock. A fixed clock allows tests to produce deterministic
+// output, and a simulated one allows recorded requests to be replayed
+// as of the time they were made. Queriers should use Now to get the time
+// from the handler's clock.
+func WithClock(c Clock) Opt {
+	return func(sjc *Handler) error {
+		sjc.clock = c
+		return nil
+	}
+}
+
+// now returns the current time according to the handler's clock.
+func (h *Handler) now() time.Time {
+	if h.clock == nil {
+		return time.Now()
+	}
+	return h.clock.Now()
+}
+
+// Now returns the current time according to the clock of the handler
+// serving the request in ctx, see WithClock. Outside of a handler, or if
+// no clock has been set, it returns time.Now().
+func Now(ctx context.Context) time.Time {
+	if c, ok := ctx.Value(clockContextKey).(Clock); ok {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// withClock adds the handler's clock to the context of requests, so that
+// it is available to Now.
+func (h *Handler) withClock(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clockContextKey, h.clock)))
+	})
+}
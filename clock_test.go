@@ -0,0 +1,85 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// clockTestHandler returns a handler that answers each query with a
+// single point at the end of the range, with the value of the handler's
+// clock.
+func clockTestHandler(clock simplejson.Clock) *simplejson.Handler {
+	return simplejson.New(
+		simplejson.WithClock(clock),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return []simplejson.DataPoint{{Time: args.To, Value: float64(simplejson.Now(ctx).Unix())}}, nil
+		})),
+	)
+}
+
+const clockTestQuery = `{"rangeRaw":{"from":"now-1h","to":"now"},"targets":[{"target":"a"}]}`
+
+func TestWithClock(t *testing.T) {
+	clock := simplejson.NewSimulatedClock(time.Unix(3600, 0))
+	gsj := clockTestHandler(clock)
+
+	for _, expect := range []string{
+		`[{"target":"a","datapoints":[[3600,3600000]]}]`,
+		`[{"target":"a","datapoints":[[3660,3660000]]}]`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(clockTestQuery))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Body.String() != expect {
+			t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+		}
+		clock.Advance(time.Minute)
+	}
+}
+
+func TestNow_NoHandler(t *testing.T) {
+	before := time.Now()
+	if now := simplejson.Now(context.Background()); now.Before(before) {
+		t.Fatalf("expected the system time, got %v", now)
+	}
+}
+
+func TestReplay_SimulatedClock(t *testing.T) {
+	recs := []simplejson.Recording{
+		{Time: time.Unix(7200, 0), Method: http.MethodPost, URL: "/query", Body: clockTestQuery},
+		{Time: time.Unix(3600, 0), Method: http.MethodPost, URL: "/query", Body: clockTestQuery},
+	}
+
+	res, err := simplejson.Replay(clockTestHandler(simplejson.NewSimulatedClock(time.Time{})), recs)
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	for i, expect := range []string{
+		`[{"target":"a","datapoints":[[7200,7200000]]}]`,
+		`[{"target":"a","datapoints":[[3600,3600000]]}]`,
+	} {
+		if res[i].Response.Body != expect {
+			t.Errorf("%d:\nexpected: %s\ngot:      %s", i, expect, res[i].Response.Body)
+		}
+	}
+}
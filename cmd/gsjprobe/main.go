@@ -0,0 +1,428 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gsjprobe sends requests to a running SimpleJSON datasource, and
+// prints the responses. It is intended for debugging deployments without
+// needing Grafana.
+//
+//	gsjprobe -url http://localhost:8080 -from now-6h query cpu mem
+//	gsjprobe search
+//	gsjprobe -json annotations deploys
+//	gsjprobe check
+//
+// The check command sends the canned requests of the simplejsontest
+// package, as made by several versions of Grafana, and reports any that
+// fail or have malformed responses.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/client"
+	"github.com/tcolgate/grafana-simple-json-go/simplejsontest"
+)
+
+const usage = `usage: gsjprobe [flags] command [args]
+
+commands:
+  query target...    query timeseries
+  table target...    query tables
+  search [target]    search for metrics
+  annotations [query]
+                     query annotations
+  tag-keys           list adhoc filter keys
+  tag-values key     list the values of an adhoc filter key
+  check              send canned Grafana requests and validate the responses
+
+flags:
+`
+
+// headers collects repeated -H flags.
+type headers []string
+
+func (h *headers) String() string { return strings.Join(*h, ", ") }
+
+func (h *headers) Set(v string) error {
+	if _, _, ok := strings.Cut(v, ":"); !ok {
+		return fmt.Errorf("header %q should be of the form Key: Value", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// probe holds the configuration for a run of the tool.
+type probe struct {
+	url      string
+	from, to time.Time
+	interval time.Duration
+	maxDPs   int
+	headers  headers
+	json     bool
+	timeout  time.Duration
+
+	out io.Writer
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "gsjprobe:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	p := &probe{out: stdout}
+
+	fs := flag.NewFlagSet("gsjprobe", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&p.url, "url", "http://localhost:8080", "base URL of the datasource")
+	from := fs.String("from", "now-1h", "start of the time range, absolute or relative to now")
+	to := fs.String("to", "now", "end of the time range, absolute or relative to now")
+	fs.DurationVar(&p.interval, "interval", 0, "query interval, defaults to the range divided by -max-data-points")
+	fs.IntVar(&p.maxDPs, "max-data-points", 1000, "maximum datapoints to request per series")
+	fs.Var(&p.headers, "H", "header to add to requests, e.g. \"Authorization: Bearer xyz\" (may be repeated)")
+	fs.BoolVar(&p.json, "json", false, "print responses as JSON")
+	fs.DurationVar(&p.timeout, "timeout", 30*time.Second, "timeout for each request")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	now := time.Now()
+	var err error
+	if p.from, err = simplejson.ParseRelativeTime(*from, now); err != nil {
+		return err
+	}
+	if p.to, err = simplejson.ParseRelativeTime(*to, now); err != nil {
+		return err
+	}
+	if !p.from.Before(p.to) {
+		return fmt.Errorf("-from must be before -to")
+	}
+	if p.interval == 0 && p.maxDPs > 0 {
+		p.interval = max(p.to.Sub(p.from)/time.Duration(p.maxDPs), time.Millisecond).Round(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "query":
+		return p.query(ctx, cmdArgs)
+	case "table":
+		return p.table(ctx, cmdArgs)
+	case "search":
+		return p.search(ctx, cmdArgs)
+	case "annotations":
+		return p.annotations(ctx, cmdArgs)
+	case "tag-keys":
+		return p.tagKeys(ctx)
+	case "tag-values":
+		return p.tagValues(ctx, cmdArgs)
+	case "check":
+		return p.check(ctx)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func (p *probe) client() (*client.Client, error) {
+	opts := []client.Opt{client.WithHTTPClient(&http.Client{Timeout: p.timeout})}
+	for _, h := range p.headers {
+		k, v, _ := strings.Cut(h, ":")
+		opts = append(opts, client.WithHeader(strings.TrimSpace(k), strings.TrimSpace(v)))
+	}
+	return client.New(p.url, opts...)
+}
+
+func (p *probe) targets(args []string) ([]client.Target, error) {
+	if len(args) == 0 {
+		return nil, errors.New("at least one target is required")
+	}
+	ts := make([]client.Target, len(args))
+	for i, a := range args {
+		ts[i] = client.Target{Target: a, RefID: string(rune('A' + i%26))}
+	}
+	return ts, nil
+}
+
+// printJSON prints v as indented JSON, and reports whether JSON output
+// was requested.
+func (p *probe) printJSON(v interface{}) (bool, error) {
+	if !p.json {
+		return false, nil
+	}
+	enc := json.NewEncoder(p.out)
+	enc.SetIndent("", "  ")
+	return true, enc.Encode(v)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func (p *probe) query(ctx context.Context, args []string) error {
+	targets, err := p.targets(args)
+	if err != nil {
+		return err
+	}
+	c, err := p.client()
+	if err != nil {
+		return err
+	}
+	series, err := c.Query(ctx, simplejson.QueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: p.from, To: p.to},
+		Interval:             p.interval,
+		MaxDPs:               p.maxDPs,
+	}, targets...)
+	if err != nil {
+		return err
+	}
+	if ok, err := p.printJSON(series); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	for i, s := range series {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "# %s (%s), %d points\n", s.Target, s.RefID, len(s.DataPoints))
+		for _, dp := range s.DataPoints {
+			v := "null"
+			if !dp.Null {
+				v = fmt.Sprint(dp.Value)
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", formatTime(dp.Time), v)
+		}
+	}
+	return tw.Flush()
+}
+
+func (p *probe) table(ctx context.Context, args []string) error {
+	targets, err := p.targets(args)
+	if err != nil {
+		return err
+	}
+	c, err := p.client()
+	if err != nil {
+		return err
+	}
+	tables, err := c.QueryTable(ctx, simplejson.TableQueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: p.from, To: p.to},
+	}, targets...)
+	if err != nil {
+		return err
+	}
+	if ok, err := p.printJSON(tables); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	for i, t := range tables {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "# %s, %d rows\n", t.RefID, len(t.Rows))
+		hdrs := make([]string, len(t.Columns))
+		for j, col := range t.Columns {
+			hdrs[j] = col.Text
+		}
+		fmt.Fprintln(tw, strings.Join(hdrs, "\t"))
+		for _, row := range t.Rows {
+			vals := make([]string, len(row))
+			for j, v := range row {
+				switch v := v.(type) {
+				case time.Time:
+					vals[j] = formatTime(v)
+				case nil:
+					vals[j] = "null"
+				default:
+					vals[j] = fmt.Sprint(v)
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(vals, "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+func (p *probe) search(ctx context.Context, args []string) error {
+	c, err := p.client()
+	if err != nil {
+		return err
+	}
+	res, err := c.Search(ctx, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	if ok, err := p.printJSON(res); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	for _, r := range res {
+		fmt.Fprintf(tw, "%s\t%v\n", r.Text, r.Value)
+	}
+	return tw.Flush()
+}
+
+func (p *probe) annotations(ctx context.Context, args []string) error {
+	c, err := p.client()
+	if err != nil {
+		return err
+	}
+	anns, err := c.Annotations(ctx, simplejson.AnnotationQuery{
+		AnnotationsArguments: simplejson.AnnotationsArguments{
+			QueryCommonArguments: simplejson.QueryCommonArguments{From: p.from, To: p.to},
+		},
+		Name:  "gsjprobe",
+		Query: strings.Join(args, " "),
+	})
+	if err != nil {
+		return err
+	}
+	if ok, err := p.printJSON(anns); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEND\tTITLE\tTAGS\tTEXT")
+	for _, a := range anns {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", formatTime(a.Time), formatTime(a.TimeEnd), a.Title, strings.Join(a.Tags, ","), a.Text)
+	}
+	return tw.Flush()
+}
+
+func (p *probe) tagKeys(ctx context.Context) error {
+	c, err := p.client()
+	if err != nil {
+		return err
+	}
+	keys, err := c.TagKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := p.printJSON(keys); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", k.Text, k.Type)
+	}
+	return tw.Flush()
+}
+
+func (p *probe) tagValues(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("tag-values requires a single key")
+	}
+	c, err := p.client()
+	if err != nil {
+		return err
+	}
+	vals, err := c.TagValues(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if ok, err := p.printJSON(vals); ok {
+		return err
+	}
+
+	for _, v := range vals {
+		fmt.Fprintln(p.out, v)
+	}
+	return nil
+}
+
+// check sends each of the simplejsontest fixtures to the datasource.
+func (p *probe) check(ctx context.Context) error {
+	hc := &http.Client{Timeout: p.timeout}
+	base := strings.TrimSuffix(p.url, "/")
+
+	failed := 0
+	for _, f := range simplejsontest.Fixtures() {
+		name := fmt.Sprintf("%s (Grafana %s, %s)", f.Name, f.Grafana, f.Plugin)
+
+		req := f.Request().WithContext(ctx)
+		u, err := req.URL.Parse(base + f.Endpoint)
+		if err != nil {
+			return err
+		}
+		req.URL, req.Host = u, u.Host
+		for _, h := range p.headers {
+			k, v, _ := strings.Cut(h, ":")
+			req.Header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+
+		status, err := checkFixture(hc, req, f.Endpoint)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(p.out, "FAIL  %s: %v\n", name, err)
+		case status == http.StatusNotFound:
+			fmt.Fprintf(p.out, "skip  %s: not implemented\n", name)
+		default:
+			fmt.Fprintf(p.out, "ok    %s\n", name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d requests failed", failed)
+	}
+	return nil
+}
+
+func checkFixture(hc *http.Client, req *http.Request, endpoint string) (int, error) {
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && endpoint != "/":
+		return resp.StatusCode, nil
+	case resp.StatusCode != http.StatusOK:
+		return resp.StatusCode, fmt.Errorf("status %d, %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, simplejsontest.CheckResponse(endpoint, body)
+}
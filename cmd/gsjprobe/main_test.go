@@ -0,0 +1,99 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func probeTestServer(t *testing.T) *httptest.Server {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			if args.Interval <= 0 {
+				t.Errorf("expected an interval to be set")
+			}
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0).UTC(), Value: 42}}, nil
+		})),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{{Text: "host", Data: simplejson.TableStringColumn{"a"}}}, nil
+		})),
+		simplejson.WithSearcher(simplejson.SearcherFunc(func(ctx context.Context, target string) ([]string, error) {
+			return []string{"cpu", "mem"}, nil
+		})),
+	)
+	srv := httptest.NewServer(gsj)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProbeQuery(t *testing.T) {
+	srv := probeTestServer(t)
+
+	var out, errs bytes.Buffer
+	if err := run([]string{"-url", srv.URL, "-from", "now-6h", "query", "cpu"}, &out, &errs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := "# cpu (A), 1 points\n1970-01-01T00:00:01Z  42\n"
+	if out.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:      %q", expect, out.String())
+	}
+}
+
+func TestProbeSearchJSON(t *testing.T) {
+	srv := probeTestServer(t)
+
+	var out, errs bytes.Buffer
+	if err := run([]string{"-url", srv.URL, "-json", "search"}, &out, &errs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), `"text": "mem"`) {
+		t.Fatalf("expected JSON search results, got %s", out.String())
+	}
+}
+
+func TestProbeCheck(t *testing.T) {
+	srv := probeTestServer(t)
+
+	var out, errs bytes.Buffer
+	if err := run([]string{"-url", srv.URL, "check"}, &out, &errs); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	for _, s := range []string{"ok    query/timeserie", "ok    query/table", "skip  annotations"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected output to contain %q, got\n%s", s, out.String())
+		}
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"query"},
+		{"-from", "now", "-to", "now-1h", "search"},
+		{"-H", "nocolon", "search"},
+	} {
+		var out, errs bytes.Buffer
+		if err := run(args, &out, &errs); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
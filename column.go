@@ -0,0 +1,157 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import "time"
+
+// A Column holds the values of a single table column. The Grafana column
+// type is derived from T: float64 values are sent as a "number" column,
+// time.Time as "time", string as "string", bool as "boolean", and
+// time.Duration as a "number" column of milliseconds. Pointers to
+// float64, time.Time, string and bool give columns of the same type in
+// which nil values are sent as null. Columns of any other type are sent to
+// Grafana as JSON in an "other" column.
+type Column[T any] []T
+
+// Append adds values to the end of the column.
+func (c *Column[T]) Append(vs ...T) {
+	*c = append(*c, vs...)
+}
+
+func (Column[T]) simpleJSONColumn() {}
+
+func (Column[T]) columnType() string {
+	var zero T
+	switch any(zero).(type) {
+	case float64, *float64, time.Duration:
+		return "number"
+	case time.Time, *time.Time:
+		return "time"
+	case string, *string:
+		return "string"
+	case bool, *bool:
+		return "boolean"
+	default:
+		return "other"
+	}
+}
+
+func (c Column[T]) length() int { return len(c) }
+
+func (c Column[T]) value(i int) interface{} {
+	if d, ok := any(c[i]).(time.Duration); ok {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return c[i]
+}
+
+// TableNumberColumn holds values for a "number" column in a table.
+type TableNumberColumn = Column[float64]
+
+// TableTimeColumn holds values for a "time" column in a table.
+type TableTimeColumn = Column[time.Time]
+
+// TableStringColumn holds values for a "string" column in a table.
+type TableStringColumn = Column[string]
+
+// TableBoolColumn holds values for a "boolean" column in a table.
+type TableBoolColumn = Column[bool]
+
+// TableDurationColumn holds durations, which are sent to Grafana as a
+// "number" column of milliseconds. The unit of the column defaults to
+// "ms".
+type TableDurationColumn = Column[time.Duration]
+
+// TableJSONColumn holds arbitrary values, such as maps or slices, which
+// are sent to Grafana as JSON in an "other" column.
+type TableJSONColumn = Column[interface{}]
+
+// A TableField describes how a single column of a table is taken from
+// rows of type R. Fields are created with NewTableField.
+type TableField[R any] struct {
+	text string
+	unit string
+	bind func() tableFieldColumn[R]
+}
+
+// tableFieldColumn accumulates the values of one field as rows are
+// appended to a TableBuilder.
+type tableFieldColumn[R any] interface {
+	appendRow(r R)
+	data() TableColumnData
+}
+
+type boundTableField[R, T any] struct {
+	get func(R) T
+	col Column[T]
+}
+
+func (f *boundTableField[R, T]) appendRow(r R)         { f.col = append(f.col, f.get(r)) }
+func (f *boundTableField[R, T]) data() TableColumnData { return f.col }
+
+// NewTableField returns a field for a column named text, whose values are
+// taken from each row by get. The type of the column is that of a
+// Column[T].
+func NewTableField[R, T any](text string, get func(R) T) TableField[R] {
+	return TableField[R]{
+		text: text,
+		bind: func() tableFieldColumn[R] {
+			return &boundTableField[R, T]{get: get}
+		},
+	}
+}
+
+// WithUnit returns a copy of the field with the unit of the column set.
+func (f TableField[R]) WithUnit(unit string) TableField[R] {
+	f.unit = unit
+	return f
+}
+
+// A TableBuilder builds a table row by row, from values of type R. Since
+// each field extracts its own value from the row, the types of a row's
+// values are checked at compile time, however wide the table.
+type TableBuilder[R any] struct {
+	fields []TableField[R]
+	cols   []tableFieldColumn[R]
+}
+
+// NewTableBuilder returns a builder for a table with the given fields.
+func NewTableBuilder[R any](fields ...TableField[R]) *TableBuilder[R] {
+	b := &TableBuilder[R]{
+		fields: fields,
+		cols:   make([]tableFieldColumn[R], len(fields)),
+	}
+	for i, f := range fields {
+		b.cols[i] = f.bind()
+	}
+	return b
+}
+
+// AppendRow adds rows to the table.
+func (b *TableBuilder[R]) AppendRow(rows ...R) {
+	for _, r := range rows {
+		for _, c := range b.cols {
+			c.appendRow(r)
+		}
+	}
+}
+
+// Columns returns the columns of the table built so far.
+func (b *TableBuilder[R]) Columns() []TableColumn {
+	cols := make([]TableColumn, len(b.fields))
+	for i, f := range b.fields {
+		cols[i] = TableColumn{Text: f.text, Unit: f.unit, Data: b.cols[i].data()}
+	}
+	return cols
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type columnRow struct {
+	host    string
+	latency time.Duration
+	up      bool
+	labels  []string
+}
+
+func TestTableBuilder(t *testing.T) {
+	b := simplejson.NewTableBuilder(
+		simplejson.NewTableField("Host", func(r columnRow) string { return r.host }),
+		simplejson.NewTableField("Latency", func(r columnRow) time.Duration { return r.latency }),
+		simplejson.NewTableField("Up", func(r columnRow) bool { return r.up }),
+		simplejson.NewTableField("Labels", func(r columnRow) []string { return r.labels }),
+		simplejson.NewTableField("Count", func(r columnRow) float64 { return float64(len(r.labels)) }).WithUnit("short"),
+	)
+	b.AppendRow(
+		columnRow{"web-1", 20 * time.Millisecond, true, []string{"a"}},
+		columnRow{"web-2", time.Second, false, nil},
+	)
+
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return b.Columns(), nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "t", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","columns":[` +
+		`{"text":"Host","type":"string"},` +
+		`{"text":"Latency","type":"number","unit":"ms"},` +
+		`{"text":"Up","type":"boolean"},` +
+		`{"text":"Labels","type":"other"},` +
+		`{"text":"Count","type":"number","unit":"short"}],` +
+		`"rows":[["web-1",20,true,["a"],1],["web-2",1000,false,null,0]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestColumnAppend(t *testing.T) {
+	var c simplejson.TableNumberColumn
+	c.Append(1, 2)
+	c.Append(3)
+	if len(c) != 3 || c[2] != 3 {
+		t.Fatalf("unexpected column %v", c)
+	}
+
+	var d simplejson.Column[float64] = c
+	if _, ok := simplejson.TableColumnData(d).(simplejson.TableNumberColumn); !ok {
+		t.Fatalf("expected Column[float64] to be a TableNumberColumn")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CompositeQuerier is a Querier that routes each target to one of a set
+// of registered queriers, by prefix, glob or regular expression. Routes
+// are tried in the order they were added, and targets matching no route
+// are passed to the fallback querier. Targets are passed on unchanged.
+//
+// CompositeQuerier is also a Searcher, merging the results of all of its
+// queriers that implement Searcher.
+//
+// Routes should all be added before the CompositeQuerier is used.
+type CompositeQuerier struct {
+	routes   []compositeRoute
+	fallback Querier
+}
+
+type compositeRoute struct {
+	match func(target string) bool
+	q     Querier
+}
+
+// NewCompositeQuerier creates a CompositeQuerier with no routes.
+func NewCompositeQuerier() *CompositeQuerier {
+	return &CompositeQuerier{}
+}
+
+// HandlePrefix routes targets starting with prefix to q.
+func (c *CompositeQuerier) HandlePrefix(prefix string, q Querier) {
+	c.routes = append(c.routes, compositeRoute{
+		match: func(target string) bool { return strings.HasPrefix(target, prefix) },
+		q:     q,
+	})
+}
+
+// HandleGlob routes targets matching pattern, as used by path.Match, to
+// q.
+func (c *CompositeQuerier) HandleGlob(pattern string, q Querier) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q, %w", pattern, err)
+	}
+	c.routes = append(c.routes, compositeRoute{
+		match: func(target string) bool {
+			ok, _ := path.Match(pattern, target)
+			return ok
+		},
+		q: q,
+	})
+	return nil
+}
+
+// HandleRegexp routes targets matching re to q.
+func (c *CompositeQuerier) HandleRegexp(re *regexp.Regexp, q Querier) {
+	c.routes = append(c.routes, compositeRoute{
+		match: re.MatchString,
+		q:     q,
+	})
+}
+
+// HandleFallback sets the querier used for targets that match no route.
+func (c *CompositeQuerier) HandleFallback(q Querier) {
+	c.fallback = q
+}
+
+// querier finds the querier that should handle target.
+func (c *CompositeQuerier) querier(target string) Querier {
+	for _, r := range c.routes {
+		if r.match(target) {
+			return r.q
+		}
+	}
+	return c.fallback
+}
+
+// GrafanaQuery implements Querier, passing the query to the querier
+// routed for target.
+func (c *CompositeQuerier) GrafanaQuery(ctx context.Context, target string, args QueryArguments) ([]DataPoint, error) {
+	q := c.querier(target)
+	if q == nil {
+		return nil, Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("no querier for target %q", target)}
+	}
+	return q.GrafanaQuery(ctx, target, args)
+}
+
+// GrafanaSearch implements Searcher, searching all queriers that
+// implement Searcher concurrently. The results are merged, in the order
+// the queriers were added, with duplicates removed.
+func (c *CompositeQuerier) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	var searchers []Searcher
+	for _, r := range c.routes {
+		if s, ok := r.q.(Searcher); ok {
+			searchers = append(searchers, s)
+		}
+	}
+	if s, ok := c.fallback.(Searcher); ok {
+		searchers = append(searchers, s)
+	}
+
+	results := make([][]string, len(searchers))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, s := range searchers {
+		g.Go(func() (err error) {
+			defer catchPanic(&err)
+			results[i], err = s.GrafanaSearch(gctx, target)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		repanic(err)
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, rs := range results {
+		for _, r := range rs {
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+			merged = append(merged, r)
+		}
+	}
+	return merged, nil
+}
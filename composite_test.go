@@ -0,0 +1,80 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// searchableQuerier is a constQuerier that can also be searched.
+type searchableQuerier struct {
+	constQuerier
+	staticSearcher
+}
+
+func TestCompositeQuerier(t *testing.T) {
+	cq := simplejson.NewCompositeQuerier()
+	cq.HandlePrefix("cpu.", searchableQuerier{constQuerier(1), staticSearcher{"cpu.user", "cpu.system"}})
+	if err := cq.HandleGlob("mem.*.free", constQuerier(2)); err != nil {
+		t.Fatal(err)
+	}
+	cq.HandleRegexp(regexp.MustCompile(`^disk\d+$`), searchableQuerier{constQuerier(3), staticSearcher{"disk0", "cpu.user"}})
+
+	gsj := simplejson.New(
+		simplejson.WithSource(cq),
+	)
+
+	q := `{
+				"targets": [
+					{ "target": "cpu.user", "refId": "A" },
+					{ "target": "mem.node1.free", "refId": "B" },
+					{ "target": "disk12", "refId": "C" }
+				]
+			}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"cpu.user","refId":"A","datapoints":[[1,1000]]},` +
+		`{"target":"mem.node1.free","refId":"B","datapoints":[[2,1000]]},` +
+		`{"target":"disk12","refId":"C","datapoints":[[3,1000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	// Unmatched targets are an error until a fallback is set.
+	req = httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "net"}]}`))
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	cq.HandleFallback(searchableQuerier{constQuerier(4), staticSearcher{"net"}})
+	req = httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "net"}]}`))
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+	if expect := `[{"target":"net","datapoints":[[4,1000]]}]`; w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	res, err := cq.GrafanaSearch(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"cpu.user", "cpu.system", "disk0", "net"}; !reflect.DeepEqual(res, expect) {
+		t.Fatalf("\nexpected search results: %v\ngot: %v", expect, res)
+	}
+}
+
+func TestCompositeQuerier_InvalidGlob(t *testing.T) {
+	if err := simplejson.NewCompositeQuerier().HandleGlob("[", constQuerier(1)); err == nil {
+		t.Fatalf("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressHandler gzip compresses the responses from next, if the client
+// accepts it, and the response is at least minSize bytes.
+func compressHandler(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(enc) != "gzip" {
+			continue
+		}
+		return strings.ReplaceAll(q, " ", "") != "q=0"
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the response until at least minSize bytes
+// have been written, at which point it switches to streaming gzip
+// compressed output.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	started bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.status == 0 {
+		gw.status = status
+	}
+}
+
+func (gw *gzipResponseWriter) Write(bs []byte) (int, error) {
+	if gw.gz != nil {
+		return gw.gz.Write(bs)
+	}
+	if gw.started {
+		return gw.ResponseWriter.Write(bs)
+	}
+
+	gw.buf = append(gw.buf, bs...)
+	if len(gw.buf) < gw.minSize {
+		return len(bs), nil
+	}
+
+	if err := gw.start(true); err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
+
+// start writes the response headers and any buffered data, compressing
+// the remainder of the response if compress is true.
+func (gw *gzipResponseWriter) start(compress bool) error {
+	gw.started = true
+
+	hdr := gw.Header()
+	if hdr.Get("Content-Encoding") != "" || gw.status == http.StatusNoContent {
+		compress = false
+	}
+	if compress {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+	}
+
+	status := gw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	gw.ResponseWriter.WriteHeader(status)
+
+	buf := gw.buf
+	gw.buf = nil
+	if compress {
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+		_, err := gw.gz.Write(buf)
+		return err
+	}
+
+	_, err := gw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush implements http.Flusher.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.started {
+		gw.start(len(gw.buf) >= gw.minSize)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close completes the response.
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.started {
+		return gw.start(false)
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// Unwrap allows http.ResponseController to access the underlying
+// ResponseWriter.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,70 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithCompression(t *testing.T) {
+	var sq seriesQuerier
+	for i := 0; i < 1000; i++ {
+		sq = append(sq, simplejson.DataPoint{Time: time.Unix(int64(i), 0), Value: float64(i)})
+	}
+
+	gsj := simplejson.New(
+		simplejson.WithQuerier(sq),
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithCompression(1024),
+	)
+
+	// A large query response should be compressed.
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", ce)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip response, %v", err)
+	}
+	bs, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("invalid gzip response, %v", err)
+	}
+	if !bytes.HasPrefix(bs, []byte(`[{"target":"upper_50"`)) {
+		t.Fatalf("unexpected response %s", bs[:32])
+	}
+
+	// A small search response should not be compressed.
+	req = httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Fatalf("expected no content encoding, got %q", ce)
+	}
+	expect := `["example1","example2","example3"]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	// Clients that don't accept gzip should not get compressed responses.
+	req = httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Fatalf("expected no content encoding, got %q", ce)
+	}
+}
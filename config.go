@@ -0,0 +1,97 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// FieldConfig holds display hints for a timeserie or table column, which
+// allow panels to configure themselves. It is sent to Grafana in the
+// "config" of the series or column, in the same form as the field config
+// of a Grafana data frame. Unit is a Grafana unit ID, e.g. "ms" or
+// "bytes". Decimals, if not nil, is the number of decimal places to
+// display. DisplayName replaces the name of the series or column.
+type FieldConfig struct {
+	Unit        string      `json:"unit,omitempty"`
+	Decimals    *int        `json:"decimals,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Links       []FieldLink `json:"links,omitempty"`
+}
+
+// A FieldLink is a link shown by Grafana alongside the values of a field.
+// URL may use Grafana's data link variables, e.g. ${__value.raw}.
+type FieldLink struct {
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+	TargetBlank bool   `json:"targetBlank,omitempty"`
+}
+
+// fieldConfigHolder receives the FieldConfig, and labels, set by a
+// timeserie handler.
+type fieldConfigHolder struct {
+	sync.Mutex
+	cfg    *FieldConfig
+	labels map[string]string
+}
+
+func (fch *fieldConfigHolder) get() *FieldConfig {
+	fch.Lock()
+	defer fch.Unlock()
+	return fch.cfg
+}
+
+func (fch *fieldConfigHolder) getLabels() map[string]string {
+	fch.Lock()
+	defer fch.Unlock()
+	return fch.labels
+}
+
+// withFieldConfigHolder returns a context to which a timeserie handler can
+// attach a FieldConfig, and the holder that will receive it.
+func withFieldConfigHolder(ctx context.Context) (context.Context, *fieldConfigHolder) {
+	fch := &fieldConfigHolder{}
+	return context.WithValue(ctx, fieldConfigContextKey, fch), fch
+}
+
+// SetFieldConfig attaches cfg to the timeserie being returned by a
+// Querier, RequestQuerier or IterQuerier, ctx must be the context the
+// handler was called with. It reports whether the config could be set.
+// Table columns carry their own config, see TableColumn.
+func SetFieldConfig(ctx context.Context, cfg FieldConfig) bool {
+	fch, ok := ctx.Value(fieldConfigContextKey).(*fieldConfigHolder)
+	if !ok {
+		return false
+	}
+	fch.Lock()
+	defer fch.Unlock()
+	fch.cfg = &cfg
+	return true
+}
+
+// appendFieldConfig appends the "config" field for cfg to buf, if cfg is
+// not nil.
+func appendFieldConfig(buf []byte, cfg *FieldConfig) ([]byte, error) {
+	if cfg == nil {
+		return buf, nil
+	}
+	bs, err := json.Marshal(cfg)
+	if err != nil {
+		return buf, err
+	}
+	buf = append(buf, `,"config":`...)
+	return append(buf, bs...), nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+var configTestConfig = simplejson.FieldConfig{
+	Unit:        "bytes",
+	Decimals:    new(int),
+	DisplayName: "Memory",
+	Links:       []simplejson.FieldLink{{Title: "Details", URL: "https://example.com/${__value.raw}", TargetBlank: true}},
+}
+
+const configTestJSON = `{"unit":"bytes","decimals":0,"displayName":"Memory","links":[{"title":"Details","url":"https://example.com/${__value.raw}","targetBlank":true}]}`
+
+func TestSetFieldConfig(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			if !simplejson.SetFieldConfig(ctx, configTestConfig) {
+				t.Errorf("expected config to be set")
+			}
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"upper_50","refId":"A","datapoints":[[1,1000]],"config":` + configTestJSON + `}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestSetFieldConfig_Iter(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithIterQuerier(simplejson.IterQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) iter.Seq2[simplejson.DataPoint, error] {
+			return func(yield func(simplejson.DataPoint, error) bool) {
+				if !yield(simplejson.DataPoint{Time: time.Unix(1, 0), Value: 1}, nil) {
+					return
+				}
+				simplejson.SetFieldConfig(ctx, simplejson.FieldConfig{Unit: "s"})
+			}
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"upper_50","refId":"A","datapoints":[[1,1000]],"config":{"unit":"s"}}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestSetFieldConfig_NoHandler(t *testing.T) {
+	if simplejson.SetFieldConfig(context.Background(), configTestConfig) {
+		t.Fatalf("expected config not to be set outside a handler")
+	}
+}
+
+func TestTableColumnConfig(t *testing.T) {
+	cfg := configTestConfig
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{{Text: "mem", Data: simplejson.TableNumberColumn{1024}, Config: &cfg}}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "t", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","columns":[{"text":"mem","type":"number","config":` + configTestJSON + `}],"rows":[[1024]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
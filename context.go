@@ -0,0 +1,93 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strconv"
+)
+
+// contextKey is used for values this package stores in request contexts.
+type contextKey int
+
+const (
+	tenantContextKey contextKey = iota
+	orgIDContextKey
+	userContextKey
+	requestContextKey
+	clientCertContextKey
+	fieldConfigContextKey
+	debugRequestContextKey
+	clockContextKey
+	shutdownContextKey
+)
+
+// Headers set by Grafana when proxying requests to a datasource.
+const (
+	grafanaOrgIDHeader = "X-Grafana-Org-Id"
+	grafanaUserHeader  = "X-Grafana-User"
+)
+
+// OrgIDFromContext returns the ID of the Grafana organisation on whose
+// behalf a request is being made. This is only available when Grafana
+// proxies the request to the datasource.
+func OrgIDFromContext(ctx context.Context) (int64, bool) {
+	orgID, ok := ctx.Value(orgIDContextKey).(int64)
+	return orgID, ok
+}
+
+// UserFromContext returns the login of the Grafana user making a request.
+// This is only available if Grafana has been configured to send the user
+// header to datasources.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userContextKey).(string)
+	return user, ok
+}
+
+// HTTPRequestFromContext returns the HTTP request that is being handled.
+// This gives handlers access to cookies, client certificates and custom
+// headers. The request should not be modified, and its body has already
+// been consumed.
+func HTTPRequestFromContext(ctx context.Context) (*http.Request, bool) {
+	r, ok := ctx.Value(requestContextKey).(*http.Request)
+	return r, ok
+}
+
+// ClientCertFromContext returns the verified TLS client certificate
+// presented with a request. This is only available if the server verifies
+// client certificates, see WithClientCertAuth.
+func ClientCertFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(clientCertContextKey).(*x509.Certificate)
+	return cert, ok
+}
+
+// requestContext adds the request, details of the Grafana org and user
+// making it, and any verified client certificate, to the request context.
+func requestContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestContextKey, r)
+		if orgID, err := strconv.ParseInt(r.Header.Get(grafanaOrgIDHeader), 10, 64); err == nil {
+			ctx = context.WithValue(ctx, orgIDContextKey, orgID)
+		}
+		if user := r.Header.Get(grafanaUserHeader); user != "" {
+			ctx = context.WithValue(ctx, userContextKey, user)
+		}
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			ctx = context.WithValue(ctx, clientCertContextKey, r.TLS.VerifiedChains[0][0])
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,79 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type grafanaContextSearcher struct{}
+
+func (grafanaContextSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	orgID, orgOK := simplejson.OrgIDFromContext(ctx)
+	user, userOK := simplejson.UserFromContext(ctx)
+	return []string{
+		fmt.Sprintf("%d %v", orgID, orgOK),
+		fmt.Sprintf("%s %v", user, userOK),
+	}, nil
+}
+
+func TestGrafanaContext(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(grafanaContextSearcher{}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": ""}`))
+	req.Header.Set("X-Grafana-Org-Id", "12")
+	req.Header.Set("X-Grafana-User", "admin")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `["12 true","admin true"]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": ""}`))
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect = `["0 false"," false"]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
+
+type requestSearcher struct{}
+
+func (requestSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	r, ok := simplejson.HTTPRequestFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("no request in context")
+	}
+	c, err := r.Cookie("session")
+	if err != nil {
+		return nil, err
+	}
+	return []string{c.Value}, nil
+}
+
+func TestHTTPRequestFromContext(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(requestSearcher{}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": ""}`))
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc123"})
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `["abc123"]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache the result of
+// a preflight request.
+const corsMaxAge = "86400"
+
+// WithCORS enables Cross-Origin Resource Sharing, required when Grafana
+// is configured to access the datasource directly from the browser.
+// Requests from the listed origins are permitted, "*" permits any origin.
+// Preflight OPTIONS requests are answered for all endpoints, without
+// requiring authentication.
+func WithCORS(origins ...string) Opt {
+	return func(sjc *Handler) error {
+		allowed := map[string]bool{}
+		for _, o := range origins {
+			allowed[strings.TrimSuffix(o, "/")] = true
+		}
+		sjc.cors = func(next http.Handler) http.Handler {
+			return corsHandler(next, allowed)
+		}
+		return nil
+	}
+}
+
+func corsHandler(next http.Handler, allowed map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hdr := w.Header()
+		hdr.Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			hdr.Add("Vary", "Access-Control-Request-Method")
+			hdr.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		switch {
+		case allowed[origin]:
+			// Credentials can only be permitted for explicitly
+			// listed origins.
+			hdr.Set("Access-Control-Allow-Origin", origin)
+			hdr.Set("Access-Control-Allow-Credentials", "true")
+		case allowed["*"]:
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		default:
+			if preflight {
+				writeError(w, Error{Status: http.StatusForbidden, Message: "origin not allowed"}, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hdr.Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+		if reqHdrs := r.Header.Get("Access-Control-Request-Headers"); reqHdrs != "" {
+			hdr.Set("Access-Control-Allow-Headers", reqHdrs)
+		}
+		hdr.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
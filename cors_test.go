@@ -0,0 +1,61 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithCORS(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithBasicAuth("grafana", "secret"),
+		simplejson.WithCORS("https://grafana.example.com"),
+	)
+
+	// Preflight requests are answered without authentication.
+	req := httptest.NewRequest(http.MethodOptions, "/search", nil)
+	req.Header.Set("Origin", "https://grafana.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if o := w.Header().Get("Access-Control-Allow-Origin"); o != "https://grafana.example.com" {
+		t.Fatalf("unexpected allowed origin %q", o)
+	}
+	if h := w.Header().Get("Access-Control-Allow-Headers"); h != "authorization, content-type" {
+		t.Fatalf("unexpected allowed headers %q", h)
+	}
+
+	// Preflight from an unknown origin is refused.
+	req = httptest.NewRequest(http.MethodOptions, "/search", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// Actual requests get the CORS headers alongside the response.
+	req = httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+	req.Header.Set("Origin", "https://grafana.example.com")
+	req.SetBasicAuth("grafana", "secret")
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if o := w.Header().Get("Access-Control-Allow-Origin"); o != "https://grafana.example.com" {
+		t.Fatalf("unexpected allowed origin %q", o)
+	}
+}
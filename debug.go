@@ -0,0 +1,165 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// WithDebugJSON allows clients to ask for a readable response by adding
+// a pretty query parameter to the URL, for example
+// /query?pretty=1. The JSON response is indented, and wrapped in an
+// object that also echoes the request as it was understood by the
+// handler:
+//
+//	{
+//	  "request": {...},
+//	  "response": [...]
+//	}
+//
+// This is intended for debugging a datasource by hand, with curl for
+// example. Grafana does not understand the wrapped response. Responses
+// other than JSON, such as streams, are sent as normal.
+func WithDebugJSON() Opt {
+	return func(sjc *Handler) error {
+		sjc.debugJSON = true
+		return nil
+	}
+}
+
+// debugRequest holds the decoded body of a request made in debug mode.
+type debugRequest struct {
+	v interface{}
+}
+
+// setDebugRequest records v as the decoded body of the request being
+// handled, if the client asked for a debug response.
+func setDebugRequest(ctx context.Context, v interface{}) {
+	if dr, ok := ctx.Value(debugRequestContextKey).(*debugRequest); ok {
+		dr.v = v
+	}
+}
+
+// wantsPretty reports whether the client has asked for a debug response.
+func wantsPretty(r *http.Request) bool {
+	if !r.URL.Query().Has("pretty") {
+		return false
+	}
+	v := r.URL.Query().Get("pretty")
+	if v == "" {
+		return true
+	}
+	b, _ := strconv.ParseBool(v)
+	return b
+}
+
+// debugHandler rewrites the JSON responses of next into indented debug
+// responses, for clients that ask for them.
+func debugHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wantsPretty(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		dr := &debugRequest{}
+		r = r.WithContext(context.WithValue(r.Context(), debugRequestContextKey, dr))
+		dw := &debugResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(dw, r)
+		dw.finish(dr.v)
+	})
+}
+
+// debugResponseWriter buffers JSON responses so that they can be
+// rewritten once complete. Other responses are passed through.
+type debugResponseWriter struct {
+	http.ResponseWriter
+
+	status      int
+	buf         bytes.Buffer
+	decided     bool
+	passThrough bool
+}
+
+// decide determines whether the response is to be rewritten, once the
+// handler has set its headers.
+func (dw *debugResponseWriter) decide() {
+	if dw.decided {
+		return
+	}
+	dw.decided = true
+	mt, _, _ := mime.ParseMediaType(dw.Header().Get("Content-Type"))
+	dw.passThrough = mt != "application/json"
+	if dw.passThrough && dw.status != 0 {
+		dw.ResponseWriter.WriteHeader(dw.status)
+	}
+}
+
+func (dw *debugResponseWriter) WriteHeader(status int) {
+	if dw.status != 0 || dw.decided {
+		return
+	}
+	dw.status = status
+	dw.decide()
+}
+
+func (dw *debugResponseWriter) Write(bs []byte) (int, error) {
+	dw.decide()
+	if dw.passThrough {
+		return dw.ResponseWriter.Write(bs)
+	}
+	return dw.buf.Write(bs)
+}
+
+// Flush implements http.Flusher. Buffered responses are only flushed
+// once complete.
+func (dw *debugResponseWriter) Flush() {
+	dw.decide()
+	if !dw.passThrough {
+		return
+	}
+	if f, ok := dw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the rewritten response, echoing req if it is not nil.
+func (dw *debugResponseWriter) finish(req interface{}) {
+	dw.decide()
+	if dw.passThrough {
+		return
+	}
+
+	out := dw.buf.Bytes()
+	if json.Valid(out) {
+		debug := struct {
+			Request  interface{}     `json:"request,omitempty"`
+			Response json.RawMessage `json:"response"`
+		}{req, out}
+		if bs, err := json.MarshalIndent(debug, "", "  "); err == nil {
+			out = append(bs, '\n')
+		}
+	}
+
+	dw.Header().Del("Content-Length")
+	if dw.status != 0 {
+		dw.ResponseWriter.WriteHeader(dw.status)
+	}
+	dw.ResponseWriter.Write(out)
+}
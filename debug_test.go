@@ -0,0 +1,107 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func debugTestHandler() *simplejson.Handler {
+	return simplejson.New(
+		simplejson.WithDebugJSON(),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}}, nil
+		})),
+	)
+}
+
+func TestDebugJSON(t *testing.T) {
+	body := `{"range":{"from":"2016-01-01T00:00:00Z","to":"2016-01-01T01:00:00Z"},"interval":"1m","maxDataPoints":10,"targets":[{"target":"a","refId":"A"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query?pretty=1", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	debugTestHandler().ServeHTTP(w, req)
+
+	expect := `{
+  "request": {
+    "panelId": 0,
+    "range": {
+      "from": "2016-01-01T00:00:00Z",
+      "to": "2016-01-01T01:00:00Z",
+      "raw": {
+        "from": "",
+        "to": ""
+      }
+    },
+`
+	if !strings.HasPrefix(w.Body.String(), expect) {
+		t.Fatalf("\nexpected prefix: %s\ngot:             %s", expect, w.Body.String())
+	}
+
+	expect = `  "response": [
+    {
+      "target": "a",
+      "refId": "A",
+      "datapoints": [
+        [
+          1,
+          1000
+        ]
+      ]
+    }
+  ]
+}
+`
+	if !strings.HasSuffix(w.Body.String(), expect) {
+		t.Fatalf("\nexpected suffix: %s\ngot:             %s", expect, w.Body.String())
+	}
+}
+
+func TestDebugJSON_Error(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/query?pretty", strings.NewReader(`[]`))
+	w := httptest.NewRecorder()
+	debugTestHandler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	expect := `{
+  "response": {
+    "message": "invalid request at byte 0, expected a JSON object"
+  }
+}
+`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestDebugJSON_NotRequested(t *testing.T) {
+	for _, path := range []string{"/query", "/query?pretty=0"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"targets":[{"target":"a"}]}`))
+		w := httptest.NewRecorder()
+		debugTestHandler().ServeHTTP(w, req)
+
+		expect := `[{"target":"a","datapoints":[[1,1000]]}]`
+		if w.Body.String() != expect {
+			t.Fatalf("%s:\nexpected: %s\ngot:      %s", path, expect, w.Body.String())
+		}
+	}
+}
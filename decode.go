@@ -0,0 +1,153 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxRequestBytes is the default limit on the size of request
+// bodies, see WithMaxRequestBytes.
+const DefaultMaxRequestBytes = 10 << 20
+
+// WithMaxRequestBytes limits the size of request bodies, larger requests
+// are rejected with a 413 status. The default is DefaultMaxRequestBytes,
+// a limit of 0 allows bodies of any size.
+func WithMaxRequestBytes(n int64) Opt {
+	return func(sjc *Handler) error {
+		sjc.maxRequestBytes = n
+		return nil
+	}
+}
+
+// WithStrictDecoding rejects requests that contain fields that are not
+// understood, or trailing data after the request body. Note that Grafana
+// may send fields that this package does not use.
+func WithStrictDecoding() Opt {
+	return func(sjc *Handler) error {
+		sjc.strictDecoding = true
+		return nil
+	}
+}
+
+// requestBody returns the body of r, limited to the configured maximum
+// size.
+func (h *Handler) requestBody(w http.ResponseWriter, r *http.Request) io.ReadCloser {
+	if h.maxRequestBytes > 0 {
+		return http.MaxBytesReader(w, r.Body, h.maxRequestBytes)
+	}
+	return r.Body
+}
+
+// readerPool holds the buffered readers request bodies are decoded from.
+var readerPool = sync.Pool{
+	New: func() interface{} { return bufio.NewReaderSize(nil, 4096) },
+}
+
+// decodeRequest decodes the JSON body of r into v as it is read, applying
+// the configured size limits and strictness. The returned error is
+// suitable for passing to writeError.
+func (h *Handler) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(h.requestBody(w, r))
+	defer func() {
+		br.Reset(nil)
+		readerPool.Put(br)
+	}()
+
+	// Reject anything that is not an object before any of the body is
+	// decoded.
+	if err := expectObject(br); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(br)
+	if h.strictDecoding {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+
+	if h.strictDecoding {
+		if _, err := dec.Token(); err != io.EOF {
+			return Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid request at byte %d, unexpected data after request body", dec.InputOffset())}
+		}
+	}
+
+	setDebugRequest(r.Context(), v)
+	return nil
+}
+
+// expectObject checks that the next value to be read from br is a JSON
+// object, without consuming it.
+func expectObject(br *bufio.Reader) error {
+	for n := 0; ; n++ {
+		bs, err := br.Peek(n + 1)
+		if err == bufio.ErrBufferFull {
+			// Leave the decoder to make sense of this much whitespace.
+			return nil
+		}
+		if err != nil {
+			return decodeError(err)
+		}
+		switch bs[n] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return nil
+		default:
+			return Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid request at byte %d, expected a JSON object", n)}
+		}
+	}
+}
+
+// decodeError converts an error from decoding a request into one that
+// describes the problem to the user.
+func decodeError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return Error{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("request body too large, limit is %d bytes", maxErr.Limit),
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		return Error{Status: http.StatusBadRequest, Message: "invalid request, empty body"}
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return Error{Status: http.StatusBadRequest, Message: "invalid request, unexpected end of body"}
+	}
+
+	// Report where in the body the problem was found.
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid request at byte %d, %s", syntaxErr.Offset, err)}
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid request at byte %d, %s", typeErr.Offset, err)}
+	}
+
+	return Error{Status: http.StatusBadRequest, Message: "invalid request, " + err.Error()}
+}
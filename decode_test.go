@@ -0,0 +1,92 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithMaxRequestBytes(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithMaxRequestBytes(64),
+	)
+
+	body := `{"target": "` + strings.Repeat("x", 128) + `"}`
+	req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	expect := `{"message":"request body too large, limit is 64 bytes"}`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
+
+func TestWithStrictDecoding(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithStrictDecoding(),
+	)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"target": "upper_50"}`, status: http.StatusOK},
+		{body: `{"target": "upper_50", "bogus": 1}`, status: http.StatusBadRequest},
+		{body: `{"target": "upper_50"} {}`, status: http.StatusBadRequest},
+		{body: ``, status: http.StatusBadRequest},
+		{body: `{"target": "upper_50"} x`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.body, tt.status, w.Code)
+		}
+	}
+}
+
+func TestLenientDecoding(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+	)
+
+	tests := []struct {
+		body   string
+		status int
+		expect string
+	}{
+		{body: `{"target": "upper_50", "bogus": 1}`, status: http.StatusOK},
+		{body: `{"target": "upper_50"} {}`, status: http.StatusOK},
+		{body: ` `, status: http.StatusBadRequest, expect: `{"message":"invalid request, empty body"}`},
+		{body: `{"target": 1}`, status: http.StatusBadRequest},
+		{body: ` ["upper_50"]`, status: http.StatusBadRequest, expect: `{"message":"invalid request at byte 1, expected a JSON object"}`},
+		{body: `{"target": "upper_50",}`, status: http.StatusBadRequest, expect: `{"message":"invalid request at byte 23, invalid character '}' looking for beginning of object key string"}`},
+		{body: `{"target": "upper_50"`, status: http.StatusBadRequest, expect: `{"message":"invalid request, unexpected end of body"}`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.body, tt.status, w.Code)
+		}
+		if tt.expect != "" && w.Body.String() != tt.expect {
+			t.Errorf("%q: expected %s, got %s", tt.body, tt.expect, w.Body.String())
+		}
+	}
+}
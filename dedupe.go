@@ -0,0 +1,83 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// WithQueryDeduplication coalesces identical query targets that are in
+// flight at the same time, such as those from several panels on a
+// dashboard, so that only one backend query runs and all requests share
+// the result. Targets are considered identical if their type, target,
+// range, interval, filters and variables match, and they are made by the
+// same Grafana org and tenant.
+//
+// The shared query is not cancelled if the request that started it is,
+// but still observes that request's deadline.
+func WithQueryDeduplication() Opt {
+	return func(sjc *Handler) error {
+		sjc.flight = &singleflight.Group{}
+		return nil
+	}
+}
+
+// dedupe calls fn, sharing the result with any concurrent calls with the
+// same type and arguments if deduplication is enabled. Results may be
+// shared between requests and must not be modified.
+func dedupe[T any](h *Handler, ctx context.Context, typ string, args interface{}, fn func(context.Context) (T, error)) (T, error) {
+	if h.flight == nil {
+		return fn(ctx)
+	}
+
+	argsBs, err := json.Marshal(args)
+	if err != nil {
+		return fn(ctx)
+	}
+	orgID, _ := OrgIDFromContext(ctx)
+	tenantID, _ := TenantFromContext(ctx)
+	key := typ + "\x00" + strconv.FormatInt(orgID, 10) + "\x00" + tenantID + "\x00" + string(argsBs)
+
+	ch := h.flight.DoChan(key, func() (v interface{}, err error) {
+		// singleflight cannot recover panics, pass them back to the
+		// callers instead.
+		defer catchPanic(&err)
+
+		// The shared call should not be cancelled because the first
+		// caller gives up, but should still respect its deadline.
+		sctx := context.WithoutCancel(ctx)
+		if dl, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			sctx, cancel = context.WithDeadline(sctx, dl)
+			defer cancel()
+		}
+		return fn(sctx)
+	})
+
+	var zero T
+	select {
+	case res := <-ch:
+		repanic(res.Err)
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,60 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type blockingQuerier struct {
+	calls   atomic.Int64
+	release chan struct{}
+}
+
+func (bq *blockingQuerier) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	bq.calls.Add(1)
+	<-bq.release
+	return []simplejson.DataPoint{{Time: args.To, Value: 1}}, nil
+}
+
+func TestWithQueryDeduplication(t *testing.T) {
+	bq := &blockingQuerier{release: make(chan struct{})}
+	gsj := simplejson.New(
+		simplejson.WithQuerier(bq),
+		simplejson.WithQueryDeduplication(),
+	)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+			bodies[i] = w.Body.String()
+		}()
+	}
+
+	// Give the requests time to arrive before letting the backend answer.
+	time.Sleep(50 * time.Millisecond)
+	close(bq.release)
+	wg.Wait()
+
+	if n := bq.calls.Load(); n != 1 {
+		t.Fatalf("expected 1 backend call, got %d", n)
+	}
+	for _, b := range bodies[1:] {
+		if b != bodies[0] {
+			t.Fatalf("responses differ\nfirst: %s\nother: %s", bodies[0], b)
+		}
+	}
+}
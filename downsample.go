@@ -0,0 +1,163 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"math"
+)
+
+// A DownsampleFunc reduces points, which are sorted by time, to at most
+// maxDPs points.
+type DownsampleFunc func(points []DataPoint, maxDPs int) []DataPoint
+
+// WithDownsampling reduces series returned by the timeserie querier that
+// have more points than the maxDataPoints Grafana requested, using fn,
+// e.g. Downsample or DownsampleAvg. Series returned via an IterQuerier are
+// not downsampled.
+func WithDownsampling(fn DownsampleFunc) Opt {
+	return func(sjc *Handler) error {
+		sjc.downsample = fn
+		return nil
+	}
+}
+
+// Downsample reduces points, which must be sorted by time, to at most
+// maxDPs points using the Largest-Triangle-Three-Buckets algorithm, which
+// preserves the visual shape of the series. Null points are only kept
+// where all the points they represent are null. points is returned
+// unchanged if it is already small enough, or maxDPs is less than 3.
+func Downsample(points []DataPoint, maxDPs int) []DataPoint {
+	n := len(points)
+	if maxDPs < 3 || n <= maxDPs {
+		return points
+	}
+
+	t0 := points[0].Time
+	x := func(dp DataPoint) float64 { return float64(dp.Time.Sub(t0)) }
+
+	out := make([]DataPoint, 0, maxDPs)
+	out = append(out, points[0])
+
+	every := float64(n-2) / float64(maxDPs-2)
+	a := points[0]
+	for i := 0; i < maxDPs-2; i++ {
+		// The average of the next bucket is the third point of the
+		// triangle.
+		avgStart := int(float64(i+1)*every) + 1
+		avgEnd := min(int(float64(i+2)*every)+1, n)
+		var avgX, avgY float64
+		count := 0
+		for _, dp := range points[avgStart:avgEnd] {
+			if dp.Null {
+				continue
+			}
+			avgX += x(dp)
+			avgY += dp.Value
+			count++
+		}
+		if count == 0 {
+			avgX, avgY = x(points[avgEnd-1]), a.Value
+		} else {
+			avgX /= float64(count)
+			avgY /= float64(count)
+		}
+
+		ax, ay := x(a), a.Value
+		if a.Null {
+			ay = avgY
+		}
+
+		start := int(float64(i)*every) + 1
+		end := int(float64(i+1)*every) + 1
+		selected, maxArea := points[start], -1.0
+		for _, dp := range points[start:end] {
+			if dp.Null {
+				continue
+			}
+			area := math.Abs((ax-avgX)*(dp.Value-ay) - (ax-x(dp))*(avgY-ay))
+			if area > maxArea {
+				selected, maxArea = dp, area
+			}
+		}
+		out = append(out, selected)
+		a = selected
+	}
+
+	return append(out, points[n-1])
+}
+
+// DownsampleAvg reduces points to at most maxDPs points, by dividing
+// them into buckets and taking the average value of each bucket, at the
+// time of its first point. Buckets containing only nulls produce a null.
+func DownsampleAvg(points []DataPoint, maxDPs int) []DataPoint {
+	return downsampleBuckets(points, maxDPs, func(bucket []DataPoint) DataPoint {
+		sum, count := 0.0, 0
+		for _, dp := range bucket {
+			if !dp.Null {
+				sum += dp.Value
+				count++
+			}
+		}
+		if count == 0 {
+			return NullDataPoint(bucket[0].Time)
+		}
+		return DataPoint{Time: bucket[0].Time, Value: sum / float64(count)}
+	})
+}
+
+// DownsampleMin reduces points to at most maxDPs points, by dividing
+// them into buckets and taking the point with the lowest value from each.
+func DownsampleMin(points []DataPoint, maxDPs int) []DataPoint {
+	return downsampleBuckets(points, maxDPs, func(bucket []DataPoint) DataPoint {
+		return selectPoint(bucket, func(a, b float64) bool { return a < b })
+	})
+}
+
+// DownsampleMax reduces points to at most maxDPs points, by dividing
+// them into buckets and taking the point with the highest value from
+// each.
+func DownsampleMax(points []DataPoint, maxDPs int) []DataPoint {
+	return downsampleBuckets(points, maxDPs, func(bucket []DataPoint) DataPoint {
+		return selectPoint(bucket, func(a, b float64) bool { return a > b })
+	})
+}
+
+// downsampleBuckets divides points into maxDPs buckets of equal size,
+// reducing each to a single point with fn.
+func downsampleBuckets(points []DataPoint, maxDPs int, fn func([]DataPoint) DataPoint) []DataPoint {
+	n := len(points)
+	if maxDPs <= 0 || n <= maxDPs {
+		return points
+	}
+	out := make([]DataPoint, maxDPs)
+	for i := range out {
+		out[i] = fn(points[i*n/maxDPs : (i+1)*n/maxDPs])
+	}
+	return out
+}
+
+// selectPoint returns the point with the best value, ignoring nulls, or
+// the first point if all are null.
+func selectPoint(bucket []DataPoint, better func(a, b float64) bool) DataPoint {
+	selected := bucket[0]
+	for _, dp := range bucket {
+		if dp.Null {
+			continue
+		}
+		if selected.Null || better(dp.Value, selected.Value) {
+			selected = dp
+		}
+	}
+	return selected
+}
@@ -0,0 +1,97 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func testPoints(vals ...float64) []simplejson.DataPoint {
+	points := make([]simplejson.DataPoint, len(vals))
+	for i, v := range vals {
+		points[i] = simplejson.DataPoint{Time: time.Unix(int64(i), 0), Value: v}
+		if math.IsNaN(v) {
+			points[i] = simplejson.NullDataPoint(time.Unix(int64(i), 0))
+		}
+	}
+	return points
+}
+
+func pointValues(points []simplejson.DataPoint) []float64 {
+	vals := make([]float64, len(points))
+	for i, dp := range points {
+		vals[i] = dp.Value
+		if dp.Null {
+			vals[i] = -1
+		}
+	}
+	return vals
+}
+
+func TestDownsample(t *testing.T) {
+	// A flat series with a single spike, which LTTB should preserve.
+	points := testPoints(0, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0)
+	got := simplejson.Downsample(points, 5)
+	if len(got) != 5 {
+		t.Fatalf("expected 5 points, got %d", len(got))
+	}
+	if got[0] != points[0] || got[4] != points[10] {
+		t.Fatalf("expected the first and last points to be kept, got %v", got)
+	}
+	found := false
+	for _, dp := range got {
+		if dp.Value == 10 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the spike to be kept, got %v", pointValues(got))
+	}
+
+	if got := simplejson.Downsample(points, 20); len(got) != len(points) {
+		t.Fatalf("expected small series to be unchanged, got %d points", len(got))
+	}
+}
+
+func TestDownsampleBuckets(t *testing.T) {
+	nan := math.NaN()
+	points := testPoints(1, 3, 5, 2, nan, nan, 4, 8)
+
+	for _, tc := range []struct {
+		name   string
+		fn     simplejson.DownsampleFunc
+		expect []float64
+	}{
+		{"avg", simplejson.DownsampleAvg, []float64{2, 3.5, -1, 6}},
+		{"min", simplejson.DownsampleMin, []float64{1, 2, -1, 4}},
+		{"max", simplejson.DownsampleMax, []float64{3, 5, -1, 8}},
+	} {
+		got := tc.fn(points, 4)
+		if vals := pointValues(got); !reflect.DeepEqual(vals, tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, vals)
+		}
+	}
+}
+
+func TestWithDownsampling(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(seriesQuerier(testPoints(1, 3, 5, 7))),
+		simplejson.WithDownsampling(simplejson.DownsampleAvg),
+	)
+
+	q := `{"maxDataPoints": 2, "targets": [{ "target": "upper_50" }]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"upper_50","datapoints":[[2,0],[6,2000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
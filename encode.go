@@ -0,0 +1,368 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// streamBufferSize is the amount of encoded output that will be buffered
+// before being written to the client.
+const streamBufferSize = 32 * 1024
+
+// validateQueryResponse checks that all the results of a query can be
+// encoded, this lets us report errors before we begin streaming.
+func validateQueryResponse(out []interface{}) error {
+	for _, res := range out {
+		switch res := res.(type) {
+		case simpleJSONData:
+			for _, dp := range res.DataPoints {
+				if _, _, err := res.nonFinite.apply(dp); err != nil {
+					return err
+				}
+			}
+		case simpleJSONTableData:
+			for _, row := range res.Rows {
+				if err := validateTableRow(row); err != nil {
+					return err
+				}
+			}
+		case *simpleJSONIterData, *simpleJSONTableIterData:
+			// iterators are checked as they are consumed
+		default:
+			if _, err := json.Marshal(res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errUnsupportedFloat returns the error encoding/json would give
+// for a value that cannot be represented in JSON.
+func errUnsupportedFloat(f float64) error {
+	return &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, 64)}
+}
+
+// writerPool holds the buffered writers used to stream query responses.
+var writerPool = sync.Pool{
+	New: func() interface{} { return bufio.NewWriterSize(nil, streamBufferSize) },
+}
+
+// writeQueryResponse streams the JSON encoding of the results of a query
+// to w. Timeserie datapoints are written incrementally so that the full
+// response never needs to be held in memory.
+func writeQueryResponse(w io.Writer, out []interface{}) error {
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	defer func() {
+		bw.Reset(nil)
+		writerPool.Put(bw)
+	}()
+
+	bw.WriteByte('[')
+	for i, res := range out {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		switch res := res.(type) {
+		case simpleJSONData:
+			if err := res.writeJSON(bw); err != nil {
+				return err
+			}
+		case *simpleJSONIterData:
+			if err := res.writeJSON(bw); err != nil {
+				return err
+			}
+		case simpleJSONTableData:
+			if err := res.writeJSON(bw); err != nil {
+				return err
+			}
+		case *simpleJSONTableIterData:
+			if err := res.writeJSON(bw); err != nil {
+				return err
+			}
+		default:
+			bs, err := json.Marshal(res)
+			if err != nil {
+				return err
+			}
+			bw.Write(bs)
+		}
+	}
+	bw.WriteByte(']')
+
+	return bw.Flush()
+}
+
+// writeJSON writes the JSON encoding of the series to w.
+func (sjd simpleJSONData) writeJSON(w io.Writer) error {
+	bp := getBuffer()
+	buf := *bp
+	defer func() { *bp = buf; putBuffer(bp) }()
+
+	buf = append(buf, `{"target":`...)
+	buf = appendJSONString(buf, sjd.Target)
+	if sjd.RefID != "" {
+		buf = append(buf, `,"refId":`...)
+		buf = appendJSONString(buf, sjd.RefID)
+	}
+	buf = append(buf, `,"datapoints":[`...)
+	n := 0
+	for _, dp := range sjd.DataPoints {
+		dp, keep, err := sjd.nonFinite.apply(dp)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			continue
+		}
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendDataPoint(buf, dp, sjd.timeUnit)
+		n++
+
+		if len(buf) >= streamBufferSize/2 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	buf = append(buf, ']')
+	buf, err := appendFieldConfig(buf, sjd.config)
+	if err != nil {
+		return err
+	}
+	if buf, err = appendMeta(buf, sjd.meta); err != nil {
+		return err
+	}
+	buf = append(buf, '}')
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// writeJSON writes the JSON encoding of the table to w.
+func (sjd simpleJSONTableData) writeJSON(w io.Writer) error {
+	bp := getBuffer()
+	buf := *bp
+	defer func() { *bp = buf; putBuffer(bp) }()
+
+	buf = append(buf, `{"type":`...)
+	buf = appendJSONString(buf, sjd.Type)
+	if sjd.RefID != "" {
+		buf = append(buf, `,"refId":`...)
+		buf = appendJSONString(buf, sjd.RefID)
+	}
+	bs, err := json.Marshal(sjd.Columns)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, `,"columns":`...)
+	buf = append(buf, bs...)
+	buf = append(buf, `,"rows":`...)
+	if sjd.Rows == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '[')
+		for i, row := range sjd.Rows {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if row == nil {
+				buf = append(buf, "null"...)
+			} else if buf, err = appendTableRow(buf, row); err != nil {
+				return err
+			}
+
+			if len(buf) >= streamBufferSize/2 {
+				if _, err := w.Write(buf); err != nil {
+					return err
+				}
+				buf = buf[:0]
+			}
+		}
+		buf = append(buf, ']')
+	}
+	if buf, err = appendMeta(buf, sjd.Meta); err != nil {
+		return err
+	}
+	buf = append(buf, '}')
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// MarshalJSON implements JSON marshalling
+func (sjd simpleJSONTableData) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := sjd.writeJSON(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON implements JSON marshalling
+func (sjd simpleJSONData) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := validateQueryResponse([]interface{}{sjd}); err != nil {
+		return nil, err
+	}
+	if err := sjd.writeJSON(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// appendDataPoint appends the JSON encoding of dp, [value, timestamp], to
+// buf.
+func appendDataPoint(buf []byte, dp DataPoint, unit time.Duration) []byte {
+	buf = append(buf, '[')
+	if dp.Null {
+		buf = append(buf, "null"...)
+	} else {
+		buf = appendJSONFloat(buf, dp.Value)
+	}
+	buf = append(buf, ',')
+	buf = strconv.AppendInt(buf, epoch(dp.Time, unit), 10)
+	return append(buf, ']')
+}
+
+// bufferPool holds buffers used to encode responses, to avoid allocating
+// new ones for each series.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *[]byte {
+	buf := bufferPool.Get().(*[]byte)
+	*buf = (*buf)[:0]
+	return buf
+}
+
+// putBuffer returns buf to the pool. Very large buffers are dropped so
+// that a single large response does not pin memory.
+func putBuffer(buf *[]byte) {
+	if cap(*buf) > 4*streamBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends the JSON encoding of s to buf, escaped in
+// the same way as encoding/json.
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// appendJSONFloat appends f to buf, formatted in the same way as
+// encoding/json. f must not be NaN or infinite.
+func appendJSONFloat(buf []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
+
+// simpleJSONResults is the response to a target that results in several
+// timeseries or tables. It is flattened into the query response before
+// encoding.
+type simpleJSONResults []interface{}
+
+// flattenQueryResponse expands any targets that resulted in several
+// results into the individual results.
+func flattenQueryResponse(out []interface{}) []interface{} {
+	flat := out[:0:0]
+	for _, res := range out {
+		if rs, ok := res.(simpleJSONResults); ok {
+			flat = append(flat, rs...)
+			continue
+		}
+		flat = append(flat, res)
+	}
+	return flat
+}
@@ -0,0 +1,167 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type seriesQuerier []simplejson.DataPoint
+
+func (sq seriesQuerier) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	return sq, nil
+}
+
+const encodeTestQuery = `{
+	"range": {
+		"from": "2016-10-31T06:33:44.866Z",
+		"to": "2016-10-31T12:33:44.866Z"
+	},
+	"interval": "30s",
+	"targets": [
+		{ "target": "upper_50", "refId": "A" }
+	]
+}`
+
+func TestQueryStreaming(t *testing.T) {
+	start := time.Unix(1477917219, 0)
+	var sq seriesQuerier
+	var expectPoints [][2]float64
+	for i := 0; i < 100000; i++ {
+		v := float64(i) * 1.5e-7
+		ts := start.Add(time.Duration(i) * time.Second)
+		sq = append(sq, simplejson.DataPoint{Time: ts, Value: v})
+		expectPoints = append(expectPoints, [2]float64{v, float64(ts.UnixNano() / 1000000)})
+	}
+
+	gsj := simplejson.New(
+		simplejson.WithQuerier(sq),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expectBs, _ := json.Marshal([]interface{}{
+		map[string]interface{}{
+			"target":     "upper_50",
+			"refId":      "A",
+			"datapoints": expectPoints,
+		},
+	})
+	var expect, got interface{}
+	json.Unmarshal(expectBs, &expect)
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response JSON, %v", err)
+	}
+	if !bytes.Equal(mustMarshal(expect), mustMarshal(got)) {
+		t.Fatalf("streamed response did not match expected output")
+	}
+}
+
+func TestQueryStreaming_NaN(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(seriesQuerier{
+			{Time: time.Unix(1234, 0), Value: math.NaN()},
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+func TestQueryNullDataPoints(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(seriesQuerier{
+			{Time: time.Unix(1, 0), Value: 1},
+			simplejson.NullDataPoint(time.Unix(2, 0)),
+			{Time: time.Unix(3, 0), Value: math.NaN(), Null: true},
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"upper_50","refId":"A","datapoints":[[1,1000],[null,2000],[null,3000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
+
+func TestTableEncoding(t *testing.T) {
+	ts := time.Date(2017, 7, 14, 2, 40, 0, 5, time.FixedZone("", 3600))
+	str, b, f := "<q\" \xff>", true, 1.5e-7
+	cols := []simplejson.TableColumn{
+		{Text: "s", Data: simplejson.TableStringColumn{"a\tb", "é&\x01"}},
+		{Text: "n", Data: simplejson.TableNumberColumn{1e21, -0.5}},
+		{Text: "t", Data: simplejson.TableTimeColumn{ts, {}}},
+		{Text: "b", Data: simplejson.TableBoolColumn{true, false}},
+		{Text: "ps", Data: simplejson.Column[*string]{&str, nil}},
+		{Text: "pb", Data: simplejson.Column[*bool]{&b, nil}},
+		{Text: "pf", Data: simplejson.Column[*float64]{&f, nil}},
+		{Text: "pt", Data: simplejson.Column[*time.Time]{&ts, nil}},
+		{Text: "j", Data: simplejson.TableJSONColumn{map[string]int{"a": 1}, nil}},
+	}
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return cols, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"t","type":"table","refId":"A"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	rows := make([][]interface{}, 2)
+	for i := range rows {
+		for _, c := range cols {
+			v := reflect.ValueOf(c.Data).Index(i).Interface()
+			rows[i] = append(rows[i], v)
+		}
+	}
+	expectRows, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"rows":`+string(expectRows)+`}]`)) {
+		t.Fatalf("\nexpected rows: %s\ngot:           %s", expectRows, w.Body.String())
+	}
+}
+
+func TestTableEncoding_NaN(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{{Text: "n", Data: simplejson.TableNumberColumn{math.NaN()}}}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"t","type":"table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected an error for a NaN value, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Errors that may be returned, or wrapped, by handlers to indicate the
+// HTTP status that should be reported to Grafana.
+var (
+	ErrBadRequest  = errors.New("bad request")
+	ErrNotFound    = errors.New("not found")
+	ErrTimeout     = errors.New("timeout")
+	ErrUnavailable = errors.New("service unavailable")
+)
+
+// Error can be returned by handlers to control the HTTP status code and
+// message reported to Grafana. Either an Error or an *Error may be
+// returned.
+type Error struct {
+	Status  int
+	Message string
+}
+
+// Error implements the error interface.
+func (e Error) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.Status)
+	}
+	return e.Message
+}
+
+// errorStatus returns the HTTP status that should be used to report err,
+// def is used if no more specific status can be determined.
+func errorStatus(err error, def int) int {
+	var e Error
+	if errors.As(err, &e) && e.Status != 0 {
+		return e.Status
+	}
+	var pe *Error
+	if errors.As(err, &pe) && pe != nil && pe.Status != 0 {
+		return pe.Status
+	}
+
+	switch {
+	case errors.Is(err, ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+
+	return def
+}
+
+type simpleJSONError struct {
+	Message string `json:"message"`
+}
+
+// writeError writes err to w as a JSON object. The status code is
+// derived from err, or def if err does not specify one.
+func writeError(w http.ResponseWriter, err error, def int) {
+	// We igore the error here because the following should
+	// always be marshable.
+	bs, _ := json.Marshal(simpleJSONError{Message: err.Error()})
+	recordError(w, err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(errorStatus(err, def))
+	w.Write(bs)
+}
@@ -0,0 +1,84 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type errorSearcher struct {
+	err error
+}
+
+func (es errorSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return nil, es.err
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{
+			err:    simplejson.Error{Status: http.StatusNotFound, Message: "unknown target"},
+			status: http.StatusNotFound,
+			body:   `{"message":"unknown target"}`,
+		},
+		{
+			err:    fmt.Errorf("backend slow: %w", simplejson.ErrTimeout),
+			status: http.StatusGatewayTimeout,
+			body:   `{"message":"backend slow: timeout"}`,
+		},
+		{
+			err:    fmt.Errorf("wrapped: %w", simplejson.Error{Status: http.StatusTeapot}),
+			status: http.StatusTeapot,
+			body:   `{"message":"wrapped: I'm a teapot"}`,
+		},
+		{
+			err:    &simplejson.Error{Status: http.StatusConflict, Message: "pointer"},
+			status: http.StatusConflict,
+			body:   `{"message":"pointer"}`,
+		},
+		{
+			err:    fmt.Errorf("wrapped: %w", &simplejson.Error{Status: http.StatusTeapot}),
+			status: http.StatusTeapot,
+			body:   `{"message":"wrapped: I'm a teapot"}`,
+		},
+		{
+			err:    fmt.Errorf("plain error"),
+			status: http.StatusBadRequest,
+			body:   `{"message":"plain error"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		gsj := simplejson.New(
+			simplejson.WithSearcher(errorSearcher{err: tt.err}),
+		)
+
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+		res := w.Result()
+
+		buf := &bytes.Buffer{}
+		io.Copy(buf, res.Body)
+
+		if res.StatusCode != tt.status {
+			t.Errorf("expected status %d, got %d", tt.status, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected application/json content type, got %q", ct)
+		}
+		if buf.String() != tt.body {
+			t.Errorf("\nexpected: %q\ngot:%s", tt.body, buf.String())
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FederatedQuerier is a Querier that sends every target to each of a set
+// of backends, such as the datasources of several regions, and merges
+// their results into a single series. Points are merged in time order,
+// with points at the same time ordered as their backends were added.
+//
+// FederatedQuerier is also a Searcher, merging the results of all of its
+// backends that implement Searcher.
+//
+// Backends should all be added before the FederatedQuerier is used.
+type FederatedQuerier struct {
+	backends []federatedBackend
+	timeout  time.Duration
+	partial  bool
+	dedupe   bool
+	label    string
+}
+
+type federatedBackend struct {
+	name string
+	q    Querier
+}
+
+// A FederationOpt configures a FederatedQuerier.
+type FederationOpt func(*FederatedQuerier)
+
+// WithBackendTimeout limits the time each backend may take to answer.
+// A backend that times out is treated as having failed.
+func WithBackendTimeout(d time.Duration) FederationOpt {
+	return func(f *FederatedQuerier) {
+		f.timeout = d
+	}
+}
+
+// WithPartialResults returns the results of the backends that succeed
+// when others fail, rather than failing the whole query. An error is
+// still returned if every backend fails.
+func WithPartialResults() FederationOpt {
+	return func(f *FederatedQuerier) {
+		f.partial = true
+	}
+}
+
+// WithDeduplication removes points at the same time as an earlier point,
+// keeping the point from the backend added first. This suits backends
+// holding replicas of the same data.
+func WithDeduplication() FederationOpt {
+	return func(f *FederatedQuerier) {
+		f.dedupe = true
+	}
+}
+
+// WithBackendLabel attaches a label with the given key to merged series,
+// see SetLabels. Its value lists the names of the backends that
+// contributed points, separated by commas.
+func WithBackendLabel(key string) FederationOpt {
+	return func(f *FederatedQuerier) {
+		f.label = key
+	}
+}
+
+// NewFederatedQuerier creates a FederatedQuerier with no backends.
+func NewFederatedQuerier(opts ...FederationOpt) *FederatedQuerier {
+	f := &FederatedQuerier{}
+	for _, o := range opts {
+		o(f)
+	}
+	return f
+}
+
+// Add adds a backend, the name is used in errors and labels.
+func (f *FederatedQuerier) Add(name string, q Querier) {
+	f.backends = append(f.backends, federatedBackend{name: name, q: q})
+}
+
+// fanOut calls fn for each backend concurrently, returning the results
+// of those that succeed. Failures are returned as an error unless
+// partial results are allowed, and at least one backend succeeded.
+func fanOut[T any](ctx context.Context, f *FederatedQuerier, backends []federatedBackend, fn func(ctx context.Context, b federatedBackend) (T, error)) ([]T, []bool, error) {
+	results := make([]T, len(backends))
+	errs := make([]error, len(backends))
+
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer catchPanic(&errs[i])
+
+			bctx, cancel := ctx, context.CancelFunc(func() {})
+			if f.timeout > 0 {
+				bctx, cancel = context.WithTimeout(ctx, f.timeout)
+			}
+			defer cancel()
+			results[i], errs[i] = callWithDeadline(bctx, func(ctx context.Context) (T, error) {
+				return fn(ctx, b)
+			})
+		}()
+	}
+	wg.Wait()
+
+	ok := make([]bool, len(backends))
+	var firstErr error
+	for i, err := range errs {
+		repanic(err)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("backend %s: %w", backends[i].name, err)
+			}
+			continue
+		}
+		ok[i] = true
+	}
+	if firstErr != nil && (!f.partial || !slices.Contains(ok, true)) {
+		return nil, nil, firstErr
+	}
+	return results, ok, nil
+}
+
+// GrafanaQuery implements Querier, querying all backends concurrently and
+// merging their series.
+func (f *FederatedQuerier) GrafanaQuery(ctx context.Context, target string, args QueryArguments) ([]DataPoint, error) {
+	results, ok, err := fanOut(ctx, f, f.backends, func(ctx context.Context, b federatedBackend) ([]DataPoint, error) {
+		return b.q.GrafanaQuery(ctx, target, args)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type sourced struct {
+		dp      DataPoint
+		backend int
+	}
+	var all []sourced
+	for i, dps := range results {
+		if !ok[i] {
+			continue
+		}
+		for _, dp := range dps {
+			all = append(all, sourced{dp, i})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].dp.Time.Before(all[j].dp.Time)
+	})
+
+	merged := make([]DataPoint, 0, len(all))
+	contributed := make([]bool, len(f.backends))
+	for i, s := range all {
+		if f.dedupe && i > 0 && s.dp.Time.Equal(all[i-1].dp.Time) {
+			continue
+		}
+		merged = append(merged, s.dp)
+		contributed[s.backend] = true
+	}
+
+	if f.label != "" {
+		var names []string
+		for i, b := range f.backends {
+			if contributed[i] {
+				names = append(names, b.name)
+			}
+		}
+		SetLabels(ctx, map[string]string{f.label: strings.Join(names, ",")})
+	}
+	return merged, nil
+}
+
+// GrafanaSearch implements Searcher, searching all backends that
+// implement Searcher concurrently. The results are merged, in the order
+// the backends were added, with duplicates removed.
+func (f *FederatedQuerier) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	var searchers []federatedBackend
+	for _, b := range f.backends {
+		if _, ok := b.q.(Searcher); ok {
+			searchers = append(searchers, b)
+		}
+	}
+
+	results, _, err := fanOut(ctx, f, searchers, func(ctx context.Context, b federatedBackend) ([]string, error) {
+		return b.q.(Searcher).GrafanaSearch(ctx, target)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, rs := range results {
+		for _, r := range rs {
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+			merged = append(merged, r)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type federationTestBackend struct {
+	points []simplejson.DataPoint
+	search []string
+	err    error
+	delay  time.Duration
+}
+
+func (b federationTestBackend) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	if b.delay > 0 {
+		select {
+		case <-time.After(b.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return b.points, b.err
+}
+
+func (b federationTestBackend) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return b.search, b.err
+}
+
+func federationPoints(ts ...int64) []simplejson.DataPoint {
+	var dps []simplejson.DataPoint
+	for _, t := range ts {
+		dps = append(dps, simplejson.DataPoint{Time: time.Unix(t, 0), Value: float64(t)})
+	}
+	return dps
+}
+
+func TestFederatedQuerier(t *testing.T) {
+	eu := federationTestBackend{points: federationPoints(1, 3), search: []string{"cpu", "mem"}}
+	us := federationTestBackend{points: federationPoints(2, 3), search: []string{"cpu", "disk"}}
+	us.points[1].Value = 30
+
+	tests := []struct {
+		name   string
+		opts   []simplejson.FederationOpt
+		expect []float64
+	}{
+		{"merge", nil, []float64{1, 2, 3, 30}},
+		{"dedupe", []simplejson.FederationOpt{simplejson.WithDeduplication()}, []float64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := simplejson.NewFederatedQuerier(tt.opts...)
+			f.Add("eu", eu)
+			f.Add("us", us)
+
+			dps, err := f.GrafanaQuery(context.Background(), "cpu", simplejson.QueryArguments{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []float64
+			for _, dp := range dps {
+				got = append(got, dp.Value)
+			}
+			if len(got) != len(tt.expect) {
+				t.Fatalf("expected %v, got %v", tt.expect, got)
+			}
+			for i := range got {
+				if got[i] != tt.expect[i] {
+					t.Fatalf("expected %v, got %v", tt.expect, got)
+				}
+			}
+		})
+	}
+
+	f := simplejson.NewFederatedQuerier()
+	f.Add("eu", eu)
+	f.Add("us", us)
+	res, err := f.GrafanaSearch(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 3 || res[0] != "cpu" || res[1] != "mem" || res[2] != "disk" {
+		t.Fatalf("unexpected search results %v", res)
+	}
+}
+
+func TestFederatedQuerier_Failures(t *testing.T) {
+	ok := federationTestBackend{points: federationPoints(1)}
+	failed := federationTestBackend{err: simplejson.ErrUnavailable}
+	slow := federationTestBackend{points: federationPoints(2), delay: time.Second}
+	ctx := context.Background()
+
+	f := simplejson.NewFederatedQuerier(simplejson.WithBackendTimeout(10 * time.Millisecond))
+	f.Add("ok", ok)
+	f.Add("failed", failed)
+	if _, err := f.GrafanaQuery(ctx, "x", simplejson.QueryArguments{}); !errors.Is(err, simplejson.ErrUnavailable) {
+		t.Fatalf("expected the backend's error, got %v", err)
+	}
+
+	f = simplejson.NewFederatedQuerier(simplejson.WithBackendTimeout(10*time.Millisecond), simplejson.WithPartialResults())
+	f.Add("ok", ok)
+	f.Add("failed", failed)
+	f.Add("slow", slow)
+	start := time.Now()
+	dps, err := f.GrafanaQuery(ctx, "x", simplejson.QueryArguments{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) != 1 || dps[0].Value != 1 {
+		t.Fatalf("expected only the successful backend's points, got %+v", dps)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expected the slow backend to time out")
+	}
+
+	f = simplejson.NewFederatedQuerier(simplejson.WithPartialResults())
+	f.Add("failed", failed)
+	if _, err := f.GrafanaQuery(ctx, "x", simplejson.QueryArguments{}); err == nil {
+		t.Fatalf("expected an error when all backends fail")
+	}
+}
+
+func TestFederatedQuerier_Label(t *testing.T) {
+	f := simplejson.NewFederatedQuerier(simplejson.WithBackendLabel("region"), simplejson.WithPartialResults())
+	f.Add("eu", federationTestBackend{points: federationPoints(1)})
+	f.Add("ap", federationTestBackend{})
+	f.Add("us", federationTestBackend{points: federationPoints(2)})
+	f.Add("sa", federationTestBackend{err: errors.New("down")})
+
+	gsj := simplejson.New(simplejson.WithQuerier(f), simplejson.WithDataFrames())
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"cpu","refId":"A"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"labels":{"region":"eu,us"}`)) {
+		t.Fatalf("expected backends to be labelled, got %s", w.Body.String())
+	}
+}
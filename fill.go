@@ -0,0 +1,101 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"time"
+)
+
+// FillPolicy sets the value given to points added by FillGaps.
+type FillPolicy int
+
+const (
+	// FillNull adds null points, rendered as gaps.
+	FillNull FillPolicy = iota
+	// FillZero adds points with a value of zero.
+	FillZero
+	// FillPrevious repeats the value of the previous non-null point, or
+	// adds nulls if there is none.
+	FillPrevious
+)
+
+// alignTime rounds t down to a multiple of interval since the Unix epoch.
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	ns := t.UnixNano()
+	rem := ns % int64(interval)
+	if rem < 0 {
+		rem += int64(interval)
+	}
+	return time.Unix(0, ns-rem).In(t.Location())
+}
+
+// Align moves the time of each of points, which must be sorted by time,
+// to the start of the interval containing it, counting intervals from the
+// Unix epoch, as Grafana does. Where several points fall in the same
+// interval, only the last is kept. Align modifies points in place, and
+// returns the aligned points.
+func Align(points []DataPoint, interval time.Duration) []DataPoint {
+	if interval <= 0 {
+		return points
+	}
+	out := points[:0]
+	for _, dp := range points {
+		dp.Time = alignTime(dp.Time, interval)
+		if n := len(out); n > 0 && out[n-1].Time.Equal(dp.Time) {
+			out[n-1] = dp
+			continue
+		}
+		out = append(out, dp)
+	}
+	return out
+}
+
+// FillGaps returns a series with a point at every interval between from
+// and to, taking values from points, which must be sorted by time, and
+// using fill for intervals that have no point. points is aligned to the
+// interval as for Align, and points outside of the range are dropped.
+func FillGaps(points []DataPoint, from, to time.Time, interval time.Duration, fill FillPolicy) []DataPoint {
+	if interval <= 0 {
+		return points
+	}
+	points = Align(append([]DataPoint(nil), points...), interval)
+
+	var (
+		out  []DataPoint
+		prev *DataPoint
+		i    int
+	)
+	for t := alignTime(from, interval); !t.After(to); t = t.Add(interval) {
+		for i < len(points) && points[i].Time.Before(t) {
+			if !points[i].Null {
+				prev = &points[i]
+			}
+			i++
+		}
+		if i < len(points) && points[i].Time.Equal(t) {
+			out = append(out, points[i])
+			continue
+		}
+
+		switch {
+		case fill == FillZero:
+			out = append(out, DataPoint{Time: t})
+		case fill == FillPrevious && prev != nil:
+			out = append(out, DataPoint{Time: t, Value: prev.Value})
+		default:
+			out = append(out, NullDataPoint(t))
+		}
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package simplejson_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestAlign(t *testing.T) {
+	points := []simplejson.DataPoint{
+		{Time: time.Unix(61, 0), Value: 1},
+		{Time: time.Unix(119, 0), Value: 2},
+		{Time: time.Unix(185, 0), Value: 3},
+	}
+	got := simplejson.Align(points, time.Minute)
+	expect := []simplejson.DataPoint{
+		{Time: time.Unix(60, 0), Value: 2},
+		{Time: time.Unix(180, 0), Value: 3},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("\nexpected: %v\ngot: %v", expect, got)
+	}
+}
+
+func TestFillGaps(t *testing.T) {
+	nan := math.NaN()
+	points := []simplejson.DataPoint{
+		{Time: time.Unix(65, 0), Value: 1},
+		{Time: time.Unix(185, 0), Value: 3},
+		{Time: time.Unix(600, 0), Value: 9},
+	}
+	from, to := time.Unix(10, 0), time.Unix(290, 0)
+
+	for _, tc := range []struct {
+		name   string
+		fill   simplejson.FillPolicy
+		expect []float64
+	}{
+		{"null", simplejson.FillNull, []float64{nan, 1, nan, 3, nan}},
+		{"zero", simplejson.FillZero, []float64{0, 1, 0, 3, 0}},
+		{"previous", simplejson.FillPrevious, []float64{nan, 1, 1, 3, 3}},
+	} {
+		got := simplejson.FillGaps(points, from, to, time.Minute, tc.fill)
+		if len(got) != len(tc.expect) {
+			t.Fatalf("%s: expected %d points, got %d", tc.name, len(tc.expect), len(got))
+		}
+		for i, dp := range got {
+			if expect := time.Unix(int64(i*60), 0); !dp.Time.Equal(expect) {
+				t.Errorf("%s: point %d, expected time %v, got %v", tc.name, i, expect, dp.Time)
+			}
+			if math.IsNaN(tc.expect[i]) != dp.Null || (!dp.Null && dp.Value != tc.expect[i]) {
+				t.Errorf("%s: point %d, expected %v, got %+v", tc.name, i, tc.expect[i], dp)
+			}
+		}
+	}
+
+	if points[0].Time != time.Unix(65, 0) {
+		t.Fatalf("FillGaps modified its input")
+	}
+}
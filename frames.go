@@ -0,0 +1,269 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithDataFrames encodes the results of queries as Grafana data frames,
+// rather than the legacy timeserie and table formats. Data frames can
+// carry labels, see SetLabels, alongside the field config of each series
+// or column, and tables are sent as a single wide frame. Consumers of the
+// response must support the JSON encoding of data frames.
+//
+// Since the values of a data frame are encoded by column, results from an
+// IterQuerier or TableIterQuerier are read in full before the response is
+// written.
+func WithDataFrames() Opt {
+	return func(sjc *Handler) error {
+		sjc.dataFrames = true
+		return nil
+	}
+}
+
+// SetLabels attaches labels to the timeserie being returned by a Querier,
+// RequestQuerier or IterQuerier, ctx must be the context the handler was
+// called with. It reports whether the labels could be set. Labels are
+// only sent to Grafana when data frames are enabled, see WithDataFrames.
+func SetLabels(ctx context.Context, labels map[string]string) bool {
+	fch, ok := ctx.Value(fieldConfigContextKey).(*fieldConfigHolder)
+	if !ok {
+		return false
+	}
+	fch.Lock()
+	defer fch.Unlock()
+	fch.labels = labels
+	return true
+}
+
+// dataFrame is the JSON encoding of a Grafana data frame.
+type dataFrame struct {
+	Schema dataFrameSchema `json:"schema"`
+	Data   dataFrameData   `json:"data"`
+}
+
+type dataFrameSchema struct {
+	Name   string           `json:"name,omitempty"`
+	RefID  string           `json:"refId,omitempty"`
+	Meta   *simpleJSONMeta  `json:"meta,omitempty"`
+	Fields []dataFrameField `json:"fields"`
+}
+
+type dataFrameField struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	TypeInfo dataFrameTypeInfo `json:"typeInfo"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Config   *FieldConfig      `json:"config,omitempty"`
+}
+
+type dataFrameTypeInfo struct {
+	Frame    string `json:"frame"`
+	Nullable bool   `json:"nullable,omitempty"`
+}
+
+type dataFrameData struct {
+	Values [][]interface{} `json:"values"`
+}
+
+// dataFrameTypes maps the column types of the legacy table format to
+// the Go types Grafana uses for the fields of a frame.
+var dataFrameTypes = map[string]string{
+	"number":  "float64",
+	"time":    "time.Time",
+	"string":  "string",
+	"boolean": "bool",
+}
+
+// dataFrames converts the results of a query to data frames.
+func dataFrames(out []interface{}) ([]interface{}, error) {
+	frames := make([]interface{}, len(out))
+	for i, res := range out {
+		var err error
+		switch res := res.(type) {
+		case simpleJSONData:
+			frames[i], err = res.frame()
+		case *simpleJSONIterData:
+			frames[i], err = res.frame()
+		case simpleJSONTableData:
+			frames[i] = tableFrame("", res.RefID, res.Columns, res.Rows, res.Meta)
+		case *simpleJSONTableIterData:
+			frames[i], err = res.frame()
+		default:
+			err = fmt.Errorf("cannot convert %T to a data frame", res)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return frames, nil
+}
+
+// frame converts the series to a data frame.
+func (sjd simpleJSONData) frame() (*dataFrame, error) {
+	points := make([]DataPoint, 0, len(sjd.DataPoints))
+	for _, dp := range sjd.DataPoints {
+		dp, keep, err := sjd.nonFinite.apply(dp)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			points = append(points, dp)
+		}
+	}
+	return seriesFrame(sjd.Target, sjd.RefID, points, sjd.config, sjd.labels, sjd.meta), nil
+}
+
+// frame converts the series to a data frame, consuming the iterator.
+func (sjd *simpleJSONIterData) frame() (*dataFrame, error) {
+	defer sjd.stop()
+
+	var (
+		points []DataPoint
+		meta   *simpleJSONMeta
+	)
+	dp, ok := sjd.first, sjd.hasFirst
+	for ok {
+		out, keep, err := sjd.nonFinite.apply(dp)
+		if err != nil {
+			return nil, err
+		}
+		if keep && sjd.maxDPs > 0 && len(points) == sjd.maxDPs {
+			meta = truncationNotice(sjd.Target, "series truncated to %d points", len(points))
+			break
+		}
+		if keep {
+			points = append(points, out)
+		}
+
+		dp, err, ok = sjd.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return seriesFrame(sjd.Target, sjd.RefID, points, sjd.config.get(), sjd.config.getLabels(), meta), nil
+}
+
+// seriesFrame builds a frame with a time field, and a value field named
+// for the target.
+func seriesFrame(name, refID string, points []DataPoint, cfg *FieldConfig, labels map[string]string, meta *simpleJSONMeta) *dataFrame {
+	times := make([]interface{}, len(points))
+	values := make([]interface{}, len(points))
+	for i, dp := range points {
+		times[i] = dp.Time.UnixNano() / int64(time.Millisecond)
+		if !dp.Null {
+			values[i] = dp.Value
+		}
+	}
+	return &dataFrame{
+		Schema: dataFrameSchema{
+			Name:  name,
+			RefID: refID,
+			Meta:  meta,
+			Fields: []dataFrameField{
+				{Name: "Time", Type: "time", TypeInfo: dataFrameTypeInfo{Frame: "time.Time"}},
+				{Name: name, Type: "number", TypeInfo: dataFrameTypeInfo{Frame: "float64", Nullable: true}, Labels: labels, Config: cfg},
+			},
+		},
+		Data: dataFrameData{Values: [][]interface{}{times, values}},
+	}
+}
+
+// frame converts the table to a data frame, consuming the iterator.
+func (sjd *simpleJSONTableIterData) frame() (*dataFrame, error) {
+	defer sjd.stop()
+
+	var (
+		rows []simpleJSONTableRow
+		meta *simpleJSONMeta
+	)
+	row, ok := sjd.first, sjd.hasFirst
+	for ok {
+		if sjd.maxRows > 0 && len(rows) == sjd.maxRows {
+			meta = truncationNotice(sjd.target, "table truncated to %d rows", len(rows))
+			break
+		}
+		if len(row) != len(sjd.Columns) {
+			return nil, fmt.Errorf("row has %d values, expected %d", len(row), len(sjd.Columns))
+		}
+		rows = append(rows, row)
+
+		var err error
+		row, err, ok = sjd.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tableFrame("", sjd.RefID, sjd.Columns, rows, meta), nil
+}
+
+// tableFrame builds a wide frame with a field for each column of a table.
+func tableFrame(name, refID string, cols []simpleJSONTableColumn, rows []simpleJSONTableRow, meta *simpleJSONMeta) *dataFrame {
+	df := &dataFrame{
+		Schema: dataFrameSchema{
+			Name:   name,
+			RefID:  refID,
+			Meta:   meta,
+			Fields: make([]dataFrameField, len(cols)),
+		},
+		Data: dataFrameData{Values: make([][]interface{}, len(cols))},
+	}
+	for j, col := range cols {
+		typ, frame := col.Type, dataFrameTypes[col.Type]
+		if frame == "" {
+			typ, frame = "other", "json.RawMessage"
+		}
+		cfg := col.Config
+		if col.Unit != "" && (cfg == nil || cfg.Unit == "") {
+			c := FieldConfig{}
+			if cfg != nil {
+				c = *cfg
+			}
+			c.Unit = col.Unit
+			cfg = &c
+		}
+		df.Schema.Fields[j] = dataFrameField{
+			Name:     col.Text,
+			Type:     typ,
+			TypeInfo: dataFrameTypeInfo{Frame: frame, Nullable: true},
+			Config:   cfg,
+		}
+
+		values := make([]interface{}, len(rows))
+		for i, row := range rows {
+			values[i] = dataFrameValue(row[j])
+		}
+		df.Data.Values[j] = values
+	}
+	return df
+}
+
+// dataFrameValue converts times to milliseconds since the epoch, as
+// data frames expect.
+func dataFrameValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UnixNano() / int64(time.Millisecond)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UnixNano() / int64(time.Millisecond)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithDataFrames_Timeserie(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithDataFrames(),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			if !simplejson.SetLabels(ctx, map[string]string{"host": "web-1"}) {
+				t.Errorf("expected labels to be set")
+			}
+			simplejson.SetFieldConfig(ctx, simplejson.FieldConfig{Unit: "s"})
+			return []simplejson.DataPoint{
+				{Time: time.Unix(1, 0), Value: 1},
+				simplejson.NullDataPoint(time.Unix(2, 0)),
+			}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"schema":{"name":"upper_50","refId":"A","fields":[` +
+		`{"name":"Time","type":"time","typeInfo":{"frame":"time.Time"}},` +
+		`{"name":"upper_50","type":"number","typeInfo":{"frame":"float64","nullable":true},"labels":{"host":"web-1"},"config":{"unit":"s"}}]},` +
+		`"data":{"values":[[1000,2000],[1,null]]}}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithDataFrames_Table(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithDataFrames(),
+		simplejson.WithMaxRows(1),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{
+				{Text: "Time", Data: simplejson.TableTimeColumn{time.Unix(1, 0), time.Unix(2, 0)}},
+				{Text: "Host", Data: simplejson.TableStringColumn{"web-1", "web-2"}},
+				{Text: "Latency", Unit: "ms", Data: simplejson.TableNumberColumn{12.5, 20}},
+			}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "requests", "refId": "B", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"schema":{"refId":"B","meta":{"notices":[{"severity":"warning","text":"table truncated from 2 to 1 rows"}]},"fields":[` +
+		`{"name":"Time","type":"time","typeInfo":{"frame":"time.Time","nullable":true}},` +
+		`{"name":"Host","type":"string","typeInfo":{"frame":"string","nullable":true}},` +
+		`{"name":"Latency","type":"number","typeInfo":{"frame":"float64","nullable":true},"config":{"unit":"ms"}}]},` +
+		`"data":{"values":[[1000],["web-1"],[12.5]]}}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithDataFrames_TableIter(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithDataFrames(),
+		simplejson.WithTableIterQuerier(tableIterQuerier{rows: [][]interface{}{
+			{"a", 1.0},
+			{"b", 2.0},
+		}}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "requests", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d, %s", w.Code, w.Body.String())
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"data":{"values":[["a","b"],[1,2]]}`)) {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+}
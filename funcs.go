@@ -0,0 +1,178 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"iter"
+)
+
+// The QuerierFunc type is an adapter to allow the use of an ordinary
+// function as a Querier.
+type QuerierFunc func(ctx context.Context, target string, args QueryArguments) ([]DataPoint, error)
+
+// GrafanaQuery calls f(ctx, target, args).
+func (f QuerierFunc) GrafanaQuery(ctx context.Context, target string, args QueryArguments) ([]DataPoint, error) {
+	return f(ctx, target, args)
+}
+
+// The RequestQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a RequestQuerier.
+type RequestQuerierFunc func(ctx context.Context, req QueryRequest) ([]DataPoint, error)
+
+// GrafanaQueryRequest calls f(ctx, req).
+func (f RequestQuerierFunc) GrafanaQueryRequest(ctx context.Context, req QueryRequest) ([]DataPoint, error) {
+	return f(ctx, req)
+}
+
+// The IterQuerierFunc type is an adapter to allow the use of an ordinary
+// function as an IterQuerier.
+type IterQuerierFunc func(ctx context.Context, req QueryRequest) iter.Seq2[DataPoint, error]
+
+// GrafanaQueryIter calls f(ctx, req).
+func (f IterQuerierFunc) GrafanaQueryIter(ctx context.Context, req QueryRequest) iter.Seq2[DataPoint, error] {
+	return f(ctx, req)
+}
+
+// The SeriesQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a SeriesQuerier.
+type SeriesQuerierFunc func(ctx context.Context, req QueryRequest) ([]*Series, error)
+
+// GrafanaQuerySeries calls f(ctx, req).
+func (f SeriesQuerierFunc) GrafanaQuerySeries(ctx context.Context, req QueryRequest) ([]*Series, error) {
+	return f(ctx, req)
+}
+
+// The TableQuerierFunc type is an adapter to allow the use of an ordinary
+// function as a TableQuerier.
+type TableQuerierFunc func(ctx context.Context, target string, args TableQueryArguments) ([]TableColumn, error)
+
+// GrafanaQueryTable calls f(ctx, target, args).
+func (f TableQuerierFunc) GrafanaQueryTable(ctx context.Context, target string, args TableQueryArguments) ([]TableColumn, error) {
+	return f(ctx, target, args)
+}
+
+// The TableRequestQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a TableRequestQuerier.
+type TableRequestQuerierFunc func(ctx context.Context, req QueryRequest) ([]TableColumn, error)
+
+// GrafanaQueryTableRequest calls f(ctx, req).
+func (f TableRequestQuerierFunc) GrafanaQueryTableRequest(ctx context.Context, req QueryRequest) ([]TableColumn, error) {
+	return f(ctx, req)
+}
+
+// The TableIterQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a TableIterQuerier.
+type TableIterQuerierFunc func(ctx context.Context, req QueryRequest) ([]TableColumnHeader, iter.Seq2[[]interface{}, error], error)
+
+// GrafanaQueryTableIter calls f(ctx, req).
+func (f TableIterQuerierFunc) GrafanaQueryTableIter(ctx context.Context, req QueryRequest) ([]TableColumnHeader, iter.Seq2[[]interface{}, error], error) {
+	return f(ctx, req)
+}
+
+// The HeatmapQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a HeatmapQuerier.
+type HeatmapQuerierFunc func(ctx context.Context, req QueryRequest) ([]HeatmapBucket, error)
+
+// GrafanaQueryHeatmap calls f(ctx, req).
+func (f HeatmapQuerierFunc) GrafanaQueryHeatmap(ctx context.Context, req QueryRequest) ([]HeatmapBucket, error) {
+	return f(ctx, req)
+}
+
+// The LogQuerierFunc type is an adapter to allow the use of an ordinary
+// function as a LogQuerier.
+type LogQuerierFunc func(ctx context.Context, req QueryRequest) ([]LogLine, error)
+
+// GrafanaQueryLogs calls f(ctx, req).
+func (f LogQuerierFunc) GrafanaQueryLogs(ctx context.Context, req QueryRequest) ([]LogLine, error) {
+	return f(ctx, req)
+}
+
+// The NodeGraphQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a NodeGraphQuerier.
+type NodeGraphQuerierFunc func(ctx context.Context, req QueryRequest) ([]GraphNode, []GraphEdge, error)
+
+// GrafanaQueryNodeGraph calls f(ctx, req).
+func (f NodeGraphQuerierFunc) GrafanaQueryNodeGraph(ctx context.Context, req QueryRequest) ([]GraphNode, []GraphEdge, error) {
+	return f(ctx, req)
+}
+
+// The HealthCheckerFunc type is an adapter to allow the use of an
+// ordinary function as a HealthChecker.
+type HealthCheckerFunc func(ctx context.Context) (HealthStatus, error)
+
+// GrafanaHealthCheck calls f(ctx).
+func (f HealthCheckerFunc) GrafanaHealthCheck(ctx context.Context) (HealthStatus, error) {
+	return f(ctx)
+}
+
+// The AnnotatorFunc type is an adapter to allow the use of an ordinary
+// function as an Annotator.
+type AnnotatorFunc func(ctx context.Context, query string, args AnnotationsArguments) ([]Annotation, error)
+
+// GrafanaAnnotations calls f(ctx, query, args).
+func (f AnnotatorFunc) GrafanaAnnotations(ctx context.Context, query string, args AnnotationsArguments) ([]Annotation, error) {
+	return f(ctx, query, args)
+}
+
+// The AnnotationQuerierFunc type is an adapter to allow the use of an
+// ordinary function as an AnnotationQuerier.
+type AnnotationQuerierFunc func(ctx context.Context, q AnnotationQuery) ([]Annotation, error)
+
+// GrafanaAnnotationQuery calls f(ctx, q).
+func (f AnnotationQuerierFunc) GrafanaAnnotationQuery(ctx context.Context, q AnnotationQuery) ([]Annotation, error) {
+	return f(ctx, q)
+}
+
+// The SearcherFunc type is an adapter to allow the use of an ordinary
+// function as a Searcher.
+type SearcherFunc func(ctx context.Context, target string) ([]string, error)
+
+// GrafanaSearch calls f(ctx, target).
+func (f SearcherFunc) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return f(ctx, target)
+}
+
+// The ResultSearcherFunc type is an adapter to allow the use of an
+// ordinary function as a ResultSearcher.
+type ResultSearcherFunc func(ctx context.Context, target string) ([]SearchResult, error)
+
+// GrafanaSearchResults calls f(ctx, target).
+func (f ResultSearcherFunc) GrafanaSearchResults(ctx context.Context, target string) ([]SearchResult, error) {
+	return f(ctx, target)
+}
+
+// TagSearcherFunc allows a pair of ordinary functions to be used as a
+// TagSearcher. Keys is called for tag key queries, and Values for tag
+// value queries. A nil function returns no results.
+type TagSearcherFunc struct {
+	Keys   func(ctx context.Context) ([]TagInfoer, error)
+	Values func(ctx context.Context, key string) ([]TagValuer, error)
+}
+
+// GrafanaAdhocFilterTags calls f.Keys(ctx).
+func (f TagSearcherFunc) GrafanaAdhocFilterTags(ctx context.Context) ([]TagInfoer, error) {
+	if f.Keys == nil {
+		return nil, nil
+	}
+	return f.Keys(ctx)
+}
+
+// GrafanaAdhocFilterTagValues calls f.Values(ctx, key).
+func (f TagSearcherFunc) GrafanaAdhocFilterTagValues(ctx context.Context, key string) ([]TagValuer, error) {
+	if f.Values == nil {
+		return nil, nil
+	}
+	return f.Values(ctx, key)
+}
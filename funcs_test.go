@@ -0,0 +1,56 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestFuncAdapters(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}}, nil
+		})),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{{Text: "col", Data: simplejson.TableStringColumn{"a"}}}, nil
+		})),
+		simplejson.WithAnnotator(simplejson.AnnotatorFunc(func(ctx context.Context, query string, args simplejson.AnnotationsArguments) ([]simplejson.Annotation, error) {
+			return []simplejson.Annotation{{Time: time.Unix(1, 0), Title: query}}, nil
+		})),
+		simplejson.WithSearcher(simplejson.SearcherFunc(func(ctx context.Context, target string) ([]string, error) {
+			return []string{"found " + target}, nil
+		})),
+		simplejson.WithTagSearcher(simplejson.TagSearcherFunc{
+			Values: func(ctx context.Context, key string) ([]simplejson.TagValuer, error) {
+				return []simplejson.TagValuer{simplejson.TagStringValue(key + "-value")}, nil
+			},
+		}),
+	)
+
+	for _, tc := range []struct {
+		path, body, expect string
+	}{
+		{"/query", `{"targets":[{"target":"a"}]}`, `[{"target":"a","datapoints":[[1,1000]]}]`},
+		{"/query", `{"targets":[{"target":"a","type":"table"}]}`, `[{"type":"table","columns":[{"text":"col","type":"string"}],"rows":[["a"]]}]`},
+		{"/annotations", `{"annotation":{"name":"deploy","query":"q"}}`, ``},
+		{"/search", `{"target":"x"}`, `["found x"]`},
+		{"/tag-keys", `{}`, `[]`},
+		{"/tag-values", `{"key":"k"}`, `[{"text":"k-value"}]`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d, %s", tc.path, w.Code, w.Body.String())
+		}
+		if tc.expect != "" && w.Body.String() != tc.expect {
+			t.Fatalf("%s:\nexpected: %q\ngot:%s", tc.path, tc.expect, w.Body.String())
+		}
+	}
+}
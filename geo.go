@@ -0,0 +1,164 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A LatitudeColumn holds latitudes, in degrees, for a "number" column
+// used to place points on a Grafana Geomap panel. Naming the column
+// "latitude" allows the panel to find it automatically. Latitudes must be
+// between -90 and 90.
+type LatitudeColumn []float64
+
+func (LatitudeColumn) simpleJSONColumn()         {}
+func (LatitudeColumn) columnType() string        { return "number" }
+func (c LatitudeColumn) length() int             { return len(c) }
+func (c LatitudeColumn) value(i int) interface{} { return c[i] }
+
+func (c LatitudeColumn) validate() error {
+	for _, v := range c {
+		if !(v >= -90 && v <= 90) {
+			return fmt.Errorf("invalid latitude %v", v)
+		}
+	}
+	return nil
+}
+
+// A LongitudeColumn holds longitudes, in degrees, for a "number" column
+// used to place points on a Grafana Geomap panel. Naming the column
+// "longitude" allows the panel to find it automatically. Longitudes must
+// be between -180 and 180.
+type LongitudeColumn []float64
+
+func (LongitudeColumn) simpleJSONColumn()         {}
+func (LongitudeColumn) columnType() string        { return "number" }
+func (c LongitudeColumn) length() int             { return len(c) }
+func (c LongitudeColumn) value(i int) interface{} { return c[i] }
+
+func (c LongitudeColumn) validate() error {
+	for _, v := range c {
+		if !(v >= -180 && v <= 180) {
+			return fmt.Errorf("invalid longitude %v", v)
+		}
+	}
+	return nil
+}
+
+// A GeoHashColumn holds geohashes for a "string" column used to place
+// points on a Grafana Geomap panel. Naming the column "geohash" allows the
+// panel to find it automatically.
+type GeoHashColumn []string
+
+func (GeoHashColumn) simpleJSONColumn()         {}
+func (GeoHashColumn) columnType() string        { return "string" }
+func (c GeoHashColumn) length() int             { return len(c) }
+func (c GeoHashColumn) value(i int) interface{} { return c[i] }
+
+func (c GeoHashColumn) validate() error {
+	for _, v := range c {
+		if _, _, err := DecodeGeoHash(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LatLon converts the geohashes to latitude and longitude columns, for
+// panels, such as the Worldmap panel, that require them.
+func (c GeoHashColumn) LatLon() (LatitudeColumn, LongitudeColumn, error) {
+	lats := make(LatitudeColumn, len(c))
+	lons := make(LongitudeColumn, len(c))
+	for i, v := range c {
+		lat, lon, err := DecodeGeoHash(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		lats[i], lons[i] = lat, lon
+	}
+	return lats, lons, nil
+}
+
+// columnValidator is implemented by column types whose values are
+// restricted.
+type columnValidator interface {
+	validate() error
+}
+
+const geoHashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// DecodeGeoHash returns the latitude and longitude of the centre of the
+// area described by the geohash h.
+func DecodeGeoHash(h string) (lat, lon float64, err error) {
+	if h == "" {
+		return 0, 0, fmt.Errorf("invalid geohash %q", h)
+	}
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	even := true
+	for _, r := range strings.ToLower(h) {
+		idx := strings.IndexRune(geoHashAlphabet, r)
+		if idx < 0 {
+			return 0, 0, fmt.Errorf("invalid geohash %q", h)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			rng := &latRange
+			if even {
+				rng = &lonRange
+			}
+			mid := (rng[0] + rng[1]) / 2
+			if idx&(1<<bit) != 0 {
+				rng[0] = mid
+			} else {
+				rng[1] = mid
+			}
+			even = !even
+		}
+	}
+	return (latRange[0] + latRange[1]) / 2, (lonRange[0] + lonRange[1]) / 2, nil
+}
+
+// EncodeGeoHash returns the geohash, of precision characters, for the
+// area containing lat and lon.
+func EncodeGeoHash(lat, lon float64, precision int) string {
+	lat = math.Max(-90, math.Min(90, lat))
+	lon = math.Max(-180, math.Min(180, lon))
+
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	even := true
+	var sb strings.Builder
+	for sb.Len() < precision {
+		idx := 0
+		for bit := 4; bit >= 0; bit-- {
+			rng, v := &latRange, lat
+			if even {
+				rng, v = &lonRange, lon
+			}
+			mid := (rng[0] + rng[1]) / 2
+			if v >= mid {
+				idx |= 1 << bit
+				rng[0] = mid
+			} else {
+				rng[1] = mid
+			}
+			even = !even
+		}
+		sb.WriteByte(geoHashAlphabet[idx])
+	}
+	return sb.String()
+}
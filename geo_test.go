@@ -0,0 +1,97 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestGeoHash(t *testing.T) {
+	if h := simplejson.EncodeGeoHash(57.64911, 10.40744, 11); h != "u4pruydqqvj" {
+		t.Errorf("expected u4pruydqqvj, got %s", h)
+	}
+
+	lat, lon, err := simplejson.DecodeGeoHash("u4pruydqqvj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(lat-57.64911) > 1e-5 || math.Abs(lon-10.40744) > 1e-5 {
+		t.Errorf("unexpected location %v, %v", lat, lon)
+	}
+
+	for _, h := range []string{"", "u4pa", "u4p!"} {
+		if _, _, err := simplejson.DecodeGeoHash(h); err == nil {
+			t.Errorf("expected an error decoding %q", h)
+		}
+	}
+}
+
+func TestGeoColumns(t *testing.T) {
+	lats, lons, err := simplejson.GeoHashColumn{"u4pruydqqvj", "gcpvj0duq"}.LatLon()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{
+				{Text: "latitude", Data: lats},
+				{Text: "longitude", Data: lons},
+				{Text: "geohash", Data: simplejson.GeoHashColumn{"u4pruydqqvj", "gcpvj0duq"}},
+			}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "t", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	if math.Abs(lats[1]-51.5) > 0.01 || math.Abs(lons[1]+0.127) > 0.01 {
+		t.Errorf("unexpected location %v, %v", lats[1], lons[1])
+	}
+}
+
+func TestGeoColumns_Invalid(t *testing.T) {
+	for name, col := range map[string]simplejson.TableColumnData{
+		"latitude":  simplejson.LatitudeColumn{91},
+		"longitude": simplejson.LongitudeColumn{math.NaN()},
+		"geohash":   simplejson.GeoHashColumn{"abc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gsj := simplejson.New(
+				simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+					return []simplejson.TableColumn{{Text: name, Data: col}}, nil
+				})),
+			)
+
+			req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "t", "type": "table"}]}`))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafanaplugin_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// TestArrowEncoding checks that the handler's hand written Arrow IPC
+// streams are accepted by the Arrow project's own reader. It lives here,
+// rather than in the main module, so that the main module need not
+// depend on Arrow.
+func TestArrowEncoding(t *testing.T) {
+	lat := 12.5
+	gsj := simplejson.New(
+		simplejson.WithArrowEncoding(),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{
+				{Text: "Time", Data: simplejson.TableTimeColumn{time.Unix(1, 0), time.Unix(2, 0)}},
+				{Text: "Host", Data: simplejson.TableStringColumn{"web-1", "web-22"}},
+				{Text: "Latency", Unit: "ms", Data: simplejson.Column[*float64]{&lat, nil}},
+				{Text: "OK", Data: simplejson.TableBoolColumn{false, true}},
+			}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [
+		{"target": "a", "refId": "A", "type": "table"},
+		{"target": "b", "refId": "B", "type": "table"}
+	]}`))
+	req.Header.Set("Accept", simplejson.ArrowContentType)
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+
+	body := bytes.NewReader(w.Body.Bytes())
+	for _, refID := range []string{"A", "B"} {
+		rdr, err := ipc.NewReader(body)
+		if err != nil {
+			t.Fatalf("frame %s: %v", refID, err)
+		}
+
+		schema := rdr.Schema()
+		if v, _ := schema.Metadata().GetValue("refId"); v != refID {
+			t.Errorf("frame %s: unexpected refId %q", refID, v)
+		}
+		wantTypes := []arrow.DataType{
+			&arrow.TimestampType{Unit: arrow.Nanosecond},
+			arrow.BinaryTypes.String,
+			arrow.PrimitiveTypes.Float64,
+			arrow.FixedWidthTypes.Boolean,
+		}
+		if len(schema.Fields()) != len(wantTypes) {
+			t.Fatalf("frame %s: unexpected schema %v", refID, schema)
+		}
+		for i, f := range schema.Fields() {
+			if f.Type.ID() != wantTypes[i].ID() {
+				t.Errorf("frame %s: field %s has type %v, want %v", refID, f.Name, f.Type, wantTypes[i])
+			}
+		}
+
+		if !rdr.Next() {
+			t.Fatalf("frame %s: no record batch: %v", refID, rdr.Err())
+		}
+		rec := rdr.Record()
+		if rec.NumRows() != 2 {
+			t.Fatalf("frame %s: unexpected row count %d", refID, rec.NumRows())
+		}
+		if v := rec.Column(0).(*array.Timestamp).Value(1); v != arrow.Timestamp(time.Unix(2, 0).UnixNano()) {
+			t.Errorf("frame %s: unexpected time %v", refID, v)
+		}
+		if v := rec.Column(1).(*array.String).Value(1); v != "web-22" {
+			t.Errorf("frame %s: unexpected host %q", refID, v)
+		}
+		latency := rec.Column(2).(*array.Float64)
+		if latency.Value(0) != lat || !latency.IsNull(1) {
+			t.Errorf("frame %s: unexpected latency column %v", refID, latency)
+		}
+		if ok := rec.Column(3).(*array.Boolean); ok.Value(0) || !ok.Value(1) {
+			t.Errorf("frame %s: unexpected ok column %v", refID, ok)
+		}
+
+		if rdr.Next() {
+			t.Errorf("frame %s: unexpected second record batch", refID)
+		}
+		if err := rdr.Err(); err != nil {
+			t.Errorf("frame %s: %v", refID, err)
+		}
+		rdr.Release()
+	}
+	if body.Len() != 0 {
+		t.Errorf("%d bytes left after the last frame", body.Len())
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grafanaplugin adapts a simplejson datasource to the interfaces
+// of a Grafana backend plugin, as defined by grafana-plugin-sdk-go. This
+// allows the same implementation to be served either over HTTP, for the
+// SimpleJSON or JSON datasource plugins, or shipped as a native backend
+// plugin.
+//
+// The package is a separate module, so that users of the HTTP handler do
+// not depend upon the plugin SDK.
+package grafanaplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// Datasource implements backend.QueryDataHandler, backend.CheckHealthHandler
+// and backend.CallResourceHandler for a simplejson datasource.
+//
+// Queries are described by a JSON model with the same fields as a
+// SimpleJSON target, e.g. {"target": "cpu", "type": "table", "payload":
+// {...}}. Targets with the type "timeserie" (the default) are passed to a
+// SeriesQuerier, IterQuerier, RequestQuerier or Querier, "table" targets
+// to a TableRequestQuerier or TableQuerier, and queries with the query
+// type "annotations" to an AnnotationQuerier or Annotator. Searches are
+// made by a POST to the "search" resource, with a body of {"target":
+// "..."}, and are passed to a ResultSearcher or Searcher.
+//
+// Other target types, such as heatmaps and logs, and the TableIterQuerier
+// interface, are only supported by the HTTP handler. Grafana does not
+// pass the raw time range, or scoped variables, to backend plugins, so
+// the RawRange and ScopedVars of queries are always empty.
+type Datasource struct {
+	query        simplejson.RequestQuerier
+	series       simplejson.SeriesQuerier
+	table        simplejson.TableRequestQuerier
+	annotations  simplejson.AnnotationQuerier
+	search       simplejson.Searcher
+	resultSearch simplejson.ResultSearcher
+	health       simplejson.HealthChecker
+}
+
+// New returns a Datasource that uses src as a SeriesQuerier (or
+// IterQuerier, or RequestQuerier, or Querier), TableRequestQuerier (or
+// TableQuerier), AnnotationQuerier (or Annotator), ResultSearcher (or
+// Searcher) and HealthChecker, if it supports the required interface.
+// Where src supports several of the alternatives, the same one is used
+// as by simplejson.WithSource.
+func New(src interface{}) *Datasource {
+	ds := &Datasource{}
+	if q, ok := src.(simplejson.Querier); ok {
+		ds.query = simplejson.RequestQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+			return q.GrafanaQuery(ctx, req.Target, req.QueryArguments)
+		})
+	}
+	if q, ok := src.(simplejson.RequestQuerier); ok {
+		ds.query = q
+	}
+	if q, ok := src.(simplejson.IterQuerier); ok {
+		ds.query = simplejson.RequestQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+			var points []simplejson.DataPoint
+			for dp, err := range q.GrafanaQueryIter(ctx, req) {
+				if err != nil {
+					return nil, err
+				}
+				points = append(points, dp)
+			}
+			return points, nil
+		})
+	}
+	if q, ok := src.(simplejson.SeriesQuerier); ok {
+		ds.query = nil
+		ds.series = q
+	}
+	if q, ok := src.(simplejson.TableQuerier); ok {
+		ds.table = simplejson.TableRequestQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+			return q.GrafanaQueryTable(ctx, req.Target, simplejson.TableQueryArguments{
+				QueryCommonArguments: req.QueryCommonArguments,
+				RefID:                req.RefID,
+				Hidden:               req.Hidden,
+				RawRange:             req.RawRange,
+				ScopedVars:           req.ScopedVars,
+				Payload:              req.Payload,
+			})
+		})
+	}
+	if q, ok := src.(simplejson.TableRequestQuerier); ok {
+		ds.table = q
+	}
+	if a, ok := src.(simplejson.Annotator); ok {
+		ds.annotations = simplejson.AnnotationQuerierFunc(func(ctx context.Context, q simplejson.AnnotationQuery) ([]simplejson.Annotation, error) {
+			return a.GrafanaAnnotations(ctx, q.Query, q.AnnotationsArguments)
+		})
+	}
+	if a, ok := src.(simplejson.AnnotationQuerier); ok {
+		ds.annotations = a
+	}
+	if s, ok := src.(simplejson.Searcher); ok {
+		ds.search = s
+	}
+	if s, ok := src.(simplejson.ResultSearcher); ok {
+		ds.search = nil
+		ds.resultSearch = s
+	}
+	if hc, ok := src.(simplejson.HealthChecker); ok {
+		ds.health = hc
+	}
+	return ds
+}
+
+// queryModel is the JSON model of a query.
+type queryModel struct {
+	Target   string          `json:"target"`
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload"`
+	Hide     bool            `json:"hide"`
+	Tags     []string        `json:"tags"`
+	MatchAny bool            `json:"matchAny"`
+	Limit    int             `json:"limit"`
+}
+
+// QueryData implements backend.QueryDataHandler.
+func (ds *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	resp := backend.NewQueryDataResponse()
+	for _, q := range req.Queries {
+		frames, err := ds.query1(ctx, q)
+		if err != nil {
+			resp.Responses[q.RefID] = backend.DataResponse{Error: err}
+			continue
+		}
+		for _, f := range frames {
+			f.RefID = q.RefID
+		}
+		resp.Responses[q.RefID] = backend.DataResponse{Frames: frames}
+	}
+	return resp, nil
+}
+
+func (ds *Datasource) query1(ctx context.Context, q backend.DataQuery) (data.Frames, error) {
+	var m queryModel
+	if len(q.JSON) > 0 {
+		if err := json.Unmarshal(q.JSON, &m); err != nil {
+			return nil, fmt.Errorf("invalid query: %w", err)
+		}
+	}
+
+	common := simplejson.QueryCommonArguments{From: q.TimeRange.From, To: q.TimeRange.To}
+	if q.QueryType == "annotations" {
+		if ds.annotations == nil {
+			return nil, fmt.Errorf("annotations not implemented")
+		}
+		anns, err := ds.annotations.GrafanaAnnotationQuery(ctx, simplejson.AnnotationQuery{
+			AnnotationsArguments: simplejson.AnnotationsArguments{QueryCommonArguments: common},
+			Query:                m.Target,
+			Tags:                 m.Tags,
+			MatchAny:             m.MatchAny,
+			Limit:                m.Limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return data.Frames{annotationsFrame(anns)}, nil
+	}
+
+	req := simplejson.QueryRequest{
+		QueryArguments: simplejson.QueryArguments{
+			QueryCommonArguments: common,
+			Interval:             q.Interval,
+			MaxDPs:               int(q.MaxDataPoints),
+		},
+		Target:    m.Target,
+		RefID:     q.RefID,
+		QueryType: q.QueryType,
+		Hidden:    m.Hide,
+		Payload:   m.Payload,
+	}
+
+	switch m.Type {
+	case "", "timeserie":
+		if ds.series != nil {
+			ss, err := ds.series.GrafanaQuerySeries(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			frames := make(data.Frames, len(ss))
+			for i, s := range ss {
+				frames[i] = seriesFrame(s.Name, s.Points())
+				frames[i].Fields[1].Labels = data.Labels(s.Labels)
+			}
+			return frames, nil
+		}
+		if ds.query == nil {
+			return nil, fmt.Errorf("timeserie query not implemented")
+		}
+		points, err := ds.query.GrafanaQueryRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return data.Frames{seriesFrame(m.Target, points)}, nil
+	case "table":
+		if ds.table == nil {
+			return nil, fmt.Errorf("table query not implemented")
+		}
+		cols, err := ds.table.GrafanaQueryTableRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		f, err := tableFrame(m.Target, cols)
+		if err != nil {
+			return nil, err
+		}
+		return data.Frames{f}, nil
+	default:
+		return nil, fmt.Errorf("unknown query type %q, should be timeserie or table", m.Type)
+	}
+}
+
+// seriesFrame converts a timeserie to a data frame.
+func seriesFrame(name string, points []simplejson.DataPoint) *data.Frame {
+	// Points are sorted by time, as they are by the HTTP handler.
+	points = slices.Clone(points)
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	times := make([]time.Time, len(points))
+	values := make([]*float64, len(points))
+	for i, dp := range points {
+		times[i] = dp.Time
+		if !dp.Null {
+			v := dp.Value
+			values[i] = &v
+		}
+	}
+	return data.NewFrame(name,
+		data.NewField("time", nil, times),
+		data.NewField(name, nil, values),
+	)
+}
+
+// tableFrame converts table columns to a data frame. Since the column
+// types of the simplejson package are opaque, the values are read by
+// reflection.
+func tableFrame(name string, cols []simplejson.TableColumn) (*data.Frame, error) {
+	f := data.NewFrame(name)
+	for _, col := range cols {
+		field, err := columnField(col)
+		if err != nil {
+			return nil, err
+		}
+		f.Fields = append(f.Fields, field)
+	}
+	return f, nil
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// nullableTypes are the types of nullable columns, whose pointer
+	// element types are also the types of nullable SDK fields.
+	nullableTypes = map[reflect.Type]bool{
+		reflect.TypeOf(float64(0)): true,
+		timeType:                   true,
+		reflect.TypeOf(""):         true,
+		reflect.TypeOf(false):      true,
+	}
+)
+
+func columnField(col simplejson.TableColumn) (*data.Field, error) {
+	v := reflect.ValueOf(col.Data)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("invalid column type %T", col.Data)
+	}
+	n := v.Len()
+
+	var values interface{}
+	switch et := v.Type().Elem(); {
+	case et == timeType:
+		ts := make([]time.Time, n)
+		for i := range ts {
+			ts[i] = v.Index(i).Interface().(time.Time)
+		}
+		values = ts
+	case et.Kind() == reflect.Pointer && nullableTypes[et.Elem()]:
+		ps := reflect.MakeSlice(reflect.SliceOf(et), n, n)
+		reflect.Copy(ps, v)
+		values = ps.Interface()
+	case et == durationType:
+		ms := make([]float64, n)
+		for i := range ms {
+			ms[i] = float64(v.Index(i).Int()) / float64(time.Millisecond)
+		}
+		values = ms
+	case et.Kind() == reflect.Float64:
+		fs := make([]float64, n)
+		for i := range fs {
+			fs[i] = v.Index(i).Float()
+		}
+		values = fs
+	case et.Kind() == reflect.String:
+		ss := make([]string, n)
+		for i := range ss {
+			ss[i] = v.Index(i).String()
+		}
+		values = ss
+	case et.Kind() == reflect.Bool:
+		bs := make([]bool, n)
+		for i := range bs {
+			bs[i] = v.Index(i).Bool()
+		}
+		values = bs
+	default:
+		raws := make([]json.RawMessage, n)
+		for i := range raws {
+			bs, err := json.Marshal(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			raws[i] = bs
+		}
+		values = raws
+	}
+
+	field := data.NewField(col.Text, nil, values)
+	unit := col.Unit
+	if _, ok := col.Data.(simplejson.TableDurationColumn); ok && unit == "" {
+		unit = "ms"
+	}
+	if unit != "" || col.Config != nil {
+		cfg := &data.FieldConfig{Unit: unit}
+		if c := col.Config; c != nil {
+			if c.Unit != "" {
+				cfg.Unit = c.Unit
+			}
+			if c.Decimals != nil && *c.Decimals >= 0 {
+				d := uint16(*c.Decimals)
+				cfg.Decimals = &d
+			}
+			cfg.DisplayName = c.DisplayName
+			for _, l := range c.Links {
+				cfg.Links = append(cfg.Links, data.DataLink{Title: l.Title, URL: l.URL, TargetBlank: l.TargetBlank})
+			}
+		}
+		field.Config = cfg
+	}
+	return field, nil
+}
+
+// annotationsFrame converts annotations to a data frame.
+func annotationsFrame(anns []simplejson.Annotation) *data.Frame {
+	var (
+		times    = make([]time.Time, len(anns))
+		timeEnds = make([]*time.Time, len(anns))
+		titles   = make([]string, len(anns))
+		texts    = make([]string, len(anns))
+		tags     = make([]string, len(anns))
+	)
+	for i, a := range anns {
+		times[i] = a.Time
+		if !a.TimeEnd.IsZero() {
+			end := a.TimeEnd
+			timeEnds[i] = &end
+		}
+		titles[i] = a.Title
+		texts[i] = a.Text
+		tags[i] = strings.Join(a.Tags, ",")
+	}
+	return data.NewFrame("annotations",
+		data.NewField("time", nil, times),
+		data.NewField("timeEnd", nil, timeEnds),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+}
+
+// CheckHealth implements backend.CheckHealthHandler, using the
+// HealthChecker of the source if it has one.
+func (ds *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	if ds.health == nil {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusOk,
+			Message: "Data source is working",
+		}, nil
+	}
+
+	st, err := ds.health.GrafanaHealthCheck(ctx)
+	if err != nil {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	msg := st.Message
+	if msg == "" {
+		msg = "Data source is working"
+	}
+	details, err := json.Marshal(map[string]interface{}{"version": st.Version, "details": st.Details})
+	if err != nil {
+		return nil, err
+	}
+	return &backend.CheckHealthResult{
+		Status:      backend.HealthStatusOk,
+		Message:     msg,
+		JSONDetails: details,
+	}, nil
+}
+
+// CallResource implements backend.CallResourceHandler, answering searches
+// made to the "search" resource.
+func (ds *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	send := func(status int, v interface{}) error {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return sender.Send(&backend.CallResourceResponse{
+			Status:  status,
+			Headers: map[string][]string{"Content-Type": {"application/json"}},
+			Body:    bs,
+		})
+	}
+	type message struct {
+		Message string `json:"message"`
+	}
+
+	if req.Path != "search" || (ds.search == nil && ds.resultSearch == nil) {
+		return send(http.StatusNotFound, message{"not found"})
+	}
+
+	var sreq struct {
+		Target string `json:"target"`
+	}
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal(req.Body, &sreq); err != nil {
+			return send(http.StatusBadRequest, message{err.Error()})
+		}
+	}
+	if ds.resultSearch != nil {
+		res, err := ds.resultSearch.GrafanaSearchResults(ctx, sreq.Target)
+		if err != nil {
+			return send(http.StatusInternalServerError, message{err.Error()})
+		}
+		if res == nil {
+			res = []simplejson.SearchResult{}
+		}
+		return send(http.StatusOK, res)
+	}
+	res, err := ds.search.GrafanaSearch(ctx, sreq.Target)
+	if err != nil {
+		return send(http.StatusInternalServerError, message{err.Error()})
+	}
+	if res == nil {
+		res = []string{}
+	}
+	return send(http.StatusOK, res)
+}
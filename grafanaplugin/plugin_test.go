@@ -0,0 +1,364 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafanaplugin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/grafanaplugin"
+)
+
+type testSource struct {
+	healthErr error
+}
+
+func (testSource) GrafanaQueryRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+	if req.Target == "fail" {
+		return nil, errors.New("query failed")
+	}
+	// The points are out of order, the frame should be sorted by time.
+	return []simplejson.DataPoint{
+		{Time: req.To, Null: true},
+		{Time: req.From, Value: 1},
+	}, nil
+}
+
+func (testSource) GrafanaQueryTableRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+	return []simplejson.TableColumn{
+		{Text: "host", Data: simplejson.TableStringColumn{"a", "b"}},
+		{Text: "load", Data: simplejson.TableNumberColumn{0.5, 1.5}, Unit: "percent"},
+	}, nil
+}
+
+func (testSource) GrafanaAnnotationQuery(ctx context.Context, q simplejson.AnnotationQuery) ([]simplejson.Annotation, error) {
+	return []simplejson.Annotation{
+		{Time: q.From, Title: q.Query, Tags: q.Tags},
+	}, nil
+}
+
+func (testSource) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return []string{target + "_1", target + "_2"}, nil
+}
+
+func (ts testSource) GrafanaHealthCheck(ctx context.Context) (simplejson.HealthStatus, error) {
+	return simplejson.HealthStatus{Message: "all good", Version: "1.2.3"}, ts.healthErr
+}
+
+var (
+	testFrom = time.Unix(1000, 0).UTC()
+	testTo   = time.Unix(2000, 0).UTC()
+)
+
+func testQuery(refID, queryType, model string) backend.DataQuery {
+	return backend.DataQuery{
+		RefID:         refID,
+		QueryType:     queryType,
+		JSON:          json.RawMessage(model),
+		TimeRange:     backend.TimeRange{From: testFrom, To: testTo},
+		Interval:      time.Minute,
+		MaxDataPoints: 100,
+	}
+}
+
+func TestQueryData(t *testing.T) {
+	ds := grafanaplugin.New(testSource{})
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			testQuery("A", "", `{"target":"cpu"}`),
+			testQuery("B", "", `{"target":"hosts","type":"table"}`),
+			testQuery("C", "annotations", `{"target":"deploys","tags":["prod"]}`),
+			testQuery("D", "", `{"target":"fail"}`),
+			testQuery("E", "", `{"target":"x","type":"heatmap"}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ts := resp.Responses["A"]
+	if ts.Error != nil || len(ts.Frames) != 1 {
+		t.Fatalf("timeserie: unexpected response: %+v", ts)
+	}
+	f := ts.Frames[0]
+	if f.RefID != "A" || len(f.Fields) != 2 || f.Fields[1].Name != "cpu" || f.Rows() != 2 {
+		t.Fatalf("timeserie: unexpected frame: %+v", f)
+	}
+	if v := f.Fields[1].At(0).(*float64); v == nil || *v != 1 {
+		t.Errorf("timeserie: expected first value 1, got %v", v)
+	}
+	if v := f.Fields[1].At(1).(*float64); v != nil {
+		t.Errorf("timeserie: expected null second value, got %v", *v)
+	}
+	if tm := f.Fields[0].At(0).(time.Time); !tm.Equal(testFrom) {
+		t.Errorf("timeserie: expected time %v, got %v", testFrom, tm)
+	}
+
+	table := resp.Responses["B"]
+	if table.Error != nil || len(table.Frames) != 1 {
+		t.Fatalf("table: unexpected response: %+v", table)
+	}
+	f = table.Frames[0]
+	if len(f.Fields) != 2 || f.Rows() != 2 || f.Fields[0].At(1).(string) != "b" || f.Fields[1].At(1).(float64) != 1.5 {
+		t.Fatalf("table: unexpected frame: %+v", f)
+	}
+	if f.Fields[1].Config == nil || f.Fields[1].Config.Unit != "percent" {
+		t.Errorf("table: expected unit percent, got %+v", f.Fields[1].Config)
+	}
+
+	anns := resp.Responses["C"]
+	if anns.Error != nil || len(anns.Frames) != 1 {
+		t.Fatalf("annotations: unexpected response: %+v", anns)
+	}
+	f = anns.Frames[0]
+	if f.Rows() != 1 {
+		t.Fatalf("annotations: expected 1 row, got %d", f.Rows())
+	}
+	byName := map[string]interface{}{}
+	for _, field := range f.Fields {
+		byName[field.Name] = field.At(0)
+	}
+	if byName["title"] != "deploys" || byName["tags"] != "prod" || !byName["time"].(time.Time).Equal(testFrom) {
+		t.Errorf("annotations: unexpected row: %v", byName)
+	}
+
+	if err := resp.Responses["D"].Error; err == nil || err.Error() != "query failed" {
+		t.Errorf("expected query error, got %v", err)
+	}
+	if err := resp.Responses["E"].Error; err == nil {
+		t.Errorf("expected an error for an unknown query type")
+	}
+}
+
+type nullableTableSource struct{}
+
+func (nullableTableSource) GrafanaQueryTableRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+	f, s, b, tm := 1.5, "a", true, testFrom
+	return []simplejson.TableColumn{
+		{Text: "float", Data: simplejson.Column[*float64]{&f, nil}},
+		{Text: "string", Data: simplejson.Column[*string]{&s, nil}},
+		{Text: "bool", Data: simplejson.Column[*bool]{&b, nil}},
+		{Text: "time", Data: simplejson.Column[*time.Time]{&tm, nil}},
+	}, nil
+}
+
+func TestQueryData_NullableColumns(t *testing.T) {
+	ds := grafanaplugin.New(nullableTableSource{})
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{testQuery("A", "", `{"target":"t","type":"table"}`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil || len(res.Frames) != 1 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	expect := []data.FieldType{data.FieldTypeNullableFloat64, data.FieldTypeNullableString, data.FieldTypeNullableBool, data.FieldTypeNullableTime}
+	for i, field := range res.Frames[0].Fields {
+		if field.Type() != expect[i] {
+			t.Errorf("%s: expected field type %v, got %v", field.Name, expect[i], field.Type())
+		}
+		if reflect.ValueOf(field.At(0)).IsNil() || !reflect.ValueOf(field.At(1)).IsNil() {
+			t.Errorf("%s: unexpected values %v, %v", field.Name, field.At(0), field.At(1))
+		}
+	}
+	if v := res.Frames[0].Fields[0].At(0).(*float64); *v != 1.5 {
+		t.Errorf("expected 1.5, got %v", *v)
+	}
+}
+
+type decimalsTableSource struct{}
+
+func (decimalsTableSource) GrafanaQueryTableRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+	two, negative := 2, -1
+	return []simplejson.TableColumn{
+		{Text: "a", Data: simplejson.TableNumberColumn{1}, Config: &simplejson.FieldConfig{Decimals: &two}},
+		{Text: "b", Data: simplejson.TableNumberColumn{1}, Config: &simplejson.FieldConfig{Decimals: &negative}},
+	}, nil
+}
+
+func TestQueryData_Decimals(t *testing.T) {
+	ds := grafanaplugin.New(decimalsTableSource{})
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{testQuery("A", "", `{"target":"t","type":"table"}`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil || len(res.Frames) != 1 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	fields := res.Frames[0].Fields
+	if d := fields[0].Config.Decimals; d == nil || *d != 2 {
+		t.Errorf("expected 2 decimals, got %v", d)
+	}
+	if d := fields[1].Config.Decimals; d != nil {
+		t.Errorf("expected negative decimals to be ignored, got %v", *d)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	res, err := grafanaplugin.New(testSource{}).CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != backend.HealthStatusOk || res.Message != "all good" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	var details struct{ Version string }
+	if err := json.Unmarshal(res.JSONDetails, &details); err != nil || details.Version != "1.2.3" {
+		t.Errorf("unexpected details %s, %v", res.JSONDetails, err)
+	}
+
+	res, err = grafanaplugin.New(testSource{healthErr: errors.New("backend down")}).CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != backend.HealthStatusError || res.Message != "backend down" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func callResource(t *testing.T, ds *grafanaplugin.Datasource, path, body string) *backend.CallResourceResponse {
+	t.Helper()
+	var resp *backend.CallResourceResponse
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{Path: path, Method: http.MethodPost, Body: []byte(body)},
+		backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+			resp = r
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp == nil {
+		t.Fatalf("no response sent")
+	}
+	return resp
+}
+
+func TestCallResource_Search(t *testing.T) {
+	ds := grafanaplugin.New(testSource{})
+
+	resp := callResource(t, ds, "search", `{"target":"cpu"}`)
+	if resp.Status != http.StatusOK || string(resp.Body) != `["cpu_1","cpu_2"]` {
+		t.Errorf("unexpected response %d: %s", resp.Status, resp.Body)
+	}
+
+	resp = callResource(t, ds, "search", `{`)
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid body, got %d", resp.Status)
+	}
+
+	resp = callResource(t, ds, "other", `{}`)
+	if resp.Status != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown resource, got %d", resp.Status)
+	}
+}
+
+type altSource struct {
+	hidden chan bool
+}
+
+func (altSource) GrafanaQueryIter(ctx context.Context, req simplejson.QueryRequest) iter.Seq2[simplejson.DataPoint, error] {
+	return func(yield func(simplejson.DataPoint, error) bool) {
+		yield(simplejson.DataPoint{Time: req.From, Value: 3}, nil)
+	}
+}
+
+func (altSource) GrafanaQueryTable(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+	return []simplejson.TableColumn{{Text: "hidden", Data: simplejson.TableBoolColumn{args.Hidden}}}, nil
+}
+
+func (altSource) GrafanaSearchResults(ctx context.Context, target string) ([]simplejson.SearchResult, error) {
+	return []simplejson.SearchResult{{Text: "CPU", Value: 1}}, nil
+}
+
+func (altSource) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return []string{"unused"}, nil
+}
+
+func TestQueryData_Alternatives(t *testing.T) {
+	ds := grafanaplugin.New(altSource{})
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			testQuery("A", "", `{"target":"cpu"}`),
+			testQuery("B", "", `{"target":"t","type":"table","hide":true}`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ts := resp.Responses["A"]
+	if ts.Error != nil || len(ts.Frames) != 1 || ts.Frames[0].Rows() != 1 {
+		t.Fatalf("iter: unexpected response: %+v", ts)
+	}
+	if v := ts.Frames[0].Fields[1].At(0).(*float64); *v != 3 {
+		t.Errorf("iter: expected 3, got %v", *v)
+	}
+
+	table := resp.Responses["B"]
+	if table.Error != nil || len(table.Frames) != 1 || table.Frames[0].Fields[0].At(0) != true {
+		t.Fatalf("table: expected the hidden flag to be passed to the querier: %+v", table)
+	}
+
+	res := callResource(t, ds, "search", `{"target":"c"}`)
+	if res.Status != http.StatusOK || string(res.Body) != `[{"text":"CPU","value":1}]` {
+		t.Errorf("search: unexpected response %d: %s", res.Status, res.Body)
+	}
+}
+
+func TestQueryData_SeriesQuerier(t *testing.T) {
+	ds := grafanaplugin.New(simplejson.SeriesQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]*simplejson.Series, error) {
+		a := simplejson.NewSeries("a").Add(req.From, 1)
+		a.Labels = map[string]string{"host": "a"}
+		b := simplejson.NewSeries("b").Add(req.From, 2).Add(req.To, 3)
+		return []*simplejson.Series{a, b}, nil
+	}))
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{testQuery("A", "", `{"target":"cpu"}`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := resp.Responses["A"]
+	if res.Error != nil || len(res.Frames) != 2 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	if f := res.Frames[0]; f.Name != "a" || f.RefID != "A" || f.Fields[1].Labels["host"] != "a" {
+		t.Errorf("unexpected first frame: %+v", f)
+	}
+	if f := res.Frames[1]; f.Name != "b" || f.Rows() != 2 {
+		t.Errorf("unexpected second frame: %+v", f)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// HealthStatus describes a healthy datasource. Message is shown to the
+// user when they test the datasource in Grafana. Version may give the
+// version of the backing store, and Details any further information.
+type HealthStatus struct {
+	Message string
+	Version string
+	Details map[string]string
+}
+
+// A HealthChecker reports whether the datasource can reach its backing
+// store. GrafanaHealthCheck is called when Grafana tests the connection
+// to the datasource, by requesting /. If it returns an error the request
+// fails with a 503 Service Unavailable, or the Status of the error if it
+// is an Error, and the error is shown to the user.
+type HealthChecker interface {
+	GrafanaHealthCheck(ctx context.Context) (HealthStatus, error)
+}
+
+// WithHealthChecker adds a health check, used to answer requests to /.
+// Without a health check, requests to / always succeed.
+func WithHealthChecker(hc HealthChecker) Opt {
+	return func(sjc *Handler) error {
+		sjc.health = hc
+		return nil
+	}
+}
+
+// simpleJSONHealth is the response to a health check.
+type simpleJSONHealth struct {
+	Status    string            `json:"status"`
+	Message   string            `json:"message,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	LatencyMS float64           `json:"latencyMs"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// handleHealth runs the health check, reporting the outcome and how long
+// it took.
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), h.healthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	st, err := callWithDeadline(ctx, h.health.GrafanaHealthCheck)
+	latency := float64(time.Since(start)) / float64(time.Millisecond)
+
+	resp := simpleJSONHealth{Status: "ok", LatencyMS: latency}
+	status := http.StatusOK
+	if err != nil {
+		resp.Status = "error"
+		resp.Message = err.Error()
+		status = errorStatus(err, http.StatusServiceUnavailable)
+	} else {
+		resp.Message = st.Message
+		resp.Version = st.Version
+		resp.Details = st.Details
+	}
+
+	// We igore the error here because the response should always be
+	// marshable.
+	bs, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bs)
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithHealthChecker(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithHealthChecker(simplejson.HealthCheckerFunc(func(ctx context.Context) (simplejson.HealthStatus, error) {
+		return simplejson.HealthStatus{
+			Message: "Connected to db",
+			Version: "1.2.3",
+			Details: map[string]string{"replicas": "3"},
+		}, nil
+	})))
+
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "ok" || resp["message"] != "Connected to db" || resp["version"] != "1.2.3" {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+	if _, ok := resp["latencyMs"].(float64); !ok {
+		t.Errorf("expected a latency, got %s", w.Body.String())
+	}
+}
+
+func TestWithHealthChecker_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"unavailable", errors.New("connection refused"), http.StatusServiceUnavailable},
+		{"status", simplejson.Error{Status: http.StatusBadGateway, Message: "bad upstream"}, http.StatusBadGateway},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gsj := simplejson.New(simplejson.WithHealthChecker(simplejson.HealthCheckerFunc(func(ctx context.Context) (simplejson.HealthStatus, error) {
+				return simplejson.HealthStatus{}, tc.err
+			})))
+
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+			var resp map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["status"] != "error" || resp["message"] != tc.err.Error() {
+				t.Errorf("unexpected response %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithHealthCheckTimeout(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithHealthCheckTimeout(10*time.Millisecond),
+		simplejson.WithHealthChecker(simplejson.HealthCheckerFunc(func(ctx context.Context) (simplejson.HealthStatus, error) {
+			<-ctx.Done()
+			return simplejson.HealthStatus{}, ctx.Err()
+		})),
+	)
+
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// A HeatmapBucket holds the counts of values falling into a single bucket
+// of a histogram over time. UpperBound is the upper bound of the bucket,
+// which may be +Inf.
+type HeatmapBucket struct {
+	UpperBound float64
+	Points     []DataPoint
+}
+
+// A HeatmapQuerier responds to queries from Grafana heatmap panels, for
+// targets with the type "heatmap". Each bucket is sent to Grafana as a
+// separate timeserie, named by its upper bound, which the heatmap panel
+// uses when its data format is set to "Time series buckets". Counts should
+// not be cumulative, see DecumulateBuckets.
+type HeatmapQuerier interface {
+	GrafanaQueryHeatmap(ctx context.Context, req QueryRequest) ([]HeatmapBucket, error)
+}
+
+// WithHeatmapQuerier adds a heatmap query handler.
+func WithHeatmapQuerier(q HeatmapQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.heatmapQuery = q
+		return nil
+	}
+}
+
+// DecumulateBuckets converts buckets with cumulative counts, such as
+// Prometheus histograms, where each bucket counts all values less than or
+// equal to its upper bound, into buckets holding the counts for their own
+// range alone. Points in each bucket are matched by time. The buckets are
+// returned sorted by upper bound, and buckets is not modified.
+func DecumulateBuckets(buckets []HeatmapBucket) []HeatmapBucket {
+	sorted := slices.Clone(buckets)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].UpperBound < sorted[j].UpperBound })
+
+	out := make([]HeatmapBucket, len(sorted))
+	prev := map[int64]float64{}
+	for i, b := range sorted {
+		cur := make(map[int64]float64, len(b.Points))
+		points := make([]DataPoint, len(b.Points))
+		for j, dp := range b.Points {
+			points[j] = dp
+			if dp.Null {
+				continue
+			}
+			t := dp.Time.UnixNano()
+			cur[t] = dp.Value
+			points[j].Value = dp.Value - prev[t]
+		}
+		out[i] = HeatmapBucket{UpperBound: b.UpperBound, Points: points}
+		prev = cur
+	}
+	return out
+}
+
+// heatmapBucketName returns the name of the series for a bucket with the
+// given upper bound.
+func heatmapBucketName(ub float64) string {
+	if math.IsInf(ub, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(ub, 'g', -1, 64)
+}
+
+func (h *Handler) jsonHeatmapQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	keyReq := qr
+	keyReq.RefID = ""
+	resp, err := dedupe(h, ctx, "heatmap", keyReq, func(ctx context.Context) ([]HeatmapBucket, error) {
+		resp, err := h.heatmapQuery.GrafanaQueryHeatmap(ctx, qr)
+		if err != nil {
+			return nil, err
+		}
+		resp = slices.Clone(resp)
+		sort.SliceStable(resp, func(i, j int) bool { return resp[i].UpperBound < resp[j].UpperBound })
+		for _, b := range resp {
+			sort.Slice(b.Points, func(i, j int) bool { return b.Points[i].Time.Before(b.Points[j].Time) })
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(simpleJSONResults, len(resp))
+	for i, b := range resp {
+		out[i] = simpleJSONData{
+			Target:     heatmapBucketName(b.UpperBound),
+			RefID:      target.RefID,
+			DataPoints: b.Points,
+			nonFinite:  h.nonFinite,
+			timeUnit:   h.timeUnit,
+		}
+	}
+	return out, nil
+}
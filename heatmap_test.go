@@ -0,0 +1,92 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithHeatmapQuerier(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithHeatmapQuerier(simplejson.HeatmapQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.HeatmapBucket, error) {
+			return []simplejson.HeatmapBucket{
+				{UpperBound: math.Inf(1), Points: []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}}},
+				{UpperBound: 0.5, Points: []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 3}}},
+				{UpperBound: 0.25, Points: []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 2}}},
+			}, nil
+		})),
+		simplejson.WithQuerier(seriesQuerier{{Time: time.Unix(1, 0), Value: 7}}),
+	)
+
+	q := `{"targets": [{"target": "latency", "refId": "A", "type": "heatmap"}, {"target": "other", "refId": "B"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"0.25","refId":"A","datapoints":[[2,1000]]},` +
+		`{"target":"0.5","refId":"A","datapoints":[[3,1000]]},` +
+		`{"target":"+Inf","refId":"A","datapoints":[[1,1000]]},` +
+		`{"target":"other","refId":"B","datapoints":[[7,1000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithHeatmapQuerier_Empty(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithHeatmapQuerier(simplejson.HeatmapQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.HeatmapBucket, error) {
+			return nil, nil
+		})),
+	)
+
+	q := `{"targets": [{"target": "latency", "type": "heatmap"}, {"target": "latency", "type": "heatmap"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Body.String() != `[]` {
+		t.Fatalf("expected an empty response, got %s", w.Body.String())
+	}
+}
+
+func TestDecumulateBuckets(t *testing.T) {
+	t1, t2 := time.Unix(1, 0), time.Unix(2, 0)
+	in := []simplejson.HeatmapBucket{
+		{UpperBound: math.Inf(1), Points: []simplejson.DataPoint{{Time: t1, Value: 10}, {Time: t2, Value: 4}}},
+		{UpperBound: 1, Points: []simplejson.DataPoint{{Time: t1, Value: 3}, {Time: t2, Value: 4}}},
+		{UpperBound: 2, Points: []simplejson.DataPoint{{Time: t1, Value: 7}, {Time: t2, Value: 4}}},
+	}
+
+	got := simplejson.DecumulateBuckets(in)
+	expect := []simplejson.HeatmapBucket{
+		{UpperBound: 1, Points: []simplejson.DataPoint{{Time: t1, Value: 3}, {Time: t2, Value: 4}}},
+		{UpperBound: 2, Points: []simplejson.DataPoint{{Time: t1, Value: 4}, {Time: t2, Value: 0}}},
+		{UpperBound: math.Inf(1), Points: []simplejson.DataPoint{{Time: t1, Value: 3}, {Time: t2, Value: 0}}},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("\nexpected: %v\ngot:      %v", expect, got)
+	}
+	if in[0].Points[0].Value != 10 {
+		t.Fatalf("input was modified")
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Hooks are called as a handler serves requests, allowing operators to
+// implement audit logging, SLO tracking or alerting without wrapping the
+// handler. Any of the hooks may be nil. Hooks are called synchronously, and
+// OnQueryDone may be called concurrently for the targets of a single query,
+// so hooks should be fast and safe for concurrent use.
+type Hooks struct {
+	// OnRequest is called when a request is received, before it is
+	// handled.
+	OnRequest func(ctx context.Context, ri RequestInfo)
+	// OnRequestDone is called once the response to a request has been
+	// sent, with the status, size and duration of the response set.
+	OnRequestDone func(ctx context.Context, ri RequestInfo)
+	// OnQueryDone is called as each target of a query is completed.
+	OnQueryDone func(ctx context.Context, qi QueryInfo)
+	// OnError is called, before OnRequestDone, for requests that fail,
+	// with the error that was reported to Grafana.
+	OnError func(ctx context.Context, ri RequestInfo)
+}
+
+// RequestInfo describes a request passed to Hooks.
+type RequestInfo struct {
+	// Endpoint is the endpoint the request was sent to, as reported in
+	// metrics.
+	Endpoint string
+	Request  *http.Request
+
+	// The following are only set once the request has completed.
+	Status   int
+	Size     int // bytes of the response body, before compression
+	Duration time.Duration
+	Err      error
+}
+
+// QueryInfo describes the outcome of a single query target, passed to
+// Hooks.
+type QueryInfo struct {
+	// Query is the target as passed to the querier, after macros have been
+	// expanded.
+	Query    QueryRequest
+	Type     string
+	Duration time.Duration
+	Err      error
+
+	// Series, Points and Rows count the results returned for the target.
+	// They are -1 for results read from an iterator, whose size is not
+	// known until the response is sent.
+	Series int
+	Points int
+	Rows   int
+}
+
+// WithHooks calls hooks as requests are served.
+func WithHooks(hooks Hooks) Opt {
+	return func(sjc *Handler) error {
+		sjc.hooks = &hooks
+		return nil
+	}
+}
+
+// errRequestAborted is reported to the OnError hook when a response is
+// aborted part way through.
+var errRequestAborted = errors.New("response aborted")
+
+// serve calls the request hooks around next.
+func (hs *Hooks) serve(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+		ri := RequestInfo{Endpoint: endpointName(r.URL.Path), Request: r}
+		if hs.OnRequest != nil {
+			hs.OnRequest(ctx, ri)
+		}
+
+		hw := &hookResponseWriter{statusResponseWriter: statusResponseWriter{ResponseWriter: w}}
+		done := func(err error) {
+			ri.Status, ri.Size, ri.Duration = hw.statusCode(), hw.size, time.Since(start)
+			if err == nil && ri.Status >= http.StatusBadRequest {
+				err = errors.New(http.StatusText(ri.Status))
+			}
+			ri.Err = err
+			if err != nil && hs.OnError != nil {
+				hs.OnError(ctx, ri)
+			}
+			if hs.OnRequestDone != nil {
+				hs.OnRequestDone(ctx, ri)
+			}
+		}
+		defer func() {
+			if v := recover(); v != nil {
+				err := errRequestAborted
+				if v != http.ErrAbortHandler {
+					if pe, ok := v.(*panicError); ok {
+						err = fmt.Errorf("panic: %v", pe.value)
+					} else {
+						err = fmt.Errorf("panic: %v", v)
+					}
+					if hw.status == 0 {
+						hw.status = http.StatusInternalServerError
+					}
+				}
+				done(err)
+				panic(v)
+			}
+		}()
+
+		next.ServeHTTP(hw, r)
+		done(hw.err)
+	})
+}
+
+// queryDone calls the OnQueryDone hook for a completed target. It is safe
+// to call on a nil *Hooks.
+func (hs *Hooks) queryDone(ctx context.Context, qr QueryRequest, typ string, d time.Duration, res interface{}, err error) {
+	if hs == nil || hs.OnQueryDone == nil {
+		return
+	}
+	if typ == "" {
+		typ = "timeserie"
+	}
+	qi := QueryInfo{Query: qr, Type: typ, Duration: d, Err: err}
+	if err == nil {
+		qi.Series, qi.Points, qi.Rows = resultSize(res)
+	}
+	hs.OnQueryDone(ctx, qi)
+}
+
+// resultSize counts the series, datapoints and table rows in the result of
+// a target.
+func resultSize(res interface{}) (series, points, rows int) {
+	switch res := res.(type) {
+	case simpleJSONData:
+		return 1, len(res.DataPoints), 0
+	case simpleJSONResults:
+		for _, r := range res {
+			s, p, n := resultSize(r)
+			series, points, rows = series+s, points+p, rows+n
+		}
+		return series, points, rows
+	case simpleJSONTableData:
+		return 0, 0, len(res.Rows)
+	case *dataFrame:
+		if len(res.Data.Values) > 0 {
+			rows = len(res.Data.Values[0])
+		}
+		return 0, 0, rows
+	case *simpleJSONIterData:
+		return 1, -1, 0
+	case *simpleJSONTableIterData:
+		return 0, 0, -1
+	}
+	return 0, 0, 0
+}
+
+// An errorRecorder is notified of the error written by writeError.
+type errorRecorder interface {
+	recordError(err error)
+}
+
+// recordError notifies any errorRecorder wrapped by w of err.
+func recordError(w http.ResponseWriter, err error) {
+	for w != nil {
+		if er, ok := w.(errorRecorder); ok {
+			er.recordError(err)
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+// hookResponseWriter records the status, size and error of a response
+// for the hooks.
+type hookResponseWriter struct {
+	statusResponseWriter
+	err error
+}
+
+func (hw *hookResponseWriter) recordError(err error) {
+	if hw.err == nil {
+		hw.err = err
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type hookLog struct {
+	sync.Mutex
+	requests []simplejson.RequestInfo
+	done     []simplejson.RequestInfo
+	queries  []simplejson.QueryInfo
+	errors   []simplejson.RequestInfo
+}
+
+func (hl *hookLog) hooks() simplejson.Hooks {
+	return simplejson.Hooks{
+		OnRequest: func(ctx context.Context, ri simplejson.RequestInfo) {
+			hl.Lock()
+			defer hl.Unlock()
+			hl.requests = append(hl.requests, ri)
+		},
+		OnRequestDone: func(ctx context.Context, ri simplejson.RequestInfo) {
+			hl.Lock()
+			defer hl.Unlock()
+			hl.done = append(hl.done, ri)
+		},
+		OnQueryDone: func(ctx context.Context, qi simplejson.QueryInfo) {
+			hl.Lock()
+			defer hl.Unlock()
+			hl.queries = append(hl.queries, qi)
+		},
+		OnError: func(ctx context.Context, ri simplejson.RequestInfo) {
+			hl.Lock()
+			defer hl.Unlock()
+			hl.errors = append(hl.errors, ri)
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var hl hookLog
+	gsj := simplejson.New(
+		simplejson.WithHooks(hl.hooks()),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 1}, {Time: time.Unix(2, 0), Value: 2}}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(hl.requests) != 1 || hl.requests[0].Endpoint != "/query" {
+		t.Fatalf("unexpected OnRequest calls: %+v", hl.requests)
+	}
+	if len(hl.queries) != 1 {
+		t.Fatalf("expected 1 OnQueryDone call, got %d", len(hl.queries))
+	}
+	qi := hl.queries[0]
+	if qi.Query.Target != "upper_50" || qi.Type != "timeserie" || qi.Series != 1 || qi.Points != 2 || qi.Err != nil {
+		t.Errorf("unexpected query info: %+v", qi)
+	}
+	if len(hl.done) != 1 {
+		t.Fatalf("expected 1 OnRequestDone call, got %d", len(hl.done))
+	}
+	ri := hl.done[0]
+	if ri.Status != http.StatusOK || ri.Size != w.Body.Len() || ri.Err != nil {
+		t.Errorf("unexpected request info: %+v", ri)
+	}
+	if len(hl.errors) != 0 {
+		t.Errorf("unexpected OnError calls: %+v", hl.errors)
+	}
+}
+
+func TestHooks_Error(t *testing.T) {
+	var hl hookLog
+	queryErr := errors.New("backend unavailable")
+	gsj := simplejson.New(
+		simplejson.WithHooks(hl.hooks()),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return nil, queryErr
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(hl.queries) != 1 || !errors.Is(hl.queries[0].Err, queryErr) {
+		t.Fatalf("unexpected OnQueryDone calls: %+v", hl.queries)
+	}
+	if len(hl.errors) != 1 {
+		t.Fatalf("expected 1 OnError call, got %d", len(hl.errors))
+	}
+	ri := hl.errors[0]
+	if ri.Status != http.StatusInternalServerError || !errors.Is(ri.Err, queryErr) {
+		t.Errorf("unexpected request info: %+v", ri)
+	}
+	if len(hl.done) != 1 {
+		t.Errorf("expected 1 OnRequestDone call, got %d", len(hl.done))
+	}
+}
+
+func TestHooks_NotFound(t *testing.T) {
+	var hl hookLog
+	gsj := simplejson.New(
+		simplejson.WithHooks(hl.hooks()),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return nil, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(hl.errors) != 1 || hl.errors[0].Status != http.StatusNotFound || hl.errors[0].Endpoint != "/search" {
+		t.Fatalf("unexpected OnError calls: %+v", hl.errors)
+	}
+}
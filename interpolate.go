@@ -0,0 +1,156 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// variableRE matches the template variable syntaxes used by Grafana:
+// $var, [[var]], [[var:format]], ${var} and ${var:format}.
+var variableRE = regexp.MustCompile(`\$(\w+)|\[\[(\w+?)(?::(\w+))?\]\]|\$\{(\w+)(?::([^\}]+))?\}`)
+
+// luceneEscaper escapes the characters with special meaning in Lucene
+// queries.
+var luceneEscaper = strings.NewReplacer(
+	`\`, `\\`, `+`, `\+`, `-`, `\-`, `&`, `\&`, `|`, `\|`, `!`, `\!`,
+	`(`, `\(`, `)`, `\)`, `{`, `\{`, `}`, `\}`, `[`, `\[`, `]`, `\]`,
+	`^`, `\^`, `"`, `\"`, `~`, `\~`, `*`, `\*`, `?`, `\?`, `:`, `\:`,
+	`/`, `\/`, ` `, `\ `,
+)
+
+// Interpolate replaces references to template variables in s with their
+// values from vars, as Grafana would. Variables may be referenced as
+// $var, [[var]] or ${var}, and the latter two forms may specify a format,
+// e.g. ${var:csv}. The supported formats are csv, pipe, json, regex, glob,
+// raw, text, singlequote, doublequote, sqlstring, queryparam,
+// percentencode and lucene. Variables with several values are formatted
+// as a glob, {a,b}, if no format is given. References to unknown
+// variables are left unchanged.
+func Interpolate(s string, vars map[string]ScopedVar) string {
+	if len(vars) == 0 {
+		return s
+	}
+	return variableRE.ReplaceAllStringFunc(s, func(ref string) string {
+		m := variableRE.FindStringSubmatch(ref)
+		name, format := m[1], ""
+		switch {
+		case m[2] != "":
+			name, format = m[2], m[3]
+		case m[4] != "":
+			name, format = m[4], m[5]
+		}
+		v, ok := vars[name]
+		if !ok {
+			return ref
+		}
+		return formatVariable(name, v, format)
+	})
+}
+
+// InterpolateTarget returns the target of the request, with any template
+// variables replaced by their values from ScopedVars.
+func (qr QueryRequest) InterpolateTarget() string {
+	return Interpolate(qr.Target, qr.ScopedVars)
+}
+
+// variableValues returns the values of a variable as strings.
+func variableValues(v interface{}) ([]string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return []string{""}, false
+	case string:
+		return []string{v}, false
+	case []string:
+		return v, true
+	case []interface{}:
+		vals := make([]string, len(v))
+		for i := range v {
+			vals[i] = fmt.Sprint(v[i])
+		}
+		return vals, true
+	default:
+		return []string{fmt.Sprint(v)}, false
+	}
+}
+
+// formatVariable formats the value of the variable name.
+func formatVariable(name string, v ScopedVar, format string) string {
+	vals, multi := variableValues(v.Value)
+	quote := func(q string, escape func(string) string) []string {
+		quoted := make([]string, len(vals))
+		for i := range vals {
+			quoted[i] = q + escape(vals[i]) + q
+		}
+		return quoted
+	}
+
+	switch format {
+	case "csv", "raw":
+		return strings.Join(vals, ",")
+	case "pipe":
+		return strings.Join(vals, "|")
+	case "text":
+		return v.Text
+	case "json":
+		var bs []byte
+		if multi {
+			bs, _ = json.Marshal(vals)
+		} else {
+			bs, _ = json.Marshal(vals[0])
+		}
+		return string(bs)
+	case "regex":
+		escaped := make([]string, len(vals))
+		for i := range vals {
+			escaped[i] = regexp.QuoteMeta(vals[i])
+		}
+		if !multi {
+			return escaped[0]
+		}
+		return "(" + strings.Join(escaped, "|") + ")"
+	case "singlequote":
+		return strings.Join(quote("'", func(s string) string { return strings.ReplaceAll(s, "'", `\'`) }), ",")
+	case "doublequote":
+		return strings.Join(quote(`"`, func(s string) string { return strings.ReplaceAll(s, `"`, `\"`) }), ",")
+	case "sqlstring":
+		return strings.Join(quote("'", func(s string) string { return strings.ReplaceAll(s, "'", "''") }), ",")
+	case "queryparam":
+		params := make([]string, len(vals))
+		for i := range vals {
+			params[i] = "var-" + url.QueryEscape(name) + "=" + url.QueryEscape(vals[i])
+		}
+		return strings.Join(params, "&")
+	case "percentencode":
+		s := vals[0]
+		if multi {
+			s = "{" + strings.Join(vals, ",") + "}"
+		}
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	case "lucene":
+		if !multi {
+			return luceneEscaper.Replace(vals[0])
+		}
+		return "(" + strings.Join(quote(`"`, luceneEscaper.Replace), " OR ") + ")"
+	default:
+		if len(vals) == 1 {
+			return vals[0]
+		}
+		return "{" + strings.Join(vals, ",") + "}"
+	}
+}
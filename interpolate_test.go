@@ -0,0 +1,79 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]simplejson.ScopedVar{
+		"host":  {Text: "Web server", Value: "web-1"},
+		"hosts": {Text: "web-1 + db.1", Value: []interface{}{"web-1", "db.1"}},
+		"quote": {Text: "it's", Value: "it's"},
+		"num":   {Text: "5", Value: 5.0},
+	}
+
+	for _, tc := range []struct {
+		in, expect string
+	}{
+		{"cpu.$host.user", "cpu.web-1.user"},
+		{"cpu.[[host]].user", "cpu.web-1.user"},
+		{"cpu.${host}.user", "cpu.web-1.user"},
+		{"cpu.$hosts", "cpu.{web-1,db.1}"},
+		{"${hosts:csv}", "web-1,db.1"},
+		{"[[hosts:pipe]]", "web-1|db.1"},
+		{"${hosts:json}", `["web-1","db.1"]`},
+		{"${host:json}", `"web-1"`},
+		{"${hosts:regex}", `(web-1|db\.1)`},
+		{"${hosts:glob}", "{web-1,db.1}"},
+		{"${host:text}", "Web server"},
+		{"${quote:singlequote}", `'it\'s'`},
+		{"${hosts:doublequote}", `"web-1","db.1"`},
+		{"${quote:sqlstring}", `'it''s'`},
+		{"${hosts:queryparam}", "var-hosts=web-1&var-hosts=db.1"},
+		{"${host:percentencode}", "web-1"},
+		{"${hosts:lucene}", `("web\-1" OR "db.1")`},
+		{"$num", "5"},
+		{"$unknown [[unknown]] ${unknown:csv}", "$unknown [[unknown]] ${unknown:csv}"},
+	} {
+		if got := simplejson.Interpolate(tc.in, vars); got != tc.expect {
+			t.Errorf("Interpolate(%q)\nexpected: %q\ngot: %q", tc.in, tc.expect, got)
+		}
+	}
+}
+
+func TestQueryRequestInterpolateTarget(t *testing.T) {
+	qr := simplejson.QueryRequest{
+		Target:     "cpu.$host",
+		ScopedVars: map[string]simplejson.ScopedVar{"host": {Value: "web-1"}},
+	}
+	if got := qr.InterpolateTarget(); got != "cpu.web-1" {
+		t.Fatalf("unexpected target %q", got)
+	}
+}
+
+func TestTableQueryScopedVars(t *testing.T) {
+	var got string
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			got = simplejson.Interpolate(target, args.ScopedVars)
+			return nil, nil
+		})),
+	)
+
+	q := `{
+				"targets": [{ "target": "select * from $table", "type": "table" }],
+				"scopedVars": { "table": { "text": "users", "value": "users" } }
+			}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	gsj.ServeHTTP(httptest.NewRecorder(), req)
+
+	if expect := "select * from users"; got != expect {
+		t.Fatalf("\nexpected: %q\ngot: %q", expect, got)
+	}
+}
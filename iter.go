@@ -0,0 +1,160 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"io"
+	"iter"
+	"time"
+)
+
+// An IterQuerier responds to timeserie queries from Grafana, returning
+// the datapoints via an iterator. This allows backends that read from a
+// database cursor to stream points to Grafana without holding them all in
+// memory. Datapoints must be yielded in ascending time order.
+//
+// An error yielded before the first datapoint is reported to Grafana as
+// normal. Once the response has begun streaming an error can no longer be
+// reported, and the connection to the client is aborted instead.
+type IterQuerier interface {
+	GrafanaQueryIter(ctx context.Context, req QueryRequest) iter.Seq2[DataPoint, error]
+}
+
+// simpleJSONIterData is a lazily evaluated response to a single timeserie
+// target.
+type simpleJSONIterData struct {
+	Target string
+	RefID  string
+
+	first     DataPoint
+	hasFirst  bool
+	next      func() (DataPoint, error, bool)
+	stop      func()
+	nonFinite NonFinitePolicy
+	timeUnit  time.Duration
+	maxDPs    int // 0 if unlimited
+	config    *fieldConfigHolder
+}
+
+func (h *Handler) jsonIterQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	ctx, fch := withFieldConfigHolder(ctx)
+	next, stop := iter.Pull2(h.iterQuery.GrafanaQueryIter(ctx, qr))
+
+	// We pull the first datapoint so that any initial error can be
+	// reported before the response has been started.
+	dp, err, ok := next()
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	res := &simpleJSONIterData{
+		Target:    target.Target,
+		RefID:     target.RefID,
+		first:     dp,
+		hasFirst:  ok,
+		next:      next,
+		stop:      stop,
+		nonFinite: h.nonFinite,
+		timeUnit:  h.timeUnit,
+		config:    fch,
+	}
+	if h.enforceMaxDPs {
+		res.maxDPs = qr.MaxDPs
+	}
+	return res, nil
+}
+
+// writeJSON writes the JSON encoding of the series to w, consuming the
+// iterator.
+func (sjd *simpleJSONIterData) writeJSON(w io.Writer) error {
+	defer sjd.stop()
+
+	bp := getBuffer()
+	buf := *bp
+	defer func() { *bp = buf; putBuffer(bp) }()
+
+	buf = append(buf, `{"target":`...)
+	buf = appendJSONString(buf, sjd.Target)
+	if sjd.RefID != "" {
+		buf = append(buf, `,"refId":`...)
+		buf = appendJSONString(buf, sjd.RefID)
+	}
+	buf = append(buf, `,"datapoints":[`...)
+
+	var meta *simpleJSONMeta
+	dp, ok := sjd.first, sjd.hasFirst
+	for n := 0; ok; {
+		out, keep, err := sjd.nonFinite.apply(dp)
+		if err != nil {
+			return err
+		}
+		if keep && sjd.maxDPs > 0 && n == sjd.maxDPs {
+			meta = truncationNotice(sjd.Target, "series truncated to %d points", n)
+			break
+		}
+		if keep {
+			if n > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendDataPoint(buf, out, sjd.timeUnit)
+			n++
+		}
+
+		if len(buf) >= streamBufferSize/2 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+
+		dp, err, ok = sjd.next()
+		if err != nil {
+			return err
+		}
+	}
+	// The config may be set at any point while iterating.
+	buf = append(buf, ']')
+	buf, err := appendFieldConfig(buf, sjd.config.get())
+	if err != nil {
+		return err
+	}
+	if buf, err = appendMeta(buf, meta); err != nil {
+		return err
+	}
+	buf = append(buf, '}')
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// closeQueryResponse releases any iterators, and pooled datapoints, held
+// by the results of a query.
+func closeQueryResponse(out []interface{}) {
+	for _, res := range out {
+		switch res := res.(type) {
+		case simpleJSONData:
+			if res.release != nil {
+				res.release()
+			}
+		case simpleJSONResults:
+			closeQueryResponse(res)
+		case *simpleJSONIterData:
+			res.stop()
+		case *simpleJSONTableIterData:
+			res.stop()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type iterQuerier struct {
+	points []simplejson.DataPoint
+	err    error
+}
+
+func (iq iterQuerier) GrafanaQueryIter(ctx context.Context, req simplejson.QueryRequest) iter.Seq2[simplejson.DataPoint, error] {
+	return func(yield func(simplejson.DataPoint, error) bool) {
+		for _, dp := range iq.points {
+			if !yield(dp, nil) {
+				return
+			}
+		}
+		if iq.err != nil {
+			yield(simplejson.DataPoint{}, iq.err)
+		}
+	}
+}
+
+func TestWithIterQuerier(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithIterQuerier(iterQuerier{
+			points: []simplejson.DataPoint{
+				{Time: time.Unix(1234, 0), Value: 1},
+				{Time: time.Unix(1235, 0), Value: 2.5},
+			},
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"upper_50","refId":"A","datapoints":[[1,1234000],[2.5,1235000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
+
+func TestWithIterQuerier_InitialError(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithIterQuerier(iterQuerier{
+			err: simplejson.Error{Status: http.StatusNotFound, Message: "unknown target"},
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestWithIterQuerier_StreamError(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithIterQuerier(iterQuerier{
+			points: []simplejson.DataPoint{
+				{Time: time.Unix(1234, 0), Value: 1},
+			},
+			err: errors.New("cursor failed"),
+		}),
+	)
+	srv := httptest.NewServer(gsj)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/query", "application/json", bytes.NewBufferString(encodeTestQuery))
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Fatalf("expected truncated response to fail")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit limits the rate at which requests are accepted to rps
+// requests per second, allowing bursts of up to burst requests. Requests
+// in excess of the limit are rejected with a 429 status, and a
+// Retry-After header indicating when the client may try again.
+func WithRateLimit(rps float64, burst int) Opt {
+	return func(sjc *Handler) error {
+		sjc.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+		return nil
+	}
+}
+
+// WithMaxInFlight limits the number of requests that may be handled
+// concurrently to n. Requests in excess of the limit are rejected with a
+// 503 status. An n of 0 leaves the number of requests unlimited, a
+// negative n is reported as an error by NewWithError.
+func WithMaxInFlight(n int) Opt {
+	return func(sjc *Handler) error {
+		sjc.maxInFlight = n
+		sjc.inFlight = nil
+		if n > 0 {
+			sjc.inFlight = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// limit applies the configured rate and concurrency limits to requests
+// served by next.
+func (h *Handler) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.limiter != nil {
+			res := h.limiter.Reserve()
+			if delay := res.Delay(); !res.OK() || delay > 0 {
+				res.Cancel()
+				w.Header().Set("Retry-After", retryAfter(delay))
+				writeError(w, Error{Status: http.StatusTooManyRequests}, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if h.inFlight != nil {
+			select {
+			case h.inFlight <- struct{}{}:
+				defer func() { <-h.inFlight }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				writeError(w, Error{Status: http.StatusServiceUnavailable, Message: "too many requests in flight"}, http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// retryAfter formats d as a Retry-After value, in whole seconds.
+func retryAfter(d time.Duration) string {
+	secs := int64(math.Ceil(d.Seconds()))
+	if secs < 1 || d == rate.InfDuration {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
@@ -0,0 +1,101 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithRateLimit(0.1, 2),
+	)
+
+	var codes []int
+	var retry string
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": ""}`))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		retry = w.Header().Get("Retry-After")
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status codes %v", codes)
+	}
+	if retry != "10" {
+		t.Fatalf("expected Retry-After of 10, got %q", retry)
+	}
+}
+
+func TestWithMaxInFlight(t *testing.T) {
+	bq := &blockingQuerier{release: make(chan struct{})}
+	gsj := simplejson.New(
+		simplejson.WithQuerier(bq),
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithMaxInFlight(1),
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+		gsj.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for bq.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("query did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": ""}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	close(bq.release)
+	wg.Wait()
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+}
+
+func TestWithMaxInFlight_Unlimited(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithMaxInFlight(0),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": ""}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestWithMaxInFlight_Negative(t *testing.T) {
+	_, err := simplejson.NewWithError(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithMaxInFlight(-1),
+	)
+	if err == nil || !strings.Contains(err.Error(), "WithMaxInFlight") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
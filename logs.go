@@ -0,0 +1,98 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+)
+
+// A LogLine is a single log entry. Level is the severity of the entry,
+// e.g. "error" or "info", which Grafana uses to colour the line. Labels
+// hold any further fields of the entry.
+type LogLine struct {
+	Time    time.Time
+	Message string
+	Level   string
+	Labels  map[string]string
+}
+
+// A LogQuerier responds to queries from Grafana logs panels, for targets
+// with the type "logs". The log lines are sent to Grafana as a table with
+// Time, Message and Level columns, followed by a string column for each
+// label, in the order of the label names, which Grafana's logs panel
+// recognises as log data.
+type LogQuerier interface {
+	GrafanaQueryLogs(ctx context.Context, req QueryRequest) ([]LogLine, error)
+}
+
+// WithLogQuerier adds a logs query handler.
+func WithLogQuerier(q LogQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.logQuery = q
+		return nil
+	}
+}
+
+// LogColumns returns the table columns used to send lines to Grafana.
+func LogColumns(lines []LogLine) []TableColumn {
+	labels := map[string]struct{}{}
+	for _, l := range lines {
+		for k := range l.Labels {
+			labels[k] = struct{}{}
+		}
+	}
+	names := slices.Sorted(maps.Keys(labels))
+
+	times := make(TableTimeColumn, len(lines))
+	msgs := make(TableStringColumn, len(lines))
+	levels := make(TableStringColumn, len(lines))
+	labelCols := make([]TableStringColumn, len(names))
+	for i := range labelCols {
+		labelCols[i] = make(TableStringColumn, len(lines))
+	}
+	for i, l := range lines {
+		times[i] = l.Time
+		msgs[i] = l.Message
+		levels[i] = l.Level
+		for j, name := range names {
+			labelCols[j][i] = l.Labels[name]
+		}
+	}
+
+	cols := []TableColumn{
+		{Text: "Time", Data: times},
+		{Text: "Message", Data: msgs},
+		{Text: "Level", Data: levels},
+	}
+	for j, name := range names {
+		cols = append(cols, TableColumn{Text: name, Data: labelCols[j]})
+	}
+	return cols
+}
+
+func (h *Handler) jsonLogQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	keyReq := qr
+	keyReq.RefID = ""
+	resp, err := dedupe(h, ctx, "logs", keyReq, func(ctx context.Context) ([]LogLine, error) {
+		return h.logQuery.GrafanaQueryLogs(ctx, qr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h.tableData(target, LogColumns(resp))
+}
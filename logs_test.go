@@ -0,0 +1,62 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithLogQuerier(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithLogQuerier(simplejson.LogQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.LogLine, error) {
+			return []simplejson.LogLine{
+				{Time: time.Unix(1, 0).UTC(), Message: "started", Level: "info", Labels: map[string]string{"host": "web-1"}},
+				{Time: time.Unix(2, 0).UTC(), Message: "failed", Level: "error", Labels: map[string]string{"host": "web-2", "code": "500"}},
+			}, nil
+		})),
+	)
+
+	q := `{"targets": [{"target": "app", "refId": "A", "type": "logs"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","refId":"A","columns":[` +
+		`{"text":"Time","type":"time"},{"text":"Message","type":"string"},{"text":"Level","type":"string"},` +
+		`{"text":"code","type":"string"},{"text":"host","type":"string"}],` +
+		`"rows":[["1970-01-01T00:00:01Z","started","info","","web-1"],["1970-01-01T00:00:02Z","failed","error","500","web-2"]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithLogQuerier_NotConfigured(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithQuerier(seriesQuerier{}))
+
+	q := `{"targets": [{"target": "app", "type": "logs"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// macroRE matches a macro reference, $__name, optionally followed by a
+// parenthesised argument list.
+var macroRE = regexp.MustCompile(`\$__(\w+)(?:\(([^)]*)\))?`)
+
+// A Macro returns the expansion of a macro for a query. Args holds the
+// comma separated arguments given to the macro, with surrounding
+// whitespace removed. Errors should wrap ErrBadRequest if the macro has
+// been used incorrectly.
+type Macro func(qr QueryRequest, args []string) (string, error)
+
+// defaultMacros are the macros understood by ExpandMacros, following the
+// names used by Grafana's own datasources.
+var defaultMacros = map[string]Macro{
+	"from":          macroValue(func(qr QueryRequest) string { return strconv.FormatInt(qr.From.UnixMilli(), 10) }),
+	"to":            macroValue(func(qr QueryRequest) string { return strconv.FormatInt(qr.To.UnixMilli(), 10) }),
+	"unixEpochFrom": macroValue(func(qr QueryRequest) string { return strconv.FormatInt(qr.From.Unix(), 10) }),
+	"unixEpochTo":   macroValue(func(qr QueryRequest) string { return strconv.FormatInt(qr.To.Unix(), 10) }),
+	"timeFrom":      macroValue(func(qr QueryRequest) string { return quoteTime(qr.From) }),
+	"timeTo":        macroValue(func(qr QueryRequest) string { return quoteTime(qr.To) }),
+	"interval":      macroValue(func(qr QueryRequest) string { return formatMacroDuration(qr.Interval) }),
+	"interval_ms":   macroValue(func(qr QueryRequest) string { return strconv.FormatInt(qr.Interval.Milliseconds(), 10) }),
+	"range":         macroValue(func(qr QueryRequest) string { return formatMacroDuration(qr.To.Sub(qr.From)) }),
+	"range_s":       macroValue(func(qr QueryRequest) string { return strconv.FormatInt(int64(qr.To.Sub(qr.From)/time.Second), 10) }),
+	"range_ms":      macroValue(func(qr QueryRequest) string { return strconv.FormatInt(qr.To.Sub(qr.From).Milliseconds(), 10) }),
+	"timeFilter": macroColumn(func(qr QueryRequest, col string) string {
+		return col + " BETWEEN " + quoteTime(qr.From) + " AND " + quoteTime(qr.To)
+	}),
+	"unixEpochFilter": macroColumn(func(qr QueryRequest, col string) string {
+		return fmt.Sprintf("%s >= %d AND %s <= %d", col, qr.From.Unix(), col, qr.To.Unix())
+	}),
+	"unixEpochMsFilter": macroColumn(func(qr QueryRequest, col string) string {
+		return fmt.Sprintf("%s >= %d AND %s <= %d", col, qr.From.UnixMilli(), col, qr.To.UnixMilli())
+	}),
+}
+
+// macroValue returns a macro that takes no arguments.
+func macroValue(f func(QueryRequest) string) Macro {
+	return func(qr QueryRequest, args []string) (string, error) {
+		if len(args) > 0 {
+			return "", fmt.Errorf("macro takes no arguments, %w", ErrBadRequest)
+		}
+		return f(qr), nil
+	}
+}
+
+// macroColumn returns a macro that takes a single column name.
+func macroColumn(f func(QueryRequest, string) string) Macro {
+	return func(qr QueryRequest, args []string) (string, error) {
+		if len(args) != 1 || args[0] == "" {
+			return "", fmt.Errorf("macro expects a column name, %w", ErrBadRequest)
+		}
+		return f(qr, args[0]), nil
+	}
+}
+
+func quoteTime(t time.Time) string {
+	return "'" + t.UTC().Format(time.RFC3339) + "'"
+}
+
+// formatMacroDuration formats d in the largest whole unit, as Grafana
+// formats intervals, e.g. 30s, 5m or 500ms.
+func formatMacroDuration(d time.Duration) string {
+	for _, u := range []struct {
+		d    time.Duration
+		unit string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+	} {
+		if d >= u.d && d%u.d == 0 {
+			return strconv.FormatInt(int64(d/u.d), 10) + u.unit
+		}
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+// ExpandMacros replaces macros in s with values taken from the time range
+// and interval of qr. Macros are written $__name, or $__name(args) for
+// those that take arguments. The following are understood:
+//
+//   - $__from and $__to: the range, in milliseconds since the epoch
+//   - $__unixEpochFrom and $__unixEpochTo: the range, in seconds since the epoch
+//   - $__timeFrom and $__timeTo: the range, as quoted RFC3339 times
+//   - $__interval: the interval, e.g. 30s
+//   - $__interval_ms: the interval, in milliseconds
+//   - $__range, $__range_s and $__range_ms: the length of the range
+//   - $__timeFilter(col): col BETWEEN '<from>' AND '<to>'
+//   - $__unixEpochFilter(col): col >= <from> AND col <= <to>, in seconds
+//   - $__unixEpochMsFilter(col): as above, in milliseconds
+//
+// Macros in custom are added to, or replace, those above; they are keyed
+// by their name without the $__ prefix. References to unknown macros are
+// left unchanged.
+func ExpandMacros(s string, qr QueryRequest, custom map[string]Macro) (string, error) {
+	var err error
+	res := macroRE.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		m := macroRE.FindStringSubmatch(ref)
+		macro, ok := custom[m[1]]
+		if !ok {
+			macro, ok = defaultMacros[m[1]]
+		}
+		if !ok {
+			return ref
+		}
+		var args []string
+		if m[2] != "" {
+			args = strings.Split(m[2], ",")
+			for i := range args {
+				args[i] = strings.TrimSpace(args[i])
+			}
+		}
+		var exp string
+		exp, err = macro(qr, args)
+		if err != nil {
+			err = fmt.Errorf("expanding $__%s: %w", m[1], err)
+		}
+		return exp
+	})
+	if err != nil {
+		return "", err
+	}
+	return res, nil
+}
+
+// WithMacros expands macros in the targets of queries before they are
+// passed to the querier, see ExpandMacros. Custom macros may be given in
+// addition to the default set, keyed by name without the $__ prefix. The
+// target reported back to Grafana is left unexpanded.
+func WithMacros(custom map[string]Macro) Opt {
+	return func(sjc *Handler) error {
+		sjc.macros = map[string]Macro{}
+		for name, m := range custom {
+			sjc.macros[name] = m
+		}
+		return nil
+	}
+}
+
+// expandMacros expands the macros in the target of qr, if enabled.
+func (h *Handler) expandMacros(qr *QueryRequest) error {
+	if h.macros == nil {
+		return nil
+	}
+	target, err := ExpandMacros(qr.Target, *qr, h.macros)
+	if err != nil {
+		return err
+	}
+	qr.Target = target
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestExpandMacros(t *testing.T) {
+	qr := simplejson.QueryRequest{
+		QueryArguments: simplejson.QueryArguments{
+			QueryCommonArguments: simplejson.QueryCommonArguments{
+				From: time.Unix(1500000000, 0),
+				To:   time.Unix(1500003600, 0),
+			},
+			Interval: 30 * time.Second,
+		},
+	}
+	custom := map[string]simplejson.Macro{
+		"upper": func(qr simplejson.QueryRequest, args []string) (string, error) {
+			return strings.ToUpper(strings.Join(args, "|")), nil
+		},
+		"interval": func(qr simplejson.QueryRequest, args []string) (string, error) {
+			return "custom", nil
+		},
+	}
+
+	tests := []struct {
+		in     string
+		custom map[string]simplejson.Macro
+		expect string
+		err    bool
+	}{
+		{in: "$__from-$__to", expect: "1500000000000-1500003600000"},
+		{in: "$__unixEpochFrom $__unixEpochTo", expect: "1500000000 1500003600"},
+		{in: "$__timeFilter(ts)", expect: "ts BETWEEN '2017-07-14T02:40:00Z' AND '2017-07-14T03:40:00Z'"},
+		{in: "$__timeFrom()", expect: "'2017-07-14T02:40:00Z'"},
+		{in: "$__unixEpochFilter( ts )", expect: "ts >= 1500000000 AND ts <= 1500003600"},
+		{in: "rate(x[$__interval]) $__interval_ms", expect: "rate(x[30s]) 30000"},
+		{in: "$__range $__range_s $__range_ms", expect: "1h 3600 3600000"},
+		{in: "$__unknown $var", expect: "$__unknown $var"},
+		{in: "$__upper(a, b) $__interval", custom: custom, expect: "A|B custom"},
+		{in: "$__timeFilter()", err: true},
+		{in: "$__from(x)", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := simplejson.ExpandMacros(tt.in, qr, tt.custom)
+			if tt.err {
+				if !errors.Is(err, simplejson.ErrBadRequest) {
+					t.Fatalf("expected a bad request error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expect {
+				t.Fatalf("expected %q, got %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestWithMacros(t *testing.T) {
+	var got string
+	gsj := simplejson.New(
+		simplejson.WithMacros(nil),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			got = target
+			return nil, nil
+		})),
+	)
+
+	body := `{"range":{"from":"2017-07-14T02:40:00Z","to":"2017-07-14T03:40:00Z"},"interval":"1m","targets":[{"target":"x[$__interval] $__unixEpochFrom","refId":"A"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if got != "x[1m] 1500000000" {
+		t.Fatalf("unexpected target %q", got)
+	}
+	if !strings.Contains(w.Body.String(), `"target":"x[$__interval] $__unixEpochFrom"`) {
+		t.Fatalf("expected the unexpanded target in the response, got %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"$__timeFilter()"}]}`))
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d", w.Code)
+	}
+}
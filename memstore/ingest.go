@@ -0,0 +1,161 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// maxIngestBytes is the largest request body accepted by the ingestion
+// handler.
+const maxIngestBytes = 32 << 20
+
+// A Sample is a single point in the JSON lines ingestion format, e.g.
+//
+//	{"name":"requests","labels":{"host":"a"},"time":1700000000000,"value":12}
+//
+// Time is in milliseconds since the epoch, and defaults to the time the
+// sample is received. A null or missing value records a null point.
+type Sample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Time   int64             `json:"time,omitempty"`
+	Value  *float64          `json:"value"`
+}
+
+// IngestHandler returns an http.Handler that appends the points POSTed to
+// it to the store, allowing other processes to push metrics. The body may
+// be newline delimited JSON Samples, or, with a Content-Type of
+// application/x-protobuf, a snappy compressed Prometheus remote-write
+// request. For remote-write requests the __name__ label gives the name of
+// the series. The handler is not served by the simplejson Handler, and
+// should be added to a mux alongside it:
+//
+//	mux.Handle("/write", store.IngestHandler())
+//	mux.Handle("/", simplejson.New(simplejson.WithSource(store)))
+func (s *Store) IngestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, maxIngestBytes)
+
+		var err error
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mt {
+		case "application/x-protobuf":
+			err = s.ingestRemoteWrite(body, r.Header.Get("Content-Encoding"))
+		default:
+			err = s.ingestJSONLines(body)
+		}
+
+		var mbe *http.MaxBytesError
+		switch {
+		case errors.As(err, &mbe):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// ingestJSONLines appends the samples read from r. The samples are
+// validated before any are appended.
+func (s *Store) ingestJSONLines(r io.Reader) error {
+	now := time.Now()
+	type point struct {
+		name   string
+		labels map[string]string
+		dp     simplejson.DataPoint
+	}
+	var points []point
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxIngestBytes)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var smp Sample
+		if err := json.Unmarshal(sc.Bytes(), &smp); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if smp.Name == "" {
+			return fmt.Errorf("line %d: missing name", line)
+		}
+
+		t := now
+		if smp.Time != 0 {
+			t = time.UnixMilli(smp.Time)
+		}
+		dp := simplejson.NullDataPoint(t)
+		if smp.Value != nil {
+			dp = simplejson.DataPoint{Time: t, Value: *smp.Value}
+		}
+		points = append(points, point{smp.Name, smp.Labels, dp})
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		s.Append(p.name, p.labels, p.dp)
+	}
+	return nil
+}
+
+// ingestRemoteWrite appends the samples of a Prometheus remote-write
+// request read from r.
+func (s *Store) ingestRemoteWrite(r io.Reader, encoding string) error {
+	if encoding != "snappy" {
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if bs, err = snappyDecode(bs); err != nil {
+		return err
+	}
+	series, err := parseWriteRequest(bs)
+	if err != nil {
+		return err
+	}
+
+	for _, ts := range series {
+		name := ts.labels[nameLabel]
+		if name == "" {
+			return errors.New("series is missing a __name__ label")
+		}
+	}
+	for _, ts := range series {
+		name := ts.labels[nameLabel]
+		delete(ts.labels, nameLabel)
+		s.Append(name, ts.labels, ts.points...)
+	}
+	return nil
+}
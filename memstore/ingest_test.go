@@ -0,0 +1,184 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/memstore"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func queryAll(t *testing.T, s *memstore.Store, target string) []simplejson.DataPoint {
+	t.Helper()
+	dps, err := s.GrafanaQuery(context.Background(), target, simplejson.QueryArguments{
+		QueryCommonArguments: simplejson.QueryCommonArguments{From: time.Unix(0, 0), To: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return dps
+}
+
+func TestIngestJSONLines(t *testing.T) {
+	s := memstore.New()
+	body := `{"name":"requests","labels":{"host":"a"},"time":1000,"value":1}
+{"name":"requests","labels":{"host":"a"},"time":2000,"value":null}
+
+{"name":"requests","labels":{"host":"b"},"value":5}
+`
+	w := httptest.NewRecorder()
+	s.IngestHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d, %s", w.Code, w.Body.String())
+	}
+
+	dps := queryAll(t, s, `requests{host="a"}`)
+	if len(dps) != 2 || dps[0].Value != 1 || !dps[0].Time.Equal(time.UnixMilli(1000)) || !dps[1].Null {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+	if dps := queryAll(t, s, `requests{host="b"}`); len(dps) != 1 || dps[0].Value != 5 {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestIngestErrors(t *testing.T) {
+	s := memstore.New()
+	for _, tt := range []struct {
+		method, contentType, encoding, body string
+		status                              int
+	}{
+		{http.MethodGet, "", "", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", "", `{"name":"ok","value":1}` + "\n" + `{"value":1}`, http.StatusBadRequest},
+		{http.MethodPost, "", "", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "application/x-protobuf", "", "", http.StatusBadRequest},
+		{http.MethodPost, "application/x-protobuf", "snappy", "\x05\x00", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tt.method, "/write", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		req.Header.Set("Content-Encoding", tt.encoding)
+		w := httptest.NewRecorder()
+		s.IngestHandler().ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.body, tt.status, w.Code)
+		}
+	}
+	if s.Len() != 0 {
+		t.Fatalf("expected nothing to be ingested from invalid requests")
+	}
+}
+
+// appendSnappyLiteral appends bs to a snappy block as a literal.
+func appendSnappyLiteral(out, bs []byte) []byte {
+	switch n := len(bs) - 1; {
+	case n < 60:
+		out = append(out, byte(n)<<2)
+	default:
+		out = append(out, 61<<2, byte(n), byte(n>>8))
+	}
+	return append(out, bs...)
+}
+
+// snappyLiteral encodes bs as a snappy block of a single literal.
+func snappyLiteral(bs []byte) []byte {
+	return appendSnappyLiteral(binary.AppendUvarint(nil, uint64(len(bs))), bs)
+}
+
+func writeRequest(series ...map[string]string) []byte {
+	var req []byte
+	for i, labels := range series {
+		var ts []byte
+		for k, v := range labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, k)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, v)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+		for j, v := range []float64{float64(i), math.Float64frombits(0x7ff0000000000002)} {
+			var smp []byte
+			smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+			smp = protowire.AppendFixed64(smp, math.Float64bits(v))
+			smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+			smp = protowire.AppendVarint(smp, uint64(1000*(j+1)))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, smp)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+func TestIngestRemoteWrite(t *testing.T) {
+	s := memstore.New()
+	body := snappyLiteral(writeRequest(
+		map[string]string{"__name__": "up", "job": "a"},
+		map[string]string{"__name__": "up", "job": "b"},
+	))
+
+	req := httptest.NewRequest(http.MethodPost, "/write", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	w := httptest.NewRecorder()
+	s.IngestHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d, %s", w.Code, w.Body.String())
+	}
+
+	dps := queryAll(t, s, `up{job="b"}`)
+	if len(dps) != 2 || dps[0].Value != 1 || !dps[0].Time.Equal(time.UnixMilli(1000)) || !dps[1].Null {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+	if names, _ := s.GrafanaSearch(context.Background(), ""); len(names) != 1 || names[0] != "up" {
+		t.Fatalf("unexpected series %v", names)
+	}
+}
+
+func TestIngestRemoteWrite_SnappyCopies(t *testing.T) {
+	const name = "abcabcabcabc"
+	wr := writeRequest(map[string]string{"__name__": name})
+
+	// The name is encoded as the literal "abc", followed by an
+	// overlapping copy of 9 bytes from an offset of 3.
+	i := bytes.Index(wr, []byte(name)) + 3
+	body := binary.AppendUvarint(nil, uint64(len(wr)))
+	body = appendSnappyLiteral(body, wr[:i])
+	body = append(body, 0x01|(9-4)<<2, 3)
+	body = appendSnappyLiteral(body, wr[i+9:])
+
+	s := memstore.New()
+	req := httptest.NewRequest(http.MethodPost, "/write", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	w := httptest.NewRecorder()
+	s.IngestHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status %d, %s", w.Code, w.Body.String())
+	}
+	if names, _ := s.GrafanaSearch(context.Background(), ""); len(names) != 1 || names[0] != name {
+		t.Fatalf("unexpected series %v", names)
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memstore provides a small in-memory timeseries store, allowing
+// a process to record its own metrics and serve them to Grafana with no
+// external database.
+//
+//	store := memstore.New(memstore.WithRetention(24 * time.Hour))
+//	store.Append("requests", map[string]string{"host": "a"}, simplejson.DataPoint{Time: time.Now(), Value: 12})
+//	http.ListenAndServe(":8080", simplejson.New(simplejson.WithSource(store)))
+//
+// A series is identified by its name and labels. Query targets select
+// series by name, optionally followed by label matchers, in the style of
+// Prometheus, e.g. requests{host="a",path=~"/api/.*"}. Adhoc filters are
+// applied as further label matchers. When several series match a target
+// their values are summed.
+//
+// Other processes may push points to the store over HTTP, see
+// Store.IngestHandler.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// nameLabel is the tag key that matches the name of a series.
+const nameLabel = "__name__"
+
+// Store is an in-memory timeseries store. It implements
+// simplejson.Querier, simplejson.Searcher and simplejson.TagSearcher, and
+// may be passed to simplejson.WithSource. A Store is safe for concurrent
+// use.
+type Store struct {
+	retention time.Duration
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	name   string
+	labels map[string]string
+	points []simplejson.DataPoint
+}
+
+// An Opt configures a Store.
+type Opt func(*Store)
+
+// WithRetention discards points older than d. By default points are kept
+// indefinitely.
+func WithRetention(d time.Duration) Opt {
+	return func(s *Store) {
+		s.retention = d
+	}
+}
+
+// New creates an empty Store.
+func New(opts ...Opt) *Store {
+	s := &Store{series: map[string]*series{}}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// seriesKey returns a string uniquely identifying a series.
+func seriesKey(name string, labels map[string]string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		fmt.Fprintf(&b, "\xff%s\xff%s", k, labels[k])
+	}
+	return b.String()
+}
+
+// Append adds points to the series with the given name and labels,
+// creating it if needed. Points may be appended in any order. A point at
+// the same time as an existing one replaces it.
+func (s *Store) Append(name string, labels map[string]string, points ...simplejson.DataPoint) {
+	key := seriesKey(name, labels)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sr, ok := s.series[key]
+	if !ok {
+		sr = &series{name: name, labels: maps.Clone(labels)}
+		s.series[key] = sr
+	}
+	for _, p := range points {
+		sr.insert(p)
+	}
+	if s.retention > 0 {
+		s.expire(time.Now().Add(-s.retention))
+	}
+}
+
+// insert adds p to the series, keeping the points sorted by time.
+func (sr *series) insert(p simplejson.DataPoint) {
+	n := len(sr.points)
+	if n == 0 || sr.points[n-1].Time.Before(p.Time) {
+		sr.points = append(sr.points, p)
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return !sr.points[i].Time.Before(p.Time) })
+	if sr.points[i].Time.Equal(p.Time) {
+		sr.points[i] = p
+		return
+	}
+	sr.points = slices.Insert(sr.points, i, p)
+}
+
+// expire removes points before cutoff, and any series left empty. The
+// caller must hold the write lock.
+func (s *Store) expire(cutoff time.Time) {
+	for key, sr := range s.series {
+		i := sort.Search(len(sr.points), func(i int) bool { return !sr.points[i].Time.Before(cutoff) })
+		if i == len(sr.points) {
+			delete(s.series, key)
+			continue
+		}
+		sr.points = sr.points[i:]
+	}
+}
+
+// Len returns the number of series in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.series)
+}
+
+// GrafanaQuery implements simplejson.Querier, returning the points of the
+// series matching target, between args.From and args.To.
+func (s *Store) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	sel, err := parseSelector(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range args.Filters {
+		m, err := newMatcher(f.Key, f.Operator, f.Value)
+		if err != nil {
+			return nil, err
+		}
+		sel.matchers = append(sel.matchers, m)
+	}
+
+	from := args.From
+	if s.retention > 0 {
+		if cutoff := time.Now().Add(-s.retention); from.Before(cutoff) {
+			from = cutoff
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*series
+	for _, sr := range s.series {
+		if sel.matches(sr) {
+			matched = append(matched, sr)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return []simplejson.DataPoint{}, nil
+	case 1:
+		simplejson.SetLabels(ctx, matched[0].labels)
+		return slices.Clone(matched[0].between(from, args.To)), nil
+	}
+
+	sums := map[time.Time]float64{}
+	for _, sr := range matched {
+		for _, p := range sr.between(from, args.To) {
+			if !p.Null {
+				sums[p.Time] += p.Value
+			}
+		}
+	}
+	return simplejson.NewSeries(target).AddMap(sums).Points(), nil
+}
+
+// between returns the points from from to to, inclusive.
+func (sr *series) between(from, to time.Time) []simplejson.DataPoint {
+	i := sort.Search(len(sr.points), func(i int) bool { return !sr.points[i].Time.Before(from) })
+	j := sort.Search(len(sr.points), func(i int) bool { return sr.points[i].Time.After(to) })
+	if i >= j {
+		return nil
+	}
+	return sr.points[i:j]
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the names of
+// the series that contain target.
+func (s *Store) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := map[string]struct{}{}
+	for _, sr := range s.series {
+		if strings.Contains(sr.name, target) {
+			names[sr.name] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(names)), nil
+}
+
+// GrafanaAdhocFilterTags implements simplejson.TagSearcher, returning the
+// label keys of all series.
+func (s *Store) GrafanaAdhocFilterTags(ctx context.Context) ([]simplejson.TagInfoer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := map[string]struct{}{}
+	for _, sr := range s.series {
+		for k := range sr.labels {
+			keys[k] = struct{}{}
+		}
+	}
+
+	res := []simplejson.TagInfoer{simplejson.TagStringKey(nameLabel)}
+	for _, k := range slices.Sorted(maps.Keys(keys)) {
+		res = append(res, simplejson.TagStringKey(k))
+	}
+	return res, nil
+}
+
+// GrafanaAdhocFilterTagValues implements simplejson.TagSearcher,
+// returning the values of the label key.
+func (s *Store) GrafanaAdhocFilterTagValues(ctx context.Context, key string) ([]simplejson.TagValuer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vals := map[string]struct{}{}
+	for _, sr := range s.series {
+		if v, ok := sr.label(key); ok {
+			vals[v] = struct{}{}
+		}
+	}
+
+	res := []simplejson.TagValuer{}
+	for _, v := range slices.Sorted(maps.Keys(vals)) {
+		res = append(res, simplejson.TagStringValue(v))
+	}
+	return res, nil
+}
+
+func (sr *series) label(key string) (string, bool) {
+	if key == nameLabel {
+		return sr.name, true
+	}
+	v, ok := sr.labels[key]
+	return v, ok
+}
+
+// A selector picks series by name and labels.
+type selector struct {
+	name     string
+	matchers []matcher
+}
+
+type matcher struct {
+	key, op, value string
+	re             *regexp.Regexp
+}
+
+func newMatcher(key, op, value string) (matcher, error) {
+	m := matcher{key: key, op: op, value: value}
+	switch op {
+	case "=", "!=":
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return m, fmt.Errorf("label %s: %v, %w", key, err, simplejson.ErrBadRequest)
+		}
+		m.re = re
+	default:
+		return m, fmt.Errorf("label %s: unsupported operator %q, %w", key, op, simplejson.ErrBadRequest)
+	}
+	return m, nil
+}
+
+func (m matcher) matches(sr *series) bool {
+	v, _ := sr.label(m.key)
+	switch m.op {
+	case "=":
+		return v == m.value
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+func (sel selector) matches(sr *series) bool {
+	if sel.name != "" && sr.name != sel.name {
+		return false
+	}
+	for _, m := range sel.matchers {
+		if !m.matches(sr) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSelector parses a target of the form name{key="value",...}. The
+// name may be omitted if there are matchers.
+func parseSelector(target string) (selector, error) {
+	target = strings.TrimSpace(target)
+	name, rest, ok := strings.Cut(target, "{")
+	sel := selector{name: strings.TrimSpace(name)}
+	if !ok {
+		if sel.name == "" {
+			return sel, fmt.Errorf("empty target, %w", simplejson.ErrBadRequest)
+		}
+		return sel, nil
+	}
+
+	body, ok := strings.CutSuffix(strings.TrimSpace(rest), "}")
+	if !ok {
+		return sel, fmt.Errorf("target %q is missing a closing brace, %w", target, simplejson.ErrBadRequest)
+	}
+	for body = strings.TrimSpace(body); body != ""; {
+		i := strings.IndexAny(body, "=!")
+		if i <= 0 {
+			return sel, fmt.Errorf("target %q has an invalid label matcher, %w", target, simplejson.ErrBadRequest)
+		}
+		key := strings.TrimSpace(body[:i])
+		op := body[i : i+1]
+		if i+1 < len(body) && (body[i+1] == '=' || body[i+1] == '~') {
+			op = body[i : i+2]
+		}
+		body = strings.TrimSpace(body[i+len(op):])
+
+		if len(body) == 0 || body[0] != '"' {
+			return sel, fmt.Errorf("target %q: value for label %s must be quoted, %w", target, key, simplejson.ErrBadRequest)
+		}
+		end := strings.IndexByte(body[1:], '"')
+		if end < 0 {
+			return sel, fmt.Errorf("target %q: unterminated value for label %s, %w", target, key, simplejson.ErrBadRequest)
+		}
+		m, err := newMatcher(key, op, body[1:end+1])
+		if err != nil {
+			return sel, err
+		}
+		sel.matchers = append(sel.matchers, m)
+
+		body = strings.TrimSpace(body[end+2:])
+		body, _ = strings.CutPrefix(body, ",")
+		body = strings.TrimSpace(body)
+	}
+	return sel, nil
+}
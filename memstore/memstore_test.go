@@ -0,0 +1,140 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/memstore"
+)
+
+func testStore(now time.Time) *memstore.Store {
+	s := memstore.New()
+	for i := 0; i < 3; i++ {
+		t := now.Add(time.Duration(i) * time.Minute)
+		s.Append("requests", map[string]string{"host": "a", "code": "200"}, simplejson.DataPoint{Time: t, Value: 1})
+		s.Append("requests", map[string]string{"host": "b", "code": "500"}, simplejson.DataPoint{Time: t, Value: 10})
+		s.Append("latency", map[string]string{"host": "a"}, simplejson.DataPoint{Time: t, Value: 0.5})
+	}
+	return s
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	s := testStore(now)
+	args := simplejson.QueryArguments{QueryCommonArguments: simplejson.QueryCommonArguments{From: now, To: now.Add(time.Minute)}}
+
+	tests := []struct {
+		target  string
+		filters []simplejson.QueryAdhocFilter
+		expect  []float64
+	}{
+		{`latency`, nil, []float64{0.5, 0.5}},
+		{`requests{host="a"}`, nil, []float64{1, 1}},
+		{`requests{host!="a"}`, nil, []float64{10, 10}},
+		{`requests{code=~"5.."}`, nil, []float64{10, 10}},
+		{`requests`, nil, []float64{11, 11}},
+		{`requests`, []simplejson.QueryAdhocFilter{{Key: "host", Operator: "=", Value: "b"}}, []float64{10, 10}},
+		{`{host="a", __name__!~"req.*"}`, nil, []float64{0.5, 0.5}},
+		{`missing`, nil, nil},
+	}
+	for _, tt := range tests {
+		args := args
+		args.Filters = tt.filters
+		dps, err := s.GrafanaQuery(context.Background(), tt.target, args)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.target, err)
+		}
+		if len(dps) != len(tt.expect) {
+			t.Fatalf("%s: expected %d points, got %d", tt.target, len(tt.expect), len(dps))
+		}
+		for i, dp := range dps {
+			if dp.Value != tt.expect[i] || !dp.Time.Equal(now.Add(time.Duration(i)*time.Minute)) {
+				t.Fatalf("%s: unexpected point %d: %+v", tt.target, i, dp)
+			}
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	s := memstore.New()
+	for _, target := range []string{"", `a{b="c"`, `a{b=c}`, `a{b="c}`, `a{b=~"("}`, `a{b<"1"}`} {
+		if _, err := s.GrafanaQuery(context.Background(), target, simplejson.QueryArguments{}); !errors.Is(err, simplejson.ErrBadRequest) {
+			t.Errorf("%q: expected a bad request error, got %v", target, err)
+		}
+	}
+}
+
+func TestAppendOutOfOrder(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	s := memstore.New()
+	s.Append("x", nil,
+		simplejson.DataPoint{Time: now.Add(2 * time.Second), Value: 2},
+		simplejson.DataPoint{Time: now, Value: 0},
+		simplejson.DataPoint{Time: now.Add(time.Second), Value: 1},
+		simplejson.DataPoint{Time: now, Value: 3},
+	)
+
+	dps, _ := s.GrafanaQuery(context.Background(), "x", simplejson.QueryArguments{QueryCommonArguments: simplejson.QueryCommonArguments{From: now, To: now.Add(time.Hour)}})
+	if len(dps) != 3 || dps[0].Value != 3 || dps[1].Value != 1 || dps[2].Value != 2 {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Now()
+	s := memstore.New(memstore.WithRetention(time.Hour))
+	s.Append("old", nil, simplejson.DataPoint{Time: now.Add(-2 * time.Hour), Value: 1})
+	s.Append("x", nil,
+		simplejson.DataPoint{Time: now.Add(-90 * time.Minute), Value: 1},
+		simplejson.DataPoint{Time: now.Add(-time.Minute), Value: 2},
+	)
+	if s.Len() != 1 {
+		t.Fatalf("expected expired series to be removed, got %d series", s.Len())
+	}
+
+	dps, _ := s.GrafanaQuery(context.Background(), "x", simplejson.QueryArguments{QueryCommonArguments: simplejson.QueryCommonArguments{From: now.Add(-3 * time.Hour), To: now}})
+	if len(dps) != 1 || dps[0].Value != 2 {
+		t.Fatalf("unexpected points %+v", dps)
+	}
+}
+
+func TestSearchAndTags(t *testing.T) {
+	s := testStore(time.Now())
+	ctx := context.Background()
+
+	names, _ := s.GrafanaSearch(ctx, "")
+	if len(names) != 2 || names[0] != "latency" || names[1] != "requests" {
+		t.Fatalf("unexpected search results %v", names)
+	}
+
+	gsj := simplejson.New(simplejson.WithSource(s))
+	for _, tt := range []struct{ path, body, expect string }{
+		{"/tag-keys", `{}`, `[{"type":"string","text":"__name__"},{"type":"string","text":"code"},{"type":"string","text":"host"}]`},
+		{"/tag-values", `{"key":"host"}`, `[{"text":"a"},{"text":"b"}]`},
+	} {
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(tt.body)))
+		if w.Body.String() != tt.expect {
+			t.Fatalf("%s\nexpected: %s\ngot:      %s", tt.path, tt.expect, w.Body.String())
+		}
+	}
+}
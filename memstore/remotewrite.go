@@ -0,0 +1,211 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The remote-write protocol is small enough that it is decoded by hand,
+// rather than depending on the Prometheus packages.
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// snappyDecode decodes a snappy block, as used by Prometheus
+// remote-write. The framing format is not supported.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, l := binary.Uvarint(src)
+	if l <= 0 || n > maxIngestBytes*4 {
+		return nil, errSnappyCorrupt
+	}
+	src = src[l:]
+	dst := make([]byte, 0, n)
+
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+
+		var length, offset int
+		switch tag & 0x03 {
+		case 0x00: // literal
+			length = int(tag >> 2)
+			if length >= 60 {
+				nb := length - 59
+				if len(src) < nb {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for i := nb - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[nb:]
+			}
+			length++
+			if length > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 0x01:
+			if len(src) < 1 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[0])
+			src = src[1:]
+		case 0x02:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+		case 0x03:
+			if len(src) < 4 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errSnappyCorrupt
+		}
+		// Copies may overlap the bytes they produce, so are made a
+		// byte at a time.
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
+
+type writeSeries struct {
+	labels map[string]string
+	points []simplejson.DataPoint
+}
+
+// parseWriteRequest decodes a prometheus.WriteRequest protobuf message,
+// returning its timeseries.
+func parseWriteRequest(bs []byte) ([]writeSeries, error) {
+	var res []writeSeries
+	err := parseMessage(bs, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		ws := writeSeries{labels: map[string]string{}}
+		err := parseMessage(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				return parseLabel(v, ws.labels)
+			case num == 2 && typ == protowire.BytesType:
+				dp, err := parseSample(v)
+				ws.points = append(ws.points, dp)
+				return err
+			}
+			return nil
+		})
+		res = append(res, ws)
+		return err
+	})
+	return res, err
+}
+
+func parseLabel(bs []byte, labels map[string]string) error {
+	var name, value string
+	err := parseMessage(bs, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name = string(v)
+		case 2:
+			value = string(v)
+		}
+		return nil
+	})
+	if name != "" {
+		labels[name] = value
+	}
+	return err
+}
+
+func parseSample(bs []byte) (simplejson.DataPoint, error) {
+	var dp simplejson.DataPoint
+	err := parseMessage(bs, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			dp.Value = math.Float64frombits(binary.LittleEndian.Uint64(v))
+		case num == 2 && typ == protowire.VarintType:
+			ms, _ := protowire.ConsumeVarint(v)
+			dp.Time = time.UnixMilli(int64(ms))
+		}
+		return nil
+	})
+	// Prometheus marks stale series with a special NaN, which is
+	// recorded as a null point.
+	if math.Float64bits(dp.Value) == staleNaN {
+		dp = simplejson.NullDataPoint(dp.Time)
+	}
+	return dp, err
+}
+
+// staleNaN is the value Prometheus uses to mark stale series.
+const staleNaN = 0x7ff0000000000002
+
+// parseMessage calls fn for each field of the protobuf message in bs. For
+// varint and fixed fields v holds the encoded value, and for bytes fields
+// the contents.
+func parseMessage(bs []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(bs) > 0 {
+		num, typ, n := protowire.ConsumeTag(bs)
+		if n < 0 {
+			return fmt.Errorf("invalid remote-write request, %w", protowire.ParseError(n))
+		}
+		bs = bs[n:]
+
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(bs)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, bs)
+			if n >= 0 {
+				v = bs[:n]
+			}
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid remote-write request, %w", protowire.ParseError(n))
+		}
+		bs = bs[n:]
+
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the prometheus collectors used to instrument a Handler.
+type metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+	targetDuration  *prometheus.HistogramVec
+	targetErrors    *prometheus.CounterVec
+	cacheRequests   *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "simplejson",
+				Name:      "http_requests_total",
+				Help:      "Count of HTTP requests, by endpoint and response code.",
+			},
+			[]string{"endpoint", "code"},
+		),
+		requestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "simplejson",
+				Name:      "http_request_duration_seconds",
+				Help:      "Duration of HTTP requests, by endpoint.",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"endpoint"},
+		),
+		responseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "simplejson",
+				Name:      "http_response_size_bytes",
+				Help:      "Size of HTTP responses, by endpoint.",
+				Buckets:   prometheus.ExponentialBuckets(100, 10, 7),
+			},
+			[]string{"endpoint"},
+		),
+		targetDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "simplejson",
+				Name:      "query_target_duration_seconds",
+				Help:      "Duration of individual query targets, by target type.",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"type"},
+		),
+		targetErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "simplejson",
+				Name:      "query_target_errors_total",
+				Help:      "Count of query targets that returned an error, by target type.",
+			},
+			[]string{"type"},
+		),
+		cacheRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "simplejson",
+				Name:      "cache_requests_total",
+				Help:      "Count of cache lookups, by endpoint and result.",
+			},
+			[]string{"endpoint", "result"},
+		),
+	}
+
+	for _, err := range []error{
+		register(reg, &m.requests),
+		register(reg, &m.requestDuration),
+		register(reg, &m.responseSize),
+		register(reg, &m.targetDuration),
+		register(reg, &m.targetErrors),
+		register(reg, &m.cacheRequests),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// register registers *c with reg. If an identical collector is already
+// registered, by a previous configuration of a reloaded handler for
+// example, *c is replaced by it.
+func register[C prometheus.Collector](reg prometheus.Registerer, c *C) error {
+	err := reg.Register(*c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			*c = existing
+			return nil
+		}
+	}
+	return err
+}
+
+// WithPrometheusMetrics instruments the handler, registering the metrics
+// with reg.
+func WithPrometheusMetrics(reg prometheus.Registerer) Opt {
+	return func(sjc *Handler) error {
+		m, err := newMetrics(reg)
+		if err != nil {
+			return err
+		}
+		sjc.metrics = m
+		return nil
+	}
+}
+
+// endpointName maps a request path to a label value, unknown paths are
+// grouped together to avoid unbounded label cardinality.
+func endpointName(path string) string {
+	switch path {
+	case "/", "/query", "/annotations", "/search", "/tag-keys", "/tag-values", "/variable", "/debug/parse", "/openapi.json":
+		return path
+	}
+	if isAnnotationWritePath(path) {
+		return "/annotation"
+	}
+	if strings.HasPrefix(path, "/stream/") {
+		return "/stream"
+	}
+	return "other"
+}
+
+// instrument records request metrics for all requests served by next.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		endpoint := endpointName(r.URL.Path)
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(sw.statusCode())).Inc()
+		m.requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		m.responseSize.WithLabelValues(endpoint).Observe(float64(sw.size))
+	})
+}
+
+// observeTarget records the duration and outcome of a single query target.
+// It is safe to call on a nil *metrics.
+func (m *metrics) observeTarget(typ string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if typ == "" {
+		typ = "timeserie"
+	}
+	m.targetDuration.WithLabelValues(typ).Observe(d.Seconds())
+	if err != nil {
+		m.targetErrors.WithLabelValues(typ).Inc()
+	}
+}
+
+// observeCache records the result of a cache lookup. It is safe to call
+// on a nil *metrics.
+func (m *metrics) observeCache(endpoint string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheRequests.WithLabelValues(endpointName(endpoint), result).Inc()
+}
+
+// statusResponseWriter records the status code and size of a response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sw *statusResponseWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusResponseWriter) Write(bs []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(bs)
+	sw.size += n
+	return n, err
+}
+
+func (sw *statusResponseWriter) statusCode() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
+// Flush implements http.Flusher.
+func (sw *statusResponseWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying
+// ResponseWriter.
+func (sw *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,56 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithPrometheusMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gsj := simplejson.New(
+		simplejson.WithQuerier(GSJExample{}),
+		simplejson.WithPrometheusMetrics(reg),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics, %v", err)
+	}
+
+	found := map[string]bool{}
+	for _, mf := range mfs {
+		found[mf.GetName()] = true
+		if mf.GetName() != "simplejson_http_requests_total" {
+			continue
+		}
+		m := mf.GetMetric()[0]
+		labels := map[string]string{}
+		for _, lp := range m.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["endpoint"] != "/query" || labels["code"] != "200" || m.GetCounter().GetValue() != 1 {
+			t.Errorf("unexpected request count metric %v", m)
+		}
+	}
+
+	for _, name := range []string{
+		"simplejson_http_requests_total",
+		"simplejson_http_request_duration_seconds",
+		"simplejson_http_response_size_bytes",
+		"simplejson_query_target_duration_seconds",
+	} {
+		if !found[name] {
+			t.Errorf("metric %s not found", name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Middleware wraps an http.Handler to add extra behaviour, such as
+// logging or rate limiting.
+type Middleware func(http.Handler) http.Handler
+
+// WithMiddleware adds middleware around the handlers for all endpoints.
+// Middleware is applied in the order given, the first being the outermost.
+// Middleware runs after authentication, and before response compression.
+func WithMiddleware(mws ...Middleware) Opt {
+	return func(sjc *Handler) error {
+		sjc.middleware = append(sjc.middleware, mws...)
+		return nil
+	}
+}
+
+// WithPathPrefix serves the endpoints below prefix, e.g. with a prefix of
+// "/grafana/ds1" queries are served at "/grafana/ds1/query". Requests for
+// paths outside of the prefix receive a 404.
+func WithPathPrefix(prefix string) Opt {
+	return func(sjc *Handler) error {
+		sjc.pathPrefix = strings.TrimSuffix(prefix, "/")
+		return nil
+	}
+}
+
+// stripPrefix removes prefix from the path of requests before passing
+// them to next.
+func stripPrefix(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			writeError(w, ErrNotFound, http.StatusNotFound)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = strings.TrimPrefix(p, prefix)
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		r2.URL.RawPath = ""
+
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// buildHandler assembles the chain of handlers that wrap the mux. Each
+// wrapper is applied around the previous one, so the last is the
+// outermost.
+func (h *Handler) buildHandler() http.Handler {
+	var hndlr http.Handler = h.mux
+	if h.tenantResolver != nil {
+		hndlr = http.HandlerFunc(h.serveTenant)
+	}
+	if c := h.queryCache(); c != nil {
+		hndlr = c.serve(h, hndlr)
+	}
+	if h.tenantResolver != nil {
+		hndlr = h.resolveTenant(hndlr)
+		if h.adminPrefix != "" {
+			hndlr = h.routeAdmin(hndlr)
+		}
+	}
+	if h.recorder != nil {
+		hndlr = h.recorder.record(hndlr, h.now)
+	}
+	if h.hooks != nil {
+		hndlr = h.hooks.serve(hndlr)
+	}
+	hndlr = requestContext(hndlr)
+	if h.clock != nil {
+		hndlr = h.withClock(hndlr)
+	}
+	if h.debugJSON {
+		hndlr = debugHandler(hndlr)
+	}
+
+	if h.compress {
+		hndlr = compressHandler(hndlr, h.compressMinSize)
+	}
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		hndlr = h.middleware[i](hndlr)
+	}
+	if h.limiter != nil || h.inFlight != nil {
+		hndlr = h.limit(hndlr)
+	}
+	if len(h.authSchemes) > 0 {
+		hndlr = h.authenticate(hndlr)
+	}
+	if h.cors != nil {
+		hndlr = h.cors(hndlr)
+	}
+	hndlr = h.recoverPanics(hndlr)
+	if h.tracing {
+		hndlr = h.trace(hndlr)
+	}
+	if h.metrics != nil {
+		hndlr = h.metrics.instrument(hndlr)
+	}
+	if h.pathPrefix != "" {
+		hndlr = stripPrefix(h.pathPrefix, hndlr)
+	}
+
+	return hndlr
+}
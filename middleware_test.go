@@ -0,0 +1,69 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithMiddleware(t *testing.T) {
+	var calls []string
+	mw := func(name string) simplejson.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithMiddleware(mw("first"), mw("second")),
+		simplejson.WithMiddleware(mw("third")),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	expect := []string{"first", "second", "third"}
+	if !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("expected middleware calls %v, got %v", expect, calls)
+	}
+}
+
+func TestWithPathPrefix(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(GSJExample{}),
+		simplejson.WithPathPrefix("/grafana/ds1/"),
+	)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/grafana/ds1", status: http.StatusOK},
+		{path: "/grafana/ds1/", status: http.StatusOK},
+		{path: "/grafana/ds1/search", status: http.StatusOK},
+		{path: "/grafana/ds1search", status: http.StatusNotFound},
+		{path: "/search", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, bytes.NewBufferString(`{"target": "upper_50"}`))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"strings"
+)
+
+// WithNamedQuerier registers q to answer timeserie targets with the given
+// query type, allowing one datasource to serve several distinct families
+// of query. A target is dispatched to q if its queryType is queryType, or
+// if it has no queryType and its target has the form
+// "<queryType>:<target>". In the latter case the prefix is removed from
+// the target passed to q. Targets that match no named querier are passed
+// to the default querier, if any.
+func WithNamedQuerier(queryType string, q Querier) Opt {
+	return func(sjc *Handler) error {
+		if sjc.namedQueriers == nil {
+			sjc.namedQueriers = map[string]RequestQuerier{}
+		}
+		sjc.namedQueriers[queryType] = querierAdapter{q}
+		return nil
+	}
+}
+
+// namedQuerier returns the querier registered for the query type of qr,
+// updating qr if the query type was given as a target prefix.
+func (h *Handler) namedQuerier(qr *QueryRequest) (RequestQuerier, bool) {
+	if len(h.namedQueriers) == 0 {
+		return nil, false
+	}
+	if qr.QueryType != "" {
+		q, ok := h.namedQueriers[qr.QueryType]
+		return q, ok
+	}
+	if typ, target, ok := strings.Cut(qr.Target, ":"); ok {
+		if q, ok := h.namedQueriers[typ]; ok {
+			qr.QueryType, qr.Target = typ, target
+			return q, true
+		}
+	}
+	return nil, false
+}
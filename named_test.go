@@ -0,0 +1,69 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// constQuerier returns a single datapoint with its value.
+type constQuerier float64
+
+func (cq constQuerier) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: float64(cq)}}, nil
+}
+
+func TestWithNamedQuerier(t *testing.T) {
+	var gotTarget string
+	gsj := simplejson.New(
+		simplejson.WithQuerier(constQuerier(0)),
+		simplejson.WithNamedQuerier("metrics", constQuerier(1)),
+		simplejson.WithNamedQuerier("logs", simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			gotTarget = target
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: 2}}, nil
+		})),
+		simplejson.WithMaxConcurrentTargets(1),
+	)
+
+	q := `{
+				"targets": [
+					{ "target": "cpu", "refId": "A", "queryType": "metrics" },
+					{ "target": "logs:errors", "refId": "B" },
+					{ "target": "other:cpu", "refId": "C" },
+					{ "target": "cpu", "refId": "D", "queryType": "unknown" }
+				]
+			}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"target":"cpu","refId":"A","datapoints":[[1,1000]]},` +
+		`{"target":"logs:errors","refId":"B","datapoints":[[2,1000]]},` +
+		`{"target":"other:cpu","refId":"C","datapoints":[[0,1000]]},` +
+		`{"target":"cpu","refId":"D","datapoints":[[0,1000]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+	if gotTarget != "errors" {
+		t.Fatalf("expected the query type prefix to be removed, got target %q", gotTarget)
+	}
+}
+
+func TestWithNamedQuerier_NoDefault(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithNamedQuerier("metrics", constQuerier(1)),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "cpu"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for an unmatched target, got %d", http.StatusBadRequest, w.Code)
+	}
+}
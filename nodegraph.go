@@ -0,0 +1,189 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"maps"
+	"slices"
+)
+
+// A GraphNode is a node in a node graph panel, e.g. a service. MainStat and
+// SecondaryStat are shown on the node. Arcs give the fractions, summing to
+// 1, of the coloured arc drawn around the node, keyed by the colour of each
+// section, e.g. {"red": 0.1, "green": 0.9}. Details holds further fields
+// shown when the node is selected.
+type GraphNode struct {
+	ID            string
+	Title         string
+	SubTitle      string
+	MainStat      float64
+	SecondaryStat float64
+	Color         string
+	Arcs          map[string]float64
+	Details       map[string]string
+}
+
+// A GraphEdge is a link between two nodes of a node graph panel, e.g. the
+// calls from one service to another. Source and Target are the IDs of the
+// nodes.
+type GraphEdge struct {
+	ID            string
+	Source        string
+	Target        string
+	MainStat      float64
+	SecondaryStat float64
+	Details       map[string]string
+}
+
+// A NodeGraphQuerier responds to queries from Grafana node graph panels,
+// for targets with the type "nodegraph". The nodes and edges are sent to
+// Grafana as two tables, see NodeGraphNodes and NodeGraphEdges.
+type NodeGraphQuerier interface {
+	GrafanaQueryNodeGraph(ctx context.Context, req QueryRequest) ([]GraphNode, []GraphEdge, error)
+}
+
+// WithNodeGraphQuerier adds a node graph query handler.
+func WithNodeGraphQuerier(q NodeGraphQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.nodeGraphQuery = q
+		return nil
+	}
+}
+
+// NodeGraphNodes returns the table columns of the nodes table of a node
+// graph, with the field names Grafana requires: id, title, subtitle,
+// mainstat, secondarystat, and, if any nodes set them, color, an arc__
+// column for each arc colour, and a detail__ column for each detail.
+func NodeGraphNodes(nodes []GraphNode) []TableColumn {
+	var (
+		ids       = make(TableStringColumn, len(nodes))
+		titles    = make(TableStringColumn, len(nodes))
+		subTitles = make(TableStringColumn, len(nodes))
+		mainStats = make(TableNumberColumn, len(nodes))
+		secStats  = make(TableNumberColumn, len(nodes))
+		colors    = make(TableStringColumn, len(nodes))
+		hasColor  bool
+		arcs      = map[string]TableNumberColumn{}
+		details   = map[string]TableStringColumn{}
+	)
+	for i, n := range nodes {
+		ids[i] = n.ID
+		titles[i] = n.Title
+		subTitles[i] = n.SubTitle
+		mainStats[i] = n.MainStat
+		secStats[i] = n.SecondaryStat
+		colors[i] = n.Color
+		hasColor = hasColor || n.Color != ""
+		for k, v := range n.Arcs {
+			if arcs[k] == nil {
+				arcs[k] = make(TableNumberColumn, len(nodes))
+			}
+			arcs[k][i] = v
+		}
+		for k, v := range n.Details {
+			if details[k] == nil {
+				details[k] = make(TableStringColumn, len(nodes))
+			}
+			details[k][i] = v
+		}
+	}
+
+	cols := []TableColumn{
+		{Text: "id", Data: ids},
+		{Text: "title", Data: titles},
+		{Text: "subtitle", Data: subTitles},
+		{Text: "mainstat", Data: mainStats},
+		{Text: "secondarystat", Data: secStats},
+	}
+	if hasColor {
+		cols = append(cols, TableColumn{Text: "color", Data: colors})
+	}
+	for _, k := range slices.Sorted(maps.Keys(arcs)) {
+		cols = append(cols, TableColumn{Text: "arc__" + k, Data: arcs[k]})
+	}
+	return appendDetailColumns(cols, details)
+}
+
+// NodeGraphEdges returns the table columns of the edges table of a node
+// graph, with the field names Grafana requires: id, source, target,
+// mainstat, secondarystat, and a detail__ column for each detail.
+func NodeGraphEdges(edges []GraphEdge) []TableColumn {
+	var (
+		ids       = make(TableStringColumn, len(edges))
+		sources   = make(TableStringColumn, len(edges))
+		targets   = make(TableStringColumn, len(edges))
+		mainStats = make(TableNumberColumn, len(edges))
+		secStats  = make(TableNumberColumn, len(edges))
+		details   = map[string]TableStringColumn{}
+	)
+	for i, e := range edges {
+		ids[i] = e.ID
+		sources[i] = e.Source
+		targets[i] = e.Target
+		mainStats[i] = e.MainStat
+		secStats[i] = e.SecondaryStat
+		for k, v := range e.Details {
+			if details[k] == nil {
+				details[k] = make(TableStringColumn, len(edges))
+			}
+			details[k][i] = v
+		}
+	}
+
+	cols := []TableColumn{
+		{Text: "id", Data: ids},
+		{Text: "source", Data: sources},
+		{Text: "target", Data: targets},
+		{Text: "mainstat", Data: mainStats},
+		{Text: "secondarystat", Data: secStats},
+	}
+	return appendDetailColumns(cols, details)
+}
+
+// appendDetailColumns appends a detail__ column for each of details, in
+// the order of their names.
+func appendDetailColumns(cols []TableColumn, details map[string]TableStringColumn) []TableColumn {
+	for _, k := range slices.Sorted(maps.Keys(details)) {
+		cols = append(cols, TableColumn{Text: "detail__" + k, Data: details[k]})
+	}
+	return cols
+}
+
+func (h *Handler) jsonNodeGraphQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	type nodeGraph struct {
+		nodes []GraphNode
+		edges []GraphEdge
+	}
+	keyReq := qr
+	keyReq.RefID = ""
+	resp, err := dedupe(h, ctx, "nodegraph", keyReq, func(ctx context.Context) (nodeGraph, error) {
+		nodes, edges, err := h.nodeGraphQuery.GrafanaQueryNodeGraph(ctx, qr)
+		return nodeGraph{nodes, edges}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := simpleJSONResults{}
+	for _, cols := range [][]TableColumn{NodeGraphNodes(resp.nodes), NodeGraphEdges(resp.edges)} {
+		td, err := h.tableData(target, cols)
+		if err != nil {
+			return nil, err
+		}
+		td.Meta = td.Meta.withVisualisation("nodeGraph")
+		out = append(out, td)
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithNodeGraphQuerier(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithNodeGraphQuerier(simplejson.NodeGraphQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.GraphNode, []simplejson.GraphEdge, error) {
+			return []simplejson.GraphNode{
+				{ID: "web", Title: "Web", MainStat: 10, Arcs: map[string]float64{"green": 0.9, "red": 0.1}},
+				{ID: "db", Title: "DB", MainStat: 5, Details: map[string]string{"engine": "postgres"}},
+			}, []simplejson.GraphEdge{
+				{ID: "web-db", Source: "web", Target: "db", MainStat: 5},
+			}, nil
+		})),
+	)
+
+	q := `{"targets": [{"target": "services", "refId": "A", "type": "nodegraph"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","refId":"A","columns":[` +
+		`{"text":"id","type":"string"},{"text":"title","type":"string"},{"text":"subtitle","type":"string"},` +
+		`{"text":"mainstat","type":"number"},{"text":"secondarystat","type":"number"},` +
+		`{"text":"arc__green","type":"number"},{"text":"arc__red","type":"number"},{"text":"detail__engine","type":"string"}],` +
+		`"rows":[["web","Web","",10,0,0.9,0.1,""],["db","DB","",5,0,0,0,"postgres"]],` +
+		`"meta":{"preferredVisualisationType":"nodeGraph"}},` +
+		`{"type":"table","refId":"A","columns":[` +
+		`{"text":"id","type":"string"},{"text":"source","type":"string"},{"text":"target","type":"string"},` +
+		`{"text":"mainstat","type":"number"},{"text":"secondarystat","type":"number"}],` +
+		`"rows":[["web-db","web","db",5,0]],` +
+		`"meta":{"preferredVisualisationType":"nodeGraph"}}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestNodeGraphNodes_Color(t *testing.T) {
+	cols := simplejson.NodeGraphNodes([]simplejson.GraphNode{{ID: "a"}, {ID: "b", Color: "red"}})
+	if len(cols) != 6 || cols[5].Text != "color" {
+		t.Fatalf("expected a color column, got %+v", cols)
+	}
+	if c := cols[5].Data.(simplejson.TableStringColumn); c[0] != "" || c[1] != "red" {
+		t.Fatalf("unexpected colors %v", c)
+	}
+}
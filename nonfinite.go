@@ -0,0 +1,69 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"math"
+)
+
+// NonFinitePolicy controls how NaN and infinite datapoint values, which
+// cannot be represented in JSON, are sent to Grafana.
+type NonFinitePolicy int
+
+const (
+	// NonFiniteError fails the query. This is the default.
+	NonFiniteError NonFinitePolicy = iota
+	// NonFiniteDrop omits the datapoints from the series.
+	NonFiniteDrop
+	// NonFiniteNull sends the datapoints as null.
+	NonFiniteNull
+	// NonFiniteClamp sends infinite values as the largest, or smallest,
+	// finite float64, and NaN as null.
+	NonFiniteClamp
+)
+
+// WithNonFinitePolicy sets how NaN and infinite values returned by
+// timeserie queriers are handled.
+func WithNonFinitePolicy(p NonFinitePolicy) Opt {
+	return func(sjc *Handler) error {
+		sjc.nonFinite = p
+		return nil
+	}
+}
+
+// apply returns dp with the policy applied, and whether it should be
+// included in the response.
+func (p NonFinitePolicy) apply(dp DataPoint) (DataPoint, bool, error) {
+	if dp.Null || !(math.IsNaN(dp.Value) || math.IsInf(dp.Value, 0)) {
+		return dp, true, nil
+	}
+	switch p {
+	case NonFiniteDrop:
+		return dp, false, nil
+	case NonFiniteNull:
+		return NullDataPoint(dp.Time), true, nil
+	case NonFiniteClamp:
+		switch {
+		case math.IsInf(dp.Value, 1):
+			dp.Value = math.MaxFloat64
+		case math.IsInf(dp.Value, -1):
+			dp.Value = -math.MaxFloat64
+		default:
+			dp = NullDataPoint(dp.Time)
+		}
+		return dp, true, nil
+	default:
+		return dp, false, errUnsupportedFloat(dp.Value)
+	}
+}
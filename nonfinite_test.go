@@ -0,0 +1,66 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithNonFinitePolicy(t *testing.T) {
+	points := []simplejson.DataPoint{
+		{Time: time.Unix(1, 0), Value: math.NaN()},
+		{Time: time.Unix(2, 0), Value: 2},
+		{Time: time.Unix(3, 0), Value: math.Inf(1)},
+		{Time: time.Unix(4, 0), Value: math.Inf(-1)},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy simplejson.NonFinitePolicy
+		expect string
+	}{
+		{
+			name:   "drop",
+			policy: simplejson.NonFiniteDrop,
+			expect: `[{"target":"upper_50","refId":"A","datapoints":[[2,2000]]}]`,
+		},
+		{
+			name:   "null",
+			policy: simplejson.NonFiniteNull,
+			expect: `[{"target":"upper_50","refId":"A","datapoints":[[null,1000],[2,2000],[null,3000],[null,4000]]}]`,
+		},
+		{
+			name:   "clamp",
+			policy: simplejson.NonFiniteClamp,
+			expect: `[{"target":"upper_50","refId":"A","datapoints":[[null,1000],[2,2000],[1.7976931348623157e+308,3000],[-1.7976931348623157e+308,4000]]}]`,
+		},
+	} {
+		for _, src := range []struct {
+			name string
+			opt  simplejson.Opt
+		}{
+			{"slice", simplejson.WithQuerier(seriesQuerier(points))},
+			{"iter", simplejson.WithIterQuerier(iterQuerier{points: points})},
+		} {
+			t.Run(tc.name+"/"+src.name, func(t *testing.T) {
+				gsj := simplejson.New(
+					src.opt,
+					simplejson.WithNonFinitePolicy(tc.policy),
+				)
+
+				req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+				w := httptest.NewRecorder()
+				gsj.ServeHTTP(w, req)
+
+				if w.Body.String() != tc.expect {
+					t.Fatalf("\nexpected: %q\ngot:%s", tc.expect, w.Body.String())
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"slices"
+	"time"
+)
+
+// An OHLCBar holds the open, high, low and close prices, and the volume
+// traded, over a single period starting at Time.
+type OHLCBar struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// ohlcFields are the names Grafana's candlestick panel uses to find each
+// field of a bar, in the order it expects them.
+var ohlcFields = []string{"open", "high", "low", "close", "volume"}
+
+func (b OHLCBar) fields() [5]float64 {
+	return [5]float64{b.Open, b.High, b.Low, b.Close, b.Volume}
+}
+
+// sortedBars returns bars sorted by time, without modifying bars.
+func sortedBars(bars []OHLCBar) []OHLCBar {
+	bars = slices.Clone(bars)
+	slices.SortStableFunc(bars, func(a, b OHLCBar) int { return a.Time.Compare(b.Time) })
+	return bars
+}
+
+// OHLCColumns returns the table columns for bars, sorted by time, in the
+// form the candlestick panel expects: time, open, high, low, close and
+// volume.
+func OHLCColumns(bars []OHLCBar) []TableColumn {
+	bars = sortedBars(bars)
+
+	times := make(TableTimeColumn, len(bars))
+	values := make([]TableNumberColumn, len(ohlcFields))
+	for j := range values {
+		values[j] = make(TableNumberColumn, len(bars))
+	}
+	for i, b := range bars {
+		times[i] = b.Time
+		for j, v := range b.fields() {
+			values[j][i] = v
+		}
+	}
+
+	cols := []TableColumn{{Text: "time", Data: times}}
+	for j, name := range ohlcFields {
+		cols = append(cols, TableColumn{Text: name, Data: values[j]})
+	}
+	return cols
+}
+
+// OHLCSeries returns a series for each field of bars, named open, high,
+// low, close and volume, in that order, for datasources that return a
+// timeserie per field.
+func OHLCSeries(bars []OHLCBar) []*Series {
+	bars = sortedBars(bars)
+
+	series := make([]*Series, len(ohlcFields))
+	for j, name := range ohlcFields {
+		series[j] = NewSeries(name)
+	}
+	for _, b := range bars {
+		for j, v := range b.fields() {
+			series[j].Add(b.Time, v)
+		}
+	}
+	return series
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+var ohlcTestBars = []simplejson.OHLCBar{
+	{Time: time.Unix(60, 0).UTC(), Open: 11, High: 13, Low: 10, Close: 12, Volume: 50},
+	{Time: time.Unix(0, 0).UTC(), Open: 10, High: 12, Low: 9, Close: 11, Volume: 100},
+}
+
+func TestOHLCColumns(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return simplejson.OHLCColumns(ohlcTestBars), nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "AAPL", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","columns":[{"text":"time","type":"time"},{"text":"open","type":"number"},{"text":"high","type":"number"},` +
+		`{"text":"low","type":"number"},{"text":"close","type":"number"},{"text":"volume","type":"number"}],` +
+		`"rows":[["1970-01-01T00:00:00Z",10,12,9,11,100],["1970-01-01T00:01:00Z",11,13,10,12,50]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	if !ohlcTestBars[0].Time.Equal(time.Unix(60, 0)) {
+		t.Fatalf("bars were modified")
+	}
+}
+
+func TestOHLCSeries(t *testing.T) {
+	series := simplejson.OHLCSeries(ohlcTestBars)
+
+	names := []string{"open", "high", "low", "close", "volume"}
+	if len(series) != len(names) {
+		t.Fatalf("expected %d series, got %d", len(names), len(series))
+	}
+	for i, s := range series {
+		if s.Name != names[i] {
+			t.Errorf("expected series %d to be %s, got %s", i, names[i], s.Name)
+		}
+	}
+	if pts := series[3].Points(); len(pts) != 2 || pts[0].Value != 11 || pts[1].Value != 12 {
+		t.Errorf("unexpected close prices %v", pts)
+	}
+}
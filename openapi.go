@@ -0,0 +1,382 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WithOpenAPIEndpoint serves the OpenAPI document describing the handler,
+// see Handler.OpenAPI, at /openapi.json.
+func WithOpenAPIEndpoint() Opt {
+	return func(sjc *Handler) error {
+		sjc.openAPIEndpoint = true
+		return nil
+	}
+}
+
+// openAPIObject is a JSON object in an OpenAPI document.
+type openAPIObject = map[string]interface{}
+
+func openAPIRef(name string) openAPIObject {
+	return openAPIObject{"$ref": "#/components/schemas/" + name}
+}
+
+func openAPIArray(items openAPIObject) openAPIObject {
+	return openAPIObject{"type": "array", "items": items}
+}
+
+func openAPIType(typ string) openAPIObject {
+	return openAPIObject{"type": typ}
+}
+
+func openAPIStruct(props openAPIObject, required ...string) openAPIObject {
+	s := openAPIObject{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func openAPIJSON(schema openAPIObject) openAPIObject {
+	return openAPIObject{"application/json": openAPIObject{"schema": schema}}
+}
+
+// openAPIOperation describes an operation taking, if req is not nil, a
+// JSON body, and returning a JSON response.
+func openAPIOperation(summary string, req, resp openAPIObject) openAPIObject {
+	op := openAPIObject{
+		"summary": summary,
+		"responses": openAPIObject{
+			"200":     openAPIObject{"description": "OK", "content": openAPIJSON(resp)},
+			"default": openAPIObject{"$ref": "#/components/responses/Error"},
+		},
+	}
+	if req != nil {
+		op["requestBody"] = openAPIObject{"required": true, "content": openAPIJSON(req)}
+	}
+	return op
+}
+
+// openAPISchemas holds the schemas of the request and response bodies
+// of all the endpoints.
+var openAPISchemas = openAPIObject{
+	"Error": openAPIStruct(openAPIObject{"message": openAPIType("string")}, "message"),
+	"RawRange": openAPIStruct(openAPIObject{
+		"from": openAPIType("string"),
+		"to":   openAPIType("string"),
+	}),
+	"Range": openAPIStruct(openAPIObject{
+		"from": openAPIObject{"type": "string", "format": "date-time"},
+		"to":   openAPIObject{"type": "string", "format": "date-time"},
+		"raw":  openAPIRef("RawRange"),
+	}, "from", "to"),
+	"AdhocFilter": openAPIStruct(openAPIObject{
+		"key":      openAPIType("string"),
+		"operator": openAPIType("string"),
+		"value":    openAPIType("string"),
+	}),
+	"ScopedVar": openAPIStruct(openAPIObject{
+		"text":  openAPIType("string"),
+		"value": openAPIObject{},
+	}),
+	"Target": openAPIStruct(openAPIObject{
+		"target":    openAPIType("string"),
+		"refId":     openAPIType("string"),
+		"queryType": openAPIType("string"),
+		"hide":      openAPIType("boolean"),
+		"type":      openAPIObject{"type": "string", "enum": []string{"timeserie", "table", "heatmap", "logs", "nodegraph"}},
+		"payload":   openAPIObject{},
+	}),
+	"QueryRequest": openAPIStruct(openAPIObject{
+		"panelId":       openAPIType("integer"),
+		"range":         openAPIRef("Range"),
+		"rangeRaw":      openAPIRef("RawRange"),
+		"interval":      openAPIObject{"type": "string", "example": "30s"},
+		"intervalMs":    openAPIType("integer"),
+		"targets":       openAPIArray(openAPIRef("Target")),
+		"format":        openAPIType("string"),
+		"maxDataPoints": openAPIType("integer"),
+		"adhocFilters":  openAPIArray(openAPIRef("AdhocFilter")),
+		"scopedVars":    openAPIObject{"type": "object", "additionalProperties": openAPIRef("ScopedVar")},
+		"timeout":       openAPIType("string"),
+		"timezone":      openAPIObject{"type": "string", "example": "utc"},
+	}, "targets"),
+	"TimeSeries": openAPIStruct(openAPIObject{
+		"target": openAPIType("string"),
+		"refId":  openAPIType("string"),
+		"datapoints": openAPIArray(openAPIObject{
+			"description": "A value, or null, and a time in milliseconds since the epoch.",
+			"type":        "array",
+			"items":       openAPIObject{"type": "number", "nullable": true},
+			"minItems":    2,
+			"maxItems":    2,
+		}),
+		"config": openAPIType("object"),
+		"meta":   openAPIType("object"),
+	}, "target", "datapoints"),
+	"Table": openAPIStruct(openAPIObject{
+		"type":  openAPIObject{"type": "string", "enum": []string{"table"}},
+		"refId": openAPIType("string"),
+		"columns": openAPIArray(openAPIStruct(openAPIObject{
+			"text":   openAPIType("string"),
+			"type":   openAPIType("string"),
+			"unit":   openAPIType("string"),
+			"config": openAPIType("object"),
+		}, "text")),
+		"rows": openAPIArray(openAPIArray(openAPIObject{})),
+		"meta": openAPIType("object"),
+	}, "type", "columns", "rows"),
+	"DataFrame": openAPIStruct(openAPIObject{
+		"schema": openAPIStruct(openAPIObject{
+			"name":  openAPIType("string"),
+			"refId": openAPIType("string"),
+			"meta":  openAPIType("object"),
+			"fields": openAPIArray(openAPIStruct(openAPIObject{
+				"name": openAPIType("string"),
+				"type": openAPIType("string"),
+				"typeInfo": openAPIStruct(openAPIObject{
+					"frame":    openAPIType("string"),
+					"nullable": openAPIType("boolean"),
+				}),
+				"labels": openAPIObject{"type": "object", "additionalProperties": openAPIType("string")},
+				"config": openAPIType("object"),
+			}, "name")),
+		}, "fields"),
+		"data": openAPIStruct(openAPIObject{
+			"values": openAPIArray(openAPIArray(openAPIObject{})),
+		}, "values"),
+	}, "schema", "data"),
+	"AnnotationQuery": openAPIStruct(openAPIObject{
+		"name":       openAPIType("string"),
+		"datasource": openAPIObject{},
+		"query":      openAPIType("string"),
+		"enable":     openAPIType("boolean"),
+		"iconColor":  openAPIType("string"),
+		"tags":       openAPIArray(openAPIType("string")),
+		"matchAny":   openAPIType("boolean"),
+		"limit":      openAPIType("integer"),
+	}),
+	"AnnotationsRequest": openAPIStruct(openAPIObject{
+		"range":      openAPIRef("Range"),
+		"rangeRaw":   openAPIRef("RawRange"),
+		"annotation": openAPIRef("AnnotationQuery"),
+		"timezone":   openAPIType("string"),
+	}, "range", "annotation"),
+	"Annotation": openAPIStruct(openAPIObject{
+		"annotation": openAPIRef("AnnotationQuery"),
+		"id":         openAPIType("string"),
+		"time":       openAPIObject{"type": "integer", "description": "Milliseconds since the epoch."},
+		"timeEnd":    openAPIObject{"type": "integer", "description": "Milliseconds since the epoch."},
+		"isRegion":   openAPIType("boolean"),
+		"regionId":   openAPIType("integer"),
+		"title":      openAPIType("string"),
+		"text":       openAPIType("string"),
+		"tags":       openAPIArray(openAPIType("string")),
+	}, "time", "title", "text"),
+	"AnnotationWrite": openAPIStruct(openAPIObject{
+		"time":    openAPIObject{"type": "integer", "description": "Milliseconds since the epoch."},
+		"timeEnd": openAPIObject{"type": "integer", "description": "Milliseconds since the epoch."},
+		"title":   openAPIType("string"),
+		"text":    openAPIType("string"),
+		"tags":    openAPIArray(openAPIType("string")),
+	}),
+	"AnnotationWriteResponse": openAPIStruct(openAPIObject{
+		"message": openAPIType("string"),
+		"id":      openAPIType("string"),
+	}, "message"),
+	"SearchRequest": openAPIStruct(openAPIObject{"target": openAPIType("string")}),
+	"SearchResult": openAPIObject{"oneOf": []openAPIObject{
+		openAPIType("string"),
+		openAPIRef("TextValue"),
+	}},
+	"TextValue": openAPIStruct(openAPIObject{
+		"text":  openAPIType("string"),
+		"value": openAPIObject{},
+	}, "text"),
+	"TagKey": openAPIStruct(openAPIObject{
+		"type": openAPIType("string"),
+		"text": openAPIType("string"),
+	}, "type", "text"),
+	"TagValuesRequest": openAPIStruct(openAPIObject{"key": openAPIType("string")}, "key"),
+	"TagValue":         openAPIStruct(openAPIObject{"text": openAPIType("string")}, "text"),
+	"VariableRequest": openAPIStruct(openAPIObject{
+		"payload":  openAPIObject{},
+		"range":    openAPIRef("Range"),
+		"rangeRaw": openAPIRef("RawRange"),
+		"timezone": openAPIType("string"),
+	}),
+	"Health": openAPIStruct(openAPIObject{
+		"status":    openAPIType("string"),
+		"message":   openAPIType("string"),
+		"version":   openAPIType("string"),
+		"latencyMs": openAPIType("number"),
+		"details":   openAPIObject{"type": "object", "additionalProperties": openAPIType("string")},
+	}, "status"),
+	"ParsedQuery": openAPIStruct(openAPIObject{
+		"from":          openAPIObject{"type": "string", "format": "date-time"},
+		"to":            openAPIObject{"type": "string", "format": "date-time"},
+		"rawRange":      openAPIRef("RawRange"),
+		"interval":      openAPIType("string"),
+		"maxDataPoints": openAPIType("integer"),
+		"timeout":       openAPIType("string"),
+		"timezone":      openAPIType("string"),
+		"filters":       openAPIArray(openAPIRef("AdhocFilter")),
+		"scopedVars":    openAPIObject{"type": "object", "additionalProperties": openAPIRef("ScopedVar")},
+		"targets": openAPIArray(openAPIStruct(openAPIObject{
+			"refId":     openAPIType("string"),
+			"type":      openAPIType("string"),
+			"rawTarget": openAPIType("string"),
+			"target":    openAPIType("string"),
+			"queryType": openAPIType("string"),
+			"alias":     openAPIType("string"),
+			"hidden":    openAPIType("boolean"),
+			"skipped":   openAPIType("boolean"),
+			"payload":   openAPIObject{},
+			"error":     openAPIType("string"),
+		})),
+	}),
+}
+
+// OpenAPI returns an OpenAPI 3 document, in JSON, describing the
+// endpoints served by h, and the schemas of their requests and responses.
+// Only the endpoints the handler has been configured to answer are
+// included, optional endpoints that would respond with a 404 are left
+// out.
+func (h *Handler) OpenAPI() ([]byte, error) {
+	h = h.live()
+	paths := openAPIObject{}
+
+	root := openAPIObject{
+		"summary": "Test the connection to the datasource.",
+		"responses": openAPIObject{
+			"200": openAPIObject{
+				"description": "OK",
+				"content":     openAPIObject{"text/plain": openAPIObject{"schema": openAPIType("string")}},
+			},
+		},
+	}
+	if h.health != nil {
+		root["responses"] = openAPIObject{
+			"200": openAPIObject{"description": "Healthy", "content": openAPIJSON(openAPIRef("Health"))},
+			"503": openAPIObject{"description": "Unhealthy", "content": openAPIJSON(openAPIRef("Health"))},
+		}
+	}
+	paths["/"] = openAPIObject{"get": root}
+
+	if h.hasQuerier() {
+		result := openAPIObject{"oneOf": []openAPIObject{openAPIRef("TimeSeries"), openAPIRef("Table")}}
+		if h.dataFrames {
+			result = openAPIRef("DataFrame")
+		}
+		op := openAPIOperation("Query the datasource.", openAPIRef("QueryRequest"), openAPIArray(result))
+		content := op["responses"].(openAPIObject)["200"].(openAPIObject)["content"].(openAPIObject)
+		binary := openAPIObject{"schema": openAPIObject{"type": "string", "format": "binary"}}
+		if h.arrowEncoding {
+			content[ArrowContentType] = binary
+		}
+		if h.msgpackEncoding {
+			content[MessagePackContentType] = binary
+		}
+		if h.cborEncoding {
+			content[CBORContentType] = binary
+		}
+		paths["/query"] = openAPIObject{"post": op}
+	}
+	if h.annotations != nil {
+		paths["/annotations"] = openAPIObject{"post": openAPIOperation("Query annotations.", openAPIRef("AnnotationsRequest"), openAPIArray(openAPIRef("Annotation")))}
+	}
+	if h.annotationWriter != nil {
+		idParam := []openAPIObject{{"name": "id", "in": "path", "required": true, "schema": openAPIType("string")}}
+		paths["/annotation"] = openAPIObject{
+			"post": openAPIOperation("Create an annotation.", openAPIRef("AnnotationWrite"), openAPIRef("AnnotationWriteResponse")),
+		}
+		paths["/annotation/{id}"] = openAPIObject{
+			"parameters": idParam,
+			"patch":      openAPIOperation("Update an annotation.", openAPIRef("AnnotationWrite"), openAPIRef("AnnotationWriteResponse")),
+			"delete":     openAPIOperation("Delete an annotation.", nil, openAPIRef("AnnotationWriteResponse")),
+		}
+	}
+	if h.search != nil || h.resultSearch != nil {
+		paths["/search"] = openAPIObject{"post": openAPIOperation("Search for metrics.", openAPIRef("SearchRequest"), openAPIArray(openAPIRef("SearchResult")))}
+	}
+	if h.tags != nil {
+		paths["/tag-keys"] = openAPIObject{"post": openAPIOperation("List the keys of ad hoc filters.", openAPIType("object"), openAPIArray(openAPIRef("TagKey")))}
+		paths["/tag-values"] = openAPIObject{"post": openAPIOperation("List the values of an ad hoc filter key.", openAPIRef("TagValuesRequest"), openAPIArray(openAPIRef("TagValue")))}
+	}
+	if h.variables != nil {
+		paths["/variable"] = openAPIObject{"post": openAPIOperation("Query the values of a template variable.", openAPIRef("VariableRequest"), openAPIArray(openAPIRef("TextValue")))}
+	}
+	if h.streams != nil {
+		paths["/stream/{channel}"] = openAPIObject{"get": openAPIObject{
+			"summary":    "Stream live data as server-sent events.",
+			"parameters": []openAPIObject{{"name": "channel", "in": "path", "required": true, "schema": openAPIType("string")}},
+			"responses": openAPIObject{
+				"200": openAPIObject{
+					"description": "OK",
+					"content":     openAPIObject{"text/event-stream": openAPIObject{"schema": openAPIType("string")}},
+				},
+				"default": openAPIObject{"$ref": "#/components/responses/Error"},
+			},
+		}}
+	}
+	if h.parseEndpoint {
+		paths["/debug/parse"] = openAPIObject{"post": openAPIOperation("Describe how a query would be interpreted.", openAPIRef("QueryRequest"), openAPIRef("ParsedQuery"))}
+	}
+	if h.openAPIEndpoint {
+		paths["/openapi.json"] = openAPIObject{"get": openAPIOperation("This document.", nil, openAPIType("object"))}
+	}
+
+	doc := openAPIObject{
+		"openapi": "3.0.3",
+		"info": openAPIObject{
+			"title":   "Grafana Simple JSON datasource",
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": openAPIObject{
+			"schemas": openAPISchemas,
+			"responses": openAPIObject{
+				"Error": openAPIObject{"description": "Error", "content": openAPIJSON(openAPIRef("Error"))},
+			},
+		},
+	}
+	if h.pathPrefix != "" {
+		doc["servers"] = []openAPIObject{{"url": h.pathPrefix}}
+	}
+	return json.Marshal(doc)
+}
+
+// HandleOpenAPI serves the /openapi.json endpoint, see
+// WithOpenAPIEndpoint.
+func (h *Handler) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !h.openAPIEndpoint {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	bs, err := h.OpenAPI()
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// OpenAPIHandler returns the handler for the /openapi.json endpoint.
+func (h *Handler) OpenAPIHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleOpenAPI)
+}
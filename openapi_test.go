@@ -0,0 +1,116 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// openAPIPaths returns the sorted paths of an OpenAPI document, checking
+// that every schema reference can be resolved.
+func openAPIPaths(t *testing.T, bs []byte) []string {
+	t.Helper()
+	var doc struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		t.Fatalf("invalid document, %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("expected an OpenAPI 3 document, got version %q", doc.OpenAPI)
+	}
+
+	for _, ref := range strings.Split(string(bs), `"$ref":"#/components/schemas/`)[1:] {
+		name, _, _ := strings.Cut(ref, `"`)
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("unresolved schema reference %q", name)
+		}
+	}
+
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestOpenAPI(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithOpenAPIEndpoint(),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			return nil, nil
+		})),
+		simplejson.WithMessagePackEncoding(),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	expect := []string{"/", "/openapi.json", "/query"}
+	if got := openAPIPaths(t, w.Body.Bytes()); !slices.Equal(got, expect) {
+		t.Fatalf("expected paths %v, got %v", expect, got)
+	}
+	if !strings.Contains(w.Body.String(), `"`+simplejson.MessagePackContentType+`"`) {
+		t.Errorf("expected the MessagePack encoding to be described")
+	}
+}
+
+func TestOpenAPI_AllEndpoints(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSource(GSJExample{}),
+		simplejson.WithParseEndpoint(),
+	)
+
+	bs, err := gsj.OpenAPI()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	paths := openAPIPaths(t, bs)
+	for _, p := range []string{"/", "/query", "/annotations", "/search", "/tag-keys", "/tag-values", "/debug/parse"} {
+		if !slices.Contains(paths, p) {
+			t.Errorf("expected path %s in %v", p, paths)
+		}
+	}
+	if slices.Contains(paths, "/openapi.json") {
+		t.Errorf("did not expect /openapi.json to be described")
+	}
+}
+
+func TestOpenAPI_Disabled(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	simplejson.New().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
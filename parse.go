@@ -0,0 +1,134 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WithParseEndpoint enables the /debug/parse endpoint. A query request
+// posted to the endpoint, as Grafana would post it to /query, is not
+// run. Instead the response describes the arguments each target would be
+// passed to the handler's queriers, after the range has been resolved,
+// and macros and aliases applied. This helps diagnose why a querier is
+// being given unexpected arguments.
+func WithParseEndpoint() Opt {
+	return func(sjc *Handler) error {
+		sjc.parseEndpoint = true
+		return nil
+	}
+}
+
+// parsedQuery is the response of the /debug/parse endpoint.
+type parsedQuery struct {
+	From          time.Time            `json:"from"`
+	To            time.Time            `json:"to"`
+	RawRange      RawRange             `json:"rawRange"`
+	Interval      string               `json:"interval"`
+	MaxDataPoints int                  `json:"maxDataPoints"`
+	Timeout       string               `json:"timeout,omitempty"`
+	Timezone      string               `json:"timezone,omitempty"`
+	Filters       []QueryAdhocFilter   `json:"filters"`
+	ScopedVars    map[string]ScopedVar `json:"scopedVars,omitempty"`
+	Targets       []parsedTarget       `json:"targets"`
+}
+
+// parsedTarget describes a single target of a parsed query.
+type parsedTarget struct {
+	RefID     string          `json:"refId,omitempty"`
+	Type      string          `json:"type"`
+	RawTarget string          `json:"rawTarget"`
+	Target    string          `json:"target"`
+	QueryType string          `json:"queryType,omitempty"`
+	Alias     string          `json:"alias,omitempty"`
+	Hidden    bool            `json:"hidden,omitempty"`
+	Skipped   bool            `json:"skipped,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// HandleDebugParse serves the /debug/parse endpoint, see
+// WithParseEndpoint.
+func (h *Handler) HandleDebugParse(w http.ResponseWriter, r *http.Request) {
+	if !h.parseEndpoint {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	req := simpleJSONQuery{}
+	if err := h.decodeRequest(w, r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := h.resolveRange(&req.Range, req.RangeRaw, req.Timezone); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	resp := parsedQuery{
+		From:          time.Time(req.Range.From),
+		To:            time.Time(req.Range.To),
+		RawRange:      RawRange(req.RangeRaw),
+		Interval:      time.Duration(req.Interval).String(),
+		MaxDataPoints: req.MaxDataPoints,
+		Filters:       req.AdhocFilters,
+		ScopedVars:    req.ScopedVars,
+		Timezone:      req.Timezone,
+		Targets:       make([]parsedTarget, 0, len(req.Targets)),
+	}
+	if req.Timeout > 0 {
+		resp.Timeout = time.Duration(req.Timeout).String()
+	}
+
+	for _, target := range req.Targets {
+		pt := parsedTarget{
+			RefID:     target.RefID,
+			Type:      target.Type,
+			RawTarget: target.Target,
+			Hidden:    target.Hide,
+			Skipped:   target.Hide && !h.includeHidden,
+			Payload:   target.Payload,
+		}
+		if pt.Type == "" {
+			pt.Type = "timeserie"
+		}
+
+		qr := queryRequest(req, target)
+		if err := h.expandMacros(&qr); err != nil {
+			pt.Error = err.Error()
+		}
+		pt.Alias, _ = h.parseAlias(&qr)
+		if pt.Type == "timeserie" {
+			h.namedQuerier(&qr)
+		}
+		pt.Target, pt.QueryType = qr.Target, qr.QueryType
+
+		resp.Targets = append(resp.Targets, pt)
+	}
+
+	bs, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// DebugParseHandler returns the handler for the /debug/parse endpoint.
+func (h *Handler) DebugParseHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleDebugParse)
+}
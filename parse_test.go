@@ -0,0 +1,71 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithParseEndpoint(),
+		simplejson.WithMacros(nil),
+		simplejson.WithAliases(),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			t.Fatalf("querier should not be called")
+			return nil, nil
+		})),
+	)
+
+	body := `{
+  "range": {"from": "2016-10-31T06:33:44.866Z", "to": "2016-10-31T12:33:44.866Z"},
+  "rangeRaw": {"from": "now-6h", "to": "now"},
+  "interval": "30s",
+  "maxDataPoints": 550,
+  "adhocFilters": [{"key": "City", "operator": "=", "value": "Berlin"}],
+  "targets": [
+    {"target": "alias(cpu.$__interval, \"$0\")", "refId": "A"},
+    {"target": "mem", "refId": "B", "type": "table", "hide": true}
+  ]
+}`
+	req := httptest.NewRequest(http.MethodPost, "/debug/parse", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `{"from":"2016-10-31T06:33:44.866Z","to":"2016-10-31T12:33:44.866Z","rawRange":{"from":"now-6h","to":"now"},"interval":"30s","maxDataPoints":550,` +
+		`"filters":[{"key":"City","operator":"=","value":"Berlin"}],"targets":[` +
+		`{"refId":"A","type":"timeserie","rawTarget":"alias(cpu.$__interval, \"$0\")","target":"cpu.30s","alias":"$0"},` +
+		`{"refId":"B","type":"table","rawTarget":"mem","target":"mem","hidden":true,"skipped":true}]}`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestParseEndpoint_Disabled(t *testing.T) {
+	gsj := simplejson.New()
+
+	req := httptest.NewRequest(http.MethodPost, "/debug/parse", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import "sync"
+
+// A DataPointReleaser is a Querier or RequestQuerier that is told when
+// the handler has finished with the datapoints it returned, allowing the
+// slice to be reused for later queries. ReleaseDataPoints is called once
+// for each slice returned, after the response has been written. The
+// handler holds no reference to the slice after the call.
+//
+// Since the results of deduplicated queries are shared between requests,
+// datapoints are never released when WithQueryDeduplication is used.
+type DataPointReleaser interface {
+	ReleaseDataPoints(dps []DataPoint)
+}
+
+// dataPointReleaser returns the DataPointReleaser for q, if it has one.
+func dataPointReleaser(q RequestQuerier) (DataPointReleaser, bool) {
+	if qa, ok := q.(querierAdapter); ok {
+		r, ok := qa.q.(DataPointReleaser)
+		return r, ok
+	}
+	r, ok := q.(DataPointReleaser)
+	return r, ok
+}
+
+// A DataPointPool holds datapoint slices for reuse. Embedding a
+// *DataPointPool in a Querier makes it a DataPointReleaser, so slices
+// taken from the pool with Get are returned to it once the response has
+// been written. The zero value is ready to use.
+type DataPointPool struct {
+	pool sync.Pool
+}
+
+// Get returns an empty slice with a capacity of at least n.
+func (p *DataPointPool) Get(n int) []DataPoint {
+	if dps, ok := p.pool.Get().(*[]DataPoint); ok && cap(*dps) >= n {
+		return (*dps)[:0]
+	}
+	return make([]DataPoint, 0, n)
+}
+
+// ReleaseDataPoints returns dps to the pool.
+func (p *DataPointPool) ReleaseDataPoints(dps []DataPoint) {
+	if cap(dps) == 0 {
+		return
+	}
+	dps = dps[:0]
+	p.pool.Put(&dps)
+}
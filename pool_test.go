@@ -0,0 +1,85 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type poolTestQuerier struct {
+	*simplejson.DataPointPool
+	released atomic.Int32
+}
+
+func (q *poolTestQuerier) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	dps := q.Get(2)
+	return append(dps, simplejson.DataPoint{Time: time.Unix(1, 0), Value: 1}), nil
+}
+
+func (q *poolTestQuerier) ReleaseDataPoints(dps []simplejson.DataPoint) {
+	q.released.Add(1)
+	q.DataPointPool.ReleaseDataPoints(dps)
+}
+
+func TestDataPointReleaser(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []simplejson.Opt
+		expect int32
+	}{
+		{"released", nil, 2},
+		{"dataframes", []simplejson.Opt{simplejson.WithDataFrames()}, 2},
+		{"deduplicated", []simplejson.Opt{simplejson.WithQueryDeduplication()}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &poolTestQuerier{DataPointPool: &simplejson.DataPointPool{}}
+			gsj := simplejson.New(append([]simplejson.Opt{simplejson.WithQuerier(q)}, tt.opts...)...)
+
+			body := `{"targets":[{"target":"a","refId":"A"},{"target":"b","refId":"B"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+			}
+			if got := q.released.Load(); got != tt.expect {
+				t.Fatalf("expected %d releases, got %d", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestDataPointPool(t *testing.T) {
+	var p simplejson.DataPointPool
+	dps := p.Get(10)
+	if len(dps) != 0 || cap(dps) < 10 {
+		t.Fatalf("unexpected slice len %d, cap %d", len(dps), cap(dps))
+	}
+	dps = append(dps, simplejson.DataPoint{Value: 1})
+	p.ReleaseDataPoints(dps)
+
+	if dps := p.Get(5); len(dps) != 0 || cap(dps) < 5 {
+		t.Fatalf("unexpected slice len %d, cap %d", len(dps), cap(dps))
+	}
+}
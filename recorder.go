@@ -0,0 +1,292 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxRecordedBody is the largest request or response body that will be
+// saved by the recorder. Longer bodies are truncated.
+const maxRecordedBody = 1 << 20
+
+// redactedHeaders are not saved by the recorder, as they may hold
+// credentials.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// A Recording holds a single request received by a handler, and the
+// response that was sent.
+type Recording struct {
+	Time          time.Time        `json:"time"`
+	Duration      time.Duration    `json:"duration"`
+	Method        string           `json:"method"`
+	URL           string           `json:"url"`
+	Header        http.Header      `json:"header,omitempty"`
+	Body          string           `json:"body,omitempty"`
+	BodyTruncated bool             `json:"bodyTruncated,omitempty"`
+	Response      RecordedResponse `json:"response"`
+}
+
+// A RecordedResponse is the response to a recorded request.
+type RecordedResponse struct {
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header,omitempty"`
+	Body          string      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"bodyTruncated,omitempty"`
+}
+
+// WithRecorder saves every request received by the handler, and the
+// response sent, as a JSON file in dir. The recordings can be read with
+// ReadRecordings, and sent to a handler again with Replay, allowing
+// reports of unexpected results from dashboard users to be reproduced.
+// Credentials in the Authorization and Cookie headers are not saved.
+// Requests rejected before reaching the endpoints, by authentication or
+// rate limiting, are not recorded.
+func WithRecorder(dir string) Opt {
+	return func(sjc *Handler) error {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		sjc.recorder = &recorder{dir: dir}
+		return nil
+	}
+}
+
+type recorder struct {
+	dir string
+	seq atomic.Uint64
+}
+
+func (rec *recorder) record(next http.Handler, now func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		at, start := now(), time.Now()
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+			if err != nil {
+				writeError(w, err, http.StatusBadRequest)
+				return
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		uri := r.RequestURI
+		if uri == "" {
+			uri = r.URL.RequestURI()
+		}
+		rc := Recording{
+			Time:     at,
+			Duration: time.Since(start),
+			Method:   r.Method,
+			URL:      uri,
+			Header:   redactHeader(r.Header),
+			Response: RecordedResponse{
+				Status:        rw.statusCode(),
+				Header:        redactHeader(w.Header()),
+				Body:          rw.body.String(),
+				BodyTruncated: rw.truncated,
+			},
+		}
+		rc.Body, rc.BodyTruncated = truncateBody(body)
+
+		if err := rec.save(rc); err != nil {
+			log.Printf("simplejson: recording %s: %v", r.URL.Path, err)
+		}
+	})
+}
+
+func (rec *recorder) save(rc Recording) error {
+	bs, err := json.MarshalIndent(rc, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%06d.json", rc.Time.UTC().Format("20060102T150405.000000000Z"), rec.seq.Add(1))
+	return os.WriteFile(filepath.Join(rec.dir, name), bs, 0o644)
+}
+
+func truncateBody(bs []byte) (string, bool) {
+	if len(bs) > maxRecordedBody {
+		return string(bs[:maxRecordedBody]), true
+	}
+	return string(bs), false
+}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range redactedHeaders {
+		h.Del(k)
+	}
+	if len(h) == 0 {
+		return nil
+	}
+	return h
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// recordingResponseWriter keeps a copy of the response written by a
+// handler.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(bs []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if n := maxRecordedBody - rw.body.Len(); n < len(bs) {
+		rw.body.Write(bs[:max(n, 0)])
+		rw.truncated = true
+	} else {
+		rw.body.Write(bs)
+	}
+	return rw.ResponseWriter.Write(bs)
+}
+
+func (rw *recordingResponseWriter) statusCode() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Flush implements http.Flusher.
+func (rw *recordingResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying
+// ResponseWriter.
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// ReadRecordings reads the recordings saved by WithRecorder in dir, in
+// the order in which the requests were received.
+func ReadRecordings(dir string) ([]Recording, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	recs := make([]Recording, 0, len(names))
+	for _, name := range names {
+		bs, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var rc Recording
+		if err := json.Unmarshal(bs, &rc); err != nil {
+			return nil, fmt.Errorf("reading %s, %w", name, err)
+		}
+		recs = append(recs, rc)
+	}
+	return recs, nil
+}
+
+// Request returns a new request equivalent to the recorded one.
+func (rc Recording) Request() (*http.Request, error) {
+	req, err := http.NewRequest(rc.Method, rc.URL, strings.NewReader(rc.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = rc.URL
+	req.Header = rc.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	return req, nil
+}
+
+// A ReplayResult holds the response to a replayed request, alongside the
+// original recording.
+type ReplayResult struct {
+	Recording Recording
+	Response  RecordedResponse
+}
+
+// Changed reports whether the status or body of the response differ from
+// those recorded.
+func (rr ReplayResult) Changed() bool {
+	return rr.Recording.Response.Status != rr.Response.Status ||
+		rr.Recording.Response.Body != rr.Response.Body
+}
+
+// Replay sends each of the recorded requests to h, returning the
+// responses. Since credentials are not recorded, h should not require
+// authentication. If h is a Handler given a SimulatedClock with
+// WithClock, the clock is set to the time of each recording before it is
+// replayed, so that relative time ranges resolve as they did originally.
+func Replay(h http.Handler, recs []Recording) ([]ReplayResult, error) {
+	var clock *SimulatedClock
+	if sjh, ok := h.(*Handler); ok {
+		clock, _ = sjh.clock.(*SimulatedClock)
+	}
+
+	res := make([]ReplayResult, 0, len(recs))
+	for _, rc := range recs {
+		req, err := rc.Request()
+		if err != nil {
+			return nil, err
+		}
+		if clock != nil {
+			clock.Set(rc.Time)
+		}
+		rw := &recordingResponseWriter{ResponseWriter: &discardResponseWriter{header: http.Header{}}}
+		h.ServeHTTP(rw, req)
+
+		res = append(res, ReplayResult{
+			Recording: rc,
+			Response: RecordedResponse{
+				Status:        rw.statusCode(),
+				Header:        rw.Header(),
+				Body:          rw.body.String(),
+				BodyTruncated: rw.truncated,
+			},
+		})
+	}
+	return res, nil
+}
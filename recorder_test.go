@@ -0,0 +1,102 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	value := 1.0
+	src := simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+		return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: value}}, nil
+	})
+	gsj := simplejson.New(
+		simplejson.WithQuerier(src),
+		simplejson.WithRecorder(dir),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Grafana-User", "bob")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d, %s", w.Code, w.Body.String())
+	}
+
+	recs, err := simplejson.ReadRecordings(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 recording, got %d", len(recs))
+	}
+	rc := recs[0]
+	if rc.Method != http.MethodPost || rc.URL != "/query" || rc.Body != encodeTestQuery {
+		t.Fatalf("unexpected request recorded: %+v", rc)
+	}
+	if rc.Header.Get("Authorization") != "" {
+		t.Fatalf("expected credentials not to be recorded")
+	}
+	if rc.Header.Get("X-Grafana-User") != "bob" {
+		t.Fatalf("expected headers to be recorded, got %v", rc.Header)
+	}
+	if rc.Response.Status != http.StatusOK || rc.Response.Body != w.Body.String() {
+		t.Fatalf("unexpected response recorded: %+v", rc.Response)
+	}
+
+	replay := simplejson.New(simplejson.WithQuerier(src))
+	res, err := simplejson.Replay(replay, recs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res[0].Changed() {
+		t.Fatalf("expected replayed response to match, got %s", res[0].Response.Body)
+	}
+
+	value = 2
+	if res, _ = simplejson.Replay(replay, recs); !res[0].Changed() {
+		t.Fatalf("expected replayed response to differ")
+	}
+}
+
+func TestRecorder_Order(t *testing.T) {
+	dir := t.TempDir()
+	gsj := simplejson.New(simplejson.WithRecorder(dir))
+
+	for _, path := range []string{"/", "/search", "/tag-keys"} {
+		gsj.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	recs, err := simplejson.ReadRecordings(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, rc := range recs {
+		got = append(got, rc.URL)
+	}
+	if len(got) != 3 || got[0] != "/" || got[1] != "/search" || got[2] != "/tag-keys" {
+		t.Fatalf("unexpected recordings %v", got)
+	}
+}
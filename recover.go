@@ -0,0 +1,106 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicHandler is called when serving a request panics, with the value
+// passed to panic and the stack trace of the panicking goroutine.
+type PanicHandler func(r *http.Request, v interface{}, stack []byte)
+
+// WithPanicHandler sets the function called when a handler panics. By
+// default the panic and its stack trace are logged. The client always
+// receives a 500 response with a JSON error message. Passing nil disables
+// recovery, leaving panics to net/http.
+func WithPanicHandler(ph PanicHandler) Opt {
+	return func(sjc *Handler) error {
+		sjc.panicHandler = ph
+		return nil
+	}
+}
+
+func logPanic(r *http.Request, v interface{}, stack []byte) {
+	log.Printf("simplejson: panic serving %s: %v\n%s", r.URL.Path, v, stack)
+}
+
+// panicError carries a panic recovered in one goroutine to the goroutine
+// serving the request, where it is raised again.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (pe *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.value)
+}
+
+// catchPanic recovers a panic, storing it in *err. It must be deferred
+// directly.
+func catchPanic(err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if pe, ok := v.(*panicError); ok {
+		*err = pe
+		return
+	}
+	*err = &panicError{value: v, stack: debug.Stack()}
+}
+
+// repanic raises err again if it is a recovered panic.
+func repanic(err error) {
+	if pe, ok := err.(*panicError); ok {
+		panic(pe)
+	}
+}
+
+// recoverPanics converts panics in next into a 500 response, reporting
+// them to the panic handler.
+func (h *Handler) recoverPanics(next http.Handler) http.Handler {
+	if h.panicHandler == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			stack := debug.Stack()
+			if pe, ok := v.(*panicError); ok {
+				v, stack = pe.value, pe.stack
+			}
+			h.panicHandler(r, v, stack)
+
+			if sw.status != 0 {
+				// Part of the response has been sent, all we can do is
+				// abort it.
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, fmt.Errorf("internal error: %v", v), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
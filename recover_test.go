@@ -0,0 +1,104 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type panicSearcher struct{}
+
+func (panicSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	panic("search exploded")
+}
+
+func TestRecoverPanic(t *testing.T) {
+	var (
+		gotValue interface{}
+		gotStack []byte
+	)
+	gsj := simplejson.New(
+		simplejson.WithSearcher(panicSearcher{}),
+		simplejson.WithPanicHandler(func(r *http.Request, v interface{}, stack []byte) {
+			gotValue, gotStack = v, stack
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": ""}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	expect := `{"message":"internal error: search exploded"}`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+	if gotValue != "search exploded" {
+		t.Fatalf("panic handler got value %v", gotValue)
+	}
+	if !strings.Contains(string(gotStack), "GrafanaSearch") {
+		t.Fatalf("stack trace does not include the panicking function\n%s", gotStack)
+	}
+}
+
+func TestRecoverTargetPanic(t *testing.T) {
+	panicky := querierFunc(func(ctx context.Context) {
+		panic("query exploded")
+	})
+
+	for _, tc := range []struct {
+		name string
+		opts []simplejson.Opt
+	}{
+		{name: "default"},
+		{name: "timeout", opts: []simplejson.Opt{simplejson.WithQueryTimeout(time.Minute)}},
+		{name: "dedupe", opts: []simplejson.Opt{simplejson.WithQueryDeduplication()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotStack []byte
+			opts := append([]simplejson.Opt{
+				simplejson.WithQuerier(panicky),
+				simplejson.WithPanicHandler(func(r *http.Request, v interface{}, stack []byte) {
+					gotStack = stack
+				}),
+			}, tc.opts...)
+			gsj := simplejson.New(opts...)
+
+			req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			expect := `{"message":"internal error: query exploded"}`
+			if w.Code != http.StatusInternalServerError || w.Body.String() != expect {
+				t.Fatalf("\nexpected: %d %q\ngot: %d %s", http.StatusInternalServerError, expect, w.Code, w.Body.String())
+			}
+			if !strings.Contains(string(gotStack), "TestRecoverTargetPanic") {
+				t.Fatalf("stack trace is not from the panicking goroutine\n%s", gotStack)
+			}
+		})
+	}
+}
+
+func TestRecoverDisabled(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(panicSearcher{}),
+		simplejson.WithPanicHandler(nil),
+	)
+
+	defer func() {
+		if v := recover(); v != "search exploded" {
+			t.Fatalf("expected the panic to propagate, got %v", v)
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewBufferString(`{"target": ""}`))
+	gsj.ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry serves several independent datasources from a single
+// http.Handler. Each datasource is a Handler, with its own configuration,
+// served below a distinct path prefix.
+type Registry struct {
+	mu      sync.RWMutex
+	sources map[string]http.Handler
+	// prefixes is kept sorted longest first, so that the most specific
+	// prefix is matched.
+	prefixes []string
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		sources: map[string]http.Handler{},
+	}
+}
+
+// Register serves h below prefix, e.g. with a prefix of "/cpu" h's query
+// endpoint is served at "/cpu/query".
+func (reg *Registry) Register(prefix string, h *Handler) error {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		return fmt.Errorf("invalid datasource prefix %q", prefix)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, ok := reg.sources[prefix]; ok {
+		return fmt.Errorf("datasource already registered at %q", prefix)
+	}
+
+	reg.sources[prefix] = stripPrefix(prefix, h)
+	reg.prefixes = append(reg.prefixes, prefix)
+	sort.Slice(reg.prefixes, func(i, j int) bool {
+		return len(reg.prefixes[i]) > len(reg.prefixes[j])
+	})
+
+	return nil
+}
+
+// Prefixes returns the prefixes of all the registered datasources.
+func (reg *Registry) Prefixes() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	ps := append([]string(nil), reg.prefixes...)
+	sort.Strings(ps)
+	return ps
+}
+
+// ServeHTTP dispatches requests to the datasource registered with the
+// longest matching prefix.
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.RLock()
+	var h http.Handler
+	for _, p := range reg.prefixes {
+		if r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+			h = reg.sources[p]
+			break
+		}
+	}
+	reg.mu.RUnlock()
+
+	if h == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,53 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type staticSearcher []string
+
+func (ss staticSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return ss, nil
+}
+
+func TestRegistry(t *testing.T) {
+	reg := simplejson.NewRegistry()
+	if err := reg.Register("/cpu/", simplejson.New(simplejson.WithSearcher(staticSearcher{"cpu"}))); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register("/cpu/billing", simplejson.New(simplejson.WithSearcher(staticSearcher{"billing"}))); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register("/cpu", simplejson.New()); err == nil {
+		t.Fatalf("expected error registering duplicate prefix")
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "/cpu/search", status: http.StatusOK, body: `["cpu"]`},
+		{path: "/cpu/billing/search", status: http.StatusOK, body: `["billing"]`},
+		{path: "/memory/search", status: http.StatusNotFound, body: `{"message":"not found"}`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, bytes.NewBufferString(`{"target": ""}`))
+		w := httptest.NewRecorder()
+		reg.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, w.Code)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s:\nexpected: %q\ngot:%s", tt.path, tt.body, w.Body.String())
+		}
+	}
+}
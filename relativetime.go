@@ -0,0 +1,213 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithResolvedRawRange computes the absolute time range passed to
+// handlers from the raw range of the request, e.g. "now-6h" to "now",
+// using the server's clock, rather than using the absolute range
+// calculated by Grafana. Requests that include only a raw range are
+// always resolved this way.
+func WithResolvedRawRange() Opt {
+	return func(sjc *Handler) error {
+		sjc.resolveRawRange = true
+		return nil
+	}
+}
+
+// resolveRange sets the absolute range of rng from its raw range, if it
+// has none or the handler is configured to resolve raw ranges. Times are
+// rounded in the dashboard's timezone, tz, if it is known.
+func (h *Handler) resolveRange(rng *simpleJSONRange, raw simpleJSONRawRange, tz string) error {
+	if raw == (simpleJSONRawRange{}) {
+		raw = rng.Raw
+	}
+	if raw == (simpleJSONRawRange{}) {
+		return nil
+	}
+	if !h.resolveRawRange && !time.Time(rng.From).IsZero() && !time.Time(rng.To).IsZero() {
+		return nil
+	}
+
+	now := h.now()
+	if loc := requestLocation(tz); loc != nil {
+		now = now.In(loc)
+	}
+	from, to, err := RawRange(raw).Resolve(now)
+	if err != nil {
+		return Error{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	rng.From, rng.To = simpleJSONTime(from), simpleJSONTime(to)
+	return nil
+}
+
+// Resolve returns the absolute times of the range, relative to now. See
+// ParseRelativeTime. As in Grafana, a rounded To time is rounded up to
+// the end of the unit, so that "now/d" to "now/d" is the whole of today.
+func (r RawRange) Resolve(now time.Time) (from, to time.Time, err error) {
+	from, err = parseRelativeTime(r.From, now, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err = parseRelativeTime(r.To, now, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
+// ParseRelativeTime parses a time as given in Grafana's time picker,
+// relative to now. Relative times start with "now", followed by any
+// number of offsets, such as "-6h" or "+1d", and roundings, such as "/d",
+// which round down to the start of the unit. The units are s, m, h, d, w
+// (weeks starting on Monday), M (months) and y. For example "now-1d/d" is
+// the start of yesterday. Absolute times may be given as RFC 3339 times,
+// dates in the form 2006-01-02 or 2006-01-02 15:04:05, or milliseconds
+// since the epoch, and are interpreted in the location of now.
+func ParseRelativeTime(s string, now time.Time) (time.Time, error) {
+	return parseRelativeTime(s, now, false)
+}
+
+func parseRelativeTime(s string, now time.Time, roundUp bool) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	expr, ok := strings.CutPrefix(s, "now")
+	if !ok {
+		return parseAbsoluteTime(s, now.Location())
+	}
+
+	invalid := fmt.Errorf("invalid relative time %q", s)
+	t := now
+	for len(expr) > 0 {
+		op := expr[0]
+		expr = expr[1:]
+		switch op {
+		case '/':
+			if len(expr) == 0 {
+				return time.Time{}, invalid
+			}
+			var ok bool
+			t, ok = roundTime(t, expr[0], roundUp)
+			if !ok {
+				return time.Time{}, invalid
+			}
+			expr = expr[1:]
+		case '+', '-':
+			i := 0
+			for i < len(expr) && expr[i] >= '0' && expr[i] <= '9' {
+				i++
+			}
+			n := 1
+			if i > 0 {
+				var err error
+				if n, err = strconv.Atoi(expr[:i]); err != nil {
+					return time.Time{}, invalid
+				}
+			}
+			if i >= len(expr) {
+				return time.Time{}, invalid
+			}
+			if op == '-' {
+				n = -n
+			}
+			var ok bool
+			t, ok = addTime(t, expr[i], n)
+			if !ok {
+				return time.Time{}, invalid
+			}
+			expr = expr[i+1:]
+		default:
+			return time.Time{}, invalid
+		}
+	}
+	return t, nil
+}
+
+// parseAbsoluteTime parses the absolute time formats accepted by
+// ParseRelativeTime.
+func parseAbsoluteTime(s string, loc *time.Location) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms).In(loc), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// addTime adds n of the given unit to t.
+func addTime(t time.Time, unit byte, n int) (time.Time, bool) {
+	switch unit {
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), true
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), true
+	case 'h':
+		return t.Add(time.Duration(n) * time.Hour), true
+	case 'd':
+		return t.AddDate(0, 0, n), true
+	case 'w':
+		return t.AddDate(0, 0, 7*n), true
+	case 'M':
+		return t.AddDate(0, n, 0), true
+	case 'y':
+		return t.AddDate(n, 0, 0), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// roundTime rounds t down to the start of the given unit, or, if up is
+// set, up to the last millisecond of the unit.
+func roundTime(t time.Time, unit byte, up bool) (time.Time, bool) {
+	y, mo, d := t.Date()
+	h, mi, s := t.Clock()
+	loc := t.Location()
+
+	var start time.Time
+	switch unit {
+	case 's':
+		start = time.Date(y, mo, d, h, mi, s, 0, loc)
+	case 'm':
+		start = time.Date(y, mo, d, h, mi, 0, 0, loc)
+	case 'h':
+		start = time.Date(y, mo, d, h, 0, 0, 0, loc)
+	case 'd':
+		start = time.Date(y, mo, d, 0, 0, 0, 0, loc)
+	case 'w':
+		start = time.Date(y, mo, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case 'M':
+		start = time.Date(y, mo, 1, 0, 0, 0, 0, loc)
+	case 'y':
+		start = time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Time{}, false
+	}
+	if !up {
+		return start, true
+	}
+	end, _ := addTime(start, unit, 1)
+	return end.Add(-time.Millisecond), true
+}
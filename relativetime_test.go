@@ -0,0 +1,121 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestParseRelativeTime(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2016, 11, 2, 13, 45, 30, 0, time.UTC)
+
+	for _, tc := range []struct {
+		in     string
+		expect time.Time
+	}{
+		{"now", now},
+		{"now-6h", now.Add(-6 * time.Hour)},
+		{"now+5m", now.Add(5 * time.Minute)},
+		{"now-30s", now.Add(-30 * time.Second)},
+		{"now-1d/d", time.Date(2016, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/w", time.Date(2016, 10, 31, 0, 0, 0, 0, time.UTC)},
+		{"now-1M/M", time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/y", time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"now-2y", time.Date(2014, 11, 2, 13, 45, 30, 0, time.UTC)},
+		{"1478094330000", now},
+		{"2016-11-02T13:45:30Z", now},
+		{"2016-11-02", time.Date(2016, 11, 2, 0, 0, 0, 0, time.UTC)},
+	} {
+		got, err := simplejson.ParseRelativeTime(tc.in, now)
+		if err != nil {
+			t.Errorf("ParseRelativeTime(%q): unexpected error %v", tc.in, err)
+			continue
+		}
+		if !got.Equal(tc.expect) {
+			t.Errorf("ParseRelativeTime(%q)\nexpected: %v\ngot: %v", tc.in, tc.expect, got)
+		}
+	}
+
+	for _, in := range []string{"now-", "now-6", "now-6x", "now/", "now*2", "yesterday"} {
+		if _, err := simplejson.ParseRelativeTime(in, now); err == nil {
+			t.Errorf("ParseRelativeTime(%q): expected an error", in)
+		}
+	}
+}
+
+func TestRawRangeResolve(t *testing.T) {
+	now := time.Date(2016, 11, 2, 13, 45, 30, 0, time.UTC)
+	from, to, err := simplejson.RawRange{From: "now/d", To: "now/d"}.Resolve(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := time.Date(2016, 11, 2, 0, 0, 0, 0, time.UTC); !from.Equal(expect) {
+		t.Errorf("expected from %v, got %v", expect, from)
+	}
+	if expect := time.Date(2016, 11, 2, 23, 59, 59, int(999*time.Millisecond), time.UTC); !to.Equal(expect) {
+		t.Errorf("expected to %v, got %v", expect, to)
+	}
+}
+
+func TestResolvedRawRange(t *testing.T) {
+	rr := &requestRecorder{}
+	gsj := simplejson.New(
+		simplejson.WithRequestQuerier(rr),
+	)
+
+	// A request with only a raw range is resolved on the server.
+	q := `{
+				"rangeRaw": { "from": "now-1h", "to": "now" },
+				"targets": [{ "target": "upper_50" }]
+			}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	start := time.Now()
+	gsj.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(rr.reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rr.reqs))
+	}
+	got := rr.reqs[0]
+	if got.To.Before(start) || got.To.Sub(got.From) != time.Hour {
+		t.Fatalf("unexpected resolved range %v to %v", got.From, got.To)
+	}
+	if got.RawRange != (simplejson.RawRange{From: "now-1h", To: "now"}) {
+		t.Fatalf("unexpected raw range %#v", got.RawRange)
+	}
+
+	// With WithResolvedRawRange the absolute range from Grafana is
+	// ignored.
+	var args simplejson.AnnotationsArguments
+	gsj = simplejson.New(
+		simplejson.WithResolvedRawRange(),
+		simplejson.WithAnnotator(simplejson.AnnotatorFunc(func(ctx context.Context, query string, a simplejson.AnnotationsArguments) ([]simplejson.Annotation, error) {
+			args = a
+			return nil, nil
+		})),
+	)
+	q = `{
+				"range": { "from": "2016-04-15T13:44:39.070Z", "to": "2016-04-15T14:44:39.070Z" },
+				"rangeRaw": { "from": "now-2h", "to": "now" },
+				"annotation": { "name": "deploy", "query": "#deploy" }
+			}`
+	req = httptest.NewRequest(http.MethodPost, "/annotations", bytes.NewBufferString(q))
+	gsj.ServeHTTP(httptest.NewRecorder(), req)
+
+	if args.From.Year() == 2016 || args.To.Sub(args.From) != 2*time.Hour {
+		t.Fatalf("expected the range to be resolved from the raw range, got %v to %v", args.From, args.To)
+	}
+
+	// Invalid raw ranges are rejected.
+	req = httptest.NewRequest(http.MethodPost, "/annotations", bytes.NewBufferString(`{"rangeRaw": {"from": "now-2x", "to": "now"}}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import "net/http"
+
+// Reload replaces the configuration of the handler with one built from
+// opts, exactly as if they had been passed to New, allowing a long
+// running server to pick up new datasources, or new definitions of its
+// targets, without restarting. Requests that are in flight complete
+// using the previous configuration, later requests use the new one. If
+// any of the options fail, or the combination of options is rejected as
+// it would be by NewWithError, the configuration is left unchanged.
+//
+// Reload affects ServeHTTP, and the handlers returned by QueryHandler and
+// the other endpoint accessors. The Handle methods, such as HandleQuery,
+// always use the configuration the Handler was created with.
+//
+// Since nothing is shared with the previous configuration, responses
+// cached with WithQueryCache are discarded. Metrics registered by
+// WithPrometheusMetrics continue to be used.
+func (h *Handler) Reload(opts ...Opt) error {
+	next, err := newHandler(opts...)
+	if err != nil {
+		return err
+	}
+	if err := next.validate(); err != nil {
+		return err
+	}
+	h.current.Store(next)
+	return nil
+}
+
+// live returns the current configuration of the handler, see Reload.
+func (h *Handler) live() *Handler {
+	return h.current.Load()
+}
+
+// liveHandler returns a handler that serves requests with the endpoint
+// method m of the current configuration of h.
+func (h *Handler) liveHandler(m func(*Handler, http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m(h.live(), w, r)
+	})
+}
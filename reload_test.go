@@ -0,0 +1,128 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// reloadTestQuerier answers every query with a single point of value v.
+// If wait is not nil, the querier signals that it has started on it, then
+// waits for it to be closed.
+func reloadTestQuerier(v float64, wait chan struct{}) simplejson.Opt {
+	return simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+		if wait != nil {
+			wait <- struct{}{}
+			<-wait
+		}
+		return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: v}}, nil
+	}))
+}
+
+func reloadTestQuery(h http.Handler) string {
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"targets":[{"target":"a"}]}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func TestReload(t *testing.T) {
+	wait := make(chan struct{})
+	gsj := simplejson.New(reloadTestQuerier(1, wait))
+	qh := gsj.QueryHandler()
+
+	// Start a request with the original configuration, which will
+	// complete after the reload.
+	inFlight := make(chan string)
+	go func() { inFlight <- reloadTestQuery(gsj) }()
+	<-wait
+
+	if err := gsj.Reload(reloadTestQuerier(2, nil)); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+
+	expect := `[{"target":"a","datapoints":[[2,1000]]}]`
+	if got := reloadTestQuery(gsj); got != expect {
+		t.Errorf("\nexpected: %s\ngot:      %s", expect, got)
+	}
+	if got := reloadTestQuery(qh); got != expect {
+		t.Errorf("endpoint handler\nexpected: %s\ngot:      %s", expect, got)
+	}
+
+	close(wait)
+	expect = `[{"target":"a","datapoints":[[1,1000]]}]`
+	if got := <-inFlight; got != expect {
+		t.Errorf("in flight request\nexpected: %s\ngot:      %s", expect, got)
+	}
+}
+
+func TestReload_Error(t *testing.T) {
+	gsj := simplejson.New(reloadTestQuerier(1, nil))
+
+	if err := gsj.Reload(reloadTestQuerier(2, nil), simplejson.WithTimeUnit(time.Minute)); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	expect := `[{"target":"a","datapoints":[[1,1000]]}]`
+	if got := reloadTestQuery(gsj); got != expect {
+		t.Errorf("\nexpected: %s\ngot:      %s", expect, got)
+	}
+}
+
+func TestReload_Invalid(t *testing.T) {
+	gsj := simplejson.New(reloadTestQuerier(1, nil))
+
+	if err := gsj.Reload(reloadTestQuerier(2, nil), simplejson.WithMaxInFlight(-1)); err == nil {
+		t.Fatalf("expected an error for an invalid configuration")
+	}
+
+	expect := `[{"target":"a","datapoints":[[1,1000]]}]`
+	if got := reloadTestQuery(gsj); got != expect {
+		t.Errorf("\nexpected: %s\ngot:      %s", expect, got)
+	}
+}
+
+func TestReload_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gsj := simplejson.New(reloadTestQuerier(1, nil), simplejson.WithPrometheusMetrics(reg))
+	reloadTestQuery(gsj)
+
+	if err := gsj.Reload(reloadTestQuerier(2, nil), simplejson.WithPrometheusMetrics(reg)); err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+	reloadTestQuery(gsj)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics, %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "simplejson_http_requests_total" {
+			continue
+		}
+		if got := mf.GetMetric()[0].GetCounter().GetValue(); got != 2 {
+			t.Fatalf("expected 2 requests to be counted, got %v", got)
+		}
+		return
+	}
+	t.Fatalf("request metrics not found")
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"sort"
+	"time"
+)
+
+// Series builds the datapoints of a timeserie. Points may be added in
+// any order, and are returned sorted by time, as Grafana expects. Labels
+// are sent with the series when it is returned by a SeriesQuerier.
+//
+//	points := simplejson.NewSeries("cpu").
+//		Add(t1, 0.5).
+//		AddNull(t2).
+//		Add(t3, 0.7).
+//		Points()
+type Series struct {
+	Name   string
+	Labels map[string]string
+
+	points []DataPoint
+	sorted bool
+}
+
+// NewSeries creates an empty series.
+func NewSeries(name string) *Series {
+	return &Series{Name: name, sorted: true}
+}
+
+// Add adds a point with value v at t.
+func (s *Series) Add(t time.Time, v float64) *Series {
+	return s.AddPoint(DataPoint{Time: t, Value: v})
+}
+
+// AddNull adds a point with a missing value at t.
+func (s *Series) AddNull(t time.Time) *Series {
+	return s.AddPoint(NullDataPoint(t))
+}
+
+// AddPoint adds dp to the series.
+func (s *Series) AddPoint(dp DataPoint) *Series {
+	if n := len(s.points); n > 0 && dp.Time.Before(s.points[n-1].Time) {
+		s.sorted = false
+	}
+	s.points = append(s.points, dp)
+	return s
+}
+
+// AddMap adds a point for each time and value in m.
+func (s *Series) AddMap(m map[time.Time]float64) *Series {
+	for t, v := range m {
+		s.Add(t, v)
+	}
+	return s
+}
+
+// Len returns the number of points in the series.
+func (s *Series) Len() int {
+	return len(s.points)
+}
+
+// Points returns the points of the series, sorted by time. Points with
+// equal times are kept in the order they were added.
+func (s *Series) Points() []DataPoint {
+	if !s.sorted {
+		sort.SliceStable(s.points, func(i, j int) bool { return s.points[i].Time.Before(s.points[j].Time) })
+		s.sorted = true
+	}
+	return s.points
+}
+
+// PointsFromMap returns a datapoint for each time and value in m, sorted
+// by time.
+func PointsFromMap(m map[time.Time]float64) []DataPoint {
+	return NewSeries("").AddMap(m).Points()
+}
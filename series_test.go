@@ -0,0 +1,44 @@
+package simplejson_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestSeries(t *testing.T) {
+	s := simplejson.NewSeries("cpu").
+		Add(time.Unix(3, 0), 3).
+		Add(time.Unix(1, 0), 1).
+		AddNull(time.Unix(2, 0))
+
+	expect := []simplejson.DataPoint{
+		{Time: time.Unix(1, 0), Value: 1},
+		simplejson.NullDataPoint(time.Unix(2, 0)),
+		{Time: time.Unix(3, 0), Value: 3},
+	}
+	if got := s.Points(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("\nexpected: %v\ngot: %v", expect, got)
+	}
+	if s.Name != "cpu" || s.Len() != 3 {
+		t.Fatalf("unexpected series %q with %d points", s.Name, s.Len())
+	}
+}
+
+func TestPointsFromMap(t *testing.T) {
+	got := simplejson.PointsFromMap(map[time.Time]float64{
+		time.Unix(20, 0): 2,
+		time.Unix(10, 0): 1,
+		time.Unix(30, 0): 3,
+	})
+	expect := []simplejson.DataPoint{
+		{Time: time.Unix(10, 0), Value: 1},
+		{Time: time.Unix(20, 0), Value: 2},
+		{Time: time.Unix(30, 0), Value: 3},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("\nexpected: %v\ngot: %v", expect, got)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// A SeriesQuerier responds to timeserie queries from Grafana, and may
+// return several series for a single target, for instance when a
+// wildcard in the target matches many metrics. Each series is sent to
+// Grafana named by its Name, and with its Labels, see WithDataFrames.
+type SeriesQuerier interface {
+	GrafanaQuerySeries(ctx context.Context, req QueryRequest) ([]*Series, error)
+}
+
+// WithSeriesQuerier adds a timeserie query handler that may return several
+// series per target. This replaces any Querier, RequestQuerier or
+// IterQuerier.
+func WithSeriesQuerier(q SeriesQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.query = nil
+		sjc.iterQuery = nil
+		sjc.seriesQuery = q
+		return nil
+	}
+}
+
+// SeriesRank determines how series are compared when limiting the number
+// returned for a target.
+type SeriesRank string
+
+// The supported SeriesRank values rank series by their maximum, average,
+// or last value. Null values are ignored.
+const (
+	RankMax  SeriesRank = "max"
+	RankAvg  SeriesRank = "avg"
+	RankLast SeriesRank = "last"
+)
+
+// SeriesLimit limits the number of series returned for a target to the N
+// with the highest rank, or the lowest if Bottom is set. A zero N does
+// not limit the series.
+type SeriesLimit struct {
+	N      int        `json:"n"`
+	By     SeriesRank `json:"by"`
+	Bottom bool       `json:"bottom"`
+}
+
+// WithSeriesLimit sets the default limit on the number of series that a
+// SeriesQuerier may return for a single target. A notice is attached to
+// the results when series have been dropped. The limit may be overridden
+// for a target by including it in the target's payload:
+//
+//	{"seriesLimit": {"n": 5, "by": "last", "bottom": true}}
+//
+// Fields missing from the payload take the values of the default.
+func WithSeriesLimit(l SeriesLimit) Opt {
+	return func(sjc *Handler) error {
+		sjc.seriesLimit = l
+		return nil
+	}
+}
+
+// seriesLimitFor returns the limit to apply to the series returned for qr.
+func (h *Handler) seriesLimitFor(qr QueryRequest) SeriesLimit {
+	l := h.seriesLimit
+	if len(qr.Payload) == 0 {
+		return l
+	}
+	payload := struct {
+		SeriesLimit *SeriesLimit `json:"seriesLimit"`
+	}{SeriesLimit: &l}
+	// The payload belongs to the querier, so anything we cannot make
+	// sense of is ignored.
+	if err := json.Unmarshal(qr.Payload, &payload); err != nil {
+		return h.seriesLimit
+	}
+	return l
+}
+
+// rank returns the value of the series used to compare it to others, or
+// NaN if it has no values.
+func (r SeriesRank) rank(points []DataPoint) float64 {
+	res, n := math.NaN(), 0
+	for _, dp := range points {
+		if dp.Null || math.IsNaN(dp.Value) {
+			continue
+		}
+		switch {
+		case n == 0, r == RankLast:
+			res = dp.Value
+		case r == RankAvg:
+			res += dp.Value
+		default:
+			res = math.Max(res, dp.Value)
+		}
+		n++
+	}
+	if r == RankAvg && n > 0 {
+		res /= float64(n)
+	}
+	return res
+}
+
+// limitSeries applies l to series, returning the series to keep, in their
+// original order, and any notice that series were dropped.
+func limitSeries(target string, series []*Series, l SeriesLimit) ([]*Series, *simpleJSONMeta) {
+	if l.N <= 0 || len(series) <= l.N {
+		return series, nil
+	}
+	by := l.By
+	if by == "" {
+		by = RankMax
+	}
+
+	idx := make([]int, len(series))
+	ranks := make([]float64, len(series))
+	for i, s := range series {
+		idx[i] = i
+		ranks[i] = by.rank(s.Points())
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := ranks[idx[i]], ranks[idx[j]]
+		switch {
+		case math.IsNaN(b):
+			return !math.IsNaN(a)
+		case math.IsNaN(a):
+			return false
+		case l.Bottom:
+			return a < b
+		default:
+			return a > b
+		}
+	})
+	idx = idx[:l.N]
+	sort.Ints(idx)
+
+	kept := make([]*Series, len(idx))
+	for i, j := range idx {
+		kept[i] = series[j]
+	}
+	order := "top"
+	if l.Bottom {
+		order = "bottom"
+	}
+	return kept, truncationNotice(target, "limited to the %s %d of %d series by %s", order, l.N, len(series), by)
+}
+
+func (h *Handler) jsonSeriesQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	keyReq := qr
+	keyReq.RefID = ""
+	series, err := dedupe(h, ctx, "series", keyReq, func(ctx context.Context) ([]*Series, error) {
+		series, err := h.seriesQuery.GrafanaQuerySeries(ctx, qr)
+		for _, s := range series {
+			// Sort the points now, as the series may be shared.
+			s.Points()
+		}
+		return series, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	series, limitMeta := limitSeries(target.Target, series, h.seriesLimitFor(qr))
+
+	out := make(simpleJSONResults, len(series))
+	for i, s := range series {
+		resp := s.Points()
+		if h.downsample != nil && qr.MaxDPs > 0 && len(resp) > qr.MaxDPs {
+			resp = h.downsample(resp, qr.MaxDPs)
+		}
+		var meta *simpleJSONMeta
+		if h.enforceMaxDPs {
+			resp, meta = enforceMaxDataPoints(s.Name, resp, qr.MaxDPs)
+		}
+		if i == 0 && limitMeta != nil {
+			meta = mergeMeta(limitMeta, meta)
+		}
+		name := s.Name
+		if name == "" {
+			name = target.Target
+		}
+		out[i] = simpleJSONData{
+			Target:     name,
+			RefID:      target.RefID,
+			DataPoints: resp,
+			nonFinite:  h.nonFinite,
+			timeUnit:   h.timeUnit,
+			meta:       meta,
+			labels:     s.Labels,
+		}
+	}
+	return out, nil
+}
+
+// mergeMeta combines the notices of two sets of metadata, either of
+// which may be nil.
+func mergeMeta(a, b *simpleJSONMeta) *simpleJSONMeta {
+	if b == nil {
+		return a
+	}
+	a.Notices = append(a.Notices, b.Notices...)
+	return a
+}
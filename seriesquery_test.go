@@ -0,0 +1,100 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func seriesQueryTestHandler(opts ...simplejson.Opt) *simplejson.Handler {
+	q := simplejson.SeriesQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]*simplejson.Series, error) {
+		t1, t2 := time.Unix(1, 0), time.Unix(2, 0)
+		return []*simplejson.Series{
+			simplejson.NewSeries("cpu.a").Add(t2, 1).Add(t1, 9),
+			simplejson.NewSeries("cpu.b").Add(t1, 4).Add(t2, 5),
+			simplejson.NewSeries("cpu.c").Add(t1, 2).AddNull(t2),
+			{Name: "cpu.d", Labels: map[string]string{"host": "d"}},
+		}, nil
+	})
+	return simplejson.New(append([]simplejson.Opt{simplejson.WithSeriesQuerier(q)}, opts...)...)
+}
+
+func TestSeriesQuerier(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []simplejson.Opt
+		target string
+		expect string
+	}{
+		{
+			name:   "all",
+			target: `{"target":"cpu.*","refId":"A"}`,
+			expect: `[{"target":"cpu.a","refId":"A","datapoints":[[9,1000],[1,2000]]},{"target":"cpu.b","refId":"A","datapoints":[[4,1000],[5,2000]]},{"target":"cpu.c","refId":"A","datapoints":[[2,1000],[null,2000]]},{"target":"cpu.d","refId":"A","datapoints":[]}]`,
+		},
+		{
+			name:   "top by max",
+			opts:   []simplejson.Opt{simplejson.WithSeriesLimit(simplejson.SeriesLimit{N: 2})},
+			target: `{"target":"cpu.*"}`,
+			expect: `[{"target":"cpu.a","datapoints":[[9,1000],[1,2000]],"meta":{"notices":[{"severity":"warning","text":"limited to the top 2 of 4 series by max"}]}},{"target":"cpu.b","datapoints":[[4,1000],[5,2000]]}]`,
+		},
+		{
+			name:   "last",
+			opts:   []simplejson.Opt{simplejson.WithSeriesLimit(simplejson.SeriesLimit{N: 1, By: simplejson.RankLast})},
+			target: `{"target":"cpu.*"}`,
+			expect: `[{"target":"cpu.b","datapoints":[[4,1000],[5,2000]],"meta":{"notices":[{"severity":"warning","text":"limited to the top 1 of 4 series by last"}]}}]`,
+		},
+		{
+			name:   "payload override",
+			opts:   []simplejson.Opt{simplejson.WithSeriesLimit(simplejson.SeriesLimit{N: 3, By: simplejson.RankAvg})},
+			target: `{"target":"cpu.*","payload":{"seriesLimit":{"n":1,"bottom":true}}}`,
+			expect: `[{"target":"cpu.c","datapoints":[[2,1000],[null,2000]],"meta":{"notices":[{"severity":"warning","text":"limited to the bottom 1 of 4 series by avg"}]}}]`,
+		},
+		{
+			name:   "alias",
+			opts:   []simplejson.Opt{simplejson.WithAliases(), simplejson.WithSeriesLimit(simplejson.SeriesLimit{N: 1})},
+			target: `{"target":"alias(cpu.*, \"host $1\")"}`,
+			expect: `[{"target":"host a","datapoints":[[9,1000],[1,2000]],"meta":{"notices":[{"severity":"warning","text":"limited to the top 1 of 4 series by max"}]}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gsj := seriesQueryTestHandler(tt.opts...)
+			req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[`+tt.target+`]}`))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Body.String() != tt.expect {
+				t.Fatalf("\nexpected: %s\ngot:      %s", tt.expect, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSeriesQuerier_Labels(t *testing.T) {
+	gsj := seriesQueryTestHandler(simplejson.WithDataFrames())
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets":[{"target":"cpu.*"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"labels":{"host":"d"}`)) {
+		t.Fatalf("expected series labels in frames, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Default settings for the server started by ListenAndServe.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 2 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// ServerOpt configures the server started by ListenAndServe.
+type ServerOpt func(*serverConfig) error
+
+type serverConfig struct {
+	ctx context.Context
+
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+
+	certFile, keyFile string
+	tlsConfig         *tls.Config
+	clientCAs         *x509.CertPool
+
+	signals []os.Signal
+}
+
+// WithServerTimeouts sets the read, write and idle timeouts of the
+// server.
+func WithServerTimeouts(read, write, idle time.Duration) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.readTimeout = read
+		sc.writeTimeout = write
+		sc.idleTimeout = idle
+		return nil
+	}
+}
+
+// WithShutdownTimeout sets how long the server will wait for in-flight
+// requests to complete when shutting down.
+func WithShutdownTimeout(d time.Duration) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.shutdownTimeout = d
+		return nil
+	}
+}
+
+// WithServerTLS serves HTTPS, using the certificate and key from the
+// given files.
+func WithServerTLS(certFile, keyFile string) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.certFile = certFile
+		sc.keyFile = keyFile
+		return nil
+	}
+}
+
+// WithServerTLSConfig serves HTTPS using the provided TLS configuration,
+// which must include the server certificates unless WithServerTLS is also
+// given.
+func WithServerTLSConfig(cfg *tls.Config) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.tlsConfig = cfg
+		return nil
+	}
+}
+
+// WithClientCertAuth requires that clients present a TLS certificate
+// signed by one of the CAs in pool, such as the client certificate
+// configured in Grafana's datasource settings. The server must also be
+// configured for TLS. The verified certificate is available to handlers
+// via ClientCertFromContext.
+func WithClientCertAuth(pool *x509.CertPool) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.clientCAs = pool
+		return nil
+	}
+}
+
+// WithClientCAFile is as WithClientCertAuth, with the CA certificates read
+// from a PEM encoded file.
+func WithClientCAFile(caFile string) ServerOpt {
+	return func(sc *serverConfig) error {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+		sc.clientCAs = pool
+		return nil
+	}
+}
+
+// WithServerContext shuts the server down when ctx is done.
+func WithServerContext(ctx context.Context) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.ctx = ctx
+		return nil
+	}
+}
+
+// WithShutdownSignals sets the signals that will cause the server to shut
+// down, by default these are SIGINT and SIGTERM. Passing no signals
+// disables signal handling.
+func WithShutdownSignals(sigs ...os.Signal) ServerOpt {
+	return func(sc *serverConfig) error {
+		sc.signals = sigs
+		return nil
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves the
+// datasource until a shutdown signal is received. In-flight requests are
+// given time to complete before ListenAndServe returns, while open
+// streams, see StreamingQuerier, are ended immediately. A nil error is
+// returned if the server was shut down cleanly.
+func (h *Handler) ListenAndServe(addr string, opts ...ServerOpt) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return h.Serve(ln, opts...)
+}
+
+// Serve serves the datasource on ln, as for ListenAndServe.
+func (h *Handler) Serve(ln net.Listener, opts ...ServerOpt) error {
+	sc := &serverConfig{
+		ctx:             context.Background(),
+		readTimeout:     defaultReadTimeout,
+		writeTimeout:    defaultWriteTimeout,
+		idleTimeout:     defaultIdleTimeout,
+		shutdownTimeout: defaultShutdownTimeout,
+		signals:         []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+	for _, o := range opts {
+		if err := o(sc); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	ctx := sc.ctx
+	if len(sc.signals) > 0 {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, sc.signals...)
+		defer stop()
+	}
+
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       sc.readTimeout,
+		WriteTimeout:      sc.writeTimeout,
+		IdleTimeout:       sc.idleTimeout,
+		TLSConfig:         sc.tlsConfig,
+	}
+
+	// Streams never go idle, so would hold up Shutdown until it timed
+	// out. They are ended as soon as shutdown starts instead.
+	shutdown := make(chan struct{})
+	srv.BaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), shutdownContextKey, (<-chan struct{})(shutdown))
+	}
+	srv.RegisterOnShutdown(func() { close(shutdown) })
+	useTLS := sc.certFile != "" || sc.tlsConfig != nil
+	if useTLS && srv.TLSConfig == nil {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if sc.clientCAs != nil {
+		if !useTLS {
+			ln.Close()
+			return errors.New("client certificate authentication requires TLS")
+		}
+		srv.TLSConfig = srv.TLSConfig.Clone()
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		srv.TLSConfig.ClientCAs = sc.clientCAs
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if useTLS {
+			errs <- srv.ServeTLS(ln, sc.certFile, sc.keyFile)
+			return
+		}
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), sc.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		return err
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
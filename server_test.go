@@ -0,0 +1,202 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestServe(t *testing.T) {
+	bq := &blockingQuerier{release: make(chan struct{})}
+	gsj := simplejson.New(
+		simplejson.WithQuerier(bq),
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- gsj.Serve(ln,
+			simplejson.WithServerContext(ctx),
+			simplejson.WithShutdownSignals(),
+			simplejson.WithShutdownTimeout(5*time.Second),
+		)
+	}()
+
+	// Start a query, and shut the server down while it is in flight.
+	resps := make(chan int, 1)
+	go func() {
+		res, err := http.Post("http://"+ln.Addr().String()+"/query", "application/json", bytes.NewBufferString(encodeTestQuery))
+		if err != nil {
+			resps <- 0
+			return
+		}
+		res.Body.Close()
+		resps <- res.StatusCode
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for bq.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("query did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(bq.release)
+
+	if code := <-resps; code != http.StatusOK {
+		t.Fatalf("in-flight request was not drained, got status %d", code)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("unexpected error from Serve, %v", err)
+	}
+}
+
+// testCert creates a certificate for name, signed by parent, or self
+// signed if parent is nil.
+func testCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type clientCertSearcher struct{}
+
+func (clientCertSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	cert, ok := simplejson.ClientCertFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	return []string{cert.Subject.CommonName}, nil
+}
+
+func TestServeClientCertAuth(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	serverCert := testCert(t, "server", &ca)
+	clientCert := testCert(t, "grafana", &ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	gsj := simplejson.New(
+		simplejson.WithSearcher(clientCertSearcher{}),
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gsj.Serve(ln,
+		simplejson.WithServerContext(ctx),
+		simplejson.WithShutdownSignals(),
+		simplejson.WithServerTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+		simplejson.WithClientCertAuth(pool),
+	)
+
+	url := "https://" + ln.Addr().String() + "/search"
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs},
+		}}
+	}
+
+	if _, err := client().Post(url, "application/json", bytes.NewBufferString(`{"target": ""}`)); err == nil {
+		t.Fatalf("expected request without a client certificate to fail")
+	}
+
+	res, err := client(clientCert).Post(url, "application/json", bytes.NewBufferString(`{"target": ""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(res.Body)
+	expect := `["grafana"]`
+	if buf.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, buf.String())
+	}
+}
+
+func TestServe_Streams(t *testing.T) {
+	ts := newTestStream()
+	gsj := simplejson.New(simplejson.WithSource(ts))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- gsj.Serve(ln,
+			simplejson.WithServerContext(ctx),
+			simplejson.WithShutdownSignals(),
+			simplejson.WithShutdownTimeout(5*time.Second),
+		)
+	}()
+
+	_, closeStream := subscribe(t, "http://"+ln.Addr().String()+"/stream/cpu")
+	defer closeStream()
+	waitFor(t, ts.started)
+
+	// Shut the server down while the stream is open, it should not wait
+	// for the shutdown timeout.
+	start := time.Now()
+	cancel()
+	if err := waitFor(t, served); err != nil {
+		t.Fatalf("unexpected error from Serve, %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown waited %v for the stream", d)
+	}
+	waitFor(t, ts.stopped)
+}
@@ -21,90 +21,307 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"sort"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
 // Handler Is an opaque type that supports the required HTTP handlers for the
 // Simple JSON plugin
 type Handler struct {
-	query       Querier
-	tableQuery  TableQuerier
-	annotations Annotator
-	search      Searcher
-	tags        TagSearcher
-
-	mux *http.ServeMux
+	query            RequestQuerier
+	namedQueriers    map[string]RequestQuerier
+	iterQuery        IterQuerier
+	tableQuery       TableRequestQuerier
+	tableIter        TableIterQuerier
+	heatmapQuery     HeatmapQuerier
+	logQuery         LogQuerier
+	nodeGraphQuery   NodeGraphQuerier
+	annotations      AnnotationQuerier
+	annotationWriter AnnotationWriter
+	search           Searcher
+	resultSearch     ResultSearcher
+	tags             TagSearcher
+	variables        VariableQuerier
+	streams          *streamHub
+	health           HealthChecker
+
+	maxConcurrentTargets int
+	includeHidden        bool
+	nonFinite            NonFinitePolicy
+	downsample           DownsampleFunc
+	enforceMaxDPs        bool
+	annotationRegions    AnnotationRegionFormat
+	filterAnnotations    bool
+	maxRows              int
+	dataFrames           bool
+	arrowEncoding        bool
+	msgpackEncoding      bool
+	cborEncoding         bool
+	debugJSON            bool
+	parseEndpoint        bool
+	openAPIEndpoint      bool
+	streamBuffer         int
+
+	compress        bool
+	compressMinSize int
+
+	authSchemes []authScheme
+	cors        func(http.Handler) http.Handler
+	middleware  []Middleware
+	pathPrefix  string
+
+	tenantResolver TenantResolver
+	tenants        map[string]*Handler
+
+	queryTimeout       time.Duration
+	annotationsTimeout time.Duration
+	searchTimeout      time.Duration
+	tagsTimeout        time.Duration
+	healthTimeout      time.Duration
+
+	maxRequestBytes int64
+	strictDecoding  bool
+	resolveRawRange bool
+
+	limiter     *rate.Limiter
+	inFlight    chan struct{}
+	maxInFlight int
+
+	cache  *responseCache
+	flight *singleflight.Group
+
+	metrics *metrics
+	hooks   *Hooks
+
+	slowQueries   *slowQueryLog
+	recentQueries *queryRing
+	adminPrefix   string
+
+	recorder *recorder
+
+	macros  map[string]Macro
+	aliases bool
+
+	seriesQuery SeriesQuerier
+	seriesLimit SeriesLimit
+
+	tracing bool
+	tracer  trace.Tracer
+
+	panicHandler PanicHandler
+
+	clock    Clock
+	timeUnit time.Duration
+
+	mux     *http.ServeMux
+	handler http.Handler
+	current atomic.Pointer[Handler] // see Reload
 }
 
 // New creates a new http.Handler that will answer to the required endpoint for
 // a SimpleJSON source. You should use WithQuerier, WithTableQuerier,
 // WithAnnotator and WithSearch to set handlers for each of the endpionts.
+// New panics if any of the options fail, use NewWithError to have
+// problems with the options reported as an error.
 func New(opts ...Opt) *Handler {
+	h, err := newHandler(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func newHandler(opts ...Opt) (*Handler, error) {
 	mux := http.NewServeMux()
 	Handler := &Handler{
-		mux: mux,
+		mux:             mux,
+		tracer:          noop.NewTracerProvider().Tracer(tracerName),
+		panicHandler:    logPanic,
+		maxRequestBytes: DefaultMaxRequestBytes,
 	}
 
 	mux.HandleFunc("/", Handler.HandleRoot)
 	mux.HandleFunc("/query", Handler.HandleQuery)
 	mux.HandleFunc("/annotations", Handler.HandleAnnotations)
+	mux.HandleFunc("/annotation", Handler.HandleAnnotationWrite)
+	mux.HandleFunc("/annotation/{id}", Handler.HandleAnnotationWrite)
 	mux.HandleFunc("/search", Handler.HandleSearch)
 	mux.HandleFunc("/tag-keys", Handler.HandleTagKeys)
 	mux.HandleFunc("/tag-values", Handler.HandleTagValues)
+	mux.HandleFunc("/variable", Handler.HandleVariable)
+	mux.HandleFunc("/stream/{channel...}", Handler.HandleStream)
+	mux.HandleFunc("/debug/parse", Handler.HandleDebugParse)
+	mux.HandleFunc("/openapi.json", Handler.HandleOpenAPI)
 
 	for _, o := range opts {
 		if err := o(Handler); err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
-	return Handler
+	Handler.handler = Handler.buildHandler()
+	Handler.current.Store(Handler)
+
+	return Handler, nil
 }
 
-// WithSource will attempt to use the datasource provided as
-// a Querier, TableQuerier, Annotator, Searcher and TagSearch
-// if it supports the required interface.
+// WithSource will attempt to use the datasource provided as a
+// SeriesQuerier (or IterQuerier, or RequestQuerier, or Querier), TableIterQuerier (or
+// TableRequestQuerier, or TableQuerier), HeatmapQuerier, LogQuerier,
+// NodeGraphQuerier, AnnotationQuerier (or Annotator),
+// AnnotationWriter, ResultSearcher (or Searcher), TagSearcher,
+// VariableQuerier, StreamingQuerier and HealthChecker if it supports the
+// required interface.
 func WithSource(src interface{}) Opt {
 	return func(sjc *Handler) error {
 		if q, ok := src.(Querier); ok {
+			sjc.query = querierAdapter{q}
+		}
+		if q, ok := src.(RequestQuerier); ok {
 			sjc.query = q
 		}
+		if q, ok := src.(IterQuerier); ok {
+			sjc.query = nil
+			sjc.iterQuery = q
+		}
+		if q, ok := src.(SeriesQuerier); ok {
+			sjc.query = nil
+			sjc.iterQuery = nil
+			sjc.seriesQuery = q
+		}
 		if tq, ok := src.(TableQuerier); ok {
+			sjc.tableQuery = tableQuerierAdapter{tq}
+		}
+		if tq, ok := src.(TableRequestQuerier); ok {
 			sjc.tableQuery = tq
 		}
+		if tq, ok := src.(TableIterQuerier); ok {
+			sjc.tableQuery = nil
+			sjc.tableIter = tq
+		}
 		if a, ok := src.(Annotator); ok {
+			sjc.annotations = annotatorAdapter{a}
+		}
+		if hq, ok := src.(HeatmapQuerier); ok {
+			sjc.heatmapQuery = hq
+		}
+		if lq, ok := src.(LogQuerier); ok {
+			sjc.logQuery = lq
+		}
+		if nq, ok := src.(NodeGraphQuerier); ok {
+			sjc.nodeGraphQuery = nq
+		}
+		if a, ok := src.(AnnotationQuerier); ok {
 			sjc.annotations = a
 		}
+		if aw, ok := src.(AnnotationWriter); ok {
+			sjc.annotationWriter = aw
+		}
 		if s, ok := src.(Searcher); ok {
 			sjc.search = s
 		}
+		if s, ok := src.(ResultSearcher); ok {
+			sjc.search = nil
+			sjc.resultSearch = s
+		}
 		if ts, ok := src.(TagSearcher); ok {
 			sjc.tags = ts
 		}
+		if vq, ok := src.(VariableQuerier); ok {
+			sjc.variables = vq
+		}
+		if sq, ok := src.(StreamingQuerier); ok {
+			sjc.streams = newStreamHub(sq)
+		}
+		if hc, ok := src.(HealthChecker); ok {
+			sjc.health = hc
+		}
 		return nil
 	}
 }
 
 // WithQuerier adds a timeserie query handler.
 func WithQuerier(q Querier) Opt {
+	return func(sjc *Handler) error {
+		sjc.query = querierAdapter{q}
+		sjc.iterQuery = nil
+		sjc.seriesQuery = nil
+		return nil
+	}
+}
+
+// WithRequestQuerier adds a timeserie query handler that is passed
+// the full QueryRequest for each target.
+func WithRequestQuerier(q RequestQuerier) Opt {
 	return func(sjc *Handler) error {
 		sjc.query = q
+		sjc.iterQuery = nil
+		sjc.seriesQuery = nil
+		return nil
+	}
+}
+
+// WithIterQuerier adds a timeserie query handler that returns datapoints
+// via an iterator. This replaces any Querier, RequestQuerier or
+// SeriesQuerier.
+func WithIterQuerier(q IterQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.query = nil
+		sjc.iterQuery = q
+		sjc.seriesQuery = nil
 		return nil
 	}
 }
 
 // WithTableQuerier adds a table query handler.
 func WithTableQuerier(q TableQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.tableQuery = tableQuerierAdapter{q}
+		sjc.tableIter = nil
+		return nil
+	}
+}
+
+// WithTableRequestQuerier adds a table query handler that is passed the
+// full QueryRequest for each target.
+func WithTableRequestQuerier(q TableRequestQuerier) Opt {
 	return func(sjc *Handler) error {
 		sjc.tableQuery = q
+		sjc.tableIter = nil
+		return nil
+	}
+}
+
+// WithTableIterQuerier adds a table query handler that returns rows via an
+// iterator. This replaces any TableQuerier.
+func WithTableIterQuerier(q TableIterQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.tableQuery = nil
+		sjc.tableIter = q
 		return nil
 	}
 }
 
 // WithAnnotator adds an annoations handler.
 func WithAnnotator(a Annotator) Opt {
+	return func(sjc *Handler) error {
+		sjc.annotations = annotatorAdapter{a}
+		return nil
+	}
+}
+
+// WithAnnotationQuerier adds an annotations handler that is passed the
+// full AnnotationQuery.
+func WithAnnotationQuerier(a AnnotationQuerier) Opt {
 	return func(sjc *Handler) error {
 		sjc.annotations = a
 		return nil
@@ -115,6 +332,17 @@ func WithAnnotator(a Annotator) Opt {
 func WithSearcher(s Searcher) Opt {
 	return func(sjc *Handler) error {
 		sjc.search = s
+		sjc.resultSearch = nil
+		return nil
+	}
+}
+
+// WithResultSearcher adds a search handler that returns text/value
+// pairs. This replaces any Searcher.
+func WithResultSearcher(s ResultSearcher) Opt {
+	return func(sjc *Handler) error {
+		sjc.search = nil
+		sjc.resultSearch = s
 		return nil
 	}
 }
@@ -127,14 +355,52 @@ func WithTagSearcher(s TagSearcher) Opt {
 	}
 }
 
+// WithMaxConcurrentTargets limits the number of targets from a single
+// query that will be run concurrently. By default all targets are run
+// at once, n <= 0 removes any limit.
+func WithMaxConcurrentTargets(n int) Opt {
+	return func(sjc *Handler) error {
+		sjc.maxConcurrentTargets = n
+		return nil
+	}
+}
+
+// WithHiddenTargets passes targets that the user has hidden in the query
+// editor to the queriers. By default hidden targets are skipped, and are
+// not included in the response.
+func WithHiddenTargets() Opt {
+	return func(sjc *Handler) error {
+		sjc.includeHidden = true
+		return nil
+	}
+}
+
+// WithCompression enables gzip compression of responses for clients that
+// support it. Responses smaller than minSize bytes are sent uncompressed.
+func WithCompression(minSize int) Opt {
+	return func(sjc *Handler) error {
+		sjc.compress = true
+		sjc.compressMinSize = minSize
+		return nil
+	}
+}
+
 // Opt provides configurable options for the Handler
 type Opt func(*Handler) error
 
 // QueryCommonArguments describes the arguments common to timeserie and
-// table queries.
+// table queries. Filters holds any adhoc filters the user has applied to
+// the dashboard, they should be applied to every target. Timezone is the
+// timezone of the dashboard as sent by Grafana, such as "utc", "browser"
+// or "Europe/London". Location is the corresponding location, for
+// bucketing by day in the viewer's timezone, for example. It is nil if
+// Grafana did not send a timezone, the timezone is that of the viewer's
+// browser, or the location is not known.
 type QueryCommonArguments struct {
 	From, To time.Time
 	Filters  []QueryAdhocFilter
+	Timezone string
+	Location *time.Location `json:"-"`
 }
 
 // QueryArguments defines the options to a timeserie query.
@@ -144,9 +410,20 @@ type QueryArguments struct {
 	MaxDPs   int
 }
 
-// TableQueryArguments defines the options to a table query.
+// TableQueryArguments defines the options to a table query. RefID is the
+// identifier Grafana has given to the target within the panel. Payload
+// holds any additional, structured, query parameters sent with the
+// target, see QueryRequest. Hidden is set for targets the user has hidden,
+// see WithHiddenTargets. ScopedVars holds the values of template variables
+// scoped to the panel, see Interpolate. RawRange holds the time range as
+// entered by the user.
 type TableQueryArguments struct {
 	QueryCommonArguments
+	RefID      string
+	Hidden     bool
+	RawRange   RawRange
+	ScopedVars map[string]ScopedVar
+	Payload    json.RawMessage
 }
 
 // A Querier responds to timeseri queries from Grafana
@@ -154,6 +431,55 @@ type Querier interface {
 	GrafanaQuery(ctx context.Context, target string, args QueryArguments) ([]DataPoint, error)
 }
 
+// RawRange holds the time range as entered by the user, e.g. "now-6h".
+type RawRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ScopedVar is the value of a template variable that Grafana has
+// scoped to the panel making the request.
+type ScopedVar struct {
+	Text  string      `json:"text"`
+	Value interface{} `json:"value"`
+}
+
+// QueryRequest describes a single timeserie or table target query. Further fields
+// will be added to this struct as the Grafana protocol evolves, so it is
+// the preferred way to receive queries.
+//
+// Payload holds the JSON payload the JSON datasource plugin sends
+// alongside the target, allowing structured query parameters rather than
+// encoding everything into the target string. It is nil if no payload was
+// sent, and can be decoded with json.Unmarshal. QueryType identifies the
+// family of query, see WithNamedQuerier. Hidden is set for targets the
+// user has hidden, which are only passed on if WithHiddenTargets is given.
+type QueryRequest struct {
+	QueryArguments
+	Target     string
+	RefID      string
+	QueryType  string
+	Hidden     bool
+	RawRange   RawRange
+	ScopedVars map[string]ScopedVar
+	Payload    json.RawMessage
+}
+
+// A RequestQuerier responds to timeserie queries from Grafana, and is
+// passed the full details of the request for each target.
+type RequestQuerier interface {
+	GrafanaQueryRequest(ctx context.Context, req QueryRequest) ([]DataPoint, error)
+}
+
+// querierAdapter allows a Querier to be used as a RequestQuerier.
+type querierAdapter struct {
+	q Querier
+}
+
+func (qa querierAdapter) GrafanaQueryRequest(ctx context.Context, req QueryRequest) ([]DataPoint, error) {
+	return qa.q.GrafanaQuery(ctx, req.Target, req.QueryArguments)
+}
+
 // TagInfoer is an internal interface to describe difference types of tag.
 type TagInfoer interface {
 	tagName() string
@@ -202,9 +528,36 @@ type TableQuerier interface {
 	GrafanaQueryTable(ctx context.Context, target string, args TableQueryArguments) ([]TableColumn, error)
 }
 
+// A TableRequestQuerier responds to table queries from Grafana, and is
+// passed the full details of the request for each target, including the
+// interval and maximum number of datapoints of the panel, which allows
+// the backend to limit or bucket its results.
+type TableRequestQuerier interface {
+	GrafanaQueryTableRequest(ctx context.Context, req QueryRequest) ([]TableColumn, error)
+}
+
+// tableQuerierAdapter allows a TableQuerier to be used as a
+// TableRequestQuerier.
+type tableQuerierAdapter struct {
+	q TableQuerier
+}
+
+func (qa tableQuerierAdapter) GrafanaQueryTableRequest(ctx context.Context, req QueryRequest) ([]TableColumn, error) {
+	return qa.q.GrafanaQueryTable(ctx, req.Target, TableQueryArguments{
+		QueryCommonArguments: req.QueryCommonArguments,
+		RefID:                req.RefID,
+		Hidden:               req.Hidden,
+		RawRange:             req.RawRange,
+		ScopedVars:           req.ScopedVars,
+		Payload:              req.Payload,
+	})
+}
+
 // AnnotationsArguments defines the options to a annotations query.
+// RawRange holds the time range as entered by the user.
 type AnnotationsArguments struct {
 	QueryCommonArguments
+	RawRange RawRange
 }
 
 // An Annotator responds to queries for annotations from Grafana
@@ -212,11 +565,57 @@ type Annotator interface {
 	GrafanaAnnotations(ctx context.Context, query string, args AnnotationsArguments) ([]Annotation, error)
 }
 
+// AnnotationQuery describes a query for annotations. Name is the name of
+// the annotation query in the dashboard. Tags, MatchAny and Limit are set
+// when the user has asked for annotations with particular tags: returned
+// annotations should have all of Tags, or any of them if MatchAny is set,
+// and there should be no more than Limit of them, if Limit is not zero.
+// See WithAnnotationFiltering.
+type AnnotationQuery struct {
+	AnnotationsArguments
+	Query    string
+	Name     string
+	Tags     []string
+	MatchAny bool
+	Limit    int
+}
+
+// An AnnotationQuerier responds to queries for annotations from Grafana,
+// and is passed the full details of the query.
+type AnnotationQuerier interface {
+	GrafanaAnnotationQuery(ctx context.Context, q AnnotationQuery) ([]Annotation, error)
+}
+
+// annotatorAdapter allows an Annotator to be used as an
+// AnnotationQuerier.
+type annotatorAdapter struct {
+	a Annotator
+}
+
+func (aa annotatorAdapter) GrafanaAnnotationQuery(ctx context.Context, q AnnotationQuery) ([]Annotation, error) {
+	return aa.a.GrafanaAnnotations(ctx, q.Query, q.AnnotationsArguments)
+}
+
 // A Searcher responds to search queries from Grafana
 type Searcher interface {
 	GrafanaSearch(ctx context.Context, target string) ([]string, error)
 }
 
+// SearchResult is a single result of a search. When used for template
+// variables, Text is displayed to the user and Value is substituted into
+// queries, allowing friendly labels for stable IDs. Value should be a
+// string or a number.
+type SearchResult struct {
+	Text  string      `json:"text"`
+	Value interface{} `json:"value"`
+}
+
+// A ResultSearcher responds to search queries from Grafana with
+// text/value pairs, rather than plain strings.
+type ResultSearcher interface {
+	GrafanaSearchResults(ctx context.Context, target string) ([]SearchResult, error)
+}
+
 // QueryAdhocFilter describes a user supplied filter to be added to
 // each query target.
 type QueryAdhocFilter struct {
@@ -225,46 +624,46 @@ type QueryAdhocFilter struct {
 	Value    string `json:"value"`
 }
 
-// DataPoint represents a single datapoint at a given point in time.
+// DataPoint represents a single datapoint at a given point in time. If
+// Null is set the value is missing, Value is ignored and the point is sent
+// to Grafana as null, which is rendered as a gap in the series.
 type DataPoint struct {
 	Time  time.Time
 	Value float64
+	Null  bool
 }
 
-// A TableNumberColumn holds values for a "number" column in a table.
-type TableNumberColumn []float64
-
-func (TableNumberColumn) simpleJSONColumn() {
-}
-
-// A TableTimeColumn holds values for a "time" column in a table.
-type TableTimeColumn []time.Time
-
-func (TableTimeColumn) simpleJSONColumn() {
-}
-
-// A TableStringColumn holds values for a "string" column in a table.
-type TableStringColumn []string
-
-func (TableStringColumn) simpleJSONColumn() {
+// NullDataPoint returns a datapoint with a missing value at t.
+func NullDataPoint(t time.Time) DataPoint {
+	return DataPoint{Time: t, Null: true}
 }
 
-// TableColumnData is a private interface to this package, you should
-// use one of TableStringColumn, TableNumberColumn, or TableTimeColumn
+// TableColumnData is a private interface to this package, you should use
+// a Column, or one of TableStringColumn, TableNumberColumn,
+// TableTimeColumn, TableBoolColumn, TableDurationColumn, TableJSONColumn,
+// LatitudeColumn, LongitudeColumn or GeoHashColumn.
 type TableColumnData interface {
 	simpleJSONColumn()
+	columnType() string
+	length() int
+	value(i int) interface{}
 }
 
-// TableColumn represents a single table column. Data should
-// be one the TableNumberColumn, TableStringColumn or TableTimeColumn types.
+// TableColumn represents a single table column. Data should be one of the
+// TableColumnData types. Unit optionally gives the unit of the values,
+// e.g. "ms" or "bytes". Config optionally gives further display hints.
 type TableColumn struct {
-	Text string
-	Data TableColumnData
+	Text   string
+	Unit   string
+	Data   TableColumnData
+	Config *FieldConfig
 }
 
 // Annotation represents an annotation that can be displayed on a graph, or
-// in a table.
+// in a table. ID optionally identifies the annotation, see
+// AnnotationWriter.
 type Annotation struct {
+	ID      string    `json:"id,omitempty"`
 	Time    time.Time `json:"time"`
 	TimeEnd time.Time `json:"timeEnd,omitempty"`
 	Title   string    `json:"title"`
@@ -272,10 +671,17 @@ type Annotation struct {
 	Tags    []string  `json:"tags"`
 }
 
-// HandleRoot serves a plain 200 OK for /, required by Grafana
+// HandleRoot serves /, which Grafana requests to test the connection to
+// the datasource. If a HealthChecker has been set the outcome of the
+// check is returned, otherwise it serves a plain 200 OK.
 func (h *Handler) HandleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
-		http.Error(w, "File not found", http.StatusNotFound)
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+	if h.health != nil {
+		h.handleHealth(w, r)
+		return
 	}
 	w.Write([]byte("OK"))
 }
@@ -331,10 +737,7 @@ func (sjd *simpleJSONDuration) UnmarshalJSON(injs []byte) error {
 	return nil
 }
 
-type simpleJSONRawRange struct {
-	From string `json:"from"`
-	To   string `json:"to"`
-}
+type simpleJSONRawRange RawRange
 
 type simpleJSONRange struct {
 	From simpleJSONTime     `json:"from"`
@@ -343,10 +746,12 @@ type simpleJSONRange struct {
 }
 
 type simpleJSONTarget struct {
-	Target string `json:"target"`
-	RefID  string `json:"refId"`
-	Hide   bool   `json:"hide"`
-	Type   string `json:"type"`
+	Target    string          `json:"target"`
+	RefID     string          `json:"refId"`
+	QueryType string          `json:"queryType"`
+	Hide      bool            `json:"hide"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
 }
 
 /*
@@ -376,15 +781,18 @@ type simpleJSONTarget struct {
 */
 
 type simpleJSONQuery struct {
-	PanelID       int                `json:"panelId"`
-	Range         simpleJSONRange    `json:"range"`
-	RangeRaw      simpleJSONRawRange `json:"rangeRaw"`
-	Interval      simpleJSONDuration `json:"interval"`
-	IntervalMS    int                `json:"intervalMs"`
-	Targets       []simpleJSONTarget `json:"targets"`
-	Format        string             `json:"format"`
-	MaxDataPoints int                `json:"maxDataPoints"`
-	AdhocFilters  []QueryAdhocFilter `json:"adhocFilters"`
+	PanelID       int                  `json:"panelId"`
+	Range         simpleJSONRange      `json:"range"`
+	RangeRaw      simpleJSONRawRange   `json:"rangeRaw"`
+	Interval      simpleJSONDuration   `json:"interval"`
+	IntervalMS    int                  `json:"intervalMs"`
+	Targets       []simpleJSONTarget   `json:"targets"`
+	Format        string               `json:"format"`
+	MaxDataPoints int                  `json:"maxDataPoints"`
+	AdhocFilters  []QueryAdhocFilter   `json:"adhocFilters"`
+	ScopedVars    map[string]ScopedVar `json:"scopedVars"`
+	Timeout       simpleJSONDuration   `json:"timeout"`
+	Timezone      string               `json:"timezone"`
 }
 
 /*
@@ -450,66 +858,84 @@ func (sjdp *simpleJSONDataPoint) UnmarshalJSON(injs []byte) error {
 	return nil
 }
 
+// simpleJSONData is the response to a single timeserie target. It is
+// encoded by writeJSON rather than via reflection, so that large series
+// can be streamed to the client.
 type simpleJSONData struct {
-	Target     string                `json:"target"`
-	DataPoints []simpleJSONDataPoint `json:"datapoints"`
+	Target     string
+	RefID      string
+	DataPoints []DataPoint
+	nonFinite  NonFinitePolicy
+	timeUnit   time.Duration
+	meta       *simpleJSONMeta
+	config     *FieldConfig
+	labels     map[string]string
+	release    func() // may be nil
 }
 
 type simpleJSONTableColumn struct {
-	Text string `json:"text"`
-	Type string `json:"type"`
+	Text   string       `json:"text"`
+	Type   string       `json:"type"`
+	Unit   string       `json:"unit,omitempty"`
+	Config *FieldConfig `json:"config,omitempty"`
 }
 
 type simpleJSONTableRow []interface{}
 
 type simpleJSONTableData struct {
 	Type    string                  `json:"type"`
+	RefID   string                  `json:"refId,omitempty"`
 	Columns []simpleJSONTableColumn `json:"columns"`
 	Rows    []simpleJSONTableRow    `json:"rows"`
+	Meta    *simpleJSONMeta         `json:"meta,omitempty"`
 }
 
-func (h *Handler) jsonTableQuery(ctx context.Context, req simpleJSONQuery, target simpleJSONTarget) (interface{}, error) {
-	resp, err := h.tableQuery.GrafanaQueryTable(
-		ctx,
-		target.Target,
-		TableQueryArguments{
-			QueryCommonArguments: QueryCommonArguments{
-				From:    time.Time(req.Range.From),
-				To:      time.Time(req.Range.To),
-				Filters: req.AdhocFilters,
-			},
-		},
-	)
+func (h *Handler) jsonTableQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	keyReq := qr
+	keyReq.RefID = ""
+	resp, err := dedupe(h, ctx, "table", keyReq, func(ctx context.Context) ([]TableColumn, error) {
+		return h.tableQuery.GrafanaQueryTableRequest(ctx, qr)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	return h.tableData(target, resp)
+}
+
+// tableData builds the response to a table target from its columns.
+func (h *Handler) tableData(target simpleJSONTarget, resp []TableColumn) (simpleJSONTableData, error) {
 	rowCount := 0
 	var cols []simpleJSONTableColumn
 	for _, cv := range resp {
-		var colType string
-		var dataLen int
-		switch data := cv.Data.(type) {
-		case TableNumberColumn:
-			colType = "number"
-			dataLen = len(data)
-		case TableStringColumn:
-			colType = "string"
-			dataLen = len(data)
-		case TableTimeColumn:
-			colType = "time"
-			dataLen = len(data)
-		default:
-			return nil, errors.New("invlalid column type")
+		if cv.Data == nil {
+			return simpleJSONTableData{}, errors.New("invlalid column type")
+		}
+		if v, ok := cv.Data.(columnValidator); ok {
+			if err := v.validate(); err != nil {
+				return simpleJSONTableData{}, err
+			}
 		}
+		dataLen := cv.Data.length()
 
 		if rowCount == 0 {
 			rowCount = dataLen
 		}
 		if dataLen != rowCount {
-			return nil, errors.New("all columns must be of equal length")
+			return simpleJSONTableData{}, errors.New("all columns must be of equal length")
 		}
-		cols = append(cols, simpleJSONTableColumn{Text: cv.Text, Type: colType})
+
+		unit := cv.Unit
+		if _, ok := cv.Data.(TableDurationColumn); ok && unit == "" {
+			unit = "ms"
+		}
+		cols = append(cols, simpleJSONTableColumn{Text: cv.Text, Type: cv.Data.columnType(), Unit: unit, Config: cv.Config})
+	}
+
+	var meta *simpleJSONMeta
+	if h.maxRows > 0 && rowCount > h.maxRows {
+		meta = truncationNotice(target.Target, "table truncated from %d to %d rows", rowCount, h.maxRows)
+		rowCount = h.maxRows
 	}
 	rows := make([]simpleJSONTableRow, rowCount)
 	for i := 0; i < rowCount; i++ {
@@ -517,111 +943,293 @@ func (h *Handler) jsonTableQuery(ctx context.Context, req simpleJSONQuery, targe
 	}
 
 	for j := range resp {
-		switch data := resp[j].Data.(type) {
-		case TableNumberColumn:
-			for i := 0; i < rowCount; i++ {
-				rows[i][j] = data[i]
-			}
-		case TableStringColumn:
-			for i := 0; i < rowCount; i++ {
-				rows[i][j] = data[i]
-			}
-		case TableTimeColumn:
-			for i := 0; i < rowCount; i++ {
-				rows[i][j] = data[i]
+		for i := 0; i < rowCount; i++ {
+			rows[i][j] = resp[j].Data.value(i)
+			if h.timeUnit != 0 {
+				rows[i][j] = epochValue(rows[i][j], h.timeUnit)
 			}
 		}
 	}
 
 	return simpleJSONTableData{
 		Type:    "table",
+		RefID:   target.RefID,
 		Columns: cols,
 		Rows:    rows,
+		Meta:    meta,
 	}, nil
 }
 
-func (h *Handler) jsonQuery(ctx context.Context, req simpleJSONQuery, target simpleJSONTarget) (interface{}, error) {
-	resp, err := h.query.GrafanaQuery(
-		ctx,
-		target.Target,
-		QueryArguments{
+// queryRequest builds the QueryRequest passed to handlers for a target.
+func queryRequest(req simpleJSONQuery, target simpleJSONTarget) QueryRequest {
+	return QueryRequest{
+		QueryArguments: QueryArguments{
 			QueryCommonArguments: QueryCommonArguments{
-				From:    time.Time(req.Range.From),
-				To:      time.Time(req.Range.To),
-				Filters: req.AdhocFilters,
+				From:     time.Time(req.Range.From),
+				To:       time.Time(req.Range.To),
+				Filters:  req.AdhocFilters,
+				Timezone: req.Timezone,
+				Location: requestLocation(req.Timezone),
 			},
 			Interval: time.Duration(req.Interval),
 			MaxDPs:   req.MaxDataPoints,
-		})
+		},
+		Target:     target.Target,
+		RefID:      target.RefID,
+		QueryType:  target.QueryType,
+		Hidden:     target.Hide,
+		RawRange:   RawRange(req.RangeRaw),
+		ScopedVars: req.ScopedVars,
+		Payload:    target.Payload,
+	}
+}
+
+func (h *Handler) jsonQuery(ctx context.Context, q RequestQuerier, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	type series struct {
+		points []DataPoint
+		orig   []DataPoint // as returned by the querier
+		config *FieldConfig
+		labels map[string]string
+	}
+	keyReq := qr
+	keyReq.RefID = ""
+	res, err := dedupe(h, ctx, "timeserie", keyReq, func(ctx context.Context) (series, error) {
+		ctx, fch := withFieldConfigHolder(ctx)
+		resp, err := q.GrafanaQueryRequest(ctx, qr)
+		if err != nil {
+			return series{}, err
+		}
+		sort.Slice(resp, func(i, j int) bool { return resp[i].Time.Before(resp[j].Time) })
+		orig := resp
+		if h.downsample != nil && qr.MaxDPs > 0 && len(resp) > qr.MaxDPs {
+			resp = h.downsample(resp, qr.MaxDPs)
+		}
+		return series{resp, orig, fch.get(), fch.getLabels()}, nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	resp := res.points
 
-	sort.Slice(resp, func(i, j int) bool { return resp[i].Time.Before(resp[j].Time) })
-	out := simpleJSONData{Target: target.Target}
-	for _, v := range resp {
-		out.DataPoints = append(out.DataPoints, simpleJSONDataPoint{
-			Time:  simpleJSONPTime(v.Time),
-			Value: v.Value,
-		})
+	var release func()
+	if r, ok := dataPointReleaser(q); ok && h.flight == nil {
+		release = func() { r.ReleaseDataPoints(res.orig) }
 	}
 
-	return out, nil
+	var meta *simpleJSONMeta
+	if h.enforceMaxDPs {
+		resp, meta = enforceMaxDataPoints(target.Target, resp, qr.MaxDPs)
+	}
+
+	return simpleJSONData{
+		Target:     target.Target,
+		RefID:      target.RefID,
+		DataPoints: resp,
+		nonFinite:  h.nonFinite,
+		timeUnit:   h.timeUnit,
+		meta:       meta,
+		config:     res.config,
+		labels:     res.labels,
+		release:    release,
+	}, nil
 }
 
 // HandleQuery hands the /query endpoint, calling the appropriate timeserie
 // or table handler.
 func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
-	if h.query == nil && h.tableQuery == nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	if !h.hasQuerier() {
+		writeError(w, ErrNotFound, http.StatusNotFound)
 		return
 	}
 
-	ctx := r.Context()
+	ctx, cancel := withTimeout(r.Context(), h.queryTimeout)
+	defer cancel()
 
 	req := simpleJSONQuery{}
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := h.decodeRequest(w, r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
+	if err := h.resolveRange(&req.Range, req.RangeRaw, req.Timezone); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Honour any timeout Grafana has given for the query.
+	if req.Timeout > 0 {
+		var cancelReq context.CancelFunc
+		ctx, cancelReq = context.WithTimeout(ctx, time.Duration(req.Timeout))
+		defer cancelReq()
+	}
+
+	if !h.includeHidden {
+		targets := req.Targets[:0]
+		for _, target := range req.Targets {
+			if !target.Hide {
+				targets = append(targets, target)
+			}
+		}
+		req.Targets = targets
+	}
 
-	var err error
-	var out []interface{}
 	for _, target := range req.Targets {
-		var res interface{}
 		switch target.Type {
 		case "", "timeserie":
-			if h.query == nil {
-				http.Error(w, "timeserie query not implemented", http.StatusBadRequest)
+			qr := queryRequest(req, target)
+			if _, ok := h.namedQuerier(&qr); !ok && h.query == nil && h.iterQuery == nil && h.seriesQuery == nil {
+				writeError(w, errors.New("timeserie query not implemented"), http.StatusBadRequest)
 				return
 			}
-			res, err = h.jsonQuery(ctx, req, target)
 		case "table":
-			if h.tableQuery == nil {
-				http.Error(w, "table query not implemented", http.StatusBadRequest)
+			if h.tableQuery == nil && h.tableIter == nil {
+				writeError(w, errors.New("table query not implemented"), http.StatusBadRequest)
+				return
+			}
+		case "heatmap":
+			if h.heatmapQuery == nil {
+				writeError(w, errors.New("heatmap query not implemented"), http.StatusBadRequest)
+				return
+			}
+		case "logs":
+			if h.logQuery == nil {
+				writeError(w, errors.New("logs query not implemented"), http.StatusBadRequest)
+				return
+			}
+		case "nodegraph":
+			if h.nodeGraphQuery == nil {
+				writeError(w, errors.New("node graph query not implemented"), http.StatusBadRequest)
 				return
 			}
-			res, err = h.jsonTableQuery(ctx, req, target)
 		default:
-			http.Error(w, "unknown query type, timeserie or table", 400)
+			writeError(w, fmt.Errorf("unknown query type %q, should be timeserie, table, heatmap, logs or nodegraph", target.Type), http.StatusBadRequest)
 			return
 		}
+	}
+
+	out := make([]interface{}, len(req.Targets))
+	g, gctx := errgroup.WithContext(ctx)
+	if h.maxConcurrentTargets > 0 {
+		g.SetLimit(h.maxConcurrentTargets)
+	}
+	for i, target := range req.Targets {
+		g.Go(func() (err error) {
+			start := time.Now()
+			span := h.startTargetSpan(gctx, target)
+			qr := queryRequest(req, target)
+			defer func() {
+				d := time.Since(start)
+				h.metrics.observeTarget(target.Type, d, err)
+				h.hooks.queryDone(ctx, qr, target.Type, d, out[i], err)
+				h.slowQueries.observe(h.now(), qr, target.Type, d, err)
+				if h.recentQueries != nil {
+					h.recentQueries.add(queryRecord(h.now(), qr, target.Type, d, err))
+				}
+				endSpan(span, err)
+			}()
+			defer catchPanic(&err)
+
+			if err = h.expandMacros(&qr); err != nil {
+				return err
+			}
+			pattern, aliased := h.parseAlias(&qr)
+			out[i], err = callWithDeadline(gctx, func(gctx context.Context) (interface{}, error) {
+				if aliased {
+					res, err := h.queryTarget(gctx, ctx, span, qr, target)
+					if err != nil {
+						return nil, err
+					}
+					return applyAlias(res, pattern, target.Target, qr.Target), nil
+				}
+				return h.queryTarget(gctx, ctx, span, qr, target)
+			})
+			return err
+		})
+	}
+	defer closeQueryResponse(out)
+	if err := g.Wait(); err != nil {
+		repanic(err)
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	out = flattenQueryResponse(out)
+	if h.arrowEncoding || h.msgpackEncoding || h.cborEncoding {
+		w.Header().Add("Vary", "Accept")
+	}
+	useArrow := h.responseEncoding(r) == ArrowContentType
+	if h.dataFrames || useArrow {
+		frames, err := dataFrames(out)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			writeError(w, err, http.StatusInternalServerError)
 			return
 		}
-		out = append(out, res)
+		out = frames
 	}
 
-	bs, err := json.Marshal(out)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	if useArrow {
+		w.Header().Set("Content-Type", ArrowContentType)
+		if err := writeArrowResponse(w, out); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	// Check everything can be encoded before we start streaming the
+	// response, after which we can no longer report an error.
+	if err := validateQueryResponse(out); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if enc, ct, ok := h.binaryEncoding(r); ok {
+		w.Header().Set("Content-Type", ct)
+		if err := writeBinaryResponse(w, enc, out); err != nil {
+			panic(http.ErrAbortHandler)
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(bs)
+	if err := writeQueryResponse(w, out); err != nil {
+		// The response has already been partially sent, abort the
+		// connection so that the client does not see a truncated
+		// response as a successful one.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// queryTarget answers a single target of a query. Iterators are consumed
+// after the targets' group has finished, so are passed ctx rather than
+// the group's context, gctx.
+func (h *Handler) queryTarget(gctx, ctx context.Context, span trace.Span, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	switch target.Type {
+	case "table":
+		if h.tableIter != nil {
+			// As with timeserie iterators, the rows are
+			// consumed after the group has finished.
+			return h.jsonTableIterQuery(trace.ContextWithSpan(ctx, span), qr, target)
+		}
+		return h.jsonTableQuery(trace.ContextWithSpan(gctx, span), qr, target)
+	case "heatmap":
+		return h.jsonHeatmapQuery(trace.ContextWithSpan(gctx, span), qr, target)
+	case "logs":
+		return h.jsonLogQuery(trace.ContextWithSpan(gctx, span), qr, target)
+	case "nodegraph":
+		return h.jsonNodeGraphQuery(trace.ContextWithSpan(gctx, span), qr, target)
+	default:
+		if q, ok := h.namedQuerier(&qr); ok {
+			return h.jsonQuery(trace.ContextWithSpan(gctx, span), q, qr, target)
+		}
+		if h.iterQuery != nil {
+			// The iterator is consumed after the group has
+			// finished, so cannot use the group's context.
+			return h.jsonIterQuery(trace.ContextWithSpan(ctx, span), qr, target)
+		}
+		if h.seriesQuery != nil {
+			return h.jsonSeriesQuery(trace.ContextWithSpan(gctx, span), qr, target)
+		}
+		return h.jsonQuery(trace.ContextWithSpan(gctx, span), h.query, qr, target)
+	}
 }
 
 /*
@@ -645,16 +1253,22 @@ func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
 */
 
 type simpleJSONAnnotation struct {
-	Name       string `json:"name"`
-	Datasource string `json:"datasource"`
-	Query      string `json:"query"`
-	Enable     bool   `json:"enable"`
-	IconColor  string `json:"iconColor"`
+	Name       string               `json:"name"`
+	Datasource annotationDatasource `json:"datasource"`
+	Query      string               `json:"query"`
+	Enable     bool                 `json:"enable"`
+	IconColor  string               `json:"iconColor"`
+	Tags       annotationTags       `json:"tags,omitempty"`
+	MatchAny   bool                 `json:"matchAny,omitempty"`
+	Limit      int                  `json:"limit,omitempty"`
 }
 
 type simpleJSONAnnotationResponse struct {
 	ReqAnnotation simpleJSONAnnotation `json:"annotation"`
+	ID            string               `json:"id,omitempty"`
 	Time          simpleJSONPTime      `json:"time"`
+	TimeEnd       *simpleJSONPTime     `json:"timeEnd,omitempty"`
+	IsRegion      bool                 `json:"isRegion,omitempty"`
 	RegionID      int                  `json:"regionId,omitempty"`
 	Title         string               `json:"title"`
 	Text          string               `json:"text"`
@@ -665,16 +1279,18 @@ type simpleJSONAnnotationsQuery struct {
 	Range      simpleJSONRange      `json:"range"`
 	RangeRaw   simpleJSONRawRange   `json:"rangeRaw"`
 	Annotation simpleJSONAnnotation `json:"annotation"`
+	Timezone   string               `json:"timezone"`
 }
 
 // HandleAnnotations responds to the /annotation requests.
 func (h *Handler) HandleAnnotations(w http.ResponseWriter, r *http.Request) {
 	if h.annotations == nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusBadRequest)
+		writeError(w, ErrNotFound, http.StatusNotFound)
 		return
 	}
 
-	ctx := r.Context()
+	ctx, cancel := withTimeout(r.Context(), h.annotationsTimeout)
+	defer cancel()
 
 	if r.Method == http.MethodOptions {
 		w.Write([]byte("Allow: POST,OPTIONS"))
@@ -682,58 +1298,47 @@ func (h *Handler) HandleAnnotations(w http.ResponseWriter, r *http.Request) {
 	}
 
 	req := simpleJSONAnnotationsQuery{}
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := h.decodeRequest(w, r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := h.resolveRange(&req.Range, req.RangeRaw, req.Timezone); err != nil {
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	resp := []simpleJSONAnnotationResponse{}
-	anns, err := h.annotations.GrafanaAnnotations(
-		ctx,
-		req.Annotation.Query,
-		AnnotationsArguments{
-			QueryCommonArguments{
-				From: time.Time(req.Range.From),
-				To:   time.Time(req.Range.To),
+	q := AnnotationQuery{
+		AnnotationsArguments: AnnotationsArguments{
+			QueryCommonArguments: QueryCommonArguments{
+				From:     time.Time(req.Range.From),
+				To:       time.Time(req.Range.To),
+				Timezone: req.Timezone,
+				Location: requestLocation(req.Timezone),
 			},
-		})
+			RawRange: RawRange(req.RangeRaw),
+		},
+		Query:    req.Annotation.Query,
+		Name:     req.Annotation.Name,
+		Tags:     req.Annotation.Tags,
+		MatchAny: req.Annotation.MatchAny,
+		Limit:    req.Annotation.Limit,
+	}
+	anns, err := callWithDeadline(ctx, func(ctx context.Context) ([]Annotation, error) {
+		return h.annotations.GrafanaAnnotationQuery(ctx, q)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
-
-	regionID := 1
-	for i := range anns {
-		startAnn := simpleJSONAnnotationResponse{
-			ReqAnnotation: req.Annotation,
-			Time:          simpleJSONPTime(anns[i].Time),
-			Title:         anns[i].Title,
-			Text:          anns[i].Text,
-			Tags:          anns[i].Tags,
-		}
-		if !anns[i].TimeEnd.IsZero() {
-			startAnn.RegionID = regionID
-		}
-		resp = append(resp, startAnn)
-
-		if !anns[i].TimeEnd.IsZero() {
-			endAnn := simpleJSONAnnotationResponse{
-				ReqAnnotation: req.Annotation,
-				Time:          simpleJSONPTime(anns[i].TimeEnd),
-				Title:         anns[i].Title,
-				Text:          anns[i].Text,
-				Tags:          anns[i].Tags,
-				RegionID:      regionID,
-			}
-			resp = append(resp, endAnn)
-			regionID++
-		}
+	if h.filterAnnotations {
+		anns = q.Filter(anns)
 	}
 
-	bs, err := json.Marshal(resp)
+	resp := annotationResponses(req.Annotation, anns, h.annotationRegions.modernAnnotations(r))
+
+	bs, err := marshalAnnotationResponses(resp, h.timeUnit)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -746,29 +1351,34 @@ type simpleJSONSearchQuery struct {
 
 // HandleSearch implements the /search endpoint.
 func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
-	if h.search == nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusBadRequest)
+	if h.search == nil && h.resultSearch == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
 		return
 	}
 
-	ctx := r.Context()
+	ctx, cancel := withTimeout(r.Context(), h.searchTimeout)
+	defer cancel()
 
 	req := simpleJSONSearchQuery{}
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := h.decodeRequest(w, r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	resp, err := h.search.GrafanaSearch(ctx, req.Target)
+	resp, err := callWithDeadline(ctx, func(ctx context.Context) (interface{}, error) {
+		if h.resultSearch != nil {
+			return h.resultSearch.GrafanaSearchResults(ctx, req.Target)
+		}
+		return h.search.GrafanaSearch(ctx, req.Target)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
 	bs, err := json.Marshal(resp)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -783,18 +1393,19 @@ type simpleJSONQueryAdhocKey struct {
 // HandleTagKeys implements the /tag-keys endpoint.
 func (h *Handler) HandleTagKeys(w http.ResponseWriter, r *http.Request) {
 	if h.tags == nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		writeError(w, ErrNotFound, http.StatusNotFound)
 		return
 	}
 
-	ctx := r.Context()
+	ctx, cancel := withTimeout(r.Context(), h.tagsTimeout)
+	defer cancel()
 
-	tags, err := h.tags.GrafanaAdhocFilterTags(ctx)
+	tags, err := callWithDeadline(ctx, h.tags.GrafanaAdhocFilterTags)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
-	var allTags []simpleJSONQueryAdhocKey
+	allTags := []simpleJSONQueryAdhocKey{}
 	for _, tag := range tags {
 		allTags = append(allTags, simpleJSONQueryAdhocKey{
 			Type: tag.tagType(),
@@ -804,7 +1415,7 @@ func (h *Handler) HandleTagKeys(w http.ResponseWriter, r *http.Request) {
 
 	bs, err := json.Marshal(allTags)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -818,33 +1429,35 @@ type simpleJSONTagValuesQuery struct {
 // HandleTagValues implements the /tag-values endpoint.
 func (h *Handler) HandleTagValues(w http.ResponseWriter, r *http.Request) {
 	if h.tags == nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusBadRequest)
+		writeError(w, ErrNotFound, http.StatusNotFound)
 		return
 	}
 
-	ctx := r.Context()
+	ctx, cancel := withTimeout(r.Context(), h.tagsTimeout)
+	defer cancel()
 
 	req := simpleJSONTagValuesQuery{}
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := h.decodeRequest(w, r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	vals, err := h.tags.GrafanaAdhocFilterTagValues(ctx, req.Key)
+	vals, err := callWithDeadline(ctx, func(ctx context.Context) ([]TagValuer, error) {
+		return h.tags.GrafanaAdhocFilterTagValues(ctx, req.Key)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	var allVals []json.RawMessage
+	allVals := []json.RawMessage{}
 	for _, val := range vals {
 		allVals = append(allVals, val.tagValue())
 	}
 
 	bs, err := json.Marshal(allVals)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -854,5 +1467,37 @@ func (h *Handler) HandleTagValues(w http.ResponseWriter, r *http.Request) {
 // ServeHTTP supports the http.Handler interface for a simplejson
 // handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+	h.live().handler.ServeHTTP(w, r)
+}
+
+// RootHandler returns the handler for the / endpoint. The endpoint
+// handlers do not include any of the middleware configured on h, and
+// can be mounted and wrapped individually.
+func (h *Handler) RootHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleRoot)
+}
+
+// QueryHandler returns the handler for the /query endpoint.
+func (h *Handler) QueryHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleQuery)
+}
+
+// AnnotationsHandler returns the handler for the /annotations endpoint.
+func (h *Handler) AnnotationsHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleAnnotations)
+}
+
+// SearchHandler returns the handler for the /search endpoint.
+func (h *Handler) SearchHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleSearch)
+}
+
+// TagKeysHandler returns the handler for the /tag-keys endpoint.
+func (h *Handler) TagKeysHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleTagKeys)
+}
+
+// TagValuesHandler returns the handler for the /tag-values endpoint.
+func (h *Handler) TagValuesHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleTagValues)
 }
@@ -2,10 +2,15 @@ package simplejson_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	simplejson "github.com/tcolgate/grafana-simple-json-go"
 )
@@ -42,7 +47,7 @@ func TestWithQuerier(t *testing.T) {
 
 	buf := &bytes.Buffer{}
 	io.Copy(buf, res.Body)
-	expect := `[{"target":"upper_50","datapoints":[[1234,1477917219866],[1500,1477917224866]]},{"target":"upper_75","datapoints":[[1234,1477917219866],[1500,1477917224866]]}]`
+	expect := `[{"target":"upper_50","refId":"A","datapoints":[[1234,1477917219866],[1500,1477917224866]]},{"target":"upper_75","refId":"B","datapoints":[[1234,1477917219866],[1500,1477917224866]]}]`
 
 	if buf.String() != expect {
 		t.Fatalf("\nexpected: %q\ngot:%s", expect, buf.String())
@@ -80,13 +85,66 @@ func TestWithTableQuerier(t *testing.T) {
 
 	buf := &bytes.Buffer{}
 	io.Copy(buf, res.Body)
-	expect := `[{"type":"table","columns":[{"text":"Time","type":"time"},{"text":"SomeText","type":"string"},{"text":"Value","type":"number"}],"rows":[["2016-10-31T12:33:44.866Z","blah",1]]}]`
+	expect := `[{"type":"table","refId":"A","columns":[{"text":"Time","type":"time"},{"text":"SomeText","type":"string"},{"text":"Value","type":"number"}],"rows":[["2016-10-31T12:33:44.866Z","blah",1]]}]`
 
 	if buf.String() != expect {
 		t.Fatalf("\nexpected: %q\ngot:%s", expect, buf.String())
 	}
 }
 
+func TestWithTableRequestQuerier(t *testing.T) {
+	var got simplejson.QueryRequest
+	gsj := simplejson.New(
+		simplejson.WithTableRequestQuerier(simplejson.TableRequestQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+			got = req
+			return []simplejson.TableColumn{{Text: "Value", Data: simplejson.TableNumberColumn{1}}}, nil
+		})),
+	)
+
+	q := `{
+		"range": {"from": "2016-10-31T06:33:44.866Z", "to": "2016-10-31T12:33:44.866Z"},
+		"interval": "30s",
+		"maxDataPoints": 550,
+		"targets": [{"target": "top", "refId": "B", "type": "table", "payload": {"limit": 10}}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","refId":"B","columns":[{"text":"Value","type":"number"}],"rows":[[1]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+	if got.Target != "top" || got.RefID != "B" || got.Interval != 30*time.Second || got.MaxDPs != 550 || string(got.Payload) != `{"limit": 10}` {
+		t.Fatalf("unexpected request %+v", got)
+	}
+}
+
+func TestTableTypedColumns(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return []simplejson.TableColumn{
+				{Text: "Up", Data: simplejson.TableBoolColumn{true, false}},
+				{Text: "Took", Data: simplejson.TableDurationColumn{1500 * time.Millisecond, time.Second}},
+				{Text: "Labels", Data: simplejson.TableJSONColumn{map[string]string{"a": "b"}, nil}},
+			}, nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "t", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","columns":[` +
+		`{"text":"Up","type":"boolean"},` +
+		`{"text":"Took","type":"number","unit":"ms"},` +
+		`{"text":"Labels","type":"other"}],` +
+		`"rows":[[true,1500,{"a":"b"}],[false,1000,null]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
 func TestWithAnnotator(t *testing.T) {
 	gsj := simplejson.New(
 		simplejson.WithAnnotator(GSJExample{}),
@@ -131,6 +189,32 @@ func TestWithSearcher(t *testing.T) {
 	}
 }
 
+func TestWithResultSearcher(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithResultSearcher(simplejson.ResultSearcherFunc(func(ctx context.Context, target string) ([]simplejson.SearchResult, error) {
+			return []simplejson.SearchResult{
+				{Text: "Web server", Value: "host-1"},
+				{Text: "Database", Value: 2},
+			}, nil
+		})),
+	)
+
+	// This is the format of the inbound request from Grafana
+	reqBuf := bytes.NewBufferString(`{"target": "hosts"}`)
+	req := httptest.NewRequest(http.MethodGet, "/search", reqBuf)
+	w := httptest.NewRecorder()
+
+	gsj.ServeHTTP(w, req)
+	res := w.Result()
+
+	buf := &bytes.Buffer{}
+	io.Copy(buf, res.Body)
+	expect := `[{"text":"Web server","value":"host-1"},{"text":"Database","value":2}]`
+	if buf.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, buf.String())
+	}
+}
+
 func TestWithTagSearcher_Keys(t *testing.T) {
 	gsj := simplejson.New(
 		simplejson.WithTagSearcher(GSJExample{}),
@@ -172,3 +256,305 @@ func TestWithTagSearcher_Values(t *testing.T) {
 		t.Fatalf("\nexpected: %q\ngot:%s", expect, buf.String())
 	}
 }
+
+type filterRecorder struct {
+	filters []simplejson.QueryAdhocFilter
+}
+
+func (fr *filterRecorder) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	fr.filters = args.Filters
+	return nil, nil
+}
+
+func TestWithQuerier_AdhocFilters(t *testing.T) {
+	fr := &filterRecorder{}
+	gsj := simplejson.New(
+		simplejson.WithQuerier(fr),
+	)
+
+	// This is the format of the inbound request from Grafana
+	q := `{
+				"range": {
+					"from": "2016-10-31T06:33:44.866Z",
+					"to": "2016-10-31T12:33:44.866Z",
+					"raw": { "from": "now-6h", "to": "now"}
+				},
+				"interval": "30s",
+				"targets": [
+					{ "target": "upper_50", "refId": "A" }
+				],
+				"adhocFilters": [
+					{ "key": "mykey", "operator": "=", "value": "value1" }
+				]
+			}`
+	reqBuf := bytes.NewBufferString(q)
+	req := httptest.NewRequest(http.MethodGet, "/query", reqBuf)
+	w := httptest.NewRecorder()
+
+	gsj.ServeHTTP(w, req)
+
+	expect := []simplejson.QueryAdhocFilter{
+		{Key: "mykey", Operator: "=", Value: "value1"},
+	}
+	if !reflect.DeepEqual(fr.filters, expect) {
+		t.Fatalf("\nexpected: %#v\ngot:%#v", expect, fr.filters)
+	}
+}
+
+type requestRecorder struct {
+	reqs []simplejson.QueryRequest
+}
+
+func (rr *requestRecorder) GrafanaQueryRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+	rr.reqs = append(rr.reqs, req)
+	return nil, nil
+}
+
+func TestWithRequestQuerier(t *testing.T) {
+	rr := &requestRecorder{}
+	gsj := simplejson.New(
+		simplejson.WithRequestQuerier(rr),
+	)
+
+	// This is the format of the inbound request from Grafana
+	q := `{
+				"range": {
+					"from": "2016-10-31T06:33:44.866Z",
+					"to": "2016-10-31T12:33:44.866Z",
+					"raw": { "from": "now-6h", "to": "now"}
+				},
+				"rangeRaw": { "from": "now-6h", "to": "now" },
+				"interval": "30s",
+				"targets": [
+					{ "target": "upper_50", "refId": "A" }
+				],
+				"scopedVars": {
+					"host": { "text": "myhost", "value": "myhost" }
+				},
+				"maxDataPoints": 550
+			}`
+	reqBuf := bytes.NewBufferString(q)
+	req := httptest.NewRequest(http.MethodGet, "/query", reqBuf)
+	w := httptest.NewRecorder()
+
+	gsj.ServeHTTP(w, req)
+
+	if len(rr.reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rr.reqs))
+	}
+	got := rr.reqs[0]
+	if got.Target != "upper_50" || got.RefID != "A" {
+		t.Fatalf("unexpected target %q refId %q", got.Target, got.RefID)
+	}
+	if got.RawRange != (simplejson.RawRange{From: "now-6h", To: "now"}) {
+		t.Fatalf("unexpected raw range %#v", got.RawRange)
+	}
+	if got.Interval != 30*time.Second || got.MaxDPs != 550 {
+		t.Fatalf("unexpected interval %v maxDPs %d", got.Interval, got.MaxDPs)
+	}
+	if got.ScopedVars["host"].Text != "myhost" {
+		t.Fatalf("unexpected scoped vars %#v", got.ScopedVars)
+	}
+}
+
+func TestQueryPayload(t *testing.T) {
+	rr := &requestRecorder{}
+	var tablePayload json.RawMessage
+	gsj := simplejson.New(
+		simplejson.WithRequestQuerier(rr),
+		simplejson.WithMaxConcurrentTargets(1),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			tablePayload = args.Payload
+			return nil, nil
+		})),
+	)
+
+	// This is the format of the inbound request from the JSON plugin
+	q := `{
+				"range": {
+					"from": "2016-10-31T06:33:44.866Z",
+					"to": "2016-10-31T12:33:44.866Z"
+				},
+				"targets": [
+					{ "target": "upper_50", "refId": "A", "payload": {"percentile": 50, "hosts": ["a", "b"]} },
+					{ "target": "upper_75", "refId": "B", "type": "table", "payload": {"percentile": 75} },
+					{ "target": "upper_90", "refId": "C" }
+				]
+			}`
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(rr.reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(rr.reqs))
+	}
+	payloads := map[string]string{}
+	for _, r := range rr.reqs {
+		payloads[r.Target] = string(r.Payload)
+	}
+	if expect := `{"percentile": 50, "hosts": ["a", "b"]}`; payloads["upper_50"] != expect {
+		t.Fatalf("\nexpected payload: %s\ngot: %s", expect, payloads["upper_50"])
+	}
+	if payloads["upper_90"] != "" {
+		t.Fatalf("expected no payload, got %s", payloads["upper_90"])
+	}
+	if expect := `{"percentile": 75}`; string(tablePayload) != expect {
+		t.Fatalf("\nexpected table payload: %s\ngot: %s", expect, tablePayload)
+	}
+}
+
+func TestHiddenTargets(t *testing.T) {
+	q := `{
+				"targets": [
+					{ "target": "upper_50", "refId": "A", "hide": true },
+					{ "target": "upper_75", "refId": "B" }
+				]
+			}`
+
+	rr := &requestRecorder{}
+	gsj := simplejson.New(
+		simplejson.WithRequestQuerier(rr),
+	)
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(rr.reqs) != 1 || rr.reqs[0].Target != "upper_75" {
+		t.Fatalf("expected only the visible target to be queried, got %#v", rr.reqs)
+	}
+	if expect := `[{"target":"upper_75","refId":"B","datapoints":[]}]`; w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	rr = &requestRecorder{}
+	gsj = simplejson.New(
+		simplejson.WithRequestQuerier(rr),
+		simplejson.WithHiddenTargets(),
+		simplejson.WithMaxConcurrentTargets(1),
+	)
+	req = httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(q))
+	w = httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if len(rr.reqs) != 2 || !rr.reqs[0].Hidden || rr.reqs[1].Hidden {
+		t.Fatalf("expected both targets, with the first hidden, got %#v", rr.reqs)
+	}
+}
+
+func TestWithSource_MixedTargets(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSource(GSJExample{}),
+	)
+
+	// This is the format of the inbound request from Grafana
+	q := `{
+				"range": {
+					"from": "2016-10-31T06:33:44.866Z",
+					"to": "2016-10-31T12:33:44.866Z",
+					"raw": { "from": "now-6h", "to": "now"}
+				},
+				"interval": "30s",
+				"targets": [
+					{ "target": "upper_50", "refId": "A", "type": "table"},
+					{ "target": "upper_75", "refId": "B", "type": "timeserie"}
+				]
+			}`
+	reqBuf := bytes.NewBufferString(q)
+	req := httptest.NewRequest(http.MethodGet, "/query", reqBuf)
+	w := httptest.NewRecorder()
+
+	gsj.ServeHTTP(w, req)
+	res := w.Result()
+
+	buf := &bytes.Buffer{}
+	io.Copy(buf, res.Body)
+	expect := `[{"type":"table","refId":"A","columns":[{"text":"Time","type":"time"},{"text":"SomeText","type":"string"},{"text":"Value","type":"number"}],"rows":[["2016-10-31T12:33:44.866Z","blah",1]]},{"target":"upper_75","refId":"B","datapoints":[[1234,1477917219866],[1500,1477917224866]]}]`
+
+	if buf.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, buf.String())
+	}
+}
+
+type concurrencyRecorder struct {
+	sync.Mutex
+	current, max int
+}
+
+func (cr *concurrencyRecorder) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	cr.Lock()
+	cr.current++
+	if cr.current > cr.max {
+		cr.max = cr.current
+	}
+	cr.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	cr.Lock()
+	cr.current--
+	cr.Unlock()
+	return nil, nil
+}
+
+func TestWithMaxConcurrentTargets(t *testing.T) {
+	q := `{
+				"range": {
+					"from": "2016-10-31T06:33:44.866Z",
+					"to": "2016-10-31T12:33:44.866Z"
+				},
+				"interval": "30s",
+				"targets": [
+					{ "target": "a", "refId": "A" },
+					{ "target": "b", "refId": "B" },
+					{ "target": "c", "refId": "C" },
+					{ "target": "d", "refId": "D" }
+				]
+			}`
+
+	for _, limit := range []int{1, 2} {
+		cr := &concurrencyRecorder{}
+		gsj := simplejson.New(
+			simplejson.WithQuerier(cr),
+			simplejson.WithMaxConcurrentTargets(limit),
+		)
+
+		req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(q))
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+		if cr.max > limit {
+			t.Fatalf("expected at most %d concurrent targets, got %d", limit, cr.max)
+		}
+	}
+}
+
+func TestEndpointHandlers(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSource(GSJExample{}),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ds/search", gsj.SearchHandler())
+	mux.Handle("/ds/tag-keys", gsj.TagKeysHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/ds/search", bytes.NewBufferString(`{"target": "upper_50"}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	expect := `["example1","example2","example3"]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ds/tag-keys", bytes.NewBufferString(`{}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	expect = `[{"type":"string","text":"mykey"}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejsontest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// A Fixture is a request, as made to a datasource by a particular version
+// of Grafana and datasource plugin.
+type Fixture struct {
+	Name     string // e.g. "query/timeserie"
+	Grafana  string // the Grafana version, e.g. "7.5.0"
+	Plugin   string // the datasource plugin making the request
+	Method   string
+	Endpoint string
+	Body     string
+}
+
+// Request returns an http.Request for the fixture. The User-Agent is set
+// as it would be by the Grafana datasource proxy.
+func (f Fixture) Request() *http.Request {
+	req, _ := http.NewRequest(f.Method, "http://datasource"+f.Endpoint, strings.NewReader(f.Body))
+	if f.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("User-Agent", "Grafana/"+f.Grafana)
+	return req
+}
+
+// The plugins that make requests to SimpleJSON datasources.
+const (
+	SimpleJSONPlugin = "grafana-simple-json-datasource"
+	JSONPlugin       = "simpod-json-datasource"
+)
+
+// Fixtures returns the canned requests for every endpoint.
+func Fixtures() []Fixture {
+	return append([]Fixture(nil), fixtures...)
+}
+
+// FixturesFor returns the canned requests for endpoint, e.g. "/query".
+func FixturesFor(endpoint string) []Fixture {
+	var out []Fixture
+	for _, f := range fixtures {
+		if f.Endpoint == endpoint {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+var fixtures = []Fixture{
+	{
+		Name: "test-datasource", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodGet, Endpoint: "/",
+	},
+	{
+		Name: "test-datasource", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodGet, Endpoint: "/",
+	},
+	{
+		Name: "query/timeserie", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/query",
+		Body: `{
+  "timezone": "browser",
+  "panelId": 2,
+  "range": {
+    "from": "2016-10-31T06:33:44.866Z",
+    "to": "2016-10-31T12:33:44.866Z",
+    "raw": {"from": "now-6h", "to": "now"}
+  },
+  "rangeRaw": {"from": "now-6h", "to": "now"},
+  "interval": "30s",
+  "intervalMs": 30000,
+  "targets": [
+    {"target": "upper_50", "refId": "A", "type": "timeserie"},
+    {"target": "upper_75", "refId": "B", "type": "timeserie"}
+  ],
+  "adhocFilters": [],
+  "format": "json",
+  "maxDataPoints": 550
+}`,
+	},
+	{
+		Name: "query/table", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/query",
+		Body: `{
+  "timezone": "browser",
+  "panelId": 3,
+  "range": {
+    "from": "2016-10-31T06:33:44.866Z",
+    "to": "2016-10-31T12:33:44.866Z",
+    "raw": {"from": "now-6h", "to": "now"}
+  },
+  "rangeRaw": {"from": "now-6h", "to": "now"},
+  "interval": "30s",
+  "intervalMs": 30000,
+  "targets": [
+    {"target": "requests", "refId": "A", "type": "table"}
+  ],
+  "adhocFilters": [{"key": "host", "operator": "=", "value": "web-1"}],
+  "format": "json",
+  "maxDataPoints": 550
+}`,
+	},
+	{
+		Name: "query/timeserie", Grafana: "7.5.0", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/query",
+		Body: `{
+  "app": "dashboard",
+  "requestId": "Q101",
+  "timezone": "browser",
+  "panelId": 2,
+  "dashboardId": 1,
+  "range": {
+    "from": "2016-10-31T06:33:44.866Z",
+    "to": "2016-10-31T12:33:44.866Z",
+    "raw": {"from": "now-6h", "to": "now"}
+  },
+  "timeInfo": "",
+  "interval": "30s",
+  "intervalMs": 30000,
+  "targets": [
+    {"target": "upper_50", "refId": "A", "type": "timeserie", "hide": false},
+    {"target": "upper_90", "refId": "B", "type": "timeserie", "hide": true}
+  ],
+  "maxDataPoints": 1100,
+  "scopedVars": {
+    "__interval": {"text": "30s", "value": "30s"},
+    "__interval_ms": {"text": "30000", "value": 30000}
+  },
+  "startTime": 1477917224866,
+  "rangeRaw": {"from": "now-6h", "to": "now"},
+  "adhocFilters": []
+}`,
+	},
+	{
+		Name: "query/timeserie", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodPost, Endpoint: "/query",
+		Body: `{
+  "app": "dashboard",
+  "requestId": "SQR100",
+  "timezone": "browser",
+  "range": {
+    "from": "2016-10-31T06:33:44.866Z",
+    "to": "2016-10-31T12:33:44.866Z",
+    "raw": {"from": "now-6h", "to": "now"}
+  },
+  "interval": "30s",
+  "intervalMs": 30000,
+  "targets": [
+    {
+      "datasource": {"type": "simpod-json-datasource", "uid": "P1809F7CD0C75ACF3"},
+      "refId": "A",
+      "payload": {"host": "web-1"},
+      "target": "upper_50",
+      "editorMode": "code"
+    }
+  ],
+  "maxDataPoints": 1100,
+  "scopedVars": {
+    "__interval": {"text": "30s", "value": "30s"},
+    "__interval_ms": {"text": "30000", "value": 30000}
+  },
+  "startTime": 1477917224866,
+  "rangeRaw": {"from": "now-6h", "to": "now"},
+  "adhocFilters": []
+}`,
+	},
+	{
+		Name: "annotations", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/annotations",
+		Body: `{
+  "range": {
+    "from": "2016-04-15T13:44:39.070Z",
+    "to": "2016-04-15T14:44:39.070Z",
+    "raw": {"from": "now-1h", "to": "now"}
+  },
+  "rangeRaw": {"from": "now-1h", "to": "now"},
+  "annotation": {
+    "name": "deploy",
+    "datasource": "Simple JSON Datasource",
+    "iconColor": "rgba(255, 96, 96, 1)",
+    "enable": true,
+    "query": "#deploy"
+  }
+}`,
+	},
+	{
+		Name: "annotations", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodPost, Endpoint: "/annotations",
+		Body: `{
+  "range": {
+    "from": "2016-04-15T13:44:39.070Z",
+    "to": "2016-04-15T14:44:39.070Z",
+    "raw": {"from": "now-1h", "to": "now"}
+  },
+  "rangeRaw": {"from": "now-1h", "to": "now"},
+  "annotation": {
+    "name": "deploy",
+    "datasource": {"type": "simpod-json-datasource", "uid": "P1809F7CD0C75ACF3"},
+    "iconColor": "red",
+    "enable": true,
+    "query": "#deploy",
+    "tags": ["prod"],
+    "matchAny": false,
+    "limit": 100
+  },
+  "dashboard": {"uid": "abc"}
+}`,
+	},
+	{
+		Name: "search", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/search",
+		Body: `{"target": "upper"}`,
+	},
+	{
+		Name: "search", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodPost, Endpoint: "/search",
+		Body: `{"target": ""}`,
+	},
+	{
+		Name: "tag-keys", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/tag-keys",
+		Body: `{}`,
+	},
+	{
+		Name: "tag-values", Grafana: "5.4.3", Plugin: SimpleJSONPlugin,
+		Method: http.MethodPost, Endpoint: "/tag-values",
+		Body: `{"key": "host"}`,
+	},
+	{
+		Name: "tag-keys", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodPost, Endpoint: "/tag-keys",
+		Body: `{}`,
+	},
+	{
+		Name: "tag-values", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodPost, Endpoint: "/tag-values",
+		Body: `{"key": "host"}`,
+	},
+	{
+		Name: "variable", Grafana: "10.4.0", Plugin: JSONPlugin,
+		Method: http.MethodPost, Endpoint: "/variable",
+		Body: `{
+  "payload": {"target": "hosts"},
+  "range": {
+    "from": "2016-10-31T06:33:44.866Z",
+    "to": "2016-10-31T12:33:44.866Z",
+    "raw": {"from": "now-6h", "to": "now"}
+  },
+  "rangeRaw": {"from": "now-6h", "to": "now"}
+}`,
+	},
+}
@@ -0,0 +1,328 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package simplejsontest provides utilities for testing SimpleJSON
+// datasources without standing up Grafana: canned requests, as made by
+// several versions of Grafana, a check that a datasource handles them,
+// assertions against golden responses, and a fake Grafana to drive a
+// datasource from tests.
+//
+//	func TestProtocol(t *testing.T) {
+//		simplejsontest.CheckFixtures(t, simplejson.New(simplejson.WithSource(mySource{})))
+//	}
+package simplejsontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// CheckFixtures sends each of the fixtures to h, failing t if a request
+// is rejected, or the response is not in the form Grafana expects.
+// Fixtures for endpoints that h does not implement, for which it responds
+// with a 404, are skipped.
+func CheckFixtures(t testing.TB, h http.Handler) {
+	t.Helper()
+	for _, f := range fixtures {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, f.Request())
+
+		name := fmt.Sprintf("%s (Grafana %s, %s)", f.Name, f.Grafana, f.Plugin)
+		switch {
+		case w.Code == http.StatusNotFound && f.Endpoint != "/":
+			continue
+		case w.Code != http.StatusOK:
+			t.Errorf("%s: unexpected status %d, %s", name, w.Code, w.Body.String())
+		default:
+			if err := CheckResponse(f.Endpoint, w.Body.Bytes()); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+// CheckResponse checks that body is in the form Grafana expects for a
+// response from endpoint.
+func CheckResponse(endpoint string, body []byte) error {
+	if endpoint == "/" {
+		return nil
+	}
+
+	var items []map[string]json.RawMessage
+	if endpoint == "/search" {
+		// search results may be strings, or text/value pairs.
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return fmt.Errorf("expected a JSON array, %w", err)
+		}
+		for _, r := range raws {
+			var s string
+			if json.Unmarshal(r, &s) == nil {
+				continue
+			}
+			var item map[string]json.RawMessage
+			if err := json.Unmarshal(r, &item); err != nil {
+				return fmt.Errorf("search results should be strings or objects, got %s", r)
+			}
+			items = append(items, item)
+		}
+	} else if err := json.Unmarshal(body, &items); err != nil {
+		return fmt.Errorf("expected a JSON array of objects, %w", err)
+	}
+
+	for i, item := range items {
+		var required []string
+		switch endpoint {
+		case "/query":
+			switch {
+			case item["datapoints"] != nil:
+				var dps [][2]*float64
+				if err := json.Unmarshal(item["datapoints"], &dps); err != nil {
+					return fmt.Errorf("result %d: datapoints should be [value, timestamp] pairs, %w", i, err)
+				}
+				required = []string{"target"}
+			case item["schema"] != nil:
+				required = []string{"data"}
+			default:
+				required = []string{"type", "columns", "rows"}
+			}
+		case "/annotations":
+			required = []string{"time", "title", "text"}
+		case "/search":
+			required = []string{"text", "value"}
+		case "/tag-keys":
+			required = []string{"type", "text"}
+		case "/tag-values":
+			required = []string{"text"}
+		case "/variable":
+			required = []string{"__text", "__value"}
+		}
+		for _, k := range required {
+			if _, ok := item[k]; !ok {
+				return fmt.Errorf("result %d: missing %q field", i, k)
+			}
+		}
+	}
+	return nil
+}
+
+// AssertJSONEqual fails t if got and want are not equivalent JSON
+// documents, ignoring formatting and the order of object keys.
+func AssertJSONEqual(t testing.TB, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %q: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid expected JSON %q: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("\nexpected: %s\ngot:      %s", compact(want), compact(string(got)))
+	}
+}
+
+func compact(s string) string {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, []byte(s)); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+// UpdateGoldenEnv is the environment variable which, if set, causes
+// AssertGolden to write the golden files rather than compare against them.
+const UpdateGoldenEnv = "SIMPLEJSONTEST_UPDATE"
+
+// AssertGolden compares got to the JSON document in
+// testdata/<name>.golden, as with AssertJSONEqual. If the
+// SIMPLEJSONTEST_UPDATE environment variable is set the file is written
+// instead.
+func AssertGolden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		buf := &bytes.Buffer{}
+		if err := json.Indent(buf, got, "", "  "); err != nil {
+			t.Fatalf("invalid JSON %q: %v", got, err)
+		}
+		buf.WriteByte('\n')
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, set %s=1 to create it: %v", UpdateGoldenEnv, err)
+	}
+	AssertJSONEqual(t, got, string(want))
+}
+
+// Grafana drives a datasource in the way Grafana would, making requests
+// directly to its handler. Each method fails the test if the request is
+// not successful, or the response is not in the form Grafana expects,
+// and returns the body of the response.
+type Grafana struct {
+	Handler http.Handler
+
+	// Version is the Grafana version sent in the User-Agent.
+	Version string
+	// From and To give the time range of requests.
+	From, To time.Time
+	// Interval and MaxDataPoints are sent with queries.
+	Interval      time.Duration
+	MaxDataPoints int
+}
+
+// NewGrafana returns a Grafana that makes requests to h, with a time
+// range of the hour up to now.
+func NewGrafana(h http.Handler) *Grafana {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return &Grafana{
+		Handler:       h,
+		Version:       "10.4.0",
+		From:          now.Add(-time.Hour),
+		To:            now,
+		Interval:      30 * time.Second,
+		MaxDataPoints: 120,
+	}
+}
+
+// A Target is a query target.
+type Target struct {
+	Target  string      `json:"target"`
+	RefID   string      `json:"refId,omitempty"`
+	Type    string      `json:"type,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type grafanaRange struct {
+	From time.Time         `json:"from"`
+	To   time.Time         `json:"to"`
+	Raw  map[string]string `json:"raw"`
+}
+
+func (g *Grafana) timeRange() grafanaRange {
+	raw := map[string]string{"from": g.From.Format(time.RFC3339Nano), "to": g.To.Format(time.RFC3339Nano)}
+	return grafanaRange{From: g.From, To: g.To, Raw: raw}
+}
+
+// TestDatasource makes the request Grafana makes when testing the
+// connection to a datasource.
+func (g *Grafana) TestDatasource(t testing.TB) []byte {
+	t.Helper()
+	return g.do(t, http.MethodGet, "/", nil)
+}
+
+// Query queries targets. Targets without a RefID are given one.
+func (g *Grafana) Query(t testing.TB, targets ...Target) []byte {
+	t.Helper()
+	for i := range targets {
+		if targets[i].RefID == "" {
+			targets[i].RefID = string(rune('A' + i%26))
+		}
+	}
+	r := g.timeRange()
+	return g.do(t, http.MethodPost, "/query", map[string]interface{}{
+		"range":         r,
+		"rangeRaw":      r.Raw,
+		"interval":      g.Interval.String(),
+		"intervalMs":    g.Interval.Milliseconds(),
+		"maxDataPoints": g.MaxDataPoints,
+		"targets":       targets,
+		"adhocFilters":  []interface{}{},
+	})
+}
+
+// Annotations queries annotations.
+func (g *Grafana) Annotations(t testing.TB, query string) []byte {
+	t.Helper()
+	r := g.timeRange()
+	return g.do(t, http.MethodPost, "/annotations", map[string]interface{}{
+		"range":    r,
+		"rangeRaw": r.Raw,
+		"annotation": map[string]interface{}{
+			"name":   "annotations",
+			"enable": true,
+			"query":  query,
+		},
+	})
+}
+
+// Search searches for target.
+func (g *Grafana) Search(t testing.TB, target string) []byte {
+	t.Helper()
+	return g.do(t, http.MethodPost, "/search", map[string]string{"target": target})
+}
+
+// TagKeys requests the keys for adhoc filters.
+func (g *Grafana) TagKeys(t testing.TB) []byte {
+	t.Helper()
+	return g.do(t, http.MethodPost, "/tag-keys", map[string]string{})
+}
+
+// TagValues requests the values of an adhoc filter key.
+func (g *Grafana) TagValues(t testing.TB, key string) []byte {
+	t.Helper()
+	return g.do(t, http.MethodPost, "/tag-values", map[string]string{"key": key})
+}
+
+// Variable queries a template variable.
+func (g *Grafana) Variable(t testing.TB, payload interface{}) []byte {
+	t.Helper()
+	r := g.timeRange()
+	return g.do(t, http.MethodPost, "/variable", map[string]interface{}{
+		"payload":  payload,
+		"range":    r,
+		"rangeRaw": r.Raw,
+	})
+}
+
+func (g *Grafana) do(t testing.TB, method, path string, body interface{}) []byte {
+	t.Helper()
+	var req *http.Request
+	if body == nil {
+		req = httptest.NewRequest(method, path, nil)
+	} else {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = httptest.NewRequest(method, path, bytes.NewReader(bs))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "Grafana/"+g.Version)
+
+	w := httptest.NewRecorder()
+	g.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s: unexpected status %d, %s", method, path, w.Code, w.Body.String())
+	}
+	if err := CheckResponse(path, w.Body.Bytes()); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return w.Body.Bytes()
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejsontest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/simplejsontest"
+)
+
+type source struct{}
+
+func (source) GrafanaQueryRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+	return []simplejson.DataPoint{{Time: req.From, Value: 1}, simplejson.NullDataPoint(req.To)}, nil
+}
+
+func (source) GrafanaQueryTableRequest(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumn, error) {
+	return []simplejson.TableColumn{{Text: "Target", Data: simplejson.TableStringColumn{req.Target}}}, nil
+}
+
+func (source) GrafanaAnnotationQuery(ctx context.Context, q simplejson.AnnotationQuery) ([]simplejson.Annotation, error) {
+	return []simplejson.Annotation{{Time: q.From, Title: q.Query}}, nil
+}
+
+func (source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	return []string{target + "_50"}, nil
+}
+
+func (source) GrafanaAdhocFilterTags(ctx context.Context) ([]simplejson.TagInfoer, error) {
+	return []simplejson.TagInfoer{simplejson.TagStringKey("host")}, nil
+}
+
+func (source) GrafanaAdhocFilterTagValues(ctx context.Context, key string) ([]simplejson.TagValuer, error) {
+	return []simplejson.TagValuer{simplejson.TagStringValue("web-1")}, nil
+}
+
+func (source) GrafanaVariable(ctx context.Context, args simplejson.VariableArguments) ([]simplejson.VariableValue, error) {
+	return []simplejson.VariableValue{{Text: "Web 1", Value: "web-1"}}, nil
+}
+
+// failures records the failures reported to it.
+type failures struct {
+	testing.TB
+	msgs []string
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(format string, args ...interface{}) {
+	f.msgs = append(f.msgs, fmt.Sprintf(format, args...))
+}
+
+func (f *failures) Fatalf(format string, args ...interface{}) {
+	f.Errorf(format, args...)
+}
+
+func TestCheckFixtures(t *testing.T) {
+	simplejsontest.CheckFixtures(t, simplejson.New(simplejson.WithSource(source{})))
+
+	// Unimplemented endpoints are skipped.
+	simplejsontest.CheckFixtures(t, simplejson.New())
+}
+
+func TestCheckFixtures_Failures(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithRequestQuerier(simplejson.RequestQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.DataPoint, error) {
+		return nil, simplejson.Error{Status: http.StatusBadGateway, Message: "failed"}
+	})))
+
+	f := &failures{TB: t}
+	simplejsontest.CheckFixtures(f, gsj)
+	if n := len(simplejsontest.FixturesFor("/query")); len(f.msgs) != n {
+		t.Fatalf("expected %d failures, got %q", n, f.msgs)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint, body string
+		ok             bool
+	}{
+		{"/query", `[{"target":"a","datapoints":[[1,1000],[null,2000]]}]`, true},
+		{"/query", `[{"type":"table","columns":[],"rows":[]}]`, true},
+		{"/query", `[{"target":"a","datapoints":[["x",1000]]}]`, false},
+		{"/query", `{"target":"a"}`, false},
+		{"/search", `["a",{"text":"b","value":1}]`, true},
+		{"/search", `[1]`, false},
+		{"/annotations", `[{"time":1,"title":"a"}]`, false},
+		{"/tag-keys", `[{"type":"string","text":"host"}]`, true},
+	} {
+		if err := simplejsontest.CheckResponse(tc.endpoint, []byte(tc.body)); (err == nil) != tc.ok {
+			t.Errorf("%s %s: unexpected result %v", tc.endpoint, tc.body, err)
+		}
+	}
+}
+
+func TestAssertJSONEqual(t *testing.T) {
+	simplejsontest.AssertJSONEqual(t, []byte(`{"b": [1, 2], "a": "x"}`), `{"a":"x","b":[1,2]}`)
+
+	f := &failures{TB: t}
+	simplejsontest.AssertJSONEqual(f, []byte(`{"a": 1}`), `{"a": 2}`)
+	if len(f.msgs) != 1 {
+		t.Fatalf("expected a failure, got %q", f.msgs)
+	}
+}
+
+func TestGrafana(t *testing.T) {
+	g := simplejsontest.NewGrafana(simplejson.New(simplejson.WithSource(source{})))
+	g.From = time.UnixMilli(1000).UTC()
+	g.To = time.UnixMilli(2000).UTC()
+
+	g.TestDatasource(t)
+	simplejsontest.AssertGolden(t, "query", g.Query(t,
+		simplejsontest.Target{Target: "cpu"},
+		simplejsontest.Target{Target: "requests", Type: "table"},
+	))
+	simplejsontest.AssertJSONEqual(t, g.Search(t, "cpu"), `["cpu_50"]`)
+	simplejsontest.AssertJSONEqual(t, g.TagKeys(t), `[{"type":"string","text":"host"}]`)
+	simplejsontest.AssertJSONEqual(t, g.TagValues(t, "host"), `[{"text":"web-1"}]`)
+	simplejsontest.AssertJSONEqual(t, g.Variable(t, map[string]string{"target": "hosts"}), `[{"__text":"Web 1","__value":"web-1"}]`)
+
+	anns := g.Annotations(t, "deploys")
+	if err := simplejsontest.CheckResponse("/annotations", anns); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A QueryRecord describes a completed query target, as held in memory
+// by WithSlowQueryBuffer and WithRecentQueries.
+type QueryRecord struct {
+	Time          time.Time     `json:"time"`
+	Target        string        `json:"target"`
+	RefID         string        `json:"refId,omitempty"`
+	Type          string        `json:"type"`
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	Interval      time.Duration `json:"interval"`
+	MaxDataPoints int           `json:"maxDataPoints"`
+	Duration      time.Duration `json:"duration"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// SlowQuery is the record of a slow query, as returned by SlowQueries.
+// It is the same type as QueryRecord.
+type SlowQuery = QueryRecord
+
+// WithSlowQueryLog logs every query target that takes longer than
+// threshold to logger, along with its range and interval. If logger is
+// nil the standard logger is used. The most recent slow queries can also
+// be kept in memory, see WithSlowQueryBuffer.
+func WithSlowQueryLog(threshold time.Duration, logger *log.Logger) Opt {
+	return func(sjc *Handler) error {
+		if threshold < 0 {
+			return fmt.Errorf("negative slow query threshold %v", threshold)
+		}
+		if logger == nil {
+			logger = log.Default()
+		}
+		sql := sjc.slowQueryLog()
+		sql.threshold, sql.logger = threshold, logger
+		return nil
+	}
+}
+
+// WithSlowQueryBuffer keeps the last size slow queries logged by
+// WithSlowQueryLog in memory. They can be retrieved with SlowQueries, or
+// served as JSON by SlowQueriesHandler.
+func WithSlowQueryBuffer(size int) Opt {
+	return func(sjc *Handler) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid slow query buffer size %d", size)
+		}
+		sjc.slowQueryLog().ring = newQueryRing(size)
+		return nil
+	}
+}
+
+// slowQueryLog records slow query targets.
+type slowQueryLog struct {
+	threshold time.Duration
+	logger    *log.Logger // nil until WithSlowQueryLog is given
+	ring      *queryRing  // may be nil
+}
+
+func (h *Handler) slowQueryLog() *slowQueryLog {
+	if h.slowQueries == nil {
+		h.slowQueries = &slowQueryLog{}
+	}
+	return h.slowQueries
+}
+
+// observe records the target if it was slow. It is safe to call on a nil
+// *slowQueryLog.
+func (sql *slowQueryLog) observe(at time.Time, qr QueryRequest, typ string, d time.Duration, err error) {
+	if sql == nil || sql.logger == nil || d < sql.threshold {
+		return
+	}
+	rec := queryRecord(at, qr, typ, d, err)
+	sql.logger.Printf("simplejson: slow %s target %q took %v, range %s to %s, interval %v",
+		rec.Type, qr.Target, d, qr.From.Format(time.RFC3339), qr.To.Format(time.RFC3339), qr.Interval)
+	sql.ring.add(rec)
+}
+
+func queryRecord(at time.Time, qr QueryRequest, typ string, d time.Duration, err error) QueryRecord {
+	if typ == "" {
+		typ = "timeserie"
+	}
+	rec := QueryRecord{
+		Time:          at,
+		Target:        qr.Target,
+		RefID:         qr.RefID,
+		Type:          typ,
+		From:          qr.From,
+		To:            qr.To,
+		Interval:      qr.Interval,
+		MaxDataPoints: qr.MaxDPs,
+		Duration:      d,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// queryRing holds the most recent query records.
+type queryRing struct {
+	mu   sync.Mutex
+	buf  []QueryRecord
+	next int
+	full bool
+}
+
+func newQueryRing(size int) *queryRing {
+	return &queryRing{buf: make([]QueryRecord, size)}
+}
+
+// add records rec, replacing the oldest record if the ring is full. It is
+// safe to call on a nil *queryRing.
+func (ring *queryRing) add(rec QueryRecord) {
+	if ring == nil {
+		return
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.buf[ring.next] = rec
+	ring.next++
+	if ring.next == len(ring.buf) {
+		ring.next, ring.full = 0, true
+	}
+}
+
+// records returns the records held, oldest first. It returns nil for a
+// nil *queryRing.
+func (ring *queryRing) records() []QueryRecord {
+	if ring == nil {
+		return nil
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	out := append([]QueryRecord{}, ring.buf[:ring.next]...)
+	if ring.full {
+		out = append(append([]QueryRecord{}, ring.buf[ring.next:]...), out...)
+	}
+	return out
+}
+
+// writeJSON responds with the records held, or a 404 for a nil
+// *queryRing.
+func (ring *queryRing) writeJSON(w http.ResponseWriter) {
+	if ring == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	bs, err := json.Marshal(ring.records())
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// slowQueryRing returns the ring buffer of slow queries, if any.
+func (h *Handler) slowQueryRing() *queryRing {
+	if h.slowQueries == nil {
+		return nil
+	}
+	return h.slowQueries.ring
+}
+
+// SlowQueries returns the slow queries held in memory, oldest first. It
+// returns nil unless WithSlowQueryBuffer was given.
+func (h *Handler) SlowQueries() []QueryRecord {
+	return h.live().slowQueryRing().records()
+}
+
+// HandleSlowQueries responds with the slow queries held in memory, as a
+// JSON array, see WithSlowQueryBuffer.
+func (h *Handler) HandleSlowQueries(w http.ResponseWriter, r *http.Request) {
+	h.slowQueryRing().writeJSON(w)
+}
+
+// SlowQueriesHandler returns a handler serving the slow queries held in
+// memory. The queries may include details that should not be visible to
+// Grafana users, so the handler should be mounted on an internal admin
+// listener. The Handler itself only serves them, as /slow-queries, if
+// WithAdminPrefix is given, see AdminHandler.
+func (h *Handler) SlowQueriesHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleSlowQueries)
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func slowQueryTestQuerier(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	if target == "slow" {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil, nil
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(slowQueryTestQuerier)),
+		simplejson.WithSlowQueryLog(10*time.Millisecond, log.New(&buf, "", 0)),
+	)
+
+	body := `{"range":{"from":"2016-10-31T06:33:44.866Z","to":"2016-10-31T12:33:44.866Z"},"interval":"30s","targets":[{"target":"slow","refId":"A"},{"target":"fast","refId":"B"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	out := buf.String()
+	if !strings.Contains(out, `slow timeserie target "slow" took`) ||
+		!strings.Contains(out, "range 2016-10-31T06:33:44Z to 2016-10-31T12:33:44Z, interval 30s") {
+		t.Errorf("unexpected log output: %s", out)
+	}
+	if strings.Contains(out, `"fast"`) {
+		t.Errorf("fast target logged: %s", out)
+	}
+	if qs := gsj.SlowQueries(); qs != nil {
+		t.Errorf("expected no buffered queries without WithSlowQueryBuffer, got %v", qs)
+	}
+}
+
+func TestSlowQueryBuffer(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(simplejson.QuerierFunc(slowQueryTestQuerier)),
+		simplejson.WithSlowQueryLog(0, log.New(&bytes.Buffer{}, "", 0)),
+		simplejson.WithSlowQueryBuffer(2),
+	)
+
+	for _, target := range []string{"a", "b", "c"} {
+		body := `{"targets":[{"target":"` + target + `"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+		gsj.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	qs := gsj.SlowQueries()
+	if len(qs) != 2 || qs[0].Target != "b" || qs[1].Target != "c" {
+		t.Fatalf("unexpected slow queries: %+v", qs)
+	}
+
+	w := httptest.NewRecorder()
+	gsj.SlowQueriesHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var got []simplejson.SlowQuery
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(got) != 2 || got[1].Target != "c" || got[1].Type != "timeserie" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+}
+
+func TestSlowQueriesHandler_NotEnabled(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithQuerier(simplejson.QuerierFunc(slowQueryTestQuerier)))
+
+	w := httptest.NewRecorder()
+	gsj.SlowQueriesHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestSlowQueryBuffer_RequiresLog(t *testing.T) {
+	_, err := simplejson.NewWithError(
+		simplejson.WithQuerier(simplejson.QuerierFunc(slowQueryTestQuerier)),
+		simplejson.WithSlowQueryBuffer(10),
+	)
+	if err == nil || !strings.Contains(err.Error(), "WithSlowQueryBuffer requires WithSlowQueryLog") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// A StateChange records that an entity entered State at Time, for display
+// on state timeline and status history panels.
+type StateChange struct {
+	Time  time.Time
+	State string
+}
+
+// CompressStates returns changes sorted by time, with any change to the
+// state the entity was already in removed, which keeps payloads small for
+// states that are sampled frequently but change rarely. changes is not
+// modified.
+func CompressStates(changes []StateChange) []StateChange {
+	sorted := slices.Clone(changes)
+	slices.SortStableFunc(sorted, func(a, b StateChange) int { return a.Time.Compare(b.Time) })
+
+	out := sorted[:0]
+	for i, c := range sorted {
+		if i > 0 && c.State == out[len(out)-1].State {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// StatesFromPoints converts a numeric series to state changes, using
+// mapping to give the state for each value, as a Grafana value mapping
+// would. Values with no mapping are formatted as numbers, and null points
+// are skipped. The result is compressed, see CompressStates.
+func StatesFromPoints(points []DataPoint, mapping map[float64]string) []StateChange {
+	changes := make([]StateChange, 0, len(points))
+	for _, dp := range points {
+		if dp.Null {
+			continue
+		}
+		state, ok := mapping[dp.Value]
+		if !ok {
+			state = strconv.FormatFloat(dp.Value, 'f', -1, 64)
+		}
+		changes = append(changes, StateChange{Time: dp.Time, State: state})
+	}
+	return CompressStates(changes)
+}
+
+// StateTimelineColumns returns a table for a state timeline panel, with a
+// time column followed by a string column for each entity in states, in
+// the order of their names. The table has a row for each time at which any
+// entity changed state, giving the state every entity was in at that
+// time, or null for entities with no state yet.
+func StateTimelineColumns(states map[string][]StateChange) []TableColumn {
+	names := slices.Sorted(maps.Keys(states))
+
+	compressed := make([][]StateChange, len(names))
+	var times []time.Time
+	for j, name := range names {
+		compressed[j] = CompressStates(states[name])
+		for _, c := range compressed[j] {
+			times = append(times, c.Time)
+		}
+	}
+	slices.SortFunc(times, time.Time.Compare)
+	times = slices.CompactFunc(times, time.Time.Equal)
+
+	cols := []TableColumn{{Text: "time", Data: TableTimeColumn(times)}}
+	for j, name := range names {
+		data := make(Column[*string], len(times))
+		var cur *string
+		k := 0
+		for i, t := range times {
+			for k < len(compressed[j]) && !compressed[j][k].Time.After(t) {
+				cur = &compressed[j][k].State
+				k++
+			}
+			data[i] = cur
+		}
+		cols = append(cols, TableColumn{Text: name, Data: data})
+	}
+	return cols
+}
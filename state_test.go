@@ -0,0 +1,95 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestCompressStates(t *testing.T) {
+	in := []simplejson.StateChange{
+		{Time: time.Unix(3, 0), State: "down"},
+		{Time: time.Unix(1, 0), State: "up"},
+		{Time: time.Unix(2, 0), State: "up"},
+		{Time: time.Unix(4, 0), State: "down"},
+		{Time: time.Unix(5, 0), State: "up"},
+	}
+	expect := []simplejson.StateChange{
+		{Time: time.Unix(1, 0), State: "up"},
+		{Time: time.Unix(3, 0), State: "down"},
+		{Time: time.Unix(5, 0), State: "up"},
+	}
+	if got := simplejson.CompressStates(in); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("\nexpected: %v\ngot:      %v", expect, got)
+	}
+	if in[0].State != "down" {
+		t.Fatalf("input was modified")
+	}
+}
+
+func TestStatesFromPoints(t *testing.T) {
+	points := []simplejson.DataPoint{
+		{Time: time.Unix(1, 0), Value: 1},
+		{Time: time.Unix(2, 0), Value: 1},
+		simplejson.NullDataPoint(time.Unix(3, 0)),
+		{Time: time.Unix(4, 0), Value: 0},
+		{Time: time.Unix(5, 0), Value: 2.5},
+	}
+	expect := []simplejson.StateChange{
+		{Time: time.Unix(1, 0), State: "up"},
+		{Time: time.Unix(4, 0), State: "down"},
+		{Time: time.Unix(5, 0), State: "2.5"},
+	}
+	got := simplejson.StatesFromPoints(points, map[float64]string{0: "down", 1: "up"})
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("\nexpected: %v\ngot:      %v", expect, got)
+	}
+}
+
+func TestStateTimelineColumns(t *testing.T) {
+	states := map[string][]simplejson.StateChange{
+		"web-1": {
+			{Time: time.Unix(1, 0).UTC(), State: "up"},
+			{Time: time.Unix(2, 0).UTC(), State: "up"},
+			{Time: time.Unix(3, 0).UTC(), State: "down"},
+		},
+		"web-2": {
+			{Time: time.Unix(2, 0).UTC(), State: "up"},
+		},
+	}
+
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return simplejson.StateTimelineColumns(states), nil
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "hosts", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","columns":[{"text":"time","type":"time"},{"text":"web-1","type":"string"},{"text":"web-2","type":"string"}],` +
+		`"rows":[["1970-01-01T00:00:01Z","up",null],["1970-01-01T00:00:02Z","up","up"],["1970-01-01T00:00:03Z","down","up"]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// A StreamingQuerier pushes datapoints to live dashboards. Clients
+// subscribe to a named channel, e.g. "cpu/host=web-1", with a GET request
+// to /stream/{channel}, and receive the datapoints as server-sent events.
+//
+// GrafanaStream is called when the first client subscribes to a channel,
+// and should call send for each new datapoint until ctx is cancelled,
+// which happens once the last client has unsubscribed. All the clients
+// subscribed to a channel share the one call. If GrafanaStream returns
+// before ctx is cancelled the stream ends, any error is reported to the
+// subscribed clients, and they are disconnected.
+type StreamingQuerier interface {
+	GrafanaStream(ctx context.Context, channel string, send func(DataPoint)) error
+}
+
+// The StreamingQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a StreamingQuerier.
+type StreamingQuerierFunc func(ctx context.Context, channel string, send func(DataPoint)) error
+
+// GrafanaStream calls f(ctx, channel, send).
+func (f StreamingQuerierFunc) GrafanaStream(ctx context.Context, channel string, send func(DataPoint)) error {
+	return f(ctx, channel, send)
+}
+
+// DefaultStreamBuffer is the number of datapoints buffered for each
+// subscriber to a stream, see WithStreamBuffer.
+const DefaultStreamBuffer = 64
+
+// streamKeepAlive is the interval at which comments are sent to idle
+// subscribers, to stop proxies closing the connection.
+const streamKeepAlive = 15 * time.Second
+
+// WithStreamingQuerier adds a handler for live streams, served on
+// /stream/{channel}.
+func WithStreamingQuerier(q StreamingQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.streams = newStreamHub(q)
+		return nil
+	}
+}
+
+// WithStreamBuffer sets the number of datapoints buffered for each
+// subscriber to a stream, the default is DefaultStreamBuffer. Points are
+// sent to a subscriber in batches of up to this size, and if a subscriber
+// is too slow to keep up, the oldest buffered points are discarded.
+func WithStreamBuffer(n int) Opt {
+	return func(sjc *Handler) error {
+		if n <= 0 {
+			return fmt.Errorf("stream buffer must be positive, got %d", n)
+		}
+		sjc.streamBuffer = n
+		return nil
+	}
+}
+
+// streamChannelRE matches valid channel names, one or more segments
+// separated by slashes.
+var streamChannelRE = regexp.MustCompile(`^[A-Za-z0-9_.:=\-]+(/[A-Za-z0-9_.:=\-]+)*$`)
+
+// maxStreamChannelLen is the maximum length of a channel name.
+const maxStreamChannelLen = 160
+
+func validStreamChannel(channel string) bool {
+	return len(channel) <= maxStreamChannelLen && streamChannelRE.MatchString(channel)
+}
+
+// streamHub tracks the subscribers to each channel.
+type streamHub struct {
+	q StreamingQuerier
+
+	mu       sync.Mutex
+	channels map[string]*streamChannel
+}
+
+// streamChannel is a running call to GrafanaStream.
+type streamChannel struct {
+	cancel context.CancelFunc
+	subs   map[*streamSub]struct{}
+}
+
+// streamSub is a single subscriber to a channel. done is closed, and err
+// set, when the stream ends.
+type streamSub struct {
+	points chan DataPoint
+	done   chan struct{}
+	err    error
+}
+
+func newStreamHub(q StreamingQuerier) *streamHub {
+	return &streamHub{q: q, channels: map[string]*streamChannel{}}
+}
+
+// subscribe adds a subscriber to channel, starting the stream if this is
+// the first subscriber.
+func (sh *streamHub) subscribe(channel string, buffer int) *streamSub {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sc, ok := sh.channels[channel]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		sc = &streamChannel{cancel: cancel, subs: map[*streamSub]struct{}{}}
+		sh.channels[channel] = sc
+		go sh.run(ctx, channel, sc)
+	}
+
+	sub := &streamSub{points: make(chan DataPoint, buffer), done: make(chan struct{})}
+	sc.subs[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscriber from channel, stopping the stream if
+// there are no subscribers left.
+func (sh *streamHub) unsubscribe(channel string, sub *streamSub) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sc, ok := sh.channels[channel]
+	if !ok {
+		return
+	}
+	delete(sc.subs, sub)
+	if len(sc.subs) == 0 {
+		delete(sh.channels, channel)
+		sc.cancel()
+	}
+}
+
+// run calls the StreamingQuerier for channel, and disconnects any
+// remaining subscribers once it returns.
+func (sh *streamHub) run(ctx context.Context, channel string, sc *streamChannel) {
+	err := func() (err error) {
+		defer catchPanic(&err)
+		return sh.q.GrafanaStream(ctx, channel, func(dp DataPoint) { sh.publish(sc, dp) })
+	}()
+	if err != nil && ctx.Err() == nil {
+		log.Printf("simplejson: stream %q: %v", channel, err)
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.channels[channel] == sc {
+		delete(sh.channels, channel)
+	}
+	for sub := range sc.subs {
+		sub.err = err
+		close(sub.done)
+	}
+	sc.subs = nil
+	sc.cancel()
+}
+
+// publish sends dp to every subscriber of sc, discarding the oldest
+// buffered point of any subscriber that has fallen behind.
+func (sh *streamHub) publish(sc *streamChannel, dp DataPoint) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for sub := range sc.subs {
+		for {
+			select {
+			case sub.points <- dp:
+			default:
+				select {
+				case <-sub.points:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// HandleStream serves /stream/{channel}, sending the datapoints of the
+// channel to the client as server-sent events. Each event holds a batch
+// of datapoints, in the same form as a timeserie in a query response. If
+// the stream ends an "end" event is sent, or an "error" event if it
+// failed.
+func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	if h.streams == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	channel := r.PathValue("channel")
+	if !validStreamChannel(channel) {
+		writeError(w, fmt.Errorf("invalid channel name %q", channel), http.StatusBadRequest)
+		return
+	}
+
+	// Streams outlive any write timeout set on the server, so clear the
+	// deadline for this connection. Writers that cannot set deadlines
+	// have no deadline to clear.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	buffer := h.streamBuffer
+	if buffer == 0 {
+		buffer = DefaultStreamBuffer
+	}
+	sub := h.streams.subscribe(channel, buffer)
+	defer h.streams.unsubscribe(channel, sub)
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	// Set by Serve, so that streams end when the server shuts down.
+	shutdown, _ := r.Context().Value(shutdownContextKey).(<-chan struct{})
+
+	batch := make([]DataPoint, 0, buffer)
+	for {
+		var buf []byte
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shutdown:
+			return
+		case <-keepAlive.C:
+			buf = append(buf, ": keepalive\n\n"...)
+		case dp := <-sub.points:
+			batch = append(batch[:0], dp)
+			batch = drainStream(batch, sub.points)
+			var err error
+			if buf, err = h.appendStreamEvent(buf, channel, batch); err != nil {
+				w.Write(appendStreamError(nil, err))
+				return
+			}
+		case <-sub.done:
+			// Send any points that arrived before the stream ended.
+			batch = drainStream(batch[:0], sub.points)
+			var err error
+			if len(batch) > 0 {
+				buf, err = h.appendStreamEvent(buf, channel, batch)
+			}
+			if err == nil {
+				err = sub.err
+			}
+			if err != nil {
+				buf = appendStreamError(nil, err)
+			} else {
+				buf = append(buf, "event: end\ndata: {}\n\n"...)
+			}
+			w.Write(buf)
+			return
+		}
+
+		if _, err := w.Write(buf); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// drainStream appends any buffered points to batch, up to its capacity.
+func drainStream(batch []DataPoint, points chan DataPoint) []DataPoint {
+	for len(batch) < cap(batch) {
+		select {
+		case dp := <-points:
+			batch = append(batch, dp)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// appendStreamEvent appends a server-sent event holding points to buf.
+func (h *Handler) appendStreamEvent(buf []byte, channel string, points []DataPoint) ([]byte, error) {
+	buf = append(buf, `data: {"target":`...)
+	buf = appendJSONString(buf, channel)
+	buf = append(buf, `,"datapoints":[`...)
+	n := 0
+	for _, dp := range points {
+		dp, keep, err := h.nonFinite.apply(dp)
+		if err != nil {
+			return buf, err
+		}
+		if !keep {
+			continue
+		}
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendDataPoint(buf, dp, h.timeUnit)
+		n++
+	}
+	return append(buf, "]}\n\n"...), nil
+}
+
+// appendStreamError appends an "error" event to buf.
+func appendStreamError(buf []byte, err error) []byte {
+	buf = append(buf, "event: error\ndata: {\"message\":"...)
+	buf = appendJSONString(buf, err.Error())
+	return append(buf, "}\n\n"...)
+}
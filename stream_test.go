@@ -0,0 +1,207 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// testStream publishes the points sent to it to all subscribers.
+type testStream struct {
+	points  chan simplejson.DataPoint
+	started chan string
+	stopped chan struct{}
+	calls   atomic.Int32
+	err     error
+}
+
+func newTestStream() *testStream {
+	return &testStream{
+		points:  make(chan simplejson.DataPoint),
+		started: make(chan string, 10),
+		stopped: make(chan struct{}, 10),
+	}
+}
+
+func (ts *testStream) GrafanaStream(ctx context.Context, channel string, send func(simplejson.DataPoint)) error {
+	ts.calls.Add(1)
+	ts.started <- channel
+	defer func() { ts.stopped <- struct{}{} }()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case dp, ok := <-ts.points:
+			if !ok {
+				return ts.err
+			}
+			send(dp)
+		}
+	}
+}
+
+// readEvent reads a single server-sent event, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var lines []string
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		l = strings.TrimSuffix(l, "\n")
+		switch {
+		case l == "" && len(lines) > 0:
+			return strings.Join(lines, "\n")
+		case l == "", strings.HasPrefix(l, ":"):
+		default:
+			lines = append(lines, l)
+		}
+	}
+}
+
+func subscribe(t *testing.T, url string) (*bufio.Reader, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	return bufio.NewReader(resp.Body), func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+func waitFor[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out")
+		panic("unreachable")
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	ts := newTestStream()
+	srv := httptest.NewServer(simplejson.New(simplejson.WithSource(ts)))
+	defer srv.Close()
+
+	r1, close1 := subscribe(t, srv.URL+"/stream/cpu/host=web-1")
+	if ch := waitFor(t, ts.started); ch != "cpu/host=web-1" {
+		t.Fatalf("unexpected channel %q", ch)
+	}
+	r2, close2 := subscribe(t, srv.URL+"/stream/cpu/host=web-1")
+
+	ts.points <- simplejson.DataPoint{Time: time.Unix(1, 0), Value: 1}
+	expect := `data: {"target":"cpu/host=web-1","datapoints":[[1,1000]]}`
+	for _, r := range []*bufio.Reader{r1, r2} {
+		if ev := readEvent(t, r); ev != expect {
+			t.Errorf("\nexpected: %s\ngot:      %s", expect, ev)
+		}
+	}
+	if n := ts.calls.Load(); n != 1 {
+		t.Errorf("expected subscribers to share a stream, got %d calls", n)
+	}
+
+	close1()
+	ts.points <- simplejson.DataPoint{Time: time.Unix(2, 0), Value: 2}
+	if ev := readEvent(t, r2); !strings.Contains(ev, "[2,2000]") {
+		t.Errorf("unexpected event %s", ev)
+	}
+
+	close2()
+	waitFor(t, ts.stopped)
+}
+
+func TestHandleStream_Error(t *testing.T) {
+	ts := newTestStream()
+	ts.err = errors.New("backend gone")
+	srv := httptest.NewServer(simplejson.New(simplejson.WithStreamingQuerier(ts)))
+	defer srv.Close()
+
+	r, closeSub := subscribe(t, srv.URL+"/stream/cpu")
+	defer closeSub()
+	waitFor(t, ts.started)
+	close(ts.points)
+
+	expect := "event: error\ndata: {\"message\":\"backend gone\"}"
+	if ev := readEvent(t, r); ev != expect {
+		t.Errorf("\nexpected: %s\ngot:      %s", expect, ev)
+	}
+}
+
+func TestHandleStream_Requests(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithStreamingQuerier(newTestStream()))
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/stream/bad%20channel", http.StatusBadRequest},
+		{http.MethodGet, "/stream/" + strings.Repeat("x", 200), http.StatusBadRequest},
+		{http.MethodPost, "/stream/cpu", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	simplejson.New().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream/cpu", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d without a streaming querier, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestWithStreamBuffer(t *testing.T) {
+	if err := simplejson.WithStreamBuffer(0)(&simplejson.Handler{}); err == nil {
+		t.Errorf("expected an error for an empty buffer")
+	}
+}
+
+func TestHandleStream_WriteTimeout(t *testing.T) {
+	ts := newTestStream()
+	srv := httptest.NewUnstartedServer(simplejson.New(simplejson.WithSource(ts)))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	r, closeStream := subscribe(t, srv.URL+"/stream/cpu")
+	defer closeStream()
+	waitFor(t, ts.started)
+
+	time.Sleep(4 * srv.Config.WriteTimeout)
+	ts.points <- simplejson.DataPoint{Time: time.Unix(1, 0), Value: 1}
+	if ev := readEvent(t, r); !strings.Contains(ev, "[1,1000]") {
+		t.Errorf("unexpected event %s", ev)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"strconv"
+	"time"
+)
+
+// A TableColumnHeader describes a column of a table whose rows are
+// returned by a TableIterQuerier. Type is the Grafana column type, one of
+// "number", "time", "string", "boolean" or "other". Unit optionally gives
+// the unit of the values.
+type TableColumnHeader struct {
+	Text string
+	Type string
+	Unit string
+}
+
+// A TableIterQuerier responds to table queries from Grafana, returning the
+// rows of the table via an iterator. This allows tables with very large
+// numbers of rows to be streamed to Grafana without holding them all in
+// memory.
+//
+// Each row must have one value per column. Values are encoded as JSON,
+// time.Time values should be used for time columns.
+//
+// As with an IterQuerier, an error yielded before the first row is
+// reported to Grafana as normal, but once the response has begun streaming
+// the connection to the client is aborted instead.
+type TableIterQuerier interface {
+	GrafanaQueryTableIter(ctx context.Context, req QueryRequest) ([]TableColumnHeader, iter.Seq2[[]interface{}, error], error)
+}
+
+// simpleJSONTableIterData is a lazily evaluated response to a single table
+// target.
+type simpleJSONTableIterData struct {
+	RefID   string
+	Columns []simpleJSONTableColumn
+
+	first    []interface{}
+	hasFirst bool
+	next     func() ([]interface{}, error, bool)
+	stop     func()
+	target   string
+	maxRows  int           // 0 if unlimited
+	timeUnit time.Duration // 0 to send times as strings
+}
+
+func (h *Handler) jsonTableIterQuery(ctx context.Context, qr QueryRequest, target simpleJSONTarget) (interface{}, error) {
+	hdrs, rows, err := h.tableIter.GrafanaQueryTableIter(ctx, qr)
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make([]simpleJSONTableColumn, len(hdrs))
+	for i, hdr := range hdrs {
+		cols[i] = simpleJSONTableColumn{Text: hdr.Text, Type: hdr.Type, Unit: hdr.Unit}
+	}
+
+	next, stop := iter.Pull2(rows)
+
+	// We pull the first row so that any initial error can be
+	// reported before the response has been started.
+	row, err, ok := next()
+	if err == nil && ok && len(row) != len(cols) {
+		err = fmt.Errorf("row has %d values, expected %d", len(row), len(cols))
+	}
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	return &simpleJSONTableIterData{
+		RefID:    target.RefID,
+		Columns:  cols,
+		first:    row,
+		hasFirst: ok,
+		next:     next,
+		stop:     stop,
+		target:   target.Target,
+		maxRows:  h.maxRows,
+		timeUnit: h.timeUnit,
+	}, nil
+}
+
+// writeJSON writes the JSON encoding of the table to w, consuming the
+// iterator.
+func (sjd *simpleJSONTableIterData) writeJSON(w io.Writer) error {
+	defer sjd.stop()
+
+	bp := getBuffer()
+	buf := *bp
+	defer func() { *bp = buf; putBuffer(bp) }()
+
+	buf = append(buf, `{"type":"table"`...)
+	if sjd.RefID != "" {
+		buf = append(buf, `,"refId":`...)
+		buf = appendJSONString(buf, sjd.RefID)
+	}
+	bs, err := json.Marshal(sjd.Columns)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, `,"columns":`...)
+	buf = append(buf, bs...)
+	buf = append(buf, `,"rows":[`...)
+
+	var meta *simpleJSONMeta
+	var epochRow []interface{}
+	row, ok := sjd.first, sjd.hasFirst
+	for n := 0; ok; n++ {
+		if sjd.maxRows > 0 && n == sjd.maxRows {
+			meta = truncationNotice(sjd.target, "table truncated to %d rows", n)
+			break
+		}
+		if len(row) != len(sjd.Columns) {
+			return fmt.Errorf("row has %d values, expected %d", len(row), len(sjd.Columns))
+		}
+		if sjd.timeUnit != 0 {
+			// Rows belong to the querier, so are converted into
+			// a copy.
+			epochRow = epochRow[:0]
+			for _, v := range row {
+				epochRow = append(epochRow, epochValue(v, sjd.timeUnit))
+			}
+			row = epochRow
+		}
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+		if buf, err = appendTableRow(buf, row); err != nil {
+			return err
+		}
+
+		if len(buf) >= streamBufferSize/2 {
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+
+		row, err, ok = sjd.next()
+		if err != nil {
+			return err
+		}
+	}
+	buf = append(buf, ']')
+	if buf, err = appendMeta(buf, meta); err != nil {
+		return err
+	}
+	buf = append(buf, '}')
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// appendTableRow appends the JSON encoding of row to buf. Values of the
+// types used by table columns are encoded directly, anything else is
+// passed to encoding/json.
+func appendTableRow(buf []byte, row []interface{}) ([]byte, error) {
+	buf = append(buf, '[')
+	for i, v := range row {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		var err error
+		if buf, err = appendTableValue(buf, v); err != nil {
+			return buf, err
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+// appendTableValue appends the JSON encoding of a single table cell to
+// buf, giving the same output as encoding/json.
+func appendTableValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case string:
+		return appendJSONString(buf, v), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return buf, errUnsupportedFloat(v)
+		}
+		return appendJSONFloat(buf, v), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case time.Time:
+		if y := v.Year(); y < 0 || y > 9999 {
+			// Let encoding/json report the error.
+			break
+		}
+		buf = append(buf, '"')
+		buf = v.AppendFormat(buf, time.RFC3339Nano)
+		return append(buf, '"'), nil
+	case *float64:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		return appendTableValue(buf, *v)
+	case *string:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		return appendJSONString(buf, *v), nil
+	case *bool:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		return strconv.AppendBool(buf, *v), nil
+	case *time.Time:
+		if v == nil {
+			return append(buf, "null"...), nil
+		}
+		return appendTableValue(buf, *v)
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, bs...), nil
+}
+
+// validateTableRow checks that the values of row can be encoded, without
+// encoding those that always can be.
+func validateTableRow(row []interface{}) error {
+	for _, v := range row {
+		switch v := v.(type) {
+		case nil, string, bool, *string, *bool:
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return errUnsupportedFloat(v)
+			}
+		case time.Time:
+			if y := v.Year(); y < 0 || y > 9999 {
+				if _, err := json.Marshal(v); err != nil {
+					return err
+				}
+			}
+		default:
+			if _, err := appendTableValue(nil, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type tableIterQuerier struct {
+	rows [][]interface{}
+	err  error
+}
+
+func (tq tableIterQuerier) GrafanaQueryTableIter(ctx context.Context, req simplejson.QueryRequest) ([]simplejson.TableColumnHeader, iter.Seq2[[]interface{}, error], error) {
+	hdrs := []simplejson.TableColumnHeader{
+		{Text: "Host", Type: "string"},
+		{Text: "Latency", Type: "number", Unit: "ms"},
+	}
+	return hdrs, func(yield func([]interface{}, error) bool) {
+		for _, row := range tq.rows {
+			if !yield(row, nil) {
+				return
+			}
+		}
+		if tq.err != nil {
+			yield(nil, tq.err)
+		}
+	}, nil
+}
+
+const tableIterTestQuery = `{"targets": [{"target": "t", "refId": "A", "type": "table"}]}`
+
+func TestWithTableIterQuerier(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTableIterQuerier(tableIterQuerier{
+			rows: [][]interface{}{{"web-1", 12.5}, {"web-2", nil}},
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(tableIterTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","refId":"A","columns":[{"text":"Host","type":"string"},{"text":"Latency","type":"number","unit":"ms"}],` +
+		`"rows":[["web-1",12.5],["web-2",null]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithTableIterQuerier_Empty(t *testing.T) {
+	gsj := simplejson.New(simplejson.WithTableIterQuerier(tableIterQuerier{}))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(tableIterTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","refId":"A","columns":[{"text":"Host","type":"string"},{"text":"Latency","type":"number","unit":"ms"}],"rows":[]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestWithTableIterQuerier_InitialError(t *testing.T) {
+	for name, tq := range map[string]tableIterQuerier{
+		"error":      {err: simplejson.Error{Status: http.StatusNotFound, Message: "unknown table"}},
+		"row length": {rows: [][]interface{}{{"web-1"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gsj := simplejson.New(simplejson.WithTableIterQuerier(tq))
+
+			req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(tableIterTestQuery))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Code == http.StatusOK {
+				t.Fatalf("expected an error, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithTableIterQuerier_StreamError(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTableIterQuerier(tableIterQuerier{
+			rows: [][]interface{}{{"web-1", 1.0}},
+			err:  errors.New("cursor failed"),
+		}),
+	)
+	srv := httptest.NewServer(gsj)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/query", "application/json", bytes.NewBufferString(tableIterTestQuery))
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Fatalf("expected truncated response to fail")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// structColumn describes how a struct field is mapped to a table column.
+type structColumn struct {
+	text  string
+	typ   string
+	unit  string
+	index []int
+}
+
+// TableFromStructs builds table columns from a slice of structs, or
+// pointers to structs, with a column for each exported field. Numeric
+// fields become number columns, time.Time fields time columns,
+// time.Duration fields duration columns, bools boolean columns, strings
+// and fmt.Stringers string columns, and maps, slices, structs and
+// interfaces JSON columns.
+//
+// Columns can be configured with a grafana struct tag, giving the column
+// name followed by options, e.g.
+//
+//	type Request struct {
+//		Start    time.Time     `grafana:"Start time"`
+//		Path     string        `grafana:"Path"`
+//		Latency  float64       `grafana:"Latency,unit=ms"`
+//		Status   int           `grafana:",type=string"`
+//		internal string
+//		Ignored  string        `grafana:"-"`
+//	}
+//
+// The type option forces the column type. Any field may be sent as a
+// string or json column, and time and duration fields as a number, giving
+// milliseconds since the epoch, or milliseconds, respectively. The
+// unit option sets the unit of the column. Fields of embedded structs are
+// included as if they were fields of the outer struct.
+func TableFromStructs[T any](rows []T) ([]TableColumn, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	ptr := typ.Kind() == reflect.Pointer
+	if ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot build a table from %s, must be a struct", typ)
+	}
+
+	scols, err := structColumns(typ, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make([]TableColumn, len(scols))
+	for i, sc := range scols {
+		cols[i] = TableColumn{Text: sc.text, Unit: sc.unit}
+		switch sc.typ {
+		case "number":
+			cols[i].Data = make(TableNumberColumn, 0, len(rows))
+		case "time":
+			cols[i].Data = make(TableTimeColumn, 0, len(rows))
+		case "boolean":
+			cols[i].Data = make(TableBoolColumn, 0, len(rows))
+		case "duration":
+			cols[i].Data = make(TableDurationColumn, 0, len(rows))
+		case "json":
+			cols[i].Data = make(TableJSONColumn, 0, len(rows))
+		default:
+			cols[i].Data = make(TableStringColumn, 0, len(rows))
+		}
+	}
+
+	for r := range rows {
+		rv := reflect.ValueOf(&rows[r]).Elem()
+		if ptr {
+			if rv.IsNil() {
+				return nil, fmt.Errorf("row %d is nil", r)
+			}
+			rv = rv.Elem()
+		}
+		for i, sc := range scols {
+			fv, err := rv.FieldByIndexErr(sc.index)
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %q, %w", r, sc.text, err)
+			}
+			switch data := cols[i].Data.(type) {
+			case TableNumberColumn:
+				cols[i].Data = append(data, numberValue(fv))
+			case TableTimeColumn:
+				cols[i].Data = append(data, fv.Interface().(time.Time))
+			case TableStringColumn:
+				cols[i].Data = append(data, stringValue(fv))
+			case TableBoolColumn:
+				cols[i].Data = append(data, fv.Bool())
+			case TableDurationColumn:
+				cols[i].Data = append(data, time.Duration(fv.Int()))
+			case TableJSONColumn:
+				cols[i].Data = append(data, fv.Interface())
+			}
+		}
+	}
+
+	return cols, nil
+}
+
+// structColumns returns the columns for the fields of typ.
+func structColumns(typ reflect.Type, index []int) ([]structColumn, error) {
+	var cols []structColumn
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag, tagged := f.Tag.Lookup("grafana")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if f.Anonymous && !tagged {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				embedded, err := structColumns(ft, fieldIndex)
+				if err != nil {
+					return nil, err
+				}
+				cols = append(cols, embedded...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		col := structColumn{text: f.Name, typ: fieldColumnType(f.Type), index: fieldIndex}
+		if col.typ == "" {
+			return nil, fmt.Errorf("field %s has unsupported type %s", f.Name, f.Type)
+		}
+
+		opts := strings.Split(tag, ",")
+		if opts[0] != "" {
+			col.text = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			k, v, _ := strings.Cut(opt, "=")
+			switch k {
+			case "type":
+				if !fieldConvertible(col.typ, v) {
+					return nil, fmt.Errorf("field %s of type %s cannot be a %s column", f.Name, f.Type, v)
+				}
+				col.typ = v
+			case "unit":
+				col.unit = v
+			default:
+				return nil, fmt.Errorf("field %s has unknown grafana tag option %q", f.Name, k)
+			}
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// fieldColumnType returns the default column type for a field, or "" if
+// the field is not supported.
+func fieldColumnType(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "time"
+	case t == durationType:
+		return "duration"
+	case t.Implements(stringerType):
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Interface, reflect.Pointer:
+		return "json"
+	default:
+		return ""
+	}
+}
+
+// fieldConvertible reports whether a field whose default column type is
+// def can be sent as a column of type typ.
+func fieldConvertible(def, typ string) bool {
+	switch typ {
+	case def, "string", "json":
+		return true
+	case "number":
+		return def == "time" || def == "duration"
+	default:
+		return false
+	}
+}
+
+// numberValue returns the value of a numeric, time or duration field as a
+// float64.
+func numberValue(v reflect.Value) float64 {
+	switch v.Type() {
+	case timeType:
+		return float64(v.Interface().(time.Time).UnixMilli())
+	case durationType:
+		return float64(v.Int()) / float64(time.Millisecond)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+// stringValue returns the value of a field as a string.
+func stringValue(v reflect.Value) string {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,107 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type tableBase struct {
+	Host string `grafana:"Host"`
+}
+
+type tableRequest struct {
+	tableBase
+	Start    time.Time `grafana:"Start time"`
+	Latency  float64   `grafana:"Latency,unit=ms"`
+	Status   int       `grafana:",type=string"`
+	OK       bool
+	internal string
+	Ignored  string `grafana:"-"`
+}
+
+func TestTableFromStructs(t *testing.T) {
+	rows := []tableRequest{
+		{tableBase{"web-1"}, time.Unix(1, 0), 12.5, 200, true, "x", "y"},
+		{tableBase{"web-2"}, time.Unix(2, 0), 250, 500, false, "x", "y"},
+	}
+
+	gsj := simplejson.New(
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+			return simplejson.TableFromStructs(rows)
+		})),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(`{"targets": [{"target": "requests", "type": "table"}]}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"type":"table","columns":[` +
+		`{"text":"Host","type":"string"},` +
+		`{"text":"Start time","type":"time"},` +
+		`{"text":"Latency","type":"number","unit":"ms"},` +
+		`{"text":"Status","type":"string"},` +
+		`{"text":"OK","type":"boolean"}],` +
+		`"rows":[["web-1","` + time.Unix(1, 0).Format(time.RFC3339) + `",12.5,"200",true],` +
+		`["web-2","` + time.Unix(2, 0).Format(time.RFC3339) + `",250,"500",false]]}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %s\ngot:      %s", expect, w.Body.String())
+	}
+}
+
+func TestTableFromStructs_Pointers(t *testing.T) {
+	cols, err := simplejson.TableFromStructs([]*tableBase{{"a"}, {"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 1 || len(cols[0].Data.(simplejson.TableStringColumn)) != 2 {
+		t.Fatalf("unexpected columns %#v", cols)
+	}
+
+	if _, err := simplejson.TableFromStructs([]*tableBase{nil}); err == nil {
+		t.Fatalf("expected an error for a nil row")
+	}
+}
+
+func TestTableFromStructs_DurationAndJSON(t *testing.T) {
+	cols, err := simplejson.TableFromStructs([]struct {
+		Elapsed time.Duration
+		Labels  map[string]string
+	}{{1500 * time.Millisecond, map[string]string{"a": "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 2 {
+		t.Fatalf("unexpected columns %#v", cols)
+	}
+	if d, ok := cols[0].Data.(simplejson.TableDurationColumn); !ok || d[0] != 1500*time.Millisecond {
+		t.Errorf("expected a duration column, got %#v", cols[0].Data)
+	}
+	if _, ok := cols[1].Data.(simplejson.TableJSONColumn); !ok {
+		t.Errorf("expected a JSON column, got %#v", cols[1].Data)
+	}
+}
+
+func TestTableFromStructs_Errors(t *testing.T) {
+	if _, err := simplejson.TableFromStructs([]int{1}); err == nil {
+		t.Errorf("expected an error for a non-struct type")
+	}
+	if _, err := simplejson.TableFromStructs([]struct{ C chan int }{}); err == nil {
+		t.Errorf("expected an error for an unsupported field type")
+	}
+	if _, err := simplejson.TableFromStructs([]struct {
+		S string `grafana:",type=time"`
+	}{}); err == nil {
+		t.Errorf("expected an error for an invalid type option")
+	}
+	if _, err := simplejson.TableFromStructs([]struct {
+		S string `grafana:",colour=red"`
+	}{}); err == nil {
+		t.Errorf("expected an error for an unknown option")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// A TenantResolver determines the tenant a request is being made on behalf
+// of, for instance from a header, or an identity established during
+// authentication.
+type TenantResolver func(r *http.Request) (string, error)
+
+// WithTenantResolver enables multi-tenancy. Each request is passed to
+// resolve, and then dispatched to the datasource registered for that
+// tenant with WithTenant. Requests from tenants that have no datasource
+// registered are rejected. An error returned from resolve is reported to
+// the client, with a 403 status unless the error specifies another.
+func WithTenantResolver(resolve TenantResolver) Opt {
+	return func(sjc *Handler) error {
+		sjc.tenantResolver = resolve
+		return nil
+	}
+}
+
+// WithTenant registers a datasource, configured by opts, that will serve
+// requests for the given tenant.
+func WithTenant(tenantID string, opts ...Opt) Opt {
+	return func(sjc *Handler) error {
+		if _, ok := sjc.tenants[tenantID]; ok {
+			return fmt.Errorf("tenant %q already registered", tenantID)
+		}
+		th, err := newHandler(opts...)
+		if err != nil {
+			return fmt.Errorf("tenant %q, %w", tenantID, err)
+		}
+		if sjc.tenants == nil {
+			sjc.tenants = map[string]*Handler{}
+		}
+		sjc.tenants[tenantID] = th
+		return nil
+	}
+}
+
+// TenantFromContext returns the tenant a request is being handled for.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantContextKey).(string)
+	return tenantID, ok
+}
+
+// resolveTenant determines the tenant of each request, rejecting those
+// from unknown tenants. The tenant is resolved before the request reaches
+// the cache, so that cached responses are keyed by tenant.
+func (h *Handler) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID, err := h.tenantResolver(r)
+		if err != nil {
+			writeError(w, err, http.StatusForbidden)
+			return
+		}
+		if _, ok := h.tenants[tenantID]; !ok {
+			writeError(w, Error{Status: http.StatusForbidden, Message: "unknown tenant"}, http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tenantContextKey, tenantID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serveTenant dispatches a request to the handler of the tenant found by
+// resolveTenant.
+func (h *Handler) serveTenant(w http.ResponseWriter, r *http.Request) {
+	tenantID, _ := TenantFromContext(r.Context())
+	h.tenants[tenantID].ServeHTTP(w, r)
+}
@@ -0,0 +1,99 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type tenantSearcher struct{}
+
+func (tenantSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	tenantID, _ := simplejson.TenantFromContext(ctx)
+	return []string{tenantID}, nil
+}
+
+func TestWithTenantResolver(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithTenantResolver(func(r *http.Request) (string, error) {
+			org := r.Header.Get("X-Grafana-Org-Id")
+			if org == "" {
+				return "", errors.New("missing org id")
+			}
+			return org, nil
+		}),
+		simplejson.WithTenant("1", simplejson.WithSearcher(tenantSearcher{})),
+		simplejson.WithTenant("2", simplejson.WithSearcher(staticSearcher{"other"})),
+	)
+
+	tests := []struct {
+		org    string
+		status int
+		body   string
+	}{
+		{org: "1", status: http.StatusOK, body: `["1"]`},
+		{org: "2", status: http.StatusOK, body: `["other"]`},
+		{org: "3", status: http.StatusForbidden, body: `{"message":"unknown tenant"}`},
+		{org: "", status: http.StatusForbidden, body: `{"message":"missing org id"}`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": ""}`))
+		if tt.org != "" {
+			req.Header.Set("X-Grafana-Org-Id", tt.org)
+		}
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("org %q: expected status %d, got %d", tt.org, tt.status, w.Code)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("org %q:\nexpected: %q\ngot:%s", tt.org, tt.body, w.Body.String())
+		}
+	}
+}
+
+func TestWithTenantResolver_Cache(t *testing.T) {
+	querier := func(tenantID string) simplejson.Opt {
+		return simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			v := 1.0
+			if tenantID == "b" {
+				v = 2
+			}
+			return []simplejson.DataPoint{{Time: time.Unix(1, 0), Value: v}}, nil
+		}))
+	}
+	gsj := simplejson.New(
+		simplejson.WithQueryCache(time.Minute, 10),
+		simplejson.WithTenantResolver(func(r *http.Request) (string, error) {
+			return r.Header.Get("X-Tenant"), nil
+		}),
+		simplejson.WithTenant("a", querier("a")),
+		simplejson.WithTenant("b", querier("b")),
+	)
+
+	for _, tt := range []struct {
+		tenant string
+		body   string
+	}{
+		{"a", `[{"target":"upper_50","refId":"A","datapoints":[[1,1000]]}]`},
+		{"b", `[{"target":"upper_50","refId":"A","datapoints":[[2,1000]]}]`},
+		{"a", `[{"target":"upper_50","refId":"A","datapoints":[[1,1000]]}]`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+		req.Header.Set("X-Tenant", tt.tenant)
+		w := httptest.NewRecorder()
+		gsj.ServeHTTP(w, req)
+
+		if w.Body.String() != tt.body {
+			t.Errorf("tenant %q:\nexpected: %s\ngot:      %s", tt.tenant, tt.body, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testdatasource provides a source of synthetic timeseries, in
+// the manner of Grafana's TestData datasource, so that dashboards can be
+// prototyped before a real backend exists.
+//
+// Each target names a scenario, optionally followed by parameters, e.g.
+//
+//	random_walk
+//	sine(period=1h, amplitude=10, offset=50)
+//	spikes(probability=0.01, height=200)
+//
+// Values are derived from the seed, the target and the time of each point,
+// so repeated queries for the same range return the same series. Points
+// are aligned to multiples of the query interval.
+package testdatasource
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// The available scenarios, and their parameters. All scenarios also
+// accept a noise parameter, overriding that set by WithNoise.
+const (
+	// RandomWalk starts at start, changing by up to spread at each
+	// point, clamped to the range min to max if given.
+	RandomWalk = "random_walk"
+	// Sine is a sine wave of the given period, amplitude, offset and
+	// phase (as a fraction of the period).
+	Sine = "sine"
+	// Spikes is a baseline value, with spikes of height occurring with
+	// the given probability at each point.
+	Spikes = "spikes"
+	// Step alternates between low and high, every period.
+	Step = "step"
+	// Constant is a fixed value.
+	Constant = "constant"
+)
+
+// defaultInterval is used when a query specifies neither an interval,
+// nor a maximum number of datapoints.
+const defaultInterval = time.Minute
+
+type scenario func(p params, ts []time.Time, rnd func(time.Time) *rand.Rand) ([]float64, error)
+
+var scenarios = map[string]scenario{
+	RandomWalk: randomWalk,
+	Sine:       sine,
+	Spikes:     spikes,
+	Step:       step,
+	Constant:   constant,
+}
+
+// Source generates synthetic timeseries. It implements
+// simplejson.Querier and simplejson.Searcher, and may be passed to
+// simplejson.WithSource.
+type Source struct {
+	seed  uint64
+	noise float64
+}
+
+// An Opt configures a Source.
+type Opt func(*Source)
+
+// WithSeed sets the seed for the random values of all series. The default
+// is 0.
+func WithSeed(seed uint64) Opt {
+	return func(s *Source) {
+		s.seed = seed
+	}
+}
+
+// WithNoise adds normally distributed noise, with the given standard
+// deviation, to all series.
+func WithNoise(stddev float64) Opt {
+	return func(s *Source) {
+		s.noise = stddev
+	}
+}
+
+// New creates a new Source.
+func New(opts ...Opt) *Source {
+	s := &Source{}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// GrafanaQuery implements simplejson.Querier.
+func (s *Source) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	name, p, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := scenarios[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario %q, %w", name, simplejson.ErrBadRequest)
+	}
+
+	ts := timestamps(args)
+	h := fnv.New64a()
+	h.Write([]byte(target))
+	key := h.Sum64()
+	rnd := func(t time.Time) *rand.Rand {
+		return rand.New(rand.NewPCG(s.seed, key^uint64(t.UnixNano())))
+	}
+
+	vs, err := fn(p, ts, rnd)
+	if err != nil {
+		return nil, err
+	}
+
+	noise, err := p.float("noise", s.noise)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.unused(); err != nil {
+		return nil, err
+	}
+
+	dps := make([]simplejson.DataPoint, len(ts))
+	for i, t := range ts {
+		v := vs[i]
+		if noise != 0 {
+			// The noise uses a different stream from the scenario, so
+			// that it does not alter the underlying series.
+			v += rand.New(rand.NewPCG(^s.seed, key^uint64(t.UnixNano()))).NormFloat64() * noise
+		}
+		dps[i] = simplejson.DataPoint{Time: t, Value: v}
+	}
+	return dps, nil
+}
+
+// GrafanaSearch implements simplejson.Searcher, returning the names of
+// the scenarios that begin with target.
+func (s *Source) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	var names []string
+	for name := range scenarios {
+		if strings.HasPrefix(name, target) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// timestamps returns the times of the points for a query.
+func timestamps(args simplejson.QueryArguments) []time.Time {
+	rng := args.To.Sub(args.From)
+	if rng <= 0 {
+		return nil
+	}
+
+	interval := args.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+		if args.MaxDPs > 0 {
+			interval = rng / time.Duration(args.MaxDPs)
+		}
+	}
+	if args.MaxDPs > 0 && rng/interval >= time.Duration(args.MaxDPs) {
+		interval = rng / time.Duration(args.MaxDPs)
+	}
+	interval = max(interval, time.Millisecond)
+
+	start := args.From.Truncate(interval)
+	if start.Before(args.From) {
+		start = start.Add(interval)
+	}
+	var ts []time.Time
+	for t := start; !t.After(args.To); t = t.Add(interval) {
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+func randomWalk(p params, ts []time.Time, rnd func(time.Time) *rand.Rand) ([]float64, error) {
+	if len(ts) == 0 {
+		return nil, nil
+	}
+	start, err := p.float("start", math.NaN())
+	if err != nil {
+		return nil, err
+	}
+	spread, err := p.float("spread", 1)
+	if err != nil {
+		return nil, err
+	}
+	lo, err := p.float("min", math.Inf(-1))
+	if err != nil {
+		return nil, err
+	}
+	hi, err := p.float("max", math.Inf(1))
+	if err != nil {
+		return nil, err
+	}
+
+	v := start
+	if math.IsNaN(v) {
+		v = rnd(ts[0]).Float64() * 100
+	}
+	vs := make([]float64, len(ts))
+	for i, t := range ts {
+		if i > 0 {
+			v += (rnd(t).Float64()*2 - 1) * spread
+		}
+		v = math.Min(math.Max(v, lo), hi)
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+func sine(p params, ts []time.Time, rnd func(time.Time) *rand.Rand) ([]float64, error) {
+	period, err := p.duration("period", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	amplitude, err := p.float("amplitude", 1)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := p.float("offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	phase, err := p.float("phase", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	vs := make([]float64, len(ts))
+	for i, t := range ts {
+		x := float64(t.UnixNano())/float64(period) + phase
+		vs[i] = offset + amplitude*math.Sin(2*math.Pi*x)
+	}
+	return vs, nil
+}
+
+func spikes(p params, ts []time.Time, rnd func(time.Time) *rand.Rand) ([]float64, error) {
+	baseline, err := p.float("baseline", 0)
+	if err != nil {
+		return nil, err
+	}
+	height, err := p.float("height", 100)
+	if err != nil {
+		return nil, err
+	}
+	prob, err := p.float("probability", 0.05)
+	if err != nil {
+		return nil, err
+	}
+
+	vs := make([]float64, len(ts))
+	for i, t := range ts {
+		vs[i] = baseline
+		if rnd(t).Float64() < prob {
+			vs[i] += height
+		}
+	}
+	return vs, nil
+}
+
+func step(p params, ts []time.Time, rnd func(time.Time) *rand.Rand) ([]float64, error) {
+	period, err := p.duration("period", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	low, err := p.float("low", 0)
+	if err != nil {
+		return nil, err
+	}
+	high, err := p.float("high", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	vs := make([]float64, len(ts))
+	for i, t := range ts {
+		vs[i] = low
+		if (t.UnixNano()/int64(period))%2 != 0 {
+			vs[i] = high
+		}
+	}
+	return vs, nil
+}
+
+func constant(p params, ts []time.Time, rnd func(time.Time) *rand.Rand) ([]float64, error) {
+	value, err := p.float("value", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	vs := make([]float64, len(ts))
+	for i := range vs {
+		vs[i] = value
+	}
+	return vs, nil
+}
+
+// params holds the parameters given in a target. Parameters are removed
+// as they are read, so that unknown parameters can be reported.
+type params map[string]string
+
+// parseTarget splits a target of the form name(key=value, ...) into the
+// scenario name and its parameters.
+func parseTarget(target string) (string, params, error) {
+	target = strings.TrimSpace(target)
+	name, rest, ok := strings.Cut(target, "(")
+	name = strings.TrimSpace(name)
+	p := params{}
+	if !ok {
+		return name, p, nil
+	}
+
+	args, ok := strings.CutSuffix(strings.TrimSpace(rest), ")")
+	if !ok {
+		return "", nil, fmt.Errorf("target %q is missing a closing parenthesis, %w", target, simplejson.ErrBadRequest)
+	}
+	for _, arg := range strings.Split(args, ",") {
+		if strings.TrimSpace(arg) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("parameter %q should be of the form key=value, %w", strings.TrimSpace(arg), simplejson.ErrBadRequest)
+		}
+		p[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return name, p, nil
+}
+
+func (p params) float(key string, def float64) (float64, error) {
+	s, ok := p[key]
+	if !ok {
+		return def, nil
+	}
+	delete(p, key)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: %v, %w", key, err, simplejson.ErrBadRequest)
+	}
+	return v, nil
+}
+
+func (p params) duration(key string, def time.Duration) (time.Duration, error) {
+	s, ok := p[key]
+	if !ok {
+		return def, nil
+	}
+	delete(p, key)
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: %v, %w", key, err, simplejson.ErrBadRequest)
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("parameter %s must be positive, %w", key, simplejson.ErrBadRequest)
+	}
+	return v, nil
+}
+
+// unused returns an error if any parameters have not been read.
+func (p params) unused() error {
+	if len(p) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return fmt.Errorf("unknown parameters %s, %w", strings.Join(keys, ", "), simplejson.ErrBadRequest)
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testdatasource_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/testdatasource"
+)
+
+var testArgs = simplejson.QueryArguments{
+	QueryCommonArguments: simplejson.QueryCommonArguments{
+		From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+	},
+	Interval: time.Minute,
+}
+
+func TestScenarios(t *testing.T) {
+	src := testdatasource.New()
+	ctx := context.Background()
+
+	tests := []struct {
+		target string
+		check  func(t *testing.T, dps []simplejson.DataPoint)
+	}{
+		{"constant(value=3)", func(t *testing.T, dps []simplejson.DataPoint) {
+			for _, dp := range dps {
+				if dp.Value != 3 {
+					t.Fatalf("expected 3, got %v", dp.Value)
+				}
+			}
+		}},
+		{"sine(period=1h, amplitude=2, offset=10)", func(t *testing.T, dps []simplejson.DataPoint) {
+			if v := dps[15].Value; math.Abs(v-12) > 1e-9 {
+				t.Fatalf("expected peak of 12 at a quarter period, got %v", v)
+			}
+		}},
+		{"step(period=10m, low=1, high=5)", func(t *testing.T, dps []simplejson.DataPoint) {
+			if dps[0].Value != 1 || dps[10].Value != 5 || dps[20].Value != 1 {
+				t.Fatalf("unexpected steps %v, %v, %v", dps[0].Value, dps[10].Value, dps[20].Value)
+			}
+		}},
+		{"random_walk(start=50, spread=2, min=45, max=55)", func(t *testing.T, dps []simplejson.DataPoint) {
+			if dps[0].Value != 50 {
+				t.Fatalf("expected walk to start at 50, got %v", dps[0].Value)
+			}
+			for i := 1; i < len(dps); i++ {
+				if d := math.Abs(dps[i].Value - dps[i-1].Value); d > 2 {
+					t.Fatalf("step of %v exceeds spread", d)
+				}
+				if dps[i].Value < 45 || dps[i].Value > 55 {
+					t.Fatalf("value %v outside of bounds", dps[i].Value)
+				}
+			}
+		}},
+		{"spikes(probability=1, baseline=1, height=9)", func(t *testing.T, dps []simplejson.DataPoint) {
+			for _, dp := range dps {
+				if dp.Value != 10 {
+					t.Fatalf("expected a spike of 10, got %v", dp.Value)
+				}
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			dps, err := src.GrafanaQuery(ctx, tt.target, testArgs)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(dps) != 61 {
+				t.Fatalf("expected 61 points, got %d", len(dps))
+			}
+			if !dps[0].Time.Equal(testArgs.From) || !dps[60].Time.Equal(testArgs.To) {
+				t.Fatalf("unexpected range %v to %v", dps[0].Time, dps[60].Time)
+			}
+			tt.check(t, dps)
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	query := func(src *testdatasource.Source) []simplejson.DataPoint {
+		dps, err := src.GrafanaQuery(ctx, "random_walk", testArgs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return dps
+	}
+
+	a, b, c := query(testdatasource.New(testdatasource.WithSeed(1))), query(testdatasource.New(testdatasource.WithSeed(1))), query(testdatasource.New(testdatasource.WithSeed(2)))
+	same := true
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("expected identical series for the same seed")
+		}
+		same = same && a[i] == c[i]
+	}
+	if same {
+		t.Fatalf("expected different series for different seeds")
+	}
+}
+
+func TestNoise(t *testing.T) {
+	src := testdatasource.New(testdatasource.WithNoise(1))
+	dps, err := src.GrafanaQuery(context.Background(), "constant(value=0)", testArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sum float64
+	for _, dp := range dps {
+		sum += dp.Value * dp.Value
+	}
+	if sum == 0 {
+		t.Fatalf("expected noise to be added")
+	}
+
+	dps, _ = src.GrafanaQuery(context.Background(), "constant(value=0, noise=0)", testArgs)
+	if dps[0].Value != 0 {
+		t.Fatalf("expected noise to be disabled by the target, got %v", dps[0].Value)
+	}
+}
+
+func TestMaxDataPoints(t *testing.T) {
+	args := testArgs
+	args.Interval = time.Second
+	args.MaxDPs = 100
+	dps, err := testdatasource.New().GrafanaQuery(context.Background(), "sine", args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dps) > 101 {
+		t.Fatalf("expected at most 101 points, got %d", len(dps))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, target := range []string{
+		"nope",
+		"sine(period=1h",
+		"sine(period)",
+		"sine(period=-1h)",
+		"sine(amplitude=x)",
+		"sine(colour=red)",
+	} {
+		_, err := testdatasource.New().GrafanaQuery(context.Background(), target, testArgs)
+		if !errors.Is(err, simplejson.ErrBadRequest) {
+			t.Errorf("%s: expected a bad request error, got %v", target, err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	res, err := testdatasource.New().GrafanaSearch(context.Background(), "s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 3 || res[0] != "sine" || res[1] != "spikes" || res[2] != "step" {
+		t.Fatalf("unexpected results %v", res)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errRequestTimeout is reported when a handler does not complete before
+// its deadline.
+var errRequestTimeout = Error{Status: http.StatusGatewayTimeout, Message: "request timed out"}
+
+// WithQueryTimeout limits the time that may be spent answering a /query
+// request. If Grafana includes a shorter timeout in the query, that is
+// used instead.
+func WithQueryTimeout(d time.Duration) Opt {
+	return func(sjc *Handler) error {
+		sjc.queryTimeout = d
+		return nil
+	}
+}
+
+// WithAnnotationsTimeout limits the time that may be spent answering an
+// /annotations request.
+func WithAnnotationsTimeout(d time.Duration) Opt {
+	return func(sjc *Handler) error {
+		sjc.annotationsTimeout = d
+		return nil
+	}
+}
+
+// WithSearchTimeout limits the time that may be spent answering a /search
+// request.
+func WithSearchTimeout(d time.Duration) Opt {
+	return func(sjc *Handler) error {
+		sjc.searchTimeout = d
+		return nil
+	}
+}
+
+// WithTagSearchTimeout limits the time that may be spent answering
+// /tag-keys and /tag-values requests.
+func WithTagSearchTimeout(d time.Duration) Opt {
+	return func(sjc *Handler) error {
+		sjc.tagsTimeout = d
+		return nil
+	}
+}
+
+// WithHealthCheckTimeout limits the time that may be spent running the
+// health check when answering a request to /.
+func WithHealthCheckTimeout(d time.Duration) Opt {
+	return func(sjc *Handler) error {
+		sjc.healthTimeout = d
+		return nil
+	}
+}
+
+// withTimeout returns a context that is cancelled after d, if d is
+// positive.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// callWithDeadline calls fn, returning early with a timeout error if the
+// deadline of ctx passes before fn completes. This stops a backend that
+// ignores its context from holding up the response, fn is left to run
+// to completion in the background and its result discarded, releasing
+// any iterator it returned. A panic in fn is raised again in the caller.
+func callWithDeadline[T any](ctx context.Context, fn func(context.Context) (T, error)) (T, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return fn(ctx)
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		defer func() { done <- res }()
+		defer catchPanic(&res.err)
+		res.v, res.err = fn(ctx)
+	}()
+
+	var zero T
+	select {
+	case res := <-done:
+		repanic(res.err)
+		if res.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			closeLateResult(res.v)
+			return zero, errRequestTimeout
+		}
+		return res.v, res.err
+	case <-ctx.Done():
+		go func() {
+			closeLateResult((<-done).v)
+		}()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return zero, errRequestTimeout
+		}
+		return zero, ctx.Err()
+	}
+}
+
+// closeLateResult releases any iterators, or pooled datapoints, held by a
+// result that arrived too late to be used.
+func closeLateResult(v interface{}) {
+	if v != nil {
+		closeQueryResponse([]interface{}{v})
+	}
+}
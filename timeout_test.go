@@ -0,0 +1,161 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+type slowSearcher time.Duration
+
+func (ss slowSearcher) GrafanaSearch(ctx context.Context, target string) ([]string, error) {
+	// Deliberately ignore the context, as a badly behaved backend might.
+	time.Sleep(time.Duration(ss))
+	return []string{"slow"}, nil
+}
+
+func (ss slowSearcher) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	select {
+	case <-time.After(time.Duration(ss)):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestWithSearchTimeout(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithSearcher(slowSearcher(time.Second)),
+		simplejson.WithSearchTimeout(10*time.Millisecond),
+	)
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/search", bytes.NewBufferString(`{"target": ""}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("handler did not return at the deadline")
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	expect := `{"message":"request timed out"}`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(slowSearcher(time.Second)),
+		simplejson.WithQueryTimeout(10*time.Millisecond),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
+
+func TestQueryRequestTimeout(t *testing.T) {
+	gsj := simplejson.New(
+		simplejson.WithQuerier(slowSearcher(time.Second)),
+		simplejson.WithQueryTimeout(time.Minute),
+	)
+
+	q := `{
+		"range": {
+			"from": "2016-10-31T06:33:44.866Z",
+			"to": "2016-10-31T12:33:44.866Z"
+		},
+		"interval": "30s",
+		"timeout": "10ms",
+		"targets": [
+			{ "target": "upper_50", "refId": "A" }
+		]
+	}`
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(q))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("handler did not honour the request timeout")
+	}
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
+
+func TestQueryClientCancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	gsj := simplejson.New(
+		simplejson.WithQuerier(querierFunc(func(ctx context.Context) {
+			<-ctx.Done()
+			close(cancelled)
+		})),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	go gsj.ServeHTTP(w, req)
+
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("backend was not cancelled with the request")
+	}
+}
+
+type querierFunc func(ctx context.Context)
+
+func (qf querierFunc) GrafanaQuery(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+	qf(ctx)
+	return nil, ctx.Err()
+}
+
+func TestQueryTimeout_LateIteratorReleased(t *testing.T) {
+	finished := make(chan struct{})
+	gsj := simplejson.New(
+		simplejson.WithIterQuerier(simplejson.IterQuerierFunc(func(ctx context.Context, req simplejson.QueryRequest) iter.Seq2[simplejson.DataPoint, error] {
+			return func(yield func(simplejson.DataPoint, error) bool) {
+				defer close(finished)
+				// Deliberately ignore the context, as a badly behaved
+				// backend might.
+				time.Sleep(50 * time.Millisecond)
+				for i := 0; ; i++ {
+					if !yield(simplejson.DataPoint{Time: time.Unix(int64(i), 0), Value: 1}, nil) {
+						return
+					}
+				}
+			}
+		})),
+		simplejson.WithQueryTimeout(10*time.Millisecond),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(encodeTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatalf("late iterator was not stopped")
+	}
+}
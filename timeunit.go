@@ -0,0 +1,67 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"fmt"
+	"time"
+)
+
+// WithTimeUnit sets the unit of the epoch timestamps sent in responses,
+// which must be one of time.Second, time.Millisecond, time.Microsecond or
+// time.Nanosecond. Grafana expects milliseconds, the default, but other
+// consumers may want different precision. Times are truncated to the
+// unit.
+//
+// The unit applies to the times of datapoints, including those streamed
+// and sent with WithMessagePackEncoding or WithCBOREncoding, and of
+// annotations. Time columns of tables, which are otherwise sent as
+// RFC3339 strings, are also sent as epoch timestamps in the unit. Data
+// frames, see WithDataFrames, always hold milliseconds, as Grafana
+// requires.
+func WithTimeUnit(unit time.Duration) Opt {
+	return func(sjc *Handler) error {
+		switch unit {
+		case time.Second, time.Millisecond, time.Microsecond, time.Nanosecond:
+		default:
+			return fmt.Errorf("unsupported time unit %v", unit)
+		}
+		sjc.timeUnit = unit
+		return nil
+	}
+}
+
+// epoch returns t as a number of units since the Unix epoch. A unit of 0
+// is taken to mean milliseconds.
+func epoch(t time.Time, unit time.Duration) int64 {
+	if unit == 0 {
+		unit = time.Millisecond
+	}
+	return t.UnixNano() / int64(unit)
+}
+
+// epochValue converts a table value holding a time into an epoch
+// timestamp in unit, leaving other values unchanged.
+func epochValue(v interface{}, unit time.Duration) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return epoch(v, unit)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return epoch(*v, unit)
+	}
+	return v
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithTimeUnit(t *testing.T) {
+	ts := time.Unix(1234, 567891234)
+	gsj := func(unit time.Duration) *simplejson.Handler {
+		return simplejson.New(
+			simplejson.WithTimeUnit(unit),
+			simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, args simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+				return []simplejson.DataPoint{{Time: ts, Value: 1}}, nil
+			})),
+			simplejson.WithTableQuerier(simplejson.TableQuerierFunc(func(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+				return []simplejson.TableColumn{{Text: "time", Data: simplejson.TableTimeColumn{ts}}}, nil
+			})),
+			simplejson.WithAnnotator(GSJExample{}),
+		)
+	}
+
+	tests := []struct {
+		unit   time.Duration
+		series string
+		table  string
+		ann    string
+	}{
+		{
+			unit:   time.Second,
+			series: `[{"target":"a","datapoints":[[1,1234]]}]`,
+			table:  `[{"type":"table","columns":[{"text":"time","type":"time"}],"rows":[[1234]]}]`,
+			ann:    `"time":1234,`,
+		},
+		{
+			unit:   time.Millisecond,
+			series: `[{"target":"a","datapoints":[[1,1234567]]}]`,
+			table:  `[{"type":"table","columns":[{"text":"time","type":"time"}],"rows":[[1234567]]}]`,
+			ann:    `"time":1234000,`,
+		},
+		{
+			unit:   time.Nanosecond,
+			series: `[{"target":"a","datapoints":[[1,1234567891234]]}]`,
+			table:  `[{"type":"table","columns":[{"text":"time","type":"time"}],"rows":[[1234567891234]]}]`,
+			ann:    `"time":1234000000000,`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.unit.String(), func(t *testing.T) {
+			h := gsj(tt.unit)
+			for _, c := range []struct{ path, body, expect string }{
+				{"/query", `{"targets":[{"target":"a"}]}`, tt.series},
+				{"/query", `{"targets":[{"target":"t","type":"table"}]}`, tt.table},
+			} {
+				req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				if w.Body.String() != c.expect {
+					t.Errorf("\nexpected: %s\ngot:      %s", c.expect, w.Body.String())
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/annotations", strings.NewReader(`{"annotation":{"name":"query"}}`))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if !strings.Contains(w.Body.String(), tt.ann) {
+				t.Errorf("expected annotations to contain %s, got %s", tt.ann, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithTimeUnit_Invalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected an unsupported unit to be rejected")
+		}
+	}()
+	simplejson.New(simplejson.WithTimeUnit(time.Minute))
+}
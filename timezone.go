@@ -0,0 +1,45 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// locations caches the locations loaded for the timezones of requests.
+var locations sync.Map // map[string]*time.Location
+
+// requestLocation returns the location of the timezone of a dashboard, as
+// sent by Grafana. Grafana sends "utc", "browser", or the name of a
+// location in the IANA Time Zone database. The viewer's browser timezone,
+// and names that are not known, give nil.
+func requestLocation(tz string) *time.Location {
+	switch strings.ToLower(tz) {
+	case "", "browser":
+		return nil
+	case "utc":
+		return time.UTC
+	}
+	if loc, ok := locations.Load(tz); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil
+	}
+	locations.Store(tz, loc)
+	return loc
+}
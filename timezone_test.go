@@ -0,0 +1,93 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // for the locations used in tests
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestRequestTimezone(t *testing.T) {
+	tests := []struct {
+		timezone string
+		location string // empty if no location is expected
+	}{
+		{"", ""},
+		{"browser", ""},
+		{"utc", "UTC"},
+		{"Asia/Tokyo", "Asia/Tokyo"},
+		{"Not/AZone", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timezone, func(t *testing.T) {
+			var args simplejson.QueryArguments
+			gsj := simplejson.New(
+				simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, qa simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+					args = qa
+					return nil, nil
+				})),
+			)
+
+			body := `{"range":{"from":"2016-10-31T06:33:44Z","to":"2016-10-31T12:33:44Z"},"timezone":"` + tt.timezone + `","targets":[{"target":"a"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			gsj.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d, %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if args.Timezone != tt.timezone {
+				t.Errorf("expected timezone %q, got %q", tt.timezone, args.Timezone)
+			}
+			switch {
+			case tt.location == "" && args.Location != nil:
+				t.Errorf("expected no location, got %v", args.Location)
+			case tt.location != "" && (args.Location == nil || args.Location.String() != tt.location):
+				t.Errorf("expected location %s, got %v", tt.location, args.Location)
+			}
+		})
+	}
+}
+
+func TestRequestTimezone_RawRange(t *testing.T) {
+	var args simplejson.QueryArguments
+	gsj := simplejson.New(
+		// 2016-10-31 20:00 UTC is 2016-11-01 05:00 in Tokyo.
+		simplejson.WithClock(simplejson.NewSimulatedClock(time.Date(2016, 10, 31, 20, 0, 0, 0, time.UTC))),
+		simplejson.WithQuerier(simplejson.QuerierFunc(func(ctx context.Context, target string, qa simplejson.QueryArguments) ([]simplejson.DataPoint, error) {
+			args = qa
+			return nil, nil
+		})),
+	)
+
+	body := `{"rangeRaw":{"from":"now/d","to":"now/d"},"timezone":"Asia/Tokyo","targets":[{"target":"a"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if expect := time.Date(2016, 10, 31, 15, 0, 0, 0, time.UTC); !args.From.Equal(expect) {
+		t.Errorf("expected the range to start at %v, got %v", expect, args.From.UTC())
+	}
+	if expect := time.Date(2016, 11, 1, 15, 0, 0, 0, time.UTC); !args.To.Before(expect) || args.To.Before(expect.Add(-time.Second)) {
+		t.Errorf("expected the range to end before %v, got %v", expect, args.To.UTC())
+	}
+}
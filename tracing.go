@@ -0,0 +1,94 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/tcolgate/grafana-simple-json-go"
+
+// propagator extracts trace context passed on by Grafana.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// WithTracer enables OpenTelemetry tracing of requests. Each request
+// creates a span, continuing any trace propagated by Grafana, and each
+// query target is traced in a child span.
+func WithTracer(tp trace.TracerProvider) Opt {
+	return func(sjc *Handler) error {
+		sjc.tracer = tp.Tracer(tracerName)
+		sjc.tracing = true
+		return nil
+	}
+}
+
+// trace creates a span for all requests served by next.
+func (h *Handler) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		endpoint := endpointName(r.URL.Path)
+		ctx, span := h.tracer.Start(ctx, "simplejson "+endpoint,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.statusCode()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// startTargetSpan starts a span for an individual query target.
+func (h *Handler) startTargetSpan(ctx context.Context, target simpleJSONTarget) trace.Span {
+	typ := target.Type
+	if typ == "" {
+		typ = "timeserie"
+	}
+	_, span := h.tracer.Start(ctx, "simplejson target",
+		trace.WithAttributes(
+			attribute.String("simplejson.target", target.Target),
+			attribute.String("simplejson.ref_id", target.RefID),
+			attribute.String("simplejson.type", typ),
+		),
+	)
+	return span
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
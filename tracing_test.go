@@ -0,0 +1,44 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithTracer(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	gsj := simplejson.New(
+		simplejson.WithQuerier(GSJExample{}),
+		simplejson.WithTracer(tp),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/query", bytes.NewBufferString(encodeTestQuery))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	target, root := spans[0], spans[1]
+	if root.Name() != "simplejson /query" {
+		t.Fatalf("unexpected root span name %q", root.Name())
+	}
+	if root.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("incoming trace context was not propagated")
+	}
+	if target.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatalf("target span is not a child of the request span")
+	}
+}
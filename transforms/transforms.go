@@ -0,0 +1,115 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transforms provides functions for deriving new series from
+// the datapoints returned by a simplejson datasource, such as rates from
+// counters. The functions expect points sorted by time, and return new
+// slices, leaving their input unmodified. Null points produce nulls in
+// the output, rendered by Grafana as gaps.
+package transforms
+
+import (
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+// Delta returns the difference between each point and the one before it,
+// at the time of the later point. The result has one fewer point than
+// points.
+func Delta(points []simplejson.DataPoint) []simplejson.DataPoint {
+	return pairwise(points, func(prev, cur simplejson.DataPoint) float64 {
+		return cur.Value - prev.Value
+	})
+}
+
+// Rate returns the per-second rate of increase of a counter, at the time
+// of each point after the first. A decrease in value is treated as a
+// counter reset, with the counter having increased from zero to its
+// current value.
+func Rate(points []simplejson.DataPoint) []simplejson.DataPoint {
+	return pairwise(points, func(prev, cur simplejson.DataPoint) float64 {
+		inc := cur.Value - prev.Value
+		if inc < 0 {
+			inc = cur.Value
+		}
+		secs := cur.Time.Sub(prev.Time).Seconds()
+		if secs <= 0 {
+			return 0
+		}
+		return inc / secs
+	})
+}
+
+// pairwise calls fn with each consecutive pair of non-null points.
+func pairwise(points []simplejson.DataPoint, fn func(prev, cur simplejson.DataPoint) float64) []simplejson.DataPoint {
+	if len(points) < 2 {
+		return []simplejson.DataPoint{}
+	}
+	out := make([]simplejson.DataPoint, 0, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if prev.Null || cur.Null {
+			out = append(out, simplejson.NullDataPoint(cur.Time))
+			continue
+		}
+		out = append(out, simplejson.DataPoint{Time: cur.Time, Value: fn(prev, cur)})
+	}
+	return out
+}
+
+// MovingAverage returns the average of each point and the n-1 points
+// before it, ignoring nulls. Points whose window contains only nulls are
+// null.
+func MovingAverage(points []simplejson.DataPoint, n int) []simplejson.DataPoint {
+	if n < 1 {
+		n = 1
+	}
+	out := make([]simplejson.DataPoint, len(points))
+	var (
+		sum   float64
+		count int
+	)
+	for i, dp := range points {
+		if !dp.Null {
+			sum += dp.Value
+			count++
+		}
+		if i >= n {
+			if old := points[i-n]; !old.Null {
+				sum -= old.Value
+				count--
+			}
+		}
+		if count == 0 {
+			out[i] = simplejson.NullDataPoint(dp.Time)
+			continue
+		}
+		out[i] = simplejson.DataPoint{Time: dp.Time, Value: sum / float64(count)}
+	}
+	return out
+}
+
+// CumulativeSum returns the running total of points. Null points remain
+// null, and do not contribute to the total.
+func CumulativeSum(points []simplejson.DataPoint) []simplejson.DataPoint {
+	out := make([]simplejson.DataPoint, len(points))
+	var sum float64
+	for i, dp := range points {
+		if dp.Null {
+			out[i] = dp
+			continue
+		}
+		sum += dp.Value
+		out[i] = simplejson.DataPoint{Time: dp.Time, Value: sum}
+	}
+	return out
+}
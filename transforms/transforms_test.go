@@ -0,0 +1,61 @@
+package transforms_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+	"github.com/tcolgate/grafana-simple-json-go/transforms"
+)
+
+var null = math.NaN()
+
+// series creates points at 10s intervals, with NaN values as nulls.
+func series(vals ...float64) []simplejson.DataPoint {
+	points := make([]simplejson.DataPoint, len(vals))
+	for i, v := range vals {
+		ts := time.Unix(int64(i*10), 0)
+		if math.IsNaN(v) {
+			points[i] = simplejson.NullDataPoint(ts)
+			continue
+		}
+		points[i] = simplejson.DataPoint{Time: ts, Value: v}
+	}
+	return points
+}
+
+func checkSeries(t *testing.T, name string, got []simplejson.DataPoint, start int, expect ...float64) {
+	t.Helper()
+	if len(got) != len(expect) {
+		t.Fatalf("%s: expected %d points, got %d", name, len(expect), len(got))
+	}
+	for i, dp := range got {
+		if ts := time.Unix(int64((start+i)*10), 0); !dp.Time.Equal(ts) {
+			t.Errorf("%s: point %d, expected time %v, got %v", name, i, ts, dp.Time)
+		}
+		if math.IsNaN(expect[i]) != dp.Null || (!dp.Null && dp.Value != expect[i]) {
+			t.Errorf("%s: point %d, expected %v, got %+v", name, i, expect[i], dp)
+		}
+	}
+}
+
+func TestDelta(t *testing.T) {
+	got := transforms.Delta(series(1, 4, 2, null, 5))
+	checkSeries(t, "Delta", got, 1, 3, -2, null, null)
+}
+
+func TestRate(t *testing.T) {
+	got := transforms.Rate(series(10, 30, 50, 20, null, 40))
+	checkSeries(t, "Rate", got, 1, 2, 2, 2, null, null)
+}
+
+func TestMovingAverage(t *testing.T) {
+	got := transforms.MovingAverage(series(2, 4, 6, null, null, null, 9), 3)
+	checkSeries(t, "MovingAverage", got, 0, 2, 3, 4, 5, 6, null, 9)
+}
+
+func TestCumulativeSum(t *testing.T) {
+	got := transforms.CumulativeSum(series(1, 2, null, 3))
+	checkSeries(t, "CumulativeSum", got, 0, 1, 3, null, 6)
+}
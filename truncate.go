@@ -0,0 +1,105 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// WithMaxDataPointsEnforcement ensures no timeserie sent to Grafana has
+// more points than the maxDataPoints of the panel. Larger series are
+// reduced using Downsample, and series returned via an IterQuerier are
+// truncated. A warning notice is attached to the series in the response,
+// and logged, whenever a series is reduced.
+func WithMaxDataPointsEnforcement() Opt {
+	return func(sjc *Handler) error {
+		sjc.enforceMaxDPs = true
+		return nil
+	}
+}
+
+// WithMaxRows truncates tables to at most n rows. A warning notice is
+// attached to the table in the response, and logged, whenever a table is
+// truncated.
+func WithMaxRows(n int) Opt {
+	return func(sjc *Handler) error {
+		if n < 0 {
+			return fmt.Errorf("max rows must not be negative, got %d", n)
+		}
+		sjc.maxRows = n
+		return nil
+	}
+}
+
+// simpleJSONNotice is a message about a result, displayed by Grafana
+// alongside the panel.
+type simpleJSONNotice struct {
+	Severity string `json:"severity"`
+	Text     string `json:"text"`
+}
+
+// simpleJSONMeta holds metadata about a result. PreferredVisualisationType
+// tells Grafana how the result should be displayed.
+type simpleJSONMeta struct {
+	Notices                    []simpleJSONNotice `json:"notices,omitempty"`
+	PreferredVisualisationType string             `json:"preferredVisualisationType,omitempty"`
+}
+
+// withVisualisation returns a copy of m, which may be nil, with the
+// preferred visualisation type set.
+func (m *simpleJSONMeta) withVisualisation(typ string) *simpleJSONMeta {
+	out := &simpleJSONMeta{PreferredVisualisationType: typ}
+	if m != nil {
+		out.Notices = m.Notices
+	}
+	return out
+}
+
+// truncationNotice logs, and returns metadata warning, that the result for
+// target has been reduced.
+func truncationNotice(target, format string, args ...interface{}) *simpleJSONMeta {
+	text := fmt.Sprintf(format, args...)
+	log.Printf("simplejson: target %q: %s", target, text)
+	return &simpleJSONMeta{
+		Notices: []simpleJSONNotice{{Severity: "warning", Text: text}},
+	}
+}
+
+// appendMeta appends the "meta" field for m to buf, if m is not nil.
+func appendMeta(buf []byte, m *simpleJSONMeta) ([]byte, error) {
+	if m == nil {
+		return buf, nil
+	}
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return buf, err
+	}
+	buf = append(buf, `,"meta":`...)
+	return append(buf, bs...), nil
+}
+
+// enforceMaxDataPoints reduces points to at most maxDPs points.
+func enforceMaxDataPoints(target string, points []DataPoint, maxDPs int) ([]DataPoint, *simpleJSONMeta) {
+	n := len(points)
+	if maxDPs <= 0 || n <= maxDPs {
+		return points, nil
+	}
+	points = Downsample(points, maxDPs)
+	if len(points) > maxDPs {
+		points = points[:maxDPs]
+	}
+	return points, truncationNotice(target, "series downsampled from %d to %d points", n, len(points))
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+const truncateTestQuery = `{
+	"maxDataPoints": 5,
+	"targets": [
+		{ "target": "upper_50", "refId": "A" },
+		{ "target": "t", "refId": "B", "type": "table" }
+	]
+}`
+
+type truncateResult struct {
+	DataPoints [][2]*float64   `json:"datapoints"`
+	Rows       [][]interface{} `json:"rows"`
+	Meta       struct {
+		Notices []struct {
+			Severity string `json:"severity"`
+			Text     string `json:"text"`
+		} `json:"notices"`
+	} `json:"meta"`
+}
+
+func queryTruncated(t *testing.T, opts ...simplejson.Opt) []truncateResult {
+	t.Helper()
+
+	gsj := simplejson.New(opts...)
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewBufferString(truncateTestQuery))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var res []truncateResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body.String(), err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(res))
+	}
+	return res
+}
+
+func truncateTestPoints(n int) []simplejson.DataPoint {
+	var dps []simplejson.DataPoint
+	for i := 0; i < n; i++ {
+		dps = append(dps, simplejson.DataPoint{Time: time.Unix(int64(i), 0), Value: float64(i)})
+	}
+	return dps
+}
+
+func truncateTestTable(ctx context.Context, target string, args simplejson.TableQueryArguments) ([]simplejson.TableColumn, error) {
+	return []simplejson.TableColumn{{Text: "n", Data: simplejson.TableNumberColumn{1, 2, 3}}}, nil
+}
+
+func TestTruncate(t *testing.T) {
+	res := queryTruncated(t,
+		simplejson.WithQuerier(seriesQuerier(truncateTestPoints(20))),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(truncateTestTable)),
+		simplejson.WithMaxDataPointsEnforcement(),
+		simplejson.WithMaxRows(2),
+	)
+
+	if len(res[0].DataPoints) != 5 {
+		t.Errorf("expected 5 datapoints, got %d", len(res[0].DataPoints))
+	}
+	if len(res[0].Meta.Notices) != 1 || res[0].Meta.Notices[0].Severity != "warning" {
+		t.Errorf("expected a warning on the series, got %+v", res[0].Meta)
+	}
+	if len(res[1].Rows) != 2 {
+		t.Errorf("expected 2 rows, got %d", len(res[1].Rows))
+	}
+	if len(res[1].Meta.Notices) != 1 {
+		t.Errorf("expected a warning on the table, got %+v", res[1].Meta)
+	}
+}
+
+func TestTruncate_WithinLimits(t *testing.T) {
+	res := queryTruncated(t,
+		simplejson.WithQuerier(seriesQuerier(truncateTestPoints(5))),
+		simplejson.WithTableQuerier(simplejson.TableQuerierFunc(truncateTestTable)),
+		simplejson.WithMaxDataPointsEnforcement(),
+		simplejson.WithMaxRows(3),
+	)
+
+	if len(res[0].DataPoints) != 5 || len(res[0].Meta.Notices) != 0 {
+		t.Errorf("expected the series to be unchanged, got %+v", res[0])
+	}
+	if len(res[1].Rows) != 3 || len(res[1].Meta.Notices) != 0 {
+		t.Errorf("expected the table to be unchanged, got %+v", res[1])
+	}
+}
+
+func TestTruncate_Iterators(t *testing.T) {
+	res := queryTruncated(t,
+		simplejson.WithIterQuerier(iterQuerier{points: truncateTestPoints(20)}),
+		simplejson.WithTableIterQuerier(tableIterQuerier{
+			rows: [][]interface{}{{"a", 1.0}, {"b", 2.0}, {"c", 3.0}},
+		}),
+		simplejson.WithMaxDataPointsEnforcement(),
+		simplejson.WithMaxRows(2),
+	)
+
+	if len(res[0].DataPoints) != 5 || len(res[0].Meta.Notices) != 1 {
+		t.Errorf("expected a truncated series with a warning, got %+v", res[0])
+	}
+	if len(res[1].Rows) != 2 || len(res[1].Meta.Notices) != 1 {
+		t.Errorf("expected a truncated table with a warning, got %+v", res[1])
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// NewWithError creates a new Handler, as New does, but returns an error
+// rather than panicking if any of the options fail. The combination of
+// options is also checked, reporting options that would have no effect,
+// such as WithSeriesLimit without a SeriesQuerier, and handlers that
+// have no datasources at all. Every problem found is included in the
+// error.
+func NewWithError(opts ...Opt) (*Handler, error) {
+	h, err := newHandler(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// hasQuerier reports whether the handler can answer queries of any type.
+func (h *Handler) hasQuerier() bool {
+	return h.hasTimeserieQuerier() || h.hasTableQuerier() || h.heatmapQuery != nil || h.logQuery != nil || h.nodeGraphQuery != nil
+}
+
+func (h *Handler) hasTimeserieQuerier() bool {
+	return h.query != nil || h.iterQuery != nil || h.seriesQuery != nil || len(h.namedQueriers) > 0
+}
+
+func (h *Handler) hasTableQuerier() bool {
+	return h.tableQuery != nil || h.tableIter != nil
+}
+
+// validate checks that the options the handler was configured with are
+// consistent.
+func (h *Handler) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(h.maxInFlight >= 0, "WithMaxInFlight given a negative limit, %d", h.maxInFlight)
+
+	if h.tenantResolver != nil {
+		check(len(h.tenants) > 0, "WithTenantResolver given, but no tenants registered with WithTenant")
+		ids := make([]string, 0, len(h.tenants))
+		for id := range h.tenants {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := h.tenants[id].validate(); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %q, %w", id, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	check(len(h.tenants) == 0, "tenants registered with WithTenant, but no WithTenantResolver given")
+
+	check(h.hasQuerier() || h.annotations != nil || h.annotationWriter != nil || h.search != nil || h.resultSearch != nil || h.tags != nil || h.variables != nil || h.streams != nil,
+		"no datasource configured, see WithSource")
+
+	timeserie := h.query != nil || h.seriesQuery != nil || len(h.namedQueriers) > 0
+	check(h.downsample == nil || timeserie, "WithDownsampling requires a Querier, RequestQuerier or SeriesQuerier")
+	check(!h.enforceMaxDPs || h.hasTimeserieQuerier(), "WithMaxDataPointsEnforcement requires a timeserie querier")
+	check(h.seriesLimit == (SeriesLimit{}) || h.seriesQuery != nil, "WithSeriesLimit requires a SeriesQuerier, see WithSeriesQuerier")
+	check(h.maxRows == 0 || h.hasTableQuerier(), "WithMaxRows requires a table querier")
+	check(h.cache == nil || h.cache.enabled, "WithCacheTTL and WithStaleWhileRevalidate require WithQueryCache")
+	check(h.macros == nil || h.hasQuerier(), "WithMacros requires a querier")
+	check(!h.aliases || h.hasQuerier(), "WithAliases requires a querier")
+	check(!h.filterAnnotations || h.annotations != nil, "WithAnnotationFiltering requires an AnnotationQuerier or Annotator")
+	check(h.streamBuffer == 0 || h.streams != nil, "WithStreamBuffer requires a StreamingQuerier")
+	check(h.slowQueries == nil || h.slowQueries.logger != nil, "WithSlowQueryBuffer requires WithSlowQueryLog")
+	check(h.slowQueries == nil || h.hasQuerier(), "WithSlowQueryLog requires a querier")
+	check(h.recentQueries == nil || h.hasQuerier(), "WithRecentQueries requires a querier")
+	for _, enc := range []struct {
+		set  bool
+		name string
+	}{
+		{h.dataFrames, "WithDataFrames"},
+		{h.arrowEncoding, "WithArrowEncoding"},
+		{h.msgpackEncoding, "WithMessagePackEncoding"},
+		{h.cborEncoding, "WithCBOREncoding"},
+	} {
+		check(!enc.set || h.hasQuerier(), "%s requires a querier", enc.name)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestNewWithError(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []simplejson.Opt
+		expect []string // substrings of the error, none if valid
+	}{
+		{
+			name: "valid",
+			opts: []simplejson.Opt{simplejson.WithSource(GSJExample{}), simplejson.WithMaxRows(10)},
+		},
+		{
+			name:   "no datasource",
+			expect: []string{"no datasource configured"},
+		},
+		{
+			name:   "option error",
+			opts:   []simplejson.Opt{simplejson.WithSource(GSJExample{}), simplejson.WithTimeUnit(time.Hour)},
+			expect: []string{"unsupported time unit"},
+		},
+		{
+			name: "ineffective options",
+			opts: []simplejson.Opt{
+				simplejson.WithSearcher(GSJExample{}),
+				simplejson.WithSeriesLimit(simplejson.SeriesLimit{N: 5}),
+				simplejson.WithMaxRows(10),
+			},
+			expect: []string{"WithSeriesLimit requires a SeriesQuerier", "WithMaxRows requires a table querier"},
+		},
+		{
+			name: "tenants without resolver",
+			opts: []simplejson.Opt{
+				simplejson.WithSource(GSJExample{}),
+				simplejson.WithTenant("a", simplejson.WithSource(GSJExample{})),
+			},
+			expect: []string{"no WithTenantResolver given"},
+		},
+		{
+			name: "invalid tenant",
+			opts: []simplejson.Opt{
+				simplejson.WithTenantResolver(func(r *http.Request) (string, error) { return "a", nil }),
+				simplejson.WithTenant("a", simplejson.WithAliases()),
+			},
+			expect: []string{`tenant "a", no datasource configured`, `WithAliases requires a querier`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := simplejson.NewWithError(tt.opts...)
+			if len(tt.expect) == 0 {
+				if err != nil || h == nil {
+					t.Fatalf("unexpected error, %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			for _, e := range tt.expect {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("expected error to contain %q, got %q", e, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Qubit Digital Ltd.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simplejson
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// VariableArguments describes a template variable query. Payload holds
+// the query as configured in the variable editor of the JSON datasource
+// plugin, typically an object with a "target" field.
+type VariableArguments struct {
+	QueryCommonArguments
+	RawRange RawRange
+	Payload  json.RawMessage
+}
+
+// VariableValue is a possible value of a template variable. Text is
+// displayed to the user, Value is substituted into queries.
+type VariableValue struct {
+	Text  string `json:"__text"`
+	Value string `json:"__value"`
+}
+
+// A VariableQuerier responds to template variable queries from the JSON
+// datasource plugin, made to the /variable endpoint.
+type VariableQuerier interface {
+	GrafanaVariable(ctx context.Context, args VariableArguments) ([]VariableValue, error)
+}
+
+// The VariableQuerierFunc type is an adapter to allow the use of an
+// ordinary function as a VariableQuerier.
+type VariableQuerierFunc func(ctx context.Context, args VariableArguments) ([]VariableValue, error)
+
+// GrafanaVariable calls f(ctx, args).
+func (f VariableQuerierFunc) GrafanaVariable(ctx context.Context, args VariableArguments) ([]VariableValue, error) {
+	return f(ctx, args)
+}
+
+// WithVariableQuerier adds a template variable query handler. Variable
+// queries share the timeout set by WithSearchTimeout.
+func WithVariableQuerier(vq VariableQuerier) Opt {
+	return func(sjc *Handler) error {
+		sjc.variables = vq
+		return nil
+	}
+}
+
+type simpleJSONVariableQuery struct {
+	Payload  json.RawMessage    `json:"payload"`
+	Range    simpleJSONRange    `json:"range"`
+	RangeRaw simpleJSONRawRange `json:"rangeRaw"`
+	Timezone string             `json:"timezone"`
+}
+
+// HandleVariable implements the /variable endpoint.
+func (h *Handler) HandleVariable(w http.ResponseWriter, r *http.Request) {
+	if h.variables == nil {
+		writeError(w, ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), h.searchTimeout)
+	defer cancel()
+
+	req := simpleJSONVariableQuery{}
+	if err := h.decodeRequest(w, r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := h.resolveRange(&req.Range, req.RangeRaw, req.Timezone); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	vals, err := callWithDeadline(ctx, func(ctx context.Context) ([]VariableValue, error) {
+		return h.variables.GrafanaVariable(ctx, VariableArguments{
+			QueryCommonArguments: QueryCommonArguments{
+				From:     time.Time(req.Range.From),
+				To:       time.Time(req.Range.To),
+				Timezone: req.Timezone,
+				Location: requestLocation(req.Timezone),
+			},
+			RawRange: RawRange(req.RangeRaw),
+			Payload:  req.Payload,
+		})
+	})
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if vals == nil {
+		vals = []VariableValue{}
+	}
+
+	bs, err := json.Marshal(vals)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+}
+
+// VariableHandler returns the handler for the /variable endpoint.
+func (h *Handler) VariableHandler() http.Handler {
+	return h.liveHandler((*Handler).HandleVariable)
+}
@@ -0,0 +1,64 @@
+package simplejson_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	simplejson "github.com/tcolgate/grafana-simple-json-go"
+)
+
+func TestWithVariableQuerier(t *testing.T) {
+	var got simplejson.VariableArguments
+	gsj := simplejson.New(
+		simplejson.WithVariableQuerier(simplejson.VariableQuerierFunc(func(ctx context.Context, args simplejson.VariableArguments) ([]simplejson.VariableValue, error) {
+			got = args
+			var payload struct {
+				Target string `json:"target"`
+			}
+			if err := json.Unmarshal(args.Payload, &payload); err != nil {
+				return nil, err
+			}
+			return []simplejson.VariableValue{
+				{Text: payload.Target + " one", Value: "1"},
+				{Text: payload.Target + " two", Value: "2"},
+			}, nil
+		})),
+	)
+
+	// This is the format of the inbound request from the JSON plugin
+	reqBuf := bytes.NewBufferString(`{
+  "payload": {"target": "hosts"},
+  "range": {"from": "2016-10-31T06:33:44.866Z", "to": "2016-10-31T12:33:44.866Z", "raw": {"from": "now-6h", "to": "now"}},
+  "rangeRaw": {"from": "now-6h", "to": "now"}
+}`)
+	req := httptest.NewRequest(http.MethodPost, "/variable", reqBuf)
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	expect := `[{"__text":"hosts one","__value":"1"},{"__text":"hosts two","__value":"2"}]`
+	if w.Body.String() != expect {
+		t.Fatalf("\nexpected: %q\ngot:%s", expect, w.Body.String())
+	}
+
+	gotArgs := fmt.Sprintf("%s %s %v", got.From.Format("15:04"), got.To.Format("15:04"), got.RawRange)
+	if expect := "06:33 12:33 {now-6h now}"; gotArgs != expect {
+		t.Fatalf("\nexpected args: %q\ngot: %q", expect, gotArgs)
+	}
+}
+
+func TestVariableNotConfigured(t *testing.T) {
+	gsj := simplejson.New()
+
+	req := httptest.NewRequest(http.MethodPost, "/variable", bytes.NewBufferString(`{}`))
+	w := httptest.NewRecorder()
+	gsj.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}